@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -16,6 +17,7 @@ import (
 	"github.com/grafana/agent/pkg/config"
 	"github.com/olekukonko/tablewriter"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/prometheus/pkg/timestamp"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -55,7 +57,11 @@ func main() {
 	cmd.AddCommand(
 		configSyncCmd(),
 		configCheckCmd(),
+		clusterStatusCmd(),
 		walStatsCmd(),
+		walToBlocksCmd(),
+		walDumpCmd(),
+		walRepairCmd(),
 		targetStatsCmd(),
 		samplesCmd(),
 		operatorDetachCmd(),
@@ -68,6 +74,7 @@ func main() {
 func configSyncCmd() *cobra.Command {
 	var (
 		agentAddr string
+		tokenFile string
 		dryRun    bool
 	)
 
@@ -82,7 +89,10 @@ its extension).
 The directory is used as the source-of-truth for the entire set of configs that
 should be present in the API. config-sync will delete all existing configs from the API
 that do not match any of the names of the configs that were uploaded from the
-source-of-truth directory.`,
+source-of-truth directory.
+
+The configs are applied atomically: if any config fails validation, no configs
+are changed.`,
 		Args: cobra.ExactArgs(1),
 
 		Run: func(_ *cobra.Command, args []string) {
@@ -93,10 +103,16 @@ source-of-truth directory.`,
 				os.Exit(1)
 			}
 
+			token, err := readBearerToken(tokenFile)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to read bearer token file", "err", err)
+				os.Exit(1)
+			}
+
 			directory := args[0]
-			cli := client.New(agentAddr)
+			cli := client.NewWithBearerToken(agentAddr, token)
 
-			err := agentctl.ConfigSync(logger, cli.PrometheusClient, directory, dryRun)
+			err = agentctl.ConfigSync(logger, cli.PrometheusClient, directory, dryRun)
 			if err != nil {
 				level.Error(logger).Log("msg", "failed to sync config", "err", err)
 				os.Exit(1)
@@ -105,10 +121,24 @@ source-of-truth directory.`,
 	}
 
 	cmd.Flags().StringVarP(&agentAddr, "addr", "a", "http://localhost:12345", "address of the agent to connect to")
-	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "use the dry run option to validate config files without attempting to upload")
+	cmd.Flags().StringVar(&tokenFile, "bearer-token-file", "", "file containing a bearer token to authenticate to the config management API with")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "validate config files with the API and print the changes that would be made without applying them")
 	return cmd
 }
 
+// readBearerToken reads the bearer token in path. The token is empty if path
+// is empty.
+func readBearerToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	bb, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bb)), nil
+}
+
 func configCheckCmd() *cobra.Command {
 	var expandEnv bool
 
@@ -140,6 +170,78 @@ the exit code will be 1.`,
 	return cmd
 }
 
+func clusterStatusCmd() *cobra.Command {
+	var (
+		agentAddr string
+		tokenFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "cluster-status",
+		Short: "Print the nodes of a scraping service cluster and the owner of each config",
+		Long: `cluster-status connects to any Agent in a scraping service cluster and prints
+the nodes in the cluster and the nodes which own each config. Ownership is
+determined by the Agent connected to, using the same hash ring the cluster uses
+to distribute configs.
+
+For each node, the number of configs it owns, the number of configs it was
+running after its last reshard, and the time and error of its last reshard are
+printed. Only the configs the bearer token can read are listed.`,
+		Args: cobra.NoArgs,
+
+		Run: func(_ *cobra.Command, _ []string) {
+			if agentAddr == "" {
+				fmt.Fprintln(os.Stderr, "-addr must not be an empty string")
+				os.Exit(1)
+			}
+
+			token, err := readBearerToken(tokenFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read bearer token file: %v\n", err)
+				os.Exit(1)
+			}
+
+			cli := client.NewWithBearerToken(agentAddr, token)
+			status, err := cli.ClusterStatus(context.Background())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to get cluster status: %v\n", err)
+				os.Exit(1)
+			}
+
+			nodes := tablewriter.NewWriter(os.Stdout)
+			nodes.SetHeader([]string{"Node", "Address", "State", "Assigned", "Running", "Last Reshard", "Reshard Error"})
+			for _, n := range status.Nodes {
+				lastReshard := "never"
+				if !n.LastReshard.IsZero() {
+					lastReshard = n.LastReshard.Format(time.RFC3339)
+				}
+				nodes.Append([]string{
+					n.ID,
+					n.Addr,
+					n.State,
+					fmt.Sprintf("%d", n.AssignedConfigs),
+					fmt.Sprintf("%d", n.RunningConfigs),
+					lastReshard,
+					n.LastReshardError,
+				})
+			}
+			nodes.Render()
+			fmt.Println()
+
+			configs := tablewriter.NewWriter(os.Stdout)
+			configs.SetHeader([]string{"Config", "Owners", "Error"})
+			for _, c := range status.Configs {
+				configs.Append([]string{c.Name, strings.Join(c.Owners, ", "), c.Error})
+			}
+			configs.Render()
+		},
+	}
+
+	cmd.Flags().StringVarP(&agentAddr, "addr", "a", "http://localhost:12345", "address of the agent to connect to")
+	cmd.Flags().StringVar(&tokenFile, "bearer-token-file", "", "file containing a bearer token to authenticate to the cluster status API with")
+	return cmd
+}
+
 func samplesCmd() *cobra.Command {
 	var selector string
 
@@ -322,6 +424,263 @@ deletion but then comes back at some point).`,
 	}
 }
 
+func walToBlocksCmd() *cobra.Command {
+	var (
+		selector      string
+		from, to      string
+		blockDuration time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "wal-to-blocks [WAL directory] [output directory]",
+		Short: "Convert samples within the WAL into TSDB blocks",
+		Long: `wal-to-blocks reads a WAL directory and writes the samples within it to
+Prometheus TSDB blocks in the output directory. The blocks can then be used to
+backfill data which was never sent over remote_write into long-term storage.
+
+A label selector can be used to filter the series that should be converted, and
+--from and --to can be used to limit the time range of converted samples.
+
+Examples:
+
+Convert all samples in the WAL:
+
+$ agentctl wal-to-blocks /tmp/wal /tmp/blocks
+
+
+Convert samples for the 'up' series within a time range:
+
+$ agentctl wal-to-blocks -s up --from 2021-06-01T00:00:00Z --to 2021-06-01T12:00:00Z /tmp/wal /tmp/blocks
+`,
+		Args: cobra.ExactArgs(2),
+
+		Run: func(_ *cobra.Command, args []string) {
+			directory, outDirectory := args[0], args[1]
+			if _, err := os.Stat(directory); os.IsNotExist(err) {
+				fmt.Printf("%s does not exist\n", directory)
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Printf("error getting wal: %v\n", err)
+				os.Exit(1)
+			}
+
+			// Check if ./wal is a subdirectory, use that instead.
+			if _, err := os.Stat(filepath.Join(directory, "wal")); err == nil {
+				directory = filepath.Join(directory, "wal")
+			}
+
+			opts := agentctl.DefaultWALToBlocksOptions
+			opts.Selector = selector
+			opts.BlockDuration = blockDuration
+
+			if from != "" {
+				t, err := time.Parse(time.RFC3339, from)
+				if err != nil {
+					fmt.Printf("invalid --from: %v\n", err)
+					os.Exit(1)
+				}
+				opts.MinTime = timestamp.FromTime(t)
+			}
+			if to != "" {
+				t, err := time.Parse(time.RFC3339, to)
+				if err != nil {
+					fmt.Printf("invalid --to: %v\n", err)
+					os.Exit(1)
+				}
+				opts.MaxTime = timestamp.FromTime(t)
+			}
+
+			res, err := agentctl.WALToBlocks(directory, outDirectory, opts)
+			if err != nil {
+				fmt.Printf("failed to convert WAL to blocks: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Matched Series:     %d\n", res.Series)
+			fmt.Printf("Skipped Samples:    %d\n", res.SkippedSamples)
+			fmt.Printf("Blocks Written:     %d\n", len(res.Blocks))
+
+			if len(res.Blocks) == 0 {
+				return
+			}
+			fmt.Println()
+
+			table := tablewriter.NewWriter(os.Stdout)
+			defer table.Render()
+
+			table.SetHeader([]string{"Block", "Oldest Sample", "Newest Sample", "Samples"})
+			for _, b := range res.Blocks {
+				samplesStr := fmt.Sprintf("%d", b.Samples)
+				table.Append([]string{b.ID, b.From.String(), b.To.String(), samplesStr})
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&selector, "selector", "s", "{}", "label selector of series to convert")
+	cmd.Flags().StringVar(&from, "from", "", "RFC3339 time of the oldest sample to convert. Defaults to the oldest sample in the WAL")
+	cmd.Flags().StringVar(&to, "to", "", "RFC3339 time of the newest sample to convert. Defaults to the newest sample in the WAL")
+	cmd.Flags().DurationVar(&blockDuration, "block-duration", agentctl.DefaultWALToBlocksOptions.BlockDuration, "time range covered by each block")
+	return cmd
+}
+
+func walDumpCmd() *cobra.Command {
+	var (
+		selector string
+		from, to string
+		format   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "wal-dump [WAL directory]",
+		Short: "Print the samples within the WAL",
+		Long: `wal-dump reads a WAL directory and prints the samples within it. Samples can
+be printed as JSON lines, in the order they were written, or in the OpenMetrics
+text format, grouped by metric and series. Metrics are printed with the
+unknown type as the WAL doesn't store metric types. OpenMetrics output is
+buffered in memory until the whole WAL has been read. A label selector can be
+used to filter the series that should be printed, and --from and --to can be
+used to limit the time range of printed samples.
+
+wal-dump may be used on the WAL of a running agent.
+
+Examples:
+
+Print all samples in the WAL as JSON:
+
+$ agentctl wal-dump /tmp/wal
+
+
+Print samples for the 'up' series in the OpenMetrics format:
+
+$ agentctl wal-dump -s up -f openmetrics /tmp/wal
+`,
+		Args: cobra.ExactArgs(1),
+
+		Run: func(_ *cobra.Command, args []string) {
+			directory := args[0]
+			if _, err := os.Stat(directory); os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%s does not exist\n", directory)
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "error getting wal: %v\n", err)
+				os.Exit(1)
+			}
+
+			// Check if ./wal is a subdirectory, use that instead.
+			if _, err := os.Stat(filepath.Join(directory, "wal")); err == nil {
+				directory = filepath.Join(directory, "wal")
+			}
+
+			opts := agentctl.DefaultWALDumpOptions
+			opts.Selector = selector
+			opts.Format = agentctl.WALDumpFormat(format)
+
+			if from != "" {
+				t, err := time.Parse(time.RFC3339, from)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "invalid --from: %v\n", err)
+					os.Exit(1)
+				}
+				opts.MinTime = timestamp.FromTime(t)
+			}
+			if to != "" {
+				t, err := time.Parse(time.RFC3339, to)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "invalid --to: %v\n", err)
+					os.Exit(1)
+				}
+				opts.MaxTime = timestamp.FromTime(t)
+			}
+
+			if err := agentctl.DumpWAL(directory, os.Stdout, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to dump WAL: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&selector, "selector", "s", "{}", "label selector of series to print")
+	cmd.Flags().StringVar(&from, "from", "", "RFC3339 time of the oldest sample to print")
+	cmd.Flags().StringVar(&to, "to", "", "RFC3339 time of the newest sample to print")
+	cmd.Flags().StringVarP(&format, "format", "f", string(agentctl.WALDumpJSON), "output format. Must be json or openmetrics")
+	return cmd
+}
+
+func walRepairCmd() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "wal-repair [WAL directory]",
+		Short: "Repair a corrupted WAL",
+		Long: `wal-repair scans a WAL directory for corrupted records. If a corrupted
+record is found, the segment containing it is truncated at the last valid
+record and all segments after it are removed, leaving a consistent WAL that
+can be replayed. A summary of the series and samples that were lost is printed.
+
+The agent using the WAL must be stopped before running wal-repair. Use
+--dry-run to scan the WAL without modifying it.`,
+		Args: cobra.ExactArgs(1),
+
+		Run: func(_ *cobra.Command, args []string) {
+			directory := args[0]
+			if _, err := os.Stat(directory); os.IsNotExist(err) {
+				fmt.Printf("%s does not exist\n", directory)
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Printf("error getting wal: %v\n", err)
+				os.Exit(1)
+			}
+
+			// Check if ./wal is a subdirectory, use that instead.
+			if _, err := os.Stat(filepath.Join(directory, "wal")); err == nil {
+				directory = filepath.Join(directory, "wal")
+			}
+
+			res, err := agentctl.RepairWAL(directory, dryRun)
+			if err != nil {
+				fmt.Printf("failed to repair WAL: %v\n", err)
+				os.Exit(1)
+			}
+
+			if res.Corruption == nil {
+				fmt.Println("No corruption found")
+				return
+			}
+
+			fmt.Printf("Corruption:         %v\n", res.Corruption)
+			fmt.Printf("Corrupted Segment:  %d\n", res.Corruption.Segment)
+			fmt.Printf("Truncated Bytes:    %d\n", res.TruncatedBytes)
+			fmt.Printf("Removed Segments:   %v\n", res.RemovedSegments)
+			fmt.Printf("Lost Series:        %d\n", res.LostSeries)
+			fmt.Printf("Lost Samples:       %d\n", res.LostSamples)
+
+			if len(res.LostTargets) > 0 {
+				fmt.Printf("\nPer-target losses:\n")
+
+				table := tablewriter.NewWriter(os.Stdout)
+				table.SetHeader([]string{"Job", "Instance", "Series", "Samples"})
+
+				sort.Sort(agentctl.BySeriesCount(res.LostTargets))
+				for _, t := range res.LostTargets {
+					seriesStr := fmt.Sprintf("%d", t.Series)
+					samplesStr := fmt.Sprintf("%d", t.Samples)
+					table.Append([]string{t.Job, t.Instance, seriesStr, samplesStr})
+				}
+				table.Render()
+			}
+
+			if res.Repaired {
+				fmt.Printf("\nWAL repaired\n")
+			} else {
+				fmt.Printf("\nDry run: WAL not modified\n")
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "scan the WAL and report corruption without modifying it")
+	return cmd
+}
+
 func operatorDetachCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "operator-detach",
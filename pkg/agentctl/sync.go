@@ -2,7 +2,6 @@ package agentctl
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,6 +10,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/grafana/agent/pkg/client"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
 	"github.com/grafana/agent/pkg/metrics/instance"
 )
 
@@ -23,7 +23,11 @@ import (
 //
 // ConfigSync will completely overwrite the set of active configs
 // present in the provided PrometheusClient - configs present in the
-// API but not in the directory will be deleted.
+// API but not in the directory will be deleted. The configs are applied
+// atomically: if any config is rejected by the API, none are applied.
+//
+// When dryRun is true, the configs are validated by the API without being
+// applied.
 func ConfigSync(logger log.Logger, cli client.PrometheusClient, dir string, dryRun bool) error {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -36,45 +40,37 @@ func ConfigSync(logger log.Logger, cli client.PrometheusClient, dir string, dryR
 	}
 
 	if dryRun {
-		level.Info(logger).Log("msg", "config files validated successfully")
-		return nil
-	}
-
-	uploaded := make(map[string]struct{}, len(cfgs))
-	var hadErrors bool
-
-	for _, cfg := range cfgs {
-		level.Info(logger).Log("msg", "uploading config", "name", cfg.Name)
-		err := cli.PutConfiguration(ctx, cfg.Name, cfg)
+		resp, err := cli.ValidateConfigurations(ctx, cfgs, true)
 		if err != nil {
-			level.Error(logger).Log("msg", "failed to upload config", "name", cfg.Name, "err", err)
-			hadErrors = true
+			return fmt.Errorf("config files failed validation: %w", err)
 		}
-		uploaded[cfg.Name] = struct{}{}
+		logConfigChanges(logger, resp, true)
+		level.Info(logger).Log("msg", "config files validated successfully")
+		return nil
 	}
 
-	existing, err := cli.ListConfigs(ctx)
+	resp, err := cli.ApplyConfigurations(ctx, cfgs, true)
 	if err != nil {
-		return fmt.Errorf("could not list configs: %w", err)
+		return fmt.Errorf("failed to apply configs: %w", err)
 	}
+	logConfigChanges(logger, resp, false)
+	return nil
+}
 
-	// Delete configs from the existing API list that we didn't upload.
-	for _, existing := range existing.Configs {
-		if _, existsLocally := uploaded[existing]; !existsLocally {
-			level.Info(logger).Log("msg", "deleting config", "name", existing)
-			err := cli.DeleteConfiguration(ctx, existing)
-			if err != nil {
-				level.Error(logger).Log("msg", "failed to delete outdated config", "name", existing, "err", err)
-				hadErrors = true
-			}
-		}
+func logConfigChanges(logger log.Logger, resp *configapi.ApplyConfigurationsResponse, dryRun bool) {
+	changes := []struct {
+		msg   string
+		names []string
+	}{
+		{"config created", resp.Created},
+		{"config updated", resp.Updated},
+		{"config deleted", resp.Deleted},
 	}
-
-	if hadErrors {
-		return errors.New("one or more configurations failed to be modified; check the logs for more details")
+	for _, change := range changes {
+		for _, name := range change.names {
+			level.Info(logger).Log("msg", change.msg, "name", name, "dry_run", dryRun)
+		}
 	}
-
-	return nil
 }
 
 // ConfigsFromDirectory parses all YAML files from a directory and
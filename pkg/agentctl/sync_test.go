@@ -10,15 +10,20 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestConfigSync_EmptyStore(t *testing.T) {
+func TestConfigSync(t *testing.T) {
 	cli := &mockFuncPromClient{}
-	cli.ListConfigsFunc = func(_ context.Context) (*configapi.ListConfigurationsResponse, error) {
-		return &configapi.ListConfigurationsResponse{}, nil
+
+	var applied []string
+	cli.ApplyConfigurationsFunc = func(_ context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+		require.True(t, prune, "configs not in the directory must be deleted")
+		for _, cfg := range cfgs {
+			applied = append(applied, cfg.Name)
+		}
+		return &configapi.ApplyConfigurationsResponse{Created: applied}, nil
 	}
 
-	var putConfigs []string
 	cli.PutConfigurationFunc = func(_ context.Context, name string, _ *instance.Config) error {
-		putConfigs = append(putConfigs, name)
+		t.FailNow()
 		return nil
 	}
 
@@ -30,67 +35,38 @@ func TestConfigSync_EmptyStore(t *testing.T) {
 		"agent-2",
 		"agent-3",
 	}
-	require.Equal(t, expect, putConfigs)
+	require.Equal(t, expect, applied)
 }
 
-func TestConfigSync_PrepopulatedStore(t *testing.T) {
+func TestConfigSync_Rejected(t *testing.T) {
 	cli := &mockFuncPromClient{}
-	cli.ListConfigsFunc = func(_ context.Context) (*configapi.ListConfigurationsResponse, error) {
-		return &configapi.ListConfigurationsResponse{
-			Configs: []string{"delete-a", "agent-1", "delete-b", "delete-c"},
-		}, nil
-	}
-
-	var putConfigs []string
-	cli.PutConfigurationFunc = func(_ context.Context, name string, _ *instance.Config) error {
-		putConfigs = append(putConfigs, name)
-		return nil
-	}
-
-	var deletedConfigs []string
-	cli.DeleteConfigurationFunc = func(_ context.Context, name string) error {
-		deletedConfigs = append(deletedConfigs, name)
-		return nil
+	cli.ApplyConfigurationsFunc = func(_ context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+		return nil, errors.New("invalid configs: config agent-2: failed to validate config")
 	}
 
 	err := ConfigSync(nil, cli, "./testdata", false)
-	require.NoError(t, err)
-
-	expectUpdated := []string{
-		"agent-1",
-		"agent-2",
-		"agent-3",
-	}
-	require.Equal(t, expectUpdated, putConfigs)
-
-	expectDeleted := []string{
-		"delete-a",
-		"delete-b",
-		"delete-c",
-	}
-	require.Equal(t, expectDeleted, deletedConfigs)
+	require.EqualError(t, err, "failed to apply configs: invalid configs: config agent-2: failed to validate config")
 }
 
 func TestConfigSync_DryRun(t *testing.T) {
 	cli := &mockFuncPromClient{}
-	cli.ListConfigsFunc = func(_ context.Context) (*configapi.ListConfigurationsResponse, error) {
-		return &configapi.ListConfigurationsResponse{
-			Configs: []string{"delete-a", "agent-1", "delete-b", "delete-c"},
-		}, nil
-	}
 
-	cli.PutConfigurationFunc = func(_ context.Context, name string, _ *instance.Config) error {
-		t.FailNow()
-		return nil
+	var validated []string
+	cli.ValidateConfigurationsFunc = func(_ context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+		for _, cfg := range cfgs {
+			validated = append(validated, cfg.Name)
+		}
+		return &configapi.ApplyConfigurationsResponse{}, nil
 	}
 
-	cli.DeleteConfigurationFunc = func(_ context.Context, name string) error {
+	cli.ApplyConfigurationsFunc = func(_ context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
 		t.FailNow()
-		return nil
+		return nil, nil
 	}
 
 	err := ConfigSync(nil, cli, "./testdata", true)
 	require.NoError(t, err)
+	require.Equal(t, []string{"agent-1", "agent-2", "agent-3"}, validated)
 }
 
 type mockFuncPromClient struct {
@@ -99,6 +75,14 @@ type mockFuncPromClient struct {
 	GetConfigurationFunc    func(ctx context.Context, name string) (*instance.Config, error)
 	PutConfigurationFunc    func(ctx context.Context, name string, cfg *instance.Config) error
 	DeleteConfigurationFunc func(ctx context.Context, name string) error
+
+	ListConfigRevisionsFunc   func(ctx context.Context, name string) (*configapi.ListConfigRevisionsResponse, error)
+	RollbackConfigurationFunc func(ctx context.Context, name string, revision int) (*configapi.RollbackConfigurationResponse, error)
+
+	ValidateConfigurationsFunc func(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error)
+	ApplyConfigurationsFunc    func(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error)
+
+	ClusterStatusFunc func(ctx context.Context) (*configapi.ClusterStatusResponse, error)
 }
 
 func (m mockFuncPromClient) Instances(ctx context.Context) ([]string, error) {
@@ -135,3 +119,38 @@ func (m mockFuncPromClient) DeleteConfiguration(ctx context.Context, name string
 	}
 	return errors.New("not implemented")
 }
+
+func (m mockFuncPromClient) ListConfigRevisions(ctx context.Context, name string) (*configapi.ListConfigRevisionsResponse, error) {
+	if m.ListConfigRevisionsFunc != nil {
+		return m.ListConfigRevisionsFunc(ctx, name)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m mockFuncPromClient) RollbackConfiguration(ctx context.Context, name string, revision int) (*configapi.RollbackConfigurationResponse, error) {
+	if m.RollbackConfigurationFunc != nil {
+		return m.RollbackConfigurationFunc(ctx, name, revision)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m mockFuncPromClient) ValidateConfigurations(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+	if m.ValidateConfigurationsFunc != nil {
+		return m.ValidateConfigurationsFunc(ctx, cfgs, prune)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m mockFuncPromClient) ApplyConfigurations(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+	if m.ApplyConfigurationsFunc != nil {
+		return m.ApplyConfigurationsFunc(ctx, cfgs, prune)
+	}
+	return nil, errors.New("not implemented")
+}
+
+func (m mockFuncPromClient) ClusterStatus(ctx context.Context) (*configapi.ClusterStatusResponse, error) {
+	if m.ClusterStatusFunc != nil {
+		return m.ClusterStatusFunc(ctx)
+	}
+	return nil, errors.New("not implemented")
+}
@@ -1,6 +1,10 @@
 package agentctl
 
 import (
+	"io"
+	"os"
+
+	"github.com/go-kit/kit/log"
 	"github.com/prometheus/prometheus/tsdb/record"
 	"github.com/prometheus/prometheus/tsdb/wal"
 )
@@ -47,3 +51,80 @@ func walIterate(w *wal.WAL, f func(r *wal.Reader) error) error {
 
 	return nil
 }
+
+// recordReader is implemented by wal.Reader and wal.LiveReader.
+type recordReader interface {
+	Next() bool
+	Record() []byte
+	Err() error
+}
+
+// walIterateLive is like walIterate but supports a WAL which is being written
+// to by a running agent. The last segment is read with a wal.LiveReader so a
+// partially written record at the end of it is ignored rather than treated as
+// corruption, and segments removed by a concurrent truncation are skipped.
+func walIterateLive(dir string, f func(r recordReader) error) error {
+	checkpoint, checkpointIdx, err := wal.LastCheckpoint(dir)
+	if err != nil && err != record.ErrNotFound {
+		return err
+	}
+
+	startIdx, last, err := wal.Segments(dir)
+	if err != nil {
+		return err
+	}
+
+	if checkpoint != "" {
+		sr, err := wal.NewSegmentsReader(checkpoint)
+		if err != nil {
+			return err
+		}
+		err = f(wal.NewReader(sr))
+		_ = sr.Close()
+		if err != nil {
+			return err
+		}
+
+		startIdx = checkpointIdx + 1
+	}
+
+	for i := startIdx; i <= last; i++ {
+		if i == last {
+			return iterateLiveSegment(wal.SegmentName(dir, i), f)
+		}
+
+		s, err := wal.OpenReadSegment(wal.SegmentName(dir, i))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		sr := wal.NewSegmentBufReader(s)
+		err = f(wal.NewReader(sr))
+		_ = sr.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func iterateLiveSegment(path string, f func(r recordReader) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := wal.NewLiveReader(log.NewNopLogger(), wal.NewLiveReaderMetrics(nil), file)
+	err = f(r)
+	if err == io.EOF {
+		// The end of the segment was reached, possibly in the middle of a
+		// record which is still being written.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,265 @@
+package agentctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// maxSamplesInAppender is the number of samples appended to a block before
+// committing, preventing too many samples from being held in memory at once.
+const maxSamplesInAppender = 5000
+
+// WALToBlocksOptions configures which data from the WAL is converted into
+// blocks.
+type WALToBlocksOptions struct {
+	// Selector is a label selector for the series to convert.
+	Selector string
+
+	// MinTime and MaxTime are the inclusive range of timestamps, in
+	// milliseconds, of samples to convert.
+	MinTime int64
+	MaxTime int64
+
+	// BlockDuration is the time range covered by each block written.
+	BlockDuration time.Duration
+}
+
+// DefaultWALToBlocksOptions converts all samples from the WAL into 2h blocks.
+var DefaultWALToBlocksOptions = WALToBlocksOptions{
+	Selector:      "{}",
+	MinTime:       math.MinInt64,
+	MaxTime:       math.MaxInt64,
+	BlockDuration: 2 * time.Hour,
+}
+
+// WALBlock describes a block written from the WAL.
+type WALBlock struct {
+	ID      string
+	From    time.Time
+	To      time.Time
+	Samples int
+}
+
+// WALToBlocksResult holds the result of converting a WAL into blocks.
+type WALToBlocksResult struct {
+	// Blocks are the blocks that were written, in time order.
+	Blocks []WALBlock
+
+	// Series is the number of series in the WAL matching the selector.
+	Series int
+
+	// SkippedSamples is the number of samples which were out of order or
+	// duplicated within a series and couldn't be written to a block.
+	SkippedSamples int
+}
+
+// WALToBlocks reads the WAL in walDir and writes TSDB blocks containing the
+// samples matching opts to outDir. The blocks can be used to backfill data
+// into a Prometheus-compatible long-term storage.
+//
+// outDir should not contain any blocks overlapping with the converted time
+// range.
+func WALToBlocks(walDir, outDir string, opts WALToBlocksOptions) (WALToBlocksResult, error) {
+	var res WALToBlocksResult
+
+	if opts.BlockDuration <= 0 {
+		return res, fmt.Errorf("block duration must be greater than 0")
+	}
+	if opts.MinTime > opts.MaxTime {
+		return res, fmt.Errorf("min time must not be after max time")
+	}
+	selector, err := parser.ParseMetricSelector(opts.Selector)
+	if err != nil {
+		return res, err
+	}
+
+	w, err := wal.Open(nil, walDir)
+	if err != nil {
+		return res, err
+	}
+	defer w.Close()
+
+	labelsByRef := make(map[uint64]labels.Labels)
+	err = walIterate(w, func(r *wal.Reader) error {
+		return collectSeries(r, selector, labelsByRef)
+	})
+	if err != nil {
+		return res, fmt.Errorf("could not collect series: %w", err)
+	}
+	res.Series = len(labelsByRef)
+
+	// Find the time range of samples to convert so blocks are only written for
+	// ranges which have data.
+	var (
+		minTs int64 = math.MaxInt64
+		maxTs int64 = math.MinInt64
+	)
+	err = walIterate(w, func(r *wal.Reader) error {
+		return iterateSamples(r, func(s record.RefSample) error {
+			if _, ok := labelsByRef[s.Ref]; !ok || s.T < opts.MinTime || s.T > opts.MaxTime {
+				return nil
+			}
+			if s.T < minTs {
+				minTs = s.T
+			}
+			if s.T > maxTs {
+				maxTs = s.T
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return res, fmt.Errorf("could not collect samples: %w", err)
+	}
+	if minTs > maxTs {
+		return res, nil
+	}
+
+	blockDuration := opts.BlockDuration.Milliseconds()
+	for blockStart := blockDuration * (minTs / blockDuration); blockStart <= maxTs; blockStart += blockDuration {
+		mint, maxt := blockStart, blockStart+blockDuration-1
+		if mint < opts.MinTime {
+			mint = opts.MinTime
+		}
+		if maxt > opts.MaxTime {
+			maxt = opts.MaxTime
+		}
+
+		block, skipped, err := writeWALBlock(w, outDir, labelsByRef, mint, maxt, blockDuration)
+		if err != nil {
+			return res, fmt.Errorf("could not write block for %s to %s: %w", timestamp.Time(mint), timestamp.Time(maxt), err)
+		}
+		res.SkippedSamples += skipped
+		if block != nil {
+			res.Blocks = append(res.Blocks, *block)
+		}
+	}
+
+	return res, nil
+}
+
+// writeWALBlock writes a block with all samples from series in labelsByRef
+// between mint and maxt inclusive. Returns a nil block if there were no
+// samples in the range.
+func writeWALBlock(w *wal.WAL, outDir string, labelsByRef map[uint64]labels.Labels, mint, maxt, blockDuration int64) (block *WALBlock, skipped int, err error) {
+	// Like promtool's backfill, the block writer is given twice the block
+	// duration, as it otherwise rejects samples older than half of the block
+	// from the newest sample.
+	bw, err := tsdb.NewBlockWriter(log.NewNopLogger(), outDir, 2*blockDuration)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if closeErr := bw.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	var (
+		ctx = context.Background()
+		app = bw.Appender(ctx)
+
+		// WAL ref -> head ref
+		headRefs = make(map[uint64]uint64, len(labelsByRef))
+
+		samples, pending int
+		from, to         int64 = math.MaxInt64, math.MinInt64
+	)
+
+	err = walIterate(w, func(r *wal.Reader) error {
+		return iterateSamples(r, func(s record.RefSample) error {
+			lset, ok := labelsByRef[s.Ref]
+			if !ok || s.T < mint || s.T > maxt {
+				return nil
+			}
+
+			ref, err := app.Append(headRefs[s.Ref], lset, s.T, s.V)
+			switch {
+			case errors.Is(err, storage.ErrOutOfOrderSample),
+				errors.Is(err, storage.ErrDuplicateSampleForTimestamp),
+				errors.Is(err, storage.ErrOutOfBounds):
+				skipped++
+				return nil
+			case err != nil:
+				return fmt.Errorf("append sample: %w", err)
+			}
+			headRefs[s.Ref] = ref
+
+			samples++
+			if s.T < from {
+				from = s.T
+			}
+			if s.T > to {
+				to = s.T
+			}
+
+			pending++
+			if pending < maxSamplesInAppender {
+				return nil
+			}
+			if err := app.Commit(); err != nil {
+				return fmt.Errorf("commit: %w", err)
+			}
+			app = bw.Appender(ctx)
+			pending = 0
+			return nil
+		})
+	})
+	if err != nil {
+		_ = app.Rollback()
+		return nil, skipped, err
+	}
+	if err := app.Commit(); err != nil {
+		return nil, skipped, fmt.Errorf("commit: %w", err)
+	}
+	if samples == 0 {
+		return nil, skipped, nil
+	}
+
+	id, err := bw.Flush(ctx)
+	if err != nil {
+		return nil, skipped, fmt.Errorf("flush: %w", err)
+	}
+	return &WALBlock{
+		ID:      id.String(),
+		From:    timestamp.Time(from),
+		To:      timestamp.Time(to),
+		Samples: samples,
+	}, skipped, nil
+}
+
+// iterateSamples calls f for every sample in r.
+func iterateSamples(r *wal.Reader, f func(s record.RefSample) error) error {
+	var dec record.Decoder
+
+	for r.Next() {
+		rec := r.Record()
+
+		switch dec.Type(rec) {
+		case record.Samples:
+			samples, err := dec.Samples(rec, nil)
+			if err != nil {
+				return err
+			}
+			for _, s := range samples {
+				if err := f(s); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return r.Err()
+}
@@ -0,0 +1,92 @@
+package agentctl
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWALToBlocks(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	tt := []struct {
+		name          string
+		selector      string
+		minTime       int64
+		maxTime       int64
+		expectSeries  int
+		expectSamples int
+	}{
+		{name: "all samples", selector: "{}", minTime: math.MinInt64, maxTime: math.MaxInt64, expectSeries: 20, expectSamples: 20},
+		{name: "selector", selector: `{initial="yes"}`, minTime: math.MinInt64, maxTime: math.MaxInt64, expectSeries: 10, expectSamples: 10},
+		{name: "time range", selector: `{initial="yes"}`, minTime: 5, maxTime: 10, expectSeries: 3, expectSamples: 3},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			outDir, err := ioutil.TempDir(os.TempDir(), "blocks")
+			require.NoError(t, err)
+			defer os.RemoveAll(outDir)
+
+			opts := DefaultWALToBlocksOptions
+			opts.Selector = tc.selector
+			opts.MinTime, opts.MaxTime = tc.minTime, tc.maxTime
+
+			res, err := WALToBlocks(walDir, outDir, opts)
+			require.NoError(t, err)
+			require.Len(t, res.Blocks, 1)
+			require.Equal(t, tc.expectSamples, res.Blocks[0].Samples)
+			require.Equal(t, 0, res.SkippedSamples)
+
+			series, samples := readBlocks(t, outDir)
+			require.Equal(t, tc.expectSeries, series)
+			require.Equal(t, tc.expectSamples, samples)
+		})
+	}
+}
+
+func TestWALToBlocks_NoSamples(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	outDir, err := ioutil.TempDir(os.TempDir(), "blocks")
+	require.NoError(t, err)
+	defer os.RemoveAll(outDir)
+
+	opts := DefaultWALToBlocksOptions
+	opts.Selector = `{__name__="missing"}`
+
+	res, err := WALToBlocks(walDir, outDir, opts)
+	require.NoError(t, err)
+	require.Empty(t, res.Blocks)
+}
+
+// readBlocks returns the number of series and samples in the blocks in dir.
+func readBlocks(t *testing.T, dir string) (series, samples int) {
+	t.Helper()
+
+	db, err := tsdb.OpenDBReadOnly(dir, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	q, err := db.Querier(context.Background(), math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	defer q.Close()
+
+	ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+"))
+	for ss.Next() {
+		series++
+		it := ss.At().Iterator()
+		for it.Next() {
+			samples++
+		}
+		require.NoError(t, it.Err())
+	}
+	require.NoError(t, ss.Err())
+	return series, samples
+}
@@ -0,0 +1,273 @@
+package agentctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/tsdb/record"
+)
+
+// WALDumpFormat is the output format used by DumpWAL.
+type WALDumpFormat string
+
+// Supported formats for DumpWAL.
+const (
+	// WALDumpJSON writes one JSON object per sample.
+	WALDumpJSON WALDumpFormat = "json"
+	// WALDumpOpenMetrics writes samples in the OpenMetrics text format. Every
+	// metric is written with the unknown type, as the WAL doesn't store
+	// metric types.
+	WALDumpOpenMetrics WALDumpFormat = "openmetrics"
+)
+
+// WALDumpOptions configures which samples are written by DumpWAL.
+type WALDumpOptions struct {
+	// Selector is a label selector for the series to dump.
+	Selector string
+
+	// MinTime and MaxTime are the inclusive range of timestamps, in
+	// milliseconds, of samples to dump.
+	MinTime int64
+	MaxTime int64
+
+	Format WALDumpFormat
+}
+
+// DefaultWALDumpOptions dumps all samples from the WAL as JSON.
+var DefaultWALDumpOptions = WALDumpOptions{
+	Selector: "{}",
+	MinTime:  math.MinInt64,
+	MaxTime:  math.MaxInt64,
+	Format:   WALDumpJSON,
+}
+
+// walDumpSample is a sample written by DumpWAL in the JSON format.
+type walDumpSample struct {
+	Labels    map[string]string `json:"labels"`
+	Timestamp int64             `json:"timestamp"`
+	// Value is a string since JSON doesn't support NaN, used for staleness
+	// markers.
+	Value string `json:"value"`
+}
+
+// DumpWAL writes the samples from the WAL in walDir matching opts to out.
+// JSON samples are streamed in the order they were written to the WAL, so
+// samples from different series are interleaved. OpenMetrics requires the
+// samples of a metric to be written together, so samples are buffered in
+// memory and written once the whole WAL has been read.
+//
+// DumpWAL may be used against the WAL of a running agent. A record which is
+// being written at the end of the WAL is ignored.
+func DumpWAL(walDir string, out io.Writer, opts WALDumpOptions) error {
+	matchers, err := parser.ParseMetricSelector(opts.Selector)
+	if err != nil {
+		return err
+	}
+	selector := labels.Selector(matchers)
+
+	var (
+		bw          = bufio.NewWriter(out)
+		dec         record.Decoder
+		labelsByRef = make(map[uint64]labels.Labels)
+		om          *openMetricsBuffer
+	)
+
+	var writeSample func(lset labels.Labels, t int64, v float64) error
+	switch opts.Format {
+	case WALDumpJSON:
+		writeSample = func(lset labels.Labels, t int64, v float64) error {
+			return writeJSONSample(bw, lset, t, v)
+		}
+	case WALDumpOpenMetrics:
+		om = newOpenMetricsBuffer()
+		writeSample = func(lset labels.Labels, t int64, v float64) error {
+			om.add(lset, t, v)
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported format %q", opts.Format)
+	}
+
+	// Series records are always written before the samples referencing them,
+	// so series and samples can be collected in a single pass.
+	err = walIterateLive(walDir, func(r recordReader) error {
+		for r.Next() {
+			rec := r.Record()
+
+			switch dec.Type(rec) {
+			case record.Series:
+				series, err := dec.Series(rec, nil)
+				if err != nil {
+					return err
+				}
+				for _, s := range series {
+					if selector.Matches(s.Labels) {
+						labelsByRef[s.Ref] = s.Labels.Copy()
+					}
+				}
+			case record.Samples:
+				samples, err := dec.Samples(rec, nil)
+				if err != nil {
+					return err
+				}
+				for _, s := range samples {
+					lset, ok := labelsByRef[s.Ref]
+					if !ok || s.T < opts.MinTime || s.T > opts.MaxTime {
+						continue
+					}
+					if err := writeSample(lset, s.T, s.V); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return r.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	if om != nil {
+		if err := om.write(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeJSONSample(w *bufio.Writer, lset labels.Labels, t int64, v float64) error {
+	bb, err := json.Marshal(walDumpSample{
+		Labels:    lset.Map(),
+		Timestamp: t,
+		Value:     formatSampleValue(v),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(bb); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// openMetricsBuffer groups samples by metric and series so they can be
+// written in the OpenMetrics text format.
+type openMetricsBuffer struct {
+	metrics map[string]*openMetricsFamily
+}
+
+type openMetricsFamily struct {
+	series map[string]*openMetricsSeries
+	// order holds series in the order they were first added.
+	order []*openMetricsSeries
+}
+
+type openMetricsSeries struct {
+	lset    labels.Labels
+	samples []record.RefSample
+}
+
+func newOpenMetricsBuffer() *openMetricsBuffer {
+	return &openMetricsBuffer{metrics: make(map[string]*openMetricsFamily)}
+}
+
+// add adds a sample to the buffer.
+func (b *openMetricsBuffer) add(lset labels.Labels, t int64, v float64) {
+	name := lset.Get(labels.MetricName)
+	family, ok := b.metrics[name]
+	if !ok {
+		family = &openMetricsFamily{series: make(map[string]*openMetricsSeries)}
+		b.metrics[name] = family
+	}
+
+	key := lset.String()
+	series, ok := family.series[key]
+	if !ok {
+		series = &openMetricsSeries{lset: lset}
+		family.series[key] = series
+		family.order = append(family.order, series)
+	}
+	series.samples = append(series.samples, record.RefSample{T: t, V: v})
+}
+
+// write writes the buffered samples to w. Metrics are sorted by name and
+// each is preceded by a TYPE line. Samples of a series are sorted by
+// timestamp, keeping only the last sample written for each timestamp.
+func (b *openMetricsBuffer) write(w *bufio.Writer) error {
+	names := make([]string, 0, len(b.metrics))
+	for name := range b.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, _ = w.WriteString("# TYPE ")
+		_, _ = w.WriteString(name)
+		_, _ = w.WriteString(" unknown\n")
+
+		for _, series := range b.metrics[name].order {
+			samples := series.samples
+			sort.SliceStable(samples, func(i, j int) bool { return samples[i].T < samples[j].T })
+
+			for i, s := range samples {
+				if i+1 < len(samples) && samples[i+1].T == s.T {
+					continue
+				}
+				if err := writeOpenMetricsSample(w, series.lset, s.T, s.V); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	_, err := w.WriteString("# EOF\n")
+	return err
+}
+
+func writeOpenMetricsSample(w *bufio.Writer, lset labels.Labels, t int64, v float64) error {
+	_, _ = w.WriteString(lset.Get(labels.MetricName))
+	_ = w.WriteByte('{')
+	var written int
+	for _, l := range lset {
+		if l.Name == labels.MetricName {
+			continue
+		}
+		if written > 0 {
+			_ = w.WriteByte(',')
+		}
+		_, _ = w.WriteString(l.Name)
+		_, _ = w.WriteString(`="`)
+		_, _ = openMetricsEscaper.WriteString(w, l.Value)
+		_ = w.WriteByte('"')
+		written++
+	}
+	_, _ = w.WriteString("} ")
+	_, _ = w.WriteString(formatSampleValue(v))
+	_ = w.WriteByte(' ')
+	// OpenMetrics timestamps are in seconds.
+	_, _ = w.WriteString(strconv.FormatFloat(float64(t)/1000, 'f', -1, 64))
+	return w.WriteByte('\n')
+}
+
+func formatSampleValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,130 @@
+package agentctl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpWAL_JSON(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	opts := DefaultWALDumpOptions
+	opts.Selector = `{__name__="metric_1"}`
+
+	var buf bytes.Buffer
+	require.NoError(t, DumpWAL(walDir, &buf, opts))
+
+	var samples []walDumpSample
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var s walDumpSample
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &s))
+		samples = append(samples, s)
+	}
+
+	require.Equal(t, []walDumpSample{
+		{
+			Labels:    map[string]string{"__name__": "metric_1", "instance": "test-instance", "job": "test-job", "initial": "yes"},
+			Timestamp: 3,
+			Value:     "1",
+		},
+		{
+			Labels:    map[string]string{"__name__": "metric_1", "instance": "test-instance", "job": "test-job", "initial": "no"},
+			Timestamp: 4,
+			Value:     "1",
+		},
+	}, samples)
+}
+
+func TestDumpWAL_OpenMetrics(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	opts := DefaultWALDumpOptions
+	opts.Selector = `{initial="yes"}`
+	opts.MinTime, opts.MaxTime = 1, 3
+	opts.Format = WALDumpOpenMetrics
+
+	var buf bytes.Buffer
+	require.NoError(t, DumpWAL(walDir, &buf, opts))
+
+	expect := strings.Join([]string{
+		`# TYPE metric_0 unknown`,
+		`metric_0{initial="yes",instance="test-instance",job="test-job"} 1 0.001`,
+		`# TYPE metric_1 unknown`,
+		`metric_1{initial="yes",instance="test-instance",job="test-job"} 1 0.003`,
+		`# EOF`,
+	}, "\n") + "\n"
+	require.Equal(t, expect, buf.String())
+}
+
+func TestOpenMetricsBuffer(t *testing.T) {
+	var (
+		b = newOpenMetricsBuffer()
+
+		upA   = labels.FromStrings("__name__", "up", "instance", "a")
+		upB   = labels.FromStrings("__name__", "up", "instance", "b")
+		reqsA = labels.FromStrings("__name__", "requests_total", "instance", "a")
+	)
+
+	// Samples from different metrics and series are interleaved, out of
+	// order, and duplicated, as they may be in the WAL.
+	b.add(upA, 2000, 1)
+	b.add(reqsA, 1000, 10)
+	b.add(upB, 1000, 0)
+	b.add(upA, 1000, 1)
+	b.add(reqsA, 2000, 15)
+	b.add(reqsA, 2000, 20)
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	require.NoError(t, b.write(bw))
+	require.NoError(t, bw.Flush())
+
+	expect := strings.Join([]string{
+		`# TYPE requests_total unknown`,
+		`requests_total{instance="a"} 10 1`,
+		`requests_total{instance="a"} 20 2`,
+		`# TYPE up unknown`,
+		`up{instance="a"} 1 1`,
+		`up{instance="a"} 1 2`,
+		`up{instance="b"} 0 1`,
+		`# EOF`,
+	}, "\n") + "\n"
+	require.Equal(t, expect, buf.String())
+}
+
+func TestDumpWAL_PartialRecord(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	// Simulate an agent in the middle of writing a record to the last segment.
+	_, last, err := wal.Segments(walDir)
+	require.NoError(t, err)
+	f, err := os.OpenFile(wal.SegmentName(walDir, last), os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 0, 100, 0, 0, 0, 0, 1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	opts := DefaultWALDumpOptions
+	opts.MinTime, opts.MaxTime = 1, 1
+
+	var buf bytes.Buffer
+	require.NoError(t, DumpWAL(walDir, &buf, opts))
+	require.Equal(t, 1, strings.Count(buf.String(), "\n"))
+}
+
+func TestFormatSampleValue(t *testing.T) {
+	require.Equal(t, "NaN", formatSampleValue(math.NaN()))
+	require.Equal(t, "+Inf", formatSampleValue(math.Inf(1)))
+	require.Equal(t, "-Inf", formatSampleValue(math.Inf(-1)))
+	require.Equal(t, "1.5", formatSampleValue(1.5))
+}
@@ -0,0 +1,225 @@
+package agentctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// WALRepairResult holds the result of scanning and repairing a WAL.
+type WALRepairResult struct {
+	// Corruption is the first corruption found within the WAL. It is nil if
+	// the WAL isn't corrupted.
+	Corruption *wal.CorruptionErr
+
+	// Repaired is true if the WAL was rewritten to remove the corruption.
+	Repaired bool
+
+	// TruncatedBytes is the number of bytes removed from the end of the
+	// corrupted segment, starting from the last valid record.
+	TruncatedBytes int64
+
+	// RemovedSegments are the segments after the corrupted segment which are
+	// removed by the repair.
+	RemovedSegments []int
+
+	// LostSeries and LostSamples are the number of series and samples which
+	// could be read from the removed segments. Records after the corruption in
+	// the corrupted segment can't be read and aren't included.
+	LostSeries  int
+	LostSamples int
+
+	// LostTargets breaks down the lost series and samples by scrape target.
+	LostTargets []WALTargetStats
+}
+
+// RepairWAL scans the WAL in walDir for corrupted records. If a corruption is
+// found, the WAL is truncated at the last valid record before the corruption
+// and all later segments are removed, leaving a consistent WAL which can be
+// replayed. If dryRun is true, the WAL is scanned but not modified.
+func RepairWAL(walDir string, dryRun bool) (WALRepairResult, error) {
+	var res WALRepairResult
+
+	checkpoint, checkpointIdx, err := wal.LastCheckpoint(walDir)
+	if err != nil && err != record.ErrNotFound {
+		return res, err
+	}
+	first, last, err := wal.Segments(walDir)
+	if err != nil {
+		return res, err
+	}
+
+	labelsByRef := make(map[uint64]labels.Labels)
+
+	if checkpoint != "" {
+		sr, err := wal.NewSegmentsReader(checkpoint)
+		if err != nil {
+			return res, err
+		}
+		_, err = scanRecords(wal.NewReader(sr), labelsByRef, nil)
+		_ = sr.Close()
+		if err != nil {
+			return res, fmt.Errorf("checkpoint %s is corrupted and can't be repaired: %w", checkpoint, err)
+		}
+		first = checkpointIdx + 1
+	}
+
+	// Find the first corrupted segment.
+	corrupted := -1
+	for i := first; i <= last; i++ {
+		s, err := wal.OpenReadSegment(wal.SegmentName(walDir, i))
+		if err != nil {
+			return res, err
+		}
+		sr := wal.NewSegmentBufReader(s)
+		validOffset, err := scanRecords(wal.NewReader(sr), labelsByRef, nil)
+		_ = sr.Close()
+
+		var cerr *wal.CorruptionErr
+		if errors.As(err, &cerr) {
+			fi, statErr := os.Stat(wal.SegmentName(walDir, i))
+			if statErr != nil {
+				return res, statErr
+			}
+			res.Corruption = cerr
+			res.TruncatedBytes = fi.Size() - validOffset
+			corrupted = i
+			break
+		} else if err != nil {
+			return res, err
+		}
+	}
+	if corrupted < 0 {
+		return res, nil
+	}
+
+	// Collect everything that would be lost from the segments after the
+	// corrupted one. Those segments may be corrupted too, so read errors are
+	// ignored and only the readable records are counted.
+	lost := newLostRecords()
+	for i := corrupted + 1; i <= last; i++ {
+		res.RemovedSegments = append(res.RemovedSegments, i)
+
+		s, err := wal.OpenReadSegment(wal.SegmentName(walDir, i))
+		if err != nil {
+			continue
+		}
+		sr := wal.NewSegmentBufReader(s)
+		_, _ = scanRecords(wal.NewReader(sr), labelsByRef, lost)
+		_ = sr.Close()
+	}
+	res.LostSeries, res.LostSamples, res.LostTargets = lost.stats(labelsByRef)
+
+	if dryRun {
+		return res, nil
+	}
+
+	w, err := wal.NewSize(nil, nil, walDir, wal.DefaultSegmentSize, true)
+	if err != nil {
+		return res, err
+	}
+	if err := w.Repair(res.Corruption); err != nil {
+		_ = w.Close()
+		return res, fmt.Errorf("failed to repair WAL: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return res, err
+	}
+	res.Repaired = true
+	return res, nil
+}
+
+// lostRecords tracks the series and samples read after a corruption.
+type lostRecords struct {
+	series  map[uint64]struct{}
+	samples map[uint64]int
+}
+
+func newLostRecords() *lostRecords {
+	return &lostRecords{
+		series:  make(map[uint64]struct{}),
+		samples: make(map[uint64]int),
+	}
+}
+
+// stats returns the total number of lost series and samples, along with the
+// stats per target.
+func (l *lostRecords) stats(labelsByRef map[uint64]labels.Labels) (series, samples int, targets []WALTargetStats) {
+	var (
+		refs = make(map[uint64]struct{}, len(l.series))
+
+		// job/instance -> index in targets
+		lookup = make(map[[2]string]int)
+	)
+	for ref := range l.series {
+		refs[ref] = struct{}{}
+	}
+	for ref := range l.samples {
+		refs[ref] = struct{}{}
+	}
+
+	for ref := range refs {
+		lset := labelsByRef[ref]
+		key := [2]string{lset.Get("job"), lset.Get("instance")}
+
+		idx, ok := lookup[key]
+		if !ok {
+			idx = len(targets)
+			lookup[key] = idx
+			targets = append(targets, WALTargetStats{Job: key[0], Instance: key[1]})
+		}
+
+		targets[idx].Series++
+		targets[idx].Samples += l.samples[ref]
+		series++
+		samples += l.samples[ref]
+	}
+
+	return series, samples, targets
+}
+
+// scanRecords reads all records from r, storing series into labelsByRef. If
+// lost is non-nil, series and samples read are also recorded into lost.
+// Returns the offset of the end of the last valid record.
+func scanRecords(r *wal.Reader, labelsByRef map[uint64]labels.Labels, lost *lostRecords) (int64, error) {
+	var (
+		dec         record.Decoder
+		validOffset int64
+	)
+
+	for r.Next() {
+		rec := r.Record()
+
+		switch dec.Type(rec) {
+		case record.Series:
+			series, err := dec.Series(rec, nil)
+			if err != nil {
+				return validOffset, &wal.CorruptionErr{Err: err, Segment: r.Segment(), Offset: r.Offset()}
+			}
+			for _, s := range series {
+				labelsByRef[s.Ref] = s.Labels.Copy()
+				if lost != nil {
+					lost.series[s.Ref] = struct{}{}
+				}
+			}
+		case record.Samples:
+			samples, err := dec.Samples(rec, nil)
+			if err != nil {
+				return validOffset, &wal.CorruptionErr{Err: err, Segment: r.Segment(), Offset: r.Offset()}
+			}
+			if lost != nil {
+				for _, s := range samples {
+					lost.samples[s.Ref]++
+				}
+			}
+		}
+
+		validOffset = r.Offset()
+	}
+
+	return validOffset, r.Err()
+}
@@ -0,0 +1,97 @@
+package agentctl
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairWAL(t *testing.T) {
+	walDir := setupCorruptedWAL(t)
+
+	// A dry run shouldn't modify the WAL.
+	res, err := RepairWAL(walDir, true)
+	require.NoError(t, err)
+	require.NotNil(t, res.Corruption)
+	require.Equal(t, 0, res.Corruption.Segment)
+	require.False(t, res.Repaired)
+	require.Equal(t, []int{1, 2}, res.RemovedSegments)
+	require.Equal(t, 1, res.LostSeries)
+	require.Equal(t, 2, res.LostSamples)
+	require.Equal(t, []WALTargetStats{{Job: "test-job", Instance: "test-instance", Series: 1, Samples: 2}}, res.LostTargets)
+
+	_, last, err := wal.Segments(walDir)
+	require.NoError(t, err)
+	require.Equal(t, 2, last)
+
+	res, err = RepairWAL(walDir, false)
+	require.NoError(t, err)
+	require.True(t, res.Repaired)
+
+	// The repaired WAL should be consistent and only hold the records from
+	// before the corruption.
+	res, err = RepairWAL(walDir, true)
+	require.NoError(t, err)
+	require.Nil(t, res.Corruption)
+
+	stats, err := CalculateStats(walDir)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Series())
+	require.Equal(t, 2, stats.Samples())
+}
+
+func TestRepairWAL_Healthy(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	res, err := RepairWAL(walDir, false)
+	require.NoError(t, err)
+	require.Nil(t, res.Corruption)
+	require.False(t, res.Repaired)
+}
+
+// setupCorruptedWAL creates a WAL whose first segment is corrupted after its
+// valid records. The segments after it hold one series and two samples.
+func setupCorruptedWAL(t *testing.T) string {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(walDir)
+	})
+
+	w, err := wal.NewSize(log.NewNopLogger(), nil, walDir, wal.DefaultSegmentSize, true)
+	require.NoError(t, err)
+
+	var (
+		enc        record.Encoder
+		baseLabels = []string{"job", "test-job", "instance", "test-instance"}
+	)
+
+	require.NoError(t, w.Log(enc.Series([]record.RefSeries{
+		{Ref: 1, Labels: labels.FromStrings(append(baseLabels, "__name__", "metric_a")...)},
+		{Ref: 2, Labels: labels.FromStrings(append(baseLabels, "__name__", "metric_b")...)},
+	}, nil)))
+	require.NoError(t, w.Log(enc.Samples([]record.RefSample{{Ref: 1, T: 1, V: 1}, {Ref: 2, T: 1, V: 1}}, nil)))
+	require.NoError(t, w.NextSegment())
+
+	require.NoError(t, w.Log(enc.Series([]record.RefSeries{
+		{Ref: 3, Labels: labels.FromStrings(append(baseLabels, "__name__", "metric_c")...)},
+	}, nil)))
+	require.NoError(t, w.Log(enc.Samples([]record.RefSample{{Ref: 3, T: 2, V: 1}, {Ref: 3, T: 3, V: 1}}, nil)))
+	require.NoError(t, w.NextSegment())
+	require.NoError(t, w.Close())
+
+	// Write garbage after the padded page of the first segment.
+	f, err := os.OpenFile(wal.SegmentName(walDir, 0), os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	return walDir
+}
@@ -64,28 +64,123 @@ func (m *ReshardRequest) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_ReshardRequest proto.InternalMessageInfo
 
+type ConfirmHandoffRequest struct {
+	// Names of the configs being handed off.
+	Configs []string `protobuf:"bytes,1,rep,name=configs,proto3" json:"configs,omitempty"`
+}
+
+func (m *ConfirmHandoffRequest) Reset()      { *m = ConfirmHandoffRequest{} }
+func (*ConfirmHandoffRequest) ProtoMessage() {}
+func (*ConfirmHandoffRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_11e9fe65e2a59325, []int{1}
+}
+func (m *ConfirmHandoffRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ConfirmHandoffRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ConfirmHandoffRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ConfirmHandoffRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConfirmHandoffRequest.Merge(m, src)
+}
+func (m *ConfirmHandoffRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *ConfirmHandoffRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConfirmHandoffRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConfirmHandoffRequest proto.InternalMessageInfo
+
+func (m *ConfirmHandoffRequest) GetConfigs() []string {
+	if m != nil {
+		return m.Configs
+	}
+	return nil
+}
+
+type ConfirmHandoffResponse struct {
+	// Names of the configs from the request which are ready.
+	Ready []string `protobuf:"bytes,1,rep,name=ready,proto3" json:"ready,omitempty"`
+}
+
+func (m *ConfirmHandoffResponse) Reset()      { *m = ConfirmHandoffResponse{} }
+func (*ConfirmHandoffResponse) ProtoMessage() {}
+func (*ConfirmHandoffResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_11e9fe65e2a59325, []int{2}
+}
+func (m *ConfirmHandoffResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *ConfirmHandoffResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_ConfirmHandoffResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *ConfirmHandoffResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ConfirmHandoffResponse.Merge(m, src)
+}
+func (m *ConfirmHandoffResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *ConfirmHandoffResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ConfirmHandoffResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ConfirmHandoffResponse proto.InternalMessageInfo
+
+func (m *ConfirmHandoffResponse) GetReady() []string {
+	if m != nil {
+		return m.Ready
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*ReshardRequest)(nil), "agentproto.ReshardRequest")
+	proto.RegisterType((*ConfirmHandoffRequest)(nil), "agentproto.ConfirmHandoffRequest")
+	proto.RegisterType((*ConfirmHandoffResponse)(nil), "agentproto.ConfirmHandoffResponse")
 }
 
 func init() { proto.RegisterFile("pkg/agentproto/agent.proto", fileDescriptor_11e9fe65e2a59325) }
 
 var fileDescriptor_11e9fe65e2a59325 = []byte{
-	// 221 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x92, 0x2a, 0xc8, 0x4e, 0xd7,
-	0x4f, 0x4c, 0x4f, 0xcd, 0x2b, 0x29, 0x28, 0xca, 0x2f, 0xc9, 0x87, 0x30, 0xf5, 0xc0, 0x6c, 0x21,
-	0x2e, 0x84, 0xb8, 0x94, 0x74, 0x7a, 0x7e, 0x7e, 0x7a, 0x4e, 0xaa, 0x3e, 0x98, 0x97, 0x54, 0x9a,
-	0xa6, 0x9f, 0x9a, 0x5b, 0x50, 0x52, 0x09, 0x51, 0xa8, 0x24, 0xc0, 0xc5, 0x17, 0x94, 0x5a, 0x9c,
-	0x91, 0x58, 0x94, 0x12, 0x94, 0x5a, 0x58, 0x9a, 0x5a, 0x5c, 0x62, 0x14, 0xc0, 0xc5, 0x1f, 0x9c,
-	0x5c, 0x94, 0x58, 0x90, 0x99, 0x97, 0x1e, 0x9c, 0x5a, 0x54, 0x96, 0x99, 0x9c, 0x2a, 0x64, 0xcb,
-	0xc5, 0x0e, 0x55, 0x24, 0x24, 0xa5, 0x87, 0x30, 0x59, 0x0f, 0x55, 0xa7, 0x94, 0x98, 0x1e, 0xc4,
-	0x26, 0x3d, 0x98, 0x4d, 0x7a, 0xae, 0x20, 0x9b, 0x9c, 0x62, 0x2f, 0x3c, 0x94, 0x63, 0xb8, 0xf1,
-	0x50, 0x8e, 0xe1, 0xc3, 0x43, 0x39, 0xc6, 0x86, 0x47, 0x72, 0x8c, 0x2b, 0x1e, 0xc9, 0x31, 0x9e,
-	0x78, 0x24, 0xc7, 0x78, 0xe1, 0x91, 0x1c, 0xe3, 0x83, 0x47, 0x72, 0x8c, 0x2f, 0x1e, 0xc9, 0x31,
-	0x7c, 0x78, 0x24, 0xc7, 0x38, 0xe1, 0xb1, 0x1c, 0xc3, 0x85, 0xc7, 0x72, 0x0c, 0x37, 0x1e, 0xcb,
-	0x31, 0x44, 0xa9, 0xa7, 0x67, 0x96, 0x64, 0x94, 0x26, 0xe9, 0x25, 0xe7, 0xe7, 0xea, 0xa7, 0x17,
-	0x25, 0xa6, 0x25, 0xe6, 0x25, 0x42, 0xbc, 0xa8, 0x8f, 0xea, 0xef, 0x24, 0x36, 0x30, 0x65, 0x0c,
-	0x08, 0x00, 0x00, 0xff, 0xff, 0x0b, 0x79, 0x37, 0xd4, 0x10, 0x01, 0x00, 0x00,
+	// 301 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x91, 0x3f, 0x4b, 0xc3, 0x40,
+	0x18, 0xc6, 0xef, 0x10, 0x2d, 0xde, 0x50, 0xe5, 0xd0, 0x52, 0x22, 0xbc, 0x68, 0x16, 0x9d, 0x2e,
+	0xa8, 0xb3, 0x8b, 0x22, 0x38, 0xb7, 0x83, 0x20, 0x38, 0x5c, 0xd3, 0xcb, 0x35, 0x68, 0xef, 0xe2,
+	0x25, 0x11, 0xba, 0xf9, 0x11, 0xfc, 0x18, 0xfa, 0x4d, 0x1c, 0x33, 0x76, 0x34, 0x97, 0xc5, 0xb1,
+	0x1f, 0x41, 0x9a, 0x4b, 0xa9, 0x11, 0x71, 0xba, 0xf7, 0xb9, 0xf7, 0x0f, 0xcf, 0x8f, 0x87, 0x78,
+	0xc9, 0x83, 0x0c, 0xb8, 0x14, 0x2a, 0x4b, 0x8c, 0xce, 0xb4, 0x2b, 0x59, 0x5d, 0x53, 0xb2, 0xfe,
+	0xf7, 0x0e, 0xa4, 0xd6, 0xf2, 0x51, 0x04, 0xb5, 0x1a, 0xe5, 0x51, 0x20, 0xa6, 0x49, 0x36, 0x73,
+	0x83, 0xfe, 0x2e, 0xe9, 0x0e, 0x44, 0x3a, 0xe1, 0x66, 0x3c, 0x10, 0x4f, 0xb9, 0x48, 0x33, 0xff,
+	0x94, 0xec, 0x5f, 0x69, 0x15, 0xc5, 0x66, 0x7a, 0xc3, 0xd5, 0x58, 0x47, 0x51, 0xd3, 0xa0, 0x7d,
+	0xd2, 0x09, 0x97, 0x0d, 0x99, 0xf6, 0xf1, 0xe1, 0xc6, 0xc9, 0xf6, 0x60, 0x25, 0x7d, 0x46, 0x7a,
+	0xbf, 0x57, 0xd2, 0x44, 0xab, 0x54, 0xd0, 0x3d, 0xb2, 0x69, 0x04, 0x1f, 0xcf, 0x9a, 0x0d, 0x27,
+	0xce, 0xde, 0x31, 0xd9, 0x19, 0x86, 0x86, 0x27, 0xb1, 0x92, 0x43, 0x61, 0x9e, 0xe3, 0x50, 0xd0,
+	0x0b, 0xd2, 0x69, 0x8c, 0x50, 0x8f, 0xad, 0xdd, 0xb3, 0xb6, 0x3b, 0xaf, 0xc7, 0x1c, 0x0d, 0x5b,
+	0xd1, 0xb0, 0xeb, 0x25, 0x0d, 0xbd, 0x25, 0xdd, 0xb6, 0x05, 0x7a, 0xf4, 0xf3, 0xca, 0x9f, 0x44,
+	0x9e, 0xff, 0xdf, 0x88, 0x23, 0xb8, 0xbc, 0x2f, 0x4a, 0x40, 0xf3, 0x12, 0xd0, 0xa2, 0x04, 0xfc,
+	0x62, 0x01, 0xbf, 0x59, 0xc0, 0x1f, 0x16, 0x70, 0x61, 0x01, 0x7f, 0x5a, 0xc0, 0x5f, 0x16, 0xd0,
+	0xc2, 0x02, 0x7e, 0xad, 0x00, 0x15, 0x15, 0xa0, 0x79, 0x05, 0xe8, 0xee, 0x58, 0xc6, 0xd9, 0x24,
+	0x1f, 0xb1, 0x50, 0x4f, 0x03, 0x69, 0x78, 0xc4, 0x15, 0x77, 0xf9, 0x04, 0xed, 0xd0, 0x46, 0x5b,
+	0xf5, 0x73, 0xfe, 0x3d, 0x00, 0xdc, 0x32, 0xc9, 0x23, 0xcd, 0x01, 0x00, 0x00,
 }
 
 func (this *ReshardRequest) Equal(that interface{}) bool {
@@ -109,6 +204,64 @@ func (this *ReshardRequest) Equal(that interface{}) bool {
 	}
 	return true
 }
+func (this *ConfirmHandoffRequest) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*ConfirmHandoffRequest)
+	if !ok {
+		that2, ok := that.(ConfirmHandoffRequest)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if len(this.Configs) != len(that1.Configs) {
+		return false
+	}
+	for i := range this.Configs {
+		if this.Configs[i] != that1.Configs[i] {
+			return false
+		}
+	}
+	return true
+}
+func (this *ConfirmHandoffResponse) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*ConfirmHandoffResponse)
+	if !ok {
+		that2, ok := that.(ConfirmHandoffResponse)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if len(this.Ready) != len(that1.Ready) {
+		return false
+	}
+	for i := range this.Ready {
+		if this.Ready[i] != that1.Ready[i] {
+			return false
+		}
+	}
+	return true
+}
 func (this *ReshardRequest) GoString() string {
 	if this == nil {
 		return "nil"
@@ -118,6 +271,26 @@ func (this *ReshardRequest) GoString() string {
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
+func (this *ConfirmHandoffRequest) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 5)
+	s = append(s, "&agentproto.ConfirmHandoffRequest{")
+	s = append(s, "Configs: "+fmt.Sprintf("%#v", this.Configs)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *ConfirmHandoffResponse) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 5)
+	s = append(s, "&agentproto.ConfirmHandoffResponse{")
+	s = append(s, "Ready: "+fmt.Sprintf("%#v", this.Ready)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
 func valueToGoStringAgent(v interface{}, typ string) string {
 	rv := reflect.ValueOf(v)
 	if rv.IsNil() {
@@ -142,6 +315,11 @@ type ScrapingServiceClient interface {
 	// Reshard tells the implementing service to reshard all of its running
 	// configs.
 	Reshard(ctx context.Context, in *ReshardRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// ConfirmHandoff asks the implementing service which of the given configs
+	// it is ready to take over. A config is ready once the service is running
+	// it and has scraped each of its targets at least once. The previous owner
+	// of a config keeps running it until the new owners confirm the handoff.
+	ConfirmHandoff(ctx context.Context, in *ConfirmHandoffRequest, opts ...grpc.CallOption) (*ConfirmHandoffResponse, error)
 }
 
 type scrapingServiceClient struct {
@@ -161,11 +339,25 @@ func (c *scrapingServiceClient) Reshard(ctx context.Context, in *ReshardRequest,
 	return out, nil
 }
 
+func (c *scrapingServiceClient) ConfirmHandoff(ctx context.Context, in *ConfirmHandoffRequest, opts ...grpc.CallOption) (*ConfirmHandoffResponse, error) {
+	out := new(ConfirmHandoffResponse)
+	err := c.cc.Invoke(ctx, "/agentproto.ScrapingService/ConfirmHandoff", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ScrapingServiceServer is the server API for ScrapingService service.
 type ScrapingServiceServer interface {
 	// Reshard tells the implementing service to reshard all of its running
 	// configs.
 	Reshard(context.Context, *ReshardRequest) (*empty.Empty, error)
+	// ConfirmHandoff asks the implementing service which of the given configs
+	// it is ready to take over. A config is ready once the service is running
+	// it and has scraped each of its targets at least once. The previous owner
+	// of a config keeps running it until the new owners confirm the handoff.
+	ConfirmHandoff(context.Context, *ConfirmHandoffRequest) (*ConfirmHandoffResponse, error)
 }
 
 // UnimplementedScrapingServiceServer can be embedded to have forward compatible implementations.
@@ -175,6 +367,9 @@ type UnimplementedScrapingServiceServer struct {
 func (*UnimplementedScrapingServiceServer) Reshard(ctx context.Context, req *ReshardRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Reshard not implemented")
 }
+func (*UnimplementedScrapingServiceServer) ConfirmHandoff(ctx context.Context, req *ConfirmHandoffRequest) (*ConfirmHandoffResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConfirmHandoff not implemented")
+}
 
 func RegisterScrapingServiceServer(s *grpc.Server, srv ScrapingServiceServer) {
 	s.RegisterService(&_ScrapingService_serviceDesc, srv)
@@ -198,6 +393,24 @@ func _ScrapingService_Reshard_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ScrapingService_ConfirmHandoff_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfirmHandoffRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScrapingServiceServer).ConfirmHandoff(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/agentproto.ScrapingService/ConfirmHandoff",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScrapingServiceServer).ConfirmHandoff(ctx, req.(*ConfirmHandoffRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _ScrapingService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "agentproto.ScrapingService",
 	HandlerType: (*ScrapingServiceServer)(nil),
@@ -206,6 +419,10 @@ var _ScrapingService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Reshard",
 			Handler:    _ScrapingService_Reshard_Handler,
 		},
+		{
+			MethodName: "ConfirmHandoff",
+			Handler:    _ScrapingService_ConfirmHandoff_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/agentproto/agent.proto",
@@ -234,6 +451,70 @@ func (m *ReshardRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *ConfirmHandoffRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ConfirmHandoffRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ConfirmHandoffRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Configs) > 0 {
+		for iNdEx := len(m.Configs) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Configs[iNdEx])
+			copy(dAtA[i:], m.Configs[iNdEx])
+			i = encodeVarintAgent(dAtA, i, uint64(len(m.Configs[iNdEx])))
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *ConfirmHandoffResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ConfirmHandoffResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *ConfirmHandoffResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Ready) > 0 {
+		for iNdEx := len(m.Ready) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Ready[iNdEx])
+			copy(dAtA[i:], m.Ready[iNdEx])
+			i = encodeVarintAgent(dAtA, i, uint64(len(m.Ready[iNdEx])))
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintAgent(dAtA []byte, offset int, v uint64) int {
 	offset -= sovAgent(v)
 	base := offset
@@ -254,6 +535,36 @@ func (m *ReshardRequest) Size() (n int) {
 	return n
 }
 
+func (m *ConfirmHandoffRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Configs) > 0 {
+		for _, s := range m.Configs {
+			l = len(s)
+			n += 1 + l + sovAgent(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *ConfirmHandoffResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Ready) > 0 {
+		for _, s := range m.Ready {
+			l = len(s)
+			n += 1 + l + sovAgent(uint64(l))
+		}
+	}
+	return n
+}
+
 func sovAgent(x uint64) (n int) {
 	return (math_bits.Len64(x|1) + 6) / 7
 }
@@ -269,6 +580,26 @@ func (this *ReshardRequest) String() string {
 	}, "")
 	return s
 }
+func (this *ConfirmHandoffRequest) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&ConfirmHandoffRequest{`,
+		`Configs:` + fmt.Sprintf("%v", this.Configs) + `,`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *ConfirmHandoffResponse) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&ConfirmHandoffResponse{`,
+		`Ready:` + fmt.Sprintf("%v", this.Ready) + `,`,
+		`}`,
+	}, "")
+	return s
+}
 func valueToStringAgent(v interface{}) string {
 	rv := reflect.ValueOf(v)
 	if rv.IsNil() {
@@ -312,10 +643,171 @@ func (m *ReshardRequest) Unmarshal(dAtA []byte) error {
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthAgent
 			}
-			if (iNdEx + skippy) < 0 {
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ConfirmHandoffRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowAgent
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ConfirmHandoffRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ConfirmHandoffRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Configs", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthAgent
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthAgent
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Configs = append(m.Configs, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipAgent(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthAgent
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ConfirmHandoffResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowAgent
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ConfirmHandoffResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ConfirmHandoffResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Ready", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthAgent
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthAgent
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Ready = append(m.Ready, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipAgent(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthAgent
 			}
 			if (iNdEx + skippy) > l {
@@ -333,6 +825,7 @@ func (m *ReshardRequest) Unmarshal(dAtA []byte) error {
 func skipAgent(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
+	depth := 0
 	for iNdEx < l {
 		var wire uint64
 		for shift := uint(0); ; shift += 7 {
@@ -364,10 +857,8 @@ func skipAgent(dAtA []byte) (n int, err error) {
 					break
 				}
 			}
-			return iNdEx, nil
 		case 1:
 			iNdEx += 8
-			return iNdEx, nil
 		case 2:
 			var length int
 			for shift := uint(0); ; shift += 7 {
@@ -388,55 +879,30 @@ func skipAgent(dAtA []byte) (n int, err error) {
 				return 0, ErrInvalidLengthAgent
 			}
 			iNdEx += length
-			if iNdEx < 0 {
-				return 0, ErrInvalidLengthAgent
-			}
-			return iNdEx, nil
 		case 3:
-			for {
-				var innerWire uint64
-				var start int = iNdEx
-				for shift := uint(0); ; shift += 7 {
-					if shift >= 64 {
-						return 0, ErrIntOverflowAgent
-					}
-					if iNdEx >= l {
-						return 0, io.ErrUnexpectedEOF
-					}
-					b := dAtA[iNdEx]
-					iNdEx++
-					innerWire |= (uint64(b) & 0x7F) << shift
-					if b < 0x80 {
-						break
-					}
-				}
-				innerWireType := int(innerWire & 0x7)
-				if innerWireType == 4 {
-					break
-				}
-				next, err := skipAgent(dAtA[start:])
-				if err != nil {
-					return 0, err
-				}
-				iNdEx = start + next
-				if iNdEx < 0 {
-					return 0, ErrInvalidLengthAgent
-				}
-			}
-			return iNdEx, nil
+			depth++
 		case 4:
-			return iNdEx, nil
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupAgent
+			}
+			depth--
 		case 5:
 			iNdEx += 4
-			return iNdEx, nil
 		default:
 			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
 		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthAgent
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
 	}
-	panic("unreachable")
+	return 0, io.ErrUnexpectedEOF
 }
 
 var (
-	ErrInvalidLengthAgent = fmt.Errorf("proto: negative length found during unmarshaling")
-	ErrIntOverflowAgent   = fmt.Errorf("proto: integer overflow")
+	ErrInvalidLengthAgent        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowAgent          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupAgent = fmt.Errorf("proto: unexpected end of group")
 )
@@ -9,7 +9,8 @@ import (
 // FuncScrapingServiceServer is an implementation of ScrapingServiceServer that
 // uses function fields to implement the interface. Useful for tests.
 type FuncScrapingServiceServer struct {
-	ReshardFunc func(context.Context, *ReshardRequest) (*empty.Empty, error)
+	ReshardFunc        func(context.Context, *ReshardRequest) (*empty.Empty, error)
+	ConfirmHandoffFunc func(context.Context, *ConfirmHandoffRequest) (*ConfirmHandoffResponse, error)
 }
 
 // Reshard implements ScrapingServiceServer.
@@ -19,3 +20,11 @@ func (f *FuncScrapingServiceServer) Reshard(ctx context.Context, req *ReshardReq
 	}
 	panic("ReshardFunc is nil")
 }
+
+// ConfirmHandoff implements ScrapingServiceServer.
+func (f *FuncScrapingServiceServer) ConfirmHandoff(ctx context.Context, req *ConfirmHandoffRequest) (*ConfirmHandoffResponse, error) {
+	if f.ConfirmHandoffFunc != nil {
+		return f.ConfirmHandoffFunc(ctx, req)
+	}
+	panic("ConfirmHandoffFunc is nil")
+}
@@ -23,8 +23,14 @@ type Client struct {
 
 // New creates a new Client.
 func New(addr string) *Client {
+	return NewWithBearerToken(addr, "")
+}
+
+// NewWithBearerToken creates a new Client which authenticates to the Agent
+// with a bearer token. No token is sent when token is empty.
+func NewWithBearerToken(addr, token string) *Client {
 	return &Client{
-		PrometheusClient: &prometheusClient{addr: addr},
+		PrometheusClient: &prometheusClient{addr: addr, token: token},
 	}
 }
 
@@ -52,10 +58,33 @@ type PrometheusClient interface {
 	// DeleteConfiguration removes a named configuration from the config
 	// management KV store.
 	DeleteConfiguration(ctx context.Context, name string) error
+
+	// ListConfigRevisions returns the recorded revisions of a named
+	// configuration, oldest first.
+	ListConfigRevisions(ctx context.Context, name string) (*configapi.ListConfigRevisionsResponse, error)
+
+	// RollbackConfiguration restores a named configuration to one of its
+	// recorded revisions.
+	RollbackConfiguration(ctx context.Context, name string, revision int) (*configapi.RollbackConfigurationResponse, error)
+
+	// ValidateConfigurations validates a set of configurations without
+	// storing them, returning the changes applying them would make. When
+	// prune is true, configurations not in cfgs would be deleted.
+	ValidateConfigurations(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error)
+
+	// ApplyConfigurations atomically applies a set of configurations to the
+	// config management KV store. When prune is true, configurations not in
+	// cfgs are deleted.
+	ApplyConfigurations(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error)
+
+	// ClusterStatus returns the nodes in the scraping service cluster and
+	// the owners of every configuration.
+	ClusterStatus(ctx context.Context) (*configapi.ClusterStatusResponse, error)
 }
 
 type prometheusClient struct {
-	addr string
+	addr  string
+	token string
 }
 
 func (c *prometheusClient) Instances(ctx context.Context) ([]string, error) {
@@ -129,11 +158,88 @@ func (c *prometheusClient) DeleteConfiguration(ctx context.Context, name string)
 	return unmarshalPrometheusAPIResponse(resp.Body, nil)
 }
 
+func (c *prometheusClient) ListConfigRevisions(ctx context.Context, name string) (*configapi.ListConfigRevisionsResponse, error) {
+	url := fmt.Sprintf("%s/agent/api/v1/configs/%s/revisions", c.addr, name)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var data configapi.ListConfigRevisionsResponse
+	err = unmarshalPrometheusAPIResponse(resp.Body, &data)
+	return &data, err
+}
+
+func (c *prometheusClient) RollbackConfiguration(ctx context.Context, name string, revision int) (*configapi.RollbackConfigurationResponse, error) {
+	url := fmt.Sprintf("%s/agent/api/v1/config/%s/rollback/%d", c.addr, name, revision)
+
+	resp, err := c.doRequest(ctx, "POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var data configapi.RollbackConfigurationResponse
+	err = unmarshalPrometheusAPIResponse(resp.Body, &data)
+	return &data, err
+}
+
+func (c *prometheusClient) ValidateConfigurations(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+	url := fmt.Sprintf("%s/agent/api/v1/configs/validate", c.addr)
+	return c.postConfigurations(ctx, url, cfgs, prune)
+}
+
+func (c *prometheusClient) ApplyConfigurations(ctx context.Context, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+	url := fmt.Sprintf("%s/agent/api/v1/configs/apply", c.addr)
+	return c.postConfigurations(ctx, url, cfgs, prune)
+}
+
+func (c *prometheusClient) postConfigurations(ctx context.Context, url string, cfgs []*instance.Config, prune bool) (*configapi.ApplyConfigurationsResponse, error) {
+	req := configapi.ApplyConfigurationsRequest{Prune: prune}
+	for _, cfg := range cfgs {
+		bb, err := instance.MarshalConfig(cfg, false)
+		if err != nil {
+			return nil, err
+		}
+		req.Configs = append(req.Configs, configapi.ConfigurationValue{Name: cfg.Name, Value: string(bb)})
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var data configapi.ApplyConfigurationsResponse
+	err = unmarshalPrometheusAPIResponse(resp.Body, &data)
+	return &data, err
+}
+
+func (c *prometheusClient) ClusterStatus(ctx context.Context) (*configapi.ClusterStatusResponse, error) {
+	url := fmt.Sprintf("%s/agent/api/v1/cluster/status", c.addr)
+
+	resp, err := c.doRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var data configapi.ClusterStatusResponse
+	err = unmarshalPrometheusAPIResponse(resp.Body, &data)
+	return &data, err
+}
+
 func (c *prometheusClient) doRequest(ctx context.Context, method string, url string, body io.Reader) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 	return http.DefaultClient.Do(req)
 }
 
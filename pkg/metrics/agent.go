@@ -14,9 +14,12 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/grafana/agent/pkg/metrics/cluster"
 	"github.com/grafana/agent/pkg/metrics/cluster/client"
+	"github.com/grafana/agent/pkg/metrics/ha"
 	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/otlp"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/promql"
 	"google.golang.org/grpc"
 )
 
@@ -29,6 +32,7 @@ var DefaultConfig = Config{
 	ServiceConfig:          cluster.DefaultConfig,
 	ServiceClientConfig:    client.DefaultConfig,
 	InstanceMode:           instance.DefaultMode,
+	HA:                     ha.DefaultConfig,
 }
 
 // Config defines the configuration for the entire set of Prometheus client
@@ -38,11 +42,14 @@ type Config struct {
 	WALDir                 string                `yaml:"wal_directory,omitempty"`
 	WALCleanupAge          time.Duration         `yaml:"wal_cleanup_age,omitempty"`
 	WALCleanupPeriod       time.Duration         `yaml:"wal_cleanup_period,omitempty"`
+	WALDiskQuota           int64                 `yaml:"wal_disk_quota_bytes,omitempty"`
 	ServiceConfig          cluster.Config        `yaml:"scraping_service,omitempty"`
 	ServiceClientConfig    client.Config         `yaml:"scraping_service_client,omitempty"`
 	Configs                []instance.Config     `yaml:"configs,omitempty,omitempty"`
 	InstanceRestartBackoff time.Duration         `yaml:"instance_restart_backoff,omitempty"`
 	InstanceMode           instance.Mode         `yaml:"instance_mode,omitempty"`
+	OTLPReceiver           *otlp.Config          `yaml:"otlp_receiver,omitempty"`
+	HA                     ha.Config             `yaml:"ha,omitempty"`
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -66,6 +73,10 @@ func (c *Config) ApplyDefaults() error {
 		return errors.New("no wal_directory configured")
 	}
 
+	if c.WALDiskQuota < 0 {
+		return errors.New("wal_disk_quota_bytes must not be negative")
+	}
+
 	if c.ServiceConfig.Enabled && len(c.Configs) > 0 {
 		return errors.New("cannot use configs when scraping_service mode is enabled")
 	}
@@ -92,6 +103,34 @@ func (c *Config) ApplyDefaults() error {
 		usedNames[name] = struct{}{}
 	}
 
+	if c.OTLPReceiver != nil {
+		if err := c.OTLPReceiver.Validate(); err != nil {
+			return err
+		}
+		if _, ok := usedNames[c.OTLPReceiver.Instance]; !ok && !c.ServiceConfig.Enabled {
+			return fmt.Errorf("otlp_receiver instance %q does not exist", c.OTLPReceiver.Instance)
+		}
+	}
+
+	if c.HA.Enabled {
+		if err := c.HA.Validate(); err != nil {
+			return err
+		}
+		if c.Global.Prometheus.ExternalLabels.Get(c.HA.ClusterLabel) == "" {
+			return fmt.Errorf("ha requires the %q external label to be set", c.HA.ClusterLabel)
+		}
+	}
+
+	// Replicated configs are given their own replica and cluster labels,
+	// which would conflict with the same labels set globally.
+	if c.ServiceConfig.Enabled && c.ServiceConfig.ReplicationFactor > 1 {
+		for _, name := range []string{c.ServiceConfig.ReplicaLabel, c.ServiceConfig.ClusterLabel} {
+			if name != "" && c.Global.Prometheus.ExternalLabels.Has(name) {
+				return fmt.Errorf("external label %q is set by scraping_service for replicated configs and must not be set in global external_labels", name)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -100,6 +139,7 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&c.WALDir, "prometheus.wal-directory", "", "base directory to store the WAL in")
 	f.DurationVar(&c.WALCleanupAge, "prometheus.wal-cleanup-age", DefaultConfig.WALCleanupAge, "remove abandoned (unused) WALs older than this")
 	f.DurationVar(&c.WALCleanupPeriod, "prometheus.wal-cleanup-period", DefaultConfig.WALCleanupPeriod, "how often to check for abandoned WALs")
+	f.Int64Var(&c.WALDiskQuota, "prometheus.wal-disk-quota-bytes", DefaultConfig.WALDiskQuota, "maximum size of the WAL directory in bytes. 0 disables the quota")
 	f.DurationVar(&c.InstanceRestartBackoff, "prometheus.instance-restart-backoff", DefaultConfig.InstanceRestartBackoff, "how long to wait before restarting a failed Prometheus instance")
 
 	c.ServiceConfig.RegisterFlagsWithPrefix("prometheus.service.", f)
@@ -127,6 +167,13 @@ type Agent struct {
 
 	cluster *cluster.Cluster
 
+	// queryEngine evaluates PromQL queries against instances.
+	queryEngine *promql.Engine
+
+	otlp *otlp.Receiver
+
+	ha *ha.Elector
+
 	stopped  bool
 	stopOnce sync.Once
 	actor    chan func()
@@ -144,6 +191,7 @@ func newAgent(reg prometheus.Registerer, cfg Config, logger log.Logger, fact ins
 		reg:             reg,
 		actor:           make(chan func(), 1),
 	}
+	a.queryEngine = newQueryEngine(a.logger)
 
 	a.bm = instance.NewBasicManager(instance.BasicManagerConfig{
 		InstanceRestartBackoff: cfg.InstanceRestartBackoff,
@@ -160,6 +208,9 @@ func newAgent(reg prometheus.Registerer, cfg Config, logger log.Logger, fact ins
 		return nil, err
 	}
 
+	a.otlp = otlp.New(a.logger, reg, a.mm)
+	a.ha = ha.New(a.logger, reg, a.mm)
+
 	if err := a.ApplyConfig(cfg); err != nil {
 		return nil, err
 	}
@@ -182,7 +233,19 @@ func (a *Agent) newInstance(c instance.Config) (instance.ManagedInstance, error)
 		instanceLabel: c.Name,
 	}, a.reg)
 
-	return a.instanceFactory(reg, c, a.cfg.WALDir, a.logger)
+	inst, err := a.instanceFactory(reg, c, a.cfg.WALDir, a.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Instances of agents which aren't the HA leader start with remote_write
+	// paused. The elector keeps them in sync afterwards.
+	if p, ok := inst.(interface{ SetRemoteWritePaused(bool) error }); ok {
+		if err := p.SetRemoteWritePaused(!a.ha.IsLeader()); err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
 }
 
 // Validate will validate the incoming Config and mutate it to apply defaults.
@@ -216,7 +279,9 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 	// 2. Basic manager
 	// 3. Modal Manager
 	// 4. Cluster
-	// 5. Local configs
+	// 5. OTLP receiver
+	// 6. HA elector
+	// 7. Local configs
 
 	if a.cleaner != nil {
 		a.cleaner.Stop()
@@ -227,6 +292,7 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 		cfg.WALDir,
 		cfg.WALCleanupAge,
 		cfg.WALCleanupPeriod,
+		cfg.WALDiskQuota,
 	)
 
 	a.bm.UpdateManagerConfig(instance.BasicManagerConfig{
@@ -241,6 +307,15 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 		return fmt.Errorf("failed to apply cluster config: %w", err)
 	}
 
+	if err := a.otlp.ApplyConfig(cfg.OTLPReceiver); err != nil {
+		return err
+	}
+
+	haCluster := cfg.Global.Prometheus.ExternalLabels.Get(cfg.HA.ClusterLabel)
+	if err := a.ha.ApplyConfig(cfg.HA, cfg.ServiceConfig.KVStore, haCluster); err != nil {
+		return fmt.Errorf("failed to apply ha config: %w", err)
+	}
+
 	// Queue an actor in the background to sync the instances. This is required
 	// because creating both this function and newInstance grab the mutex.
 	oldConfig := a.cfg
@@ -313,6 +388,10 @@ func (a *Agent) Stop() {
 
 	a.cluster.Stop()
 
+	a.otlp.Stop()
+
+	a.ha.Stop()
+
 	a.cleaner.Stop()
 
 	// Only need to stop the ModalManager, which will passthrough everything to the
@@ -10,8 +10,13 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/cluster"
 	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/otlp"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
@@ -58,6 +63,59 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			expect: errors.New("prometheus instance names must be unique. found multiple instances with name instance"),
 		},
+		{
+			name: "otlp receiver",
+			mutator: func(c *Config) {
+				c.OTLPReceiver = &otlp.Config{
+					Instance:  "instance",
+					Protocols: map[string]interface{}{"grpc": nil},
+				}
+			},
+			expect: nil,
+		},
+		{
+			name: "otlp receiver with unknown instance",
+			mutator: func(c *Config) {
+				c.OTLPReceiver = &otlp.Config{
+					Instance:  "missing",
+					Protocols: map[string]interface{}{"grpc": nil},
+				}
+			},
+			expect: errors.New(`otlp_receiver instance "missing" does not exist`),
+		},
+		{
+			name: "otlp receiver with unsupported protocol",
+			mutator: func(c *Config) {
+				c.OTLPReceiver = &otlp.Config{
+					Instance:  "instance",
+					Protocols: map[string]interface{}{"thrift": nil},
+				}
+			},
+			expect: errors.New(`unsupported otlp_receiver protocol "thrift". supported values 'grpc', 'http'`),
+		},
+		{
+			name: "replicated configs with global cluster label",
+			mutator: func(c *Config) {
+				c.Configs = nil
+				c.ServiceConfig = cluster.DefaultConfig
+				c.ServiceConfig.Enabled = true
+				c.ServiceConfig.ReplicationFactor = 2
+				c.Global.Prometheus.ExternalLabels = labels.FromStrings("cluster", "prod")
+			},
+			expect: errors.New(`external label "cluster" is set by scraping_service for replicated configs and must not be set in global external_labels`),
+		},
+		{
+			name: "replicated configs without cluster label",
+			mutator: func(c *Config) {
+				c.Configs = nil
+				c.ServiceConfig = cluster.DefaultConfig
+				c.ServiceConfig.Enabled = true
+				c.ServiceConfig.ReplicationFactor = 2
+				c.ServiceConfig.ClusterLabel = ""
+				c.Global.Prometheus.ExternalLabels = labels.FromStrings("cluster", "prod")
+			},
+			expect: nil,
+		},
 	}
 
 	for _, tc := range tt {
@@ -281,6 +339,10 @@ func (i *fakeInstance) TargetsActive() map[string][]*scrape.Target {
 	return nil
 }
 
+func (i *fakeInstance) TargetsDropped() map[string][]*scrape.Target {
+	return nil
+}
+
 func (i *fakeInstance) StorageDirectory() string {
 	return ""
 }
@@ -289,6 +351,18 @@ func (i *fakeInstance) Appender(ctx context.Context) storage.Appender {
 	return nil
 }
 
+func (i *fakeInstance) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return nil, fmt.Errorf("can't query fakeInstance")
+}
+
+func (i *fakeInstance) RuleGroups() []*rules.Group {
+	return nil
+}
+
+func (i *fakeInstance) CardinalityStats(_ int) (wal.CardinalityStats, error) {
+	return wal.CardinalityStats{}, nil
+}
+
 type fakeInstanceFactory struct {
 	mut   sync.Mutex
 	mocks []*fakeInstance
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/wal"
+)
+
+// defaultCardinalityLimit is the number of entries returned for each list of
+// stats when no limit is given, matching the Prometheus TSDB status page.
+const defaultCardinalityLimit = 10
+
+// tsdbStatusResult is the data returned by the TSDB status API. It matches
+// the format used by the Prometheus HTTP API, with the addition of series
+// churn.
+type tsdbStatusResult struct {
+	HeadStats                   headStats          `json:"headStats"`
+	SeriesCountByMetricName     []tsdbStat         `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []tsdbStat         `json:"labelValueCountByLabelName"`
+	SeriesCountByLabelValuePair []tsdbStat         `json:"seriesCountByLabelValuePair"`
+	SeriesChurn                 []seriesChurnStats `json:"seriesChurn"`
+}
+
+type headStats struct {
+	NumSeries uint64 `json:"numSeries"`
+}
+
+type tsdbStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// seriesChurnStats is the number of series created and removed between two
+// truncations of the WAL.
+type seriesChurnStats struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Created uint64    `json:"created"`
+	Removed uint64    `json:"removed"`
+}
+
+// TSDBStatusHandler writes cardinality statistics computed from the series
+// held in memory by an instance's WAL. It implements the Prometheus
+// /api/v1/status/tsdb endpoint, including a limit parameter for the number of
+// entries in each list, and also returns the series churn of the most recent
+// WAL truncation periods.
+func (a *Agent) TSDBStatusHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultCardinalityLimit
+	if s := r.FormValue("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, errors.New("invalid parameter \"limit\": must be a positive integer"))
+			return
+		}
+	}
+
+	instanceName := mux.Vars(r)["instance"]
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		a.writeQueryError(w, http.StatusNotFound, queryErrorNotFound, err)
+		return
+	}
+
+	stats, err := inst.CardinalityStats(limit)
+	if err != nil {
+		a.writeQueryError(w, http.StatusServiceUnavailable, queryErrorInternal, err)
+		return
+	}
+
+	res := tsdbStatusResult{
+		HeadStats:                   headStats{NumSeries: uint64(stats.NumSeries)},
+		SeriesCountByMetricName:     tsdbStats(stats.SeriesCountByMetricName),
+		LabelValueCountByLabelName:  tsdbStats(stats.LabelValueCountByLabelName),
+		SeriesCountByLabelValuePair: tsdbStats(stats.SeriesCountByLabelValuePair),
+		SeriesChurn:                 make([]seriesChurnStats, 0, len(stats.Churn)),
+	}
+	for _, p := range stats.Churn {
+		res.SeriesChurn = append(res.SeriesChurn, seriesChurnStats{
+			Start:   p.Start,
+			End:     p.End,
+			Created: uint64(p.Created),
+			Removed: uint64(p.Removed),
+		})
+	}
+
+	a.writeQueryResponse(w, http.StatusOK, queryResponse{Status: "success", Data: res})
+}
+
+func tsdbStats(in []wal.CardinalityStat) []tsdbStat {
+	out := make([]tsdbStat, 0, len(in))
+	for _, s := range in {
+		out = append(out, tsdbStat{Name: s.Name, Value: uint64(s.Count)})
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/wal"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_TSDBStatusHandler(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := wal.NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer s.Close()
+
+	app := s.Appender(context.Background())
+	for _, lset := range []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "a"),
+		labels.FromStrings("__name__", "up", "job", "b"),
+		labels.FromStrings("__name__", "scrape_duration_seconds", "job", "a"),
+	} {
+		_, err := app.Append(0, lset, 10, 1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	cfg := makeInstanceConfig("test_instance")
+	a := newScrapeDebugAgent(t, cfg, &mockInstanceCardinality{s: s})
+
+	t.Run("stats", func(t *testing.T) {
+		rr := tsdbStatus(t, a, "test_instance", "limit=1")
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		var resp struct {
+			Status string           `json:"status"`
+			Data   tsdbStatusResult `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, "success", resp.Status)
+
+		require.Equal(t, uint64(3), resp.Data.HeadStats.NumSeries)
+		require.Equal(t, []tsdbStat{{Name: "up", Value: 2}}, resp.Data.SeriesCountByMetricName)
+		require.Equal(t, []tsdbStat{{Name: "__name__", Value: 2}}, resp.Data.LabelValueCountByLabelName)
+		require.Equal(t, []tsdbStat{{Name: "__name__=up", Value: 2}}, resp.Data.SeriesCountByLabelValuePair)
+		require.Len(t, resp.Data.SeriesChurn, 1)
+		require.Equal(t, uint64(3), resp.Data.SeriesChurn[0].Created)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		rr := tsdbStatus(t, a, "test_instance", "limit=-1")
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+	})
+
+	t.Run("missing instance", func(t *testing.T) {
+		rr := tsdbStatus(t, a, "missing", "")
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+}
+
+func tsdbStatus(t *testing.T, a *Agent, instanceName, query string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest("GET", "/agent/api/v1/instances/"+instanceName+"/api/v1/status/tsdb?"+query, nil)
+	r = mux.SetURLVars(r, map[string]string{"instance": instanceName})
+
+	rr := httptest.NewRecorder()
+	a.TSDBStatusHandler(rr, r)
+	return rr
+}
+
+type mockInstanceCardinality struct {
+	mockInstanceScrape
+	s *wal.Storage
+}
+
+func (i *mockInstanceCardinality) CardinalityStats(limit int) (wal.CardinalityStats, error) {
+	return i.s.CardinalityStats(limit), nil
+}
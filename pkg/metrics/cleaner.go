@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -12,6 +13,7 @@ import (
 	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
 	promwal "github.com/prometheus/prometheus/tsdb/wal"
 )
 
@@ -19,6 +21,10 @@ import (
 const (
 	DefaultCleanupAge    = 12 * time.Hour
 	DefaultCleanupPeriod = 30 * time.Minute
+
+	// DefaultQuotaCheckPeriod is how often the size of the WAL directory is
+	// checked against the disk quota, if one is configured.
+	DefaultQuotaCheckPeriod = time.Minute
 )
 
 var (
@@ -72,6 +78,41 @@ var (
 			Help: "Time spent performing each periodic WAL cleanup",
 		},
 	)
+
+	walDirectoryBytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "agent_prometheus_cleaner_wal_directory_bytes",
+			Help: "Size of the WAL directory in bytes, as of the last disk quota check",
+		},
+	)
+
+	quotaExceeded = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_prometheus_cleaner_quota_exceeded_total",
+			Help: "Number of disk quota checks which found the WAL directory over quota",
+		},
+	)
+
+	quotaRemovedAbandoned = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_prometheus_cleaner_quota_removed_abandoned_total",
+			Help: "Number of abandoned WALs removed to stay within the disk quota",
+		},
+	)
+
+	quotaFreedBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_prometheus_cleaner_quota_freed_bytes_total",
+			Help: "Number of bytes freed to stay within the disk quota",
+		},
+	)
+
+	quotaEvictedSamples = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "agent_prometheus_cleaner_quota_evicted_samples_total",
+			Help: "Number of samples lost from evicting WAL segments of managed instances to stay within the disk quota",
+		},
+	)
 )
 
 // lastModifiedFunc gets the last modified time of the most recent segment of a WAL
@@ -105,9 +146,19 @@ func lastModified(path string) (time.Time, error) {
 	return segmentFile.ModTime(), nil
 }
 
+// segmentEvicter is implemented by instances which can evict the oldest
+// segments of their WAL to free disk space.
+type segmentEvicter interface {
+	EvictWALSegments(bytes int64) (wal.EvictStats, error)
+}
+
 // WALCleaner periodically checks for Write Ahead Logs (WALs) that are not associated
 // with any active instance.ManagedInstance and have not been written to in some configured
 // amount of time and deletes them.
+//
+// If a disk quota is configured, WALCleaner also keeps the total size of the
+// WAL directory within the quota, first by deleting abandoned WALs and then
+// by evicting the oldest segments of WALs used by managed instances.
 type WALCleaner struct {
 	logger          log.Logger
 	instanceManager instance.Manager
@@ -115,13 +166,16 @@ type WALCleaner struct {
 	walLastModified lastModifiedFunc
 	minAge          time.Duration
 	period          time.Duration
+	diskQuota       int64
+	quotaPeriod     time.Duration
 	done            chan bool
 }
 
 // NewWALCleaner creates a new cleaner that looks for abandoned WALs in the given
 // directory and removes them if they haven't been modified in over minAge. Starts
-// a goroutine to periodically run the cleanup method in a loop
-func NewWALCleaner(logger log.Logger, manager instance.Manager, walDirectory string, minAge time.Duration, period time.Duration) *WALCleaner {
+// a goroutine to periodically run the cleanup method in a loop. A diskQuota
+// greater than 0 limits the total size of walDirectory in bytes.
+func NewWALCleaner(logger log.Logger, manager instance.Manager, walDirectory string, minAge time.Duration, period time.Duration, diskQuota int64) *WALCleaner {
 	c := &WALCleaner{
 		logger:          log.With(logger, "component", "cleaner"),
 		instanceManager: manager,
@@ -129,6 +183,8 @@ func NewWALCleaner(logger log.Logger, manager instance.Manager, walDirectory str
 		walLastModified: lastModified,
 		minAge:          DefaultCleanupAge,
 		period:          DefaultCleanupPeriod,
+		diskQuota:       diskQuota,
+		quotaPeriod:     DefaultQuotaCheckPeriod,
 		done:            make(chan bool),
 	}
 
@@ -218,23 +274,36 @@ func (c *WALCleaner) getAbandonedStorage(all []string, managed map[string]bool,
 	return out
 }
 
-// run cleans up abandoned WALs (if period != 0) in a loop periodically until stopped
+// run cleans up abandoned WALs (if period != 0) and enforces the disk quota
+// (if diskQuota > 0) in a loop periodically until stopped
 func (c *WALCleaner) run() {
+	var cleanupCh, quotaCh <-chan time.Time
+
 	// A period of 0 means don't run a cleanup task
-	if c.period == 0 {
-		return
+	if c.period > 0 {
+		ticker := time.NewTicker(c.period)
+		defer ticker.Stop()
+		cleanupCh = ticker.C
+	}
+	if c.diskQuota > 0 {
+		ticker := time.NewTicker(c.quotaPeriod)
+		defer ticker.Stop()
+		quotaCh = ticker.C
 	}
 
-	ticker := time.NewTicker(c.period)
-	defer ticker.Stop()
+	if cleanupCh == nil && quotaCh == nil {
+		return
+	}
 
 	for {
 		select {
 		case <-c.done:
 			level.Debug(c.logger).Log("msg", "stopping cleaner...")
 			return
-		case <-ticker.C:
+		case <-cleanupCh:
 			c.cleanup()
+		case <-quotaCh:
+			c.enforceQuota()
 		}
 	}
 }
@@ -265,6 +334,165 @@ func (c *WALCleaner) cleanup() {
 	cleanupTimes.Observe(time.Since(start).Seconds())
 }
 
+// enforceQuota frees space in the WAL directory if it's larger than the disk
+// quota. Abandoned WALs are removed first, oldest first, regardless of their
+// age. If the directory is still over quota, the oldest segments across WALs
+// of managed instances are evicted.
+func (c *WALCleaner) enforceQuota() {
+	size, err := fileutil.DirSize(c.walDirectory)
+	if os.IsNotExist(err) {
+		level.Debug(c.logger).Log("msg", "WAL storage path does not exist", "path", c.walDirectory, "err", err)
+		return
+	} else if err != nil {
+		level.Warn(c.logger).Log("msg", "unable to determine size of WAL directory", "path", c.walDirectory, "err", err)
+		return
+	}
+	walDirectoryBytes.Set(float64(size))
+
+	excess := size - c.diskQuota
+	if excess <= 0 {
+		return
+	}
+
+	quotaExceeded.Inc()
+	level.Warn(c.logger).Log("msg", "WAL directory exceeds disk quota", "size", size, "quota", c.diskQuota)
+
+	instances := c.instanceManager.ListInstances()
+	managed := c.getManagedStorage(instances)
+
+	excess -= c.removeAbandonedForQuota(c.getAllStorage(), managed, excess)
+	if excess <= 0 {
+		return
+	}
+
+	excess -= c.evictSegmentsForQuota(instances, excess)
+	if excess > 0 {
+		level.Warn(c.logger).Log("msg", "unable to free enough space to stay within disk quota", "excess", excess)
+	}
+}
+
+// removeAbandonedForQuota removes abandoned WALs, oldest first, until at least
+// excess bytes have been freed. Returns the number of bytes freed.
+func (c *WALCleaner) removeAbandonedForQuota(all []string, managed map[string]bool, excess int64) int64 {
+	type abandonedWAL struct {
+		dir   string
+		mtime time.Time
+		size  int64
+	}
+
+	var abandoned []abandonedWAL
+	for _, dir := range all {
+		if managed[dir] {
+			continue
+		}
+
+		size, err := fileutil.DirSize(dir)
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "unable to determine size of abandoned WAL", "name", dir, "err", err)
+			continue
+		}
+
+		// WALs whose last modification time can't be determined are considered
+		// to be the oldest.
+		mtime, _ := c.walLastModified(wal.SubDirectory(dir))
+		abandoned = append(abandoned, abandonedWAL{dir: dir, mtime: mtime, size: size})
+	}
+
+	sort.Slice(abandoned, func(i, j int) bool {
+		return abandoned[i].mtime.Before(abandoned[j].mtime)
+	})
+
+	var freed int64
+	for _, a := range abandoned {
+		if freed >= excess {
+			break
+		}
+
+		level.Info(c.logger).Log("msg", "deleting abandoned WAL to stay within disk quota", "name", a.dir, "size", a.size)
+		if err := os.RemoveAll(a.dir); err != nil {
+			level.Error(c.logger).Log("msg", "failed to delete abandoned WAL", "name", a.dir, "err", err)
+			cleanupRunsErrors.Inc()
+			continue
+		}
+
+		freed += a.size
+		quotaRemovedAbandoned.Inc()
+		quotaFreedBytes.Add(float64(a.size))
+	}
+	return freed
+}
+
+// evictSegmentsForQuota evicts the oldest segments across WALs of managed
+// instances until at least excess bytes have been freed. The segment each WAL
+// is currently writing to is never evicted. Returns the number of bytes freed.
+func (c *WALCleaner) evictSegmentsForQuota(instances map[string]instance.ManagedInstance, excess int64) int64 {
+	type segment struct {
+		instance string
+		mtime    time.Time
+		size     int64
+	}
+
+	var segments []segment
+	for name, inst := range instances {
+		if _, ok := inst.(segmentEvicter); !ok {
+			continue
+		}
+
+		walDir := wal.SubDirectory(inst.StorageDirectory())
+		first, last, err := promwal.Segments(walDir)
+		if err != nil {
+			segmentError.WithLabelValues(inst.StorageDirectory()).Inc()
+			level.Warn(c.logger).Log("msg", "unable to list WAL segments", "name", name, "err", err)
+			continue
+		}
+
+		for i := first; i < last; i++ {
+			fi, err := os.Stat(promwal.SegmentName(walDir, i))
+			if err != nil {
+				level.Warn(c.logger).Log("msg", "unable to stat WAL segment", "name", name, "segment", i, "err", err)
+				break
+			}
+			segments = append(segments, segment{instance: name, mtime: fi.ModTime(), size: fi.Size()})
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].mtime.Before(segments[j].mtime)
+	})
+
+	// Determine how many bytes to evict from each instance by picking the
+	// globally oldest segments.
+	var (
+		selected int64
+		toEvict  = make(map[string]int64)
+		order    []string
+	)
+	for _, s := range segments {
+		if selected >= excess {
+			break
+		}
+		if _, ok := toEvict[s.instance]; !ok {
+			order = append(order, s.instance)
+		}
+		toEvict[s.instance] += s.size
+		selected += s.size
+	}
+
+	var freed int64
+	for _, name := range order {
+		stats, err := instances[name].(segmentEvicter).EvictWALSegments(toEvict[name])
+		if err != nil {
+			level.Error(c.logger).Log("msg", "failed to evict WAL segments", "name", name, "err", err)
+			continue
+		}
+
+		freed += stats.Bytes
+		quotaFreedBytes.Add(float64(stats.Bytes))
+		quotaEvictedSamples.Add(float64(stats.DroppedSamples))
+	}
+	return freed
+}
+
 // Stop the cleaner and any background tasks running
 func (c *WALCleaner) Stop() {
 	close(c.done)
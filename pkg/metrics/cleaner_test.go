@@ -21,6 +21,7 @@ func TestWALCleaner_getAllStorageNoRoot(t *testing.T) {
 		walRoot,
 		DefaultCleanupAge,
 		DefaultCleanupPeriod,
+		0,
 	)
 
 	// Bogus WAL root that doesn't exist. Method should return no results
@@ -45,6 +46,7 @@ func TestWALCleaner_getAllStorageSuccess(t *testing.T) {
 		walRoot,
 		DefaultCleanupAge,
 		DefaultCleanupPeriod,
+		0,
 	)
 	wals := cleaner.getAllStorage()
 
@@ -71,6 +73,7 @@ func TestWALCleaner_getAbandonedStorageBeforeCutoff(t *testing.T) {
 		walRoot,
 		5*time.Minute,
 		DefaultCleanupPeriod,
+		0,
 	)
 
 	cleaner.walLastModified = func(path string) (time.Time, error) {
@@ -104,6 +107,7 @@ func TestWALCleaner_getAbandonedStorageAfterCutoff(t *testing.T) {
 		walRoot,
 		5*time.Minute,
 		DefaultCleanupPeriod,
+		0,
 	)
 
 	cleaner.walLastModified = func(path string) (time.Time, error) {
@@ -139,6 +143,7 @@ func TestWALCleaner_cleanup(t *testing.T) {
 		walRoot,
 		5*time.Minute,
 		DefaultCleanupPeriod,
+		0,
 	)
 
 	cleaner.walLastModified = func(path string) (time.Time, error) {
@@ -153,3 +158,51 @@ func TestWALCleaner_cleanup(t *testing.T) {
 	require.Error(t, err)
 	require.True(t, os.IsNotExist(err))
 }
+
+func TestWALCleaner_enforceQuota(t *testing.T) {
+	walRoot, err := ioutil.TempDir(os.TempDir(), "enforceQuota")
+	require.NoError(t, err)
+	defer os.RemoveAll(walRoot)
+
+	// Create two abandoned WALs of 1KiB each. Neither is old enough to be
+	// removed by the regular cleanup.
+	oldDir := filepath.Join(walRoot, "instance-old")
+	newDir := filepath.Join(walRoot, "instance-new")
+	for _, dir := range []string{oldDir, newDir} {
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data"), make([]byte, 1024), 0644))
+	}
+
+	now := time.Now()
+	logger := log.NewLogfmtLogger(os.Stderr)
+	manager := &instance.MockManager{}
+	manager.ListInstancesFunc = func() map[string]instance.ManagedInstance {
+		return make(map[string]instance.ManagedInstance)
+	}
+
+	cleaner := NewWALCleaner(
+		logger,
+		manager,
+		walRoot,
+		DefaultCleanupAge,
+		DefaultCleanupPeriod,
+		1500,
+	)
+	defer cleaner.Stop()
+
+	cleaner.walLastModified = func(path string) (time.Time, error) {
+		if filepath.Dir(path) == oldDir {
+			return now.Add(-time.Minute), nil
+		}
+		return now, nil
+	}
+
+	// The WAL directory is over quota; removing the oldest abandoned WAL is
+	// enough to get back under it.
+	cleaner.enforceQuota()
+
+	_, err = os.Stat(oldDir)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(newDir)
+	require.NoError(t, err)
+}
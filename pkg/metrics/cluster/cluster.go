@@ -3,8 +3,10 @@ package cluster
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 
+	"github.com/cortexproject/cortex/pkg/ring/kv"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/golang/protobuf/ptypes/empty"
@@ -35,9 +37,16 @@ type Cluster struct {
 	node *node
 
 	// store connects to a configstore for changes. storeAPI is an HTTP API for it.
-	store    *configstore.Remote
+	store    configstore.Store
 	storeAPI *configstore.API
 
+	// historyReg holds the metrics of the KV client used to record revisions
+	// of configs changed through storeAPI.
+	historyReg *util.Unregisterer
+
+	// auth authenticates requests to storeAPI and writes its audit log.
+	auth *configstore.Auth
+
 	// watcher watches the store and applies changes to an instance.Manager,
 	// triggering metrics to be collected and sent. configWatcher also does a
 	// complete refresh of its state on an interval.
@@ -70,14 +79,26 @@ func New(
 		return nil, fmt.Errorf("failed to initialize node membership: %w", err)
 	}
 
-	c.store, err = configstore.NewRemote(l, reg, cfg.KVStore, cfg.Enabled)
+	c.store, err = newStore(l, reg, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize configstore: %w", err)
 	}
 	c.storeAPI = configstore.NewAPI(l, c.store, c.storeValidate)
 	reg.MustRegister(c.storeAPI)
 
-	c.watcher, err = newConfigWatcher(l, cfg, c.store, im, c.node.Owns, validate)
+	c.historyReg = util.WrapWithUnregisterer(reg)
+	if err := c.applyHistory(cfg); err != nil {
+		return nil, fmt.Errorf("failed to initialize config history: %w", err)
+	}
+	if err := c.applyAuth(cfg); err != nil {
+		return nil, fmt.Errorf("failed to initialize config api auth: %w", err)
+	}
+
+	c.watcher, err = newConfigWatcher(l, cfg, c.store, im, c.node.Owns, validate, watcherHooks{
+		rebalance: c.node.Rebalance,
+		report:    c.node.ReportRefresh,
+		handoff:   c.node.ConfirmHandoff,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize configwatcher: %w", err)
 	}
@@ -87,6 +108,57 @@ func New(
 	return c, nil
 }
 
+// newStore creates the configstore.Store selected by cfg.
+func newStore(l log.Logger, reg prometheus.Registerer, cfg Config) (configstore.Store, error) {
+	switch cfg.ConfigStore {
+	case StoreFilesystem:
+		return configstore.NewFilesystem(l, cfg.ConfigDirectory)
+	case StoreKubernetes:
+		return configstore.NewKubernetes(l, cfg.Kubernetes)
+	default:
+		return configstore.NewRemote(l, reg, cfg.KVStore, cfg.Enabled)
+	}
+}
+
+// applyHistory sets the History used by storeAPI from cfg. Revisions are
+// stored in the shared KV store of the cluster, next to the prefix of the
+// configs.
+func (c *Cluster) applyHistory(cfg Config) error {
+	c.historyReg.UnregisterAll()
+
+	if !cfg.Enabled || cfg.ConfigHistoryLimit == 0 {
+		c.storeAPI.SetHistory(nil)
+		return nil
+	}
+
+	kvConfig := cfg.sharedKVStore()
+	kvConfig.Prefix = strings.TrimSuffix(kvConfig.Prefix, "/") + "_history/"
+
+	client, err := kv.NewClient(kvConfig, configstore.GetCodec(), kv.RegistererWithKVName(c.historyReg, "agent_config_history"))
+	if err != nil {
+		return err
+	}
+	c.storeAPI.SetHistory(configstore.NewHistory(client, cfg.ConfigHistoryLimit))
+	return nil
+}
+
+// applyAuth replaces the Auth used by storeAPI with one created from cfg.
+func (c *Cluster) applyAuth(cfg Config) error {
+	auth, err := configstore.NewAuth(c.log, cfg.APIAuth)
+	if err != nil {
+		return err
+	}
+	c.storeAPI.SetAuth(auth)
+
+	if c.auth != nil {
+		if err := c.auth.Close(); err != nil {
+			level.Warn(c.log).Log("msg", "failed to close config api audit log", "err", err)
+		}
+	}
+	c.auth = auth
+	return nil
+}
+
 func (c *Cluster) storeValidate(cfg *instance.Config) error {
 	c.mut.RLock()
 	defer c.mut.RUnlock()
@@ -128,12 +200,30 @@ func (c *Cluster) ApplyConfig(
 		return nil
 	}
 
+	if cfg.ConfigStore != c.cfg.ConfigStore || cfg.ConfigDirectory != c.cfg.ConfigDirectory || !util.CompareYAML(cfg.Kubernetes, c.cfg.Kubernetes) {
+		return fmt.Errorf("config_store, config_directory and kubernetes cannot be changed at runtime")
+	}
+
 	if err := c.node.ApplyConfig(cfg); err != nil {
 		return fmt.Errorf("failed to apply config to node membership: %w", err)
 	}
 
-	if err := c.store.ApplyConfig(cfg.Lifecycler.RingConfig.KVStore, cfg.Enabled); err != nil {
-		return fmt.Errorf("failed to apply config to config store: %w", err)
+	if remote, ok := c.store.(*configstore.Remote); ok {
+		if err := remote.ApplyConfig(cfg.Lifecycler.RingConfig.KVStore, cfg.Enabled); err != nil {
+			return fmt.Errorf("failed to apply config to config store: %w", err)
+		}
+	}
+
+	if cfg.Enabled != c.cfg.Enabled || cfg.ConfigHistoryLimit != c.cfg.ConfigHistoryLimit || !util.CompareYAML(cfg.sharedKVStore(), c.cfg.sharedKVStore()) {
+		if err := c.applyHistory(cfg); err != nil {
+			return fmt.Errorf("failed to apply config to config history: %w", err)
+		}
+	}
+
+	// Tokens are hidden when marshaled and can't be compared, so auth is
+	// recreated whenever the config changes.
+	if err := c.applyAuth(cfg); err != nil {
+		return fmt.Errorf("failed to apply config to config api auth: %w", err)
 	}
 
 	if err := c.watcher.ApplyConfig(cfg); err != nil {
@@ -165,6 +255,8 @@ func (c *Cluster) ApplyConfig(
 func (c *Cluster) WireAPI(r *mux.Router) {
 	c.storeAPI.WireAPI(r)
 	c.node.WireAPI(r)
+
+	r.HandleFunc("/agent/api/v1/cluster/status", c.ClusterStatus).Methods("GET")
 }
 
 // WireGRPC injects gRPC server handlers into the provided gRPC server.
@@ -184,6 +276,7 @@ func (c *Cluster) Stop() {
 		{"node", c.node.Stop},
 		{"config store", c.store.Close},
 		{"config watcher", c.watcher.Stop},
+		{"config api auth", c.auth.Close},
 	}
 	for _, dep := range deps {
 		err := dep.closer()
@@ -2,11 +2,13 @@ package cluster
 
 import (
 	"flag"
+	"fmt"
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/ring"
 	"github.com/cortexproject/cortex/pkg/ring/kv"
 	"github.com/grafana/agent/pkg/metrics/cluster/client"
+	"github.com/grafana/agent/pkg/metrics/instance/configstore"
 	flagutil "github.com/grafana/agent/pkg/util"
 )
 
@@ -21,8 +23,35 @@ type Config struct {
 	KVStore         kv.Config             `yaml:"kvstore"`
 	Lifecycler      ring.LifecyclerConfig `yaml:"lifecycler"`
 
+	// ConfigStore selects where configs are stored. ConfigDirectory is used
+	// when ConfigStore is StoreFilesystem and Kubernetes when ConfigStore is
+	// StoreKubernetes.
+	ConfigStore     string                       `yaml:"config_store"`
+	ConfigDirectory string                       `yaml:"config_directory"`
+	Kubernetes      configstore.KubernetesConfig `yaml:"kubernetes"`
+
+	// Number of revisions of each config kept in the KV store by the config
+	// management API. 0 disables recording revisions.
+	ConfigHistoryLimit int `yaml:"config_history_limit"`
+
+	// Authentication, authorization and audit logging for the config
+	// management API.
+	APIAuth configstore.AuthConfig `yaml:"api_auth,omitempty"`
+
 	DangerousAllowReadingFiles bool `yaml:"dangerous_allow_reading_files"`
 
+	// Number of agents that run each config. When greater than 1, each copy
+	// is given a ReplicaLabel external label set to the ID of the agent
+	// running it and a ClusterLabel external label set to the name of the
+	// config, so duplicate samples can be dropped by the remote_write
+	// endpoint.
+	ReplicationFactor int    `yaml:"replication_factor"`
+	ReplicaLabel      string `yaml:"replica_label"`
+	ClusterLabel      string `yaml:"cluster_label"`
+
+	WeightedSharding WeightedShardingConfig `yaml:"weighted_sharding"`
+	Handoff          HandoffConfig          `yaml:"handoff"`
+
 	// TODO(rfratto): deprecate scraping_service_client in Agent and replace with this.
 	Client client.Config `yaml:"-"`
 }
@@ -36,10 +65,43 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err != nil {
 		return err
 	}
-	c.Lifecycler.RingConfig.ReplicationFactor = 1
+
+	switch {
+	case c.ConfigStore != StoreKV && c.ConfigStore != StoreFilesystem && c.ConfigStore != StoreKubernetes:
+		return fmt.Errorf("unknown config_store %q: must be one of %q, %q or %q", c.ConfigStore, StoreKV, StoreFilesystem, StoreKubernetes)
+	case c.ConfigStore == StoreFilesystem && c.ConfigDirectory == "":
+		return fmt.Errorf("config_directory must be set when config_store is %q", StoreFilesystem)
+	case c.ConfigStore == StoreKubernetes && c.Kubernetes.Namespace == "":
+		return fmt.Errorf("kubernetes.namespace must be set when config_store is %q", StoreKubernetes)
+	case c.ConfigHistoryLimit < 0:
+		return fmt.Errorf("config_history_limit must not be negative")
+	case c.ReplicationFactor < 1:
+		return fmt.Errorf("replication_factor must be at least 1")
+	case c.ReplicationFactor > 1 && c.ReplicaLabel == "":
+		return fmt.Errorf("replica_label must be set when replication_factor is greater than 1")
+	case c.Handoff.Enabled && c.Handoff.CheckInterval <= 0:
+		return fmt.Errorf("handoff.check_interval must be greater than 0")
+	case c.Handoff.Enabled && c.Handoff.Timeout <= 0:
+		return fmt.Errorf("handoff.timeout must be greater than 0")
+	}
+	c.Lifecycler.RingConfig.ReplicationFactor = c.ReplicationFactor
 	return nil
 }
 
+// sharedKVStore returns the KV store used for state shared between agents,
+// such as measured weights, node statuses and config revisions. Configs
+// stored in a KV store keep that state next to them. Otherwise, it's kept in
+// the KV store of the ring, which is the only KV store that has to be
+// configured, using the prefix of the configs.
+func (c Config) sharedKVStore() kv.Config {
+	if c.ConfigStore == StoreKV {
+		return c.KVStore
+	}
+	cfg := c.Lifecycler.RingConfig.KVStore
+	cfg.Prefix = c.KVStore.Prefix
+	return cfg
+}
+
 // RegisterFlags adds the flags required to config the Server to the given
 // FlagSet.
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
@@ -52,7 +114,93 @@ func (c *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.BoolVar(&c.Enabled, prefix+"enabled", false, "enables the scraping service mode")
 	f.DurationVar(&c.ReshardInterval, prefix+"reshard-interval", time.Minute*1, "how often to manually reshard")
 	f.DurationVar(&c.ReshardTimeout, prefix+"reshard-timeout", time.Second*30, "timeout for cluster-wide reshards and local reshards. Timeout of 0s disables timeout.")
+	f.IntVar(&c.ReplicationFactor, prefix+"replication-factor", 1, "number of agents that run each config")
+	f.StringVar(&c.ReplicaLabel, prefix+"replica-label", "__replica__", "external label set to the agent ID when replication factor is greater than 1")
+	f.StringVar(&c.ClusterLabel, prefix+"cluster-label", "cluster", "external label set to the config name when replication factor is greater than 1. Empty to disable.")
+	f.StringVar(&c.ConfigStore, prefix+"config-store", StoreKV, "where configs are stored. One of kvstore, filesystem or kubernetes.")
+	f.StringVar(&c.ConfigDirectory, prefix+"config-directory", "", "directory of config files when config-store is filesystem")
+	f.IntVar(&c.ConfigHistoryLimit, prefix+"config-history-limit", 10, "number of revisions kept for each config. 0 disables recording revisions.")
 	c.KVStore.RegisterFlagsWithPrefix(prefix+"config-store.", "configurations/", f)
+	c.Kubernetes.RegisterFlagsWithPrefix(prefix+"kubernetes.", f)
 	c.Lifecycler.RegisterFlagsWithPrefix(prefix, f)
 	c.Client.GRPCClientConfig.RegisterFlagsWithPrefix(prefix, f)
+	c.WeightedSharding.RegisterFlagsWithPrefix(prefix+"weighted-sharding.", f)
+	c.Handoff.RegisterFlagsWithPrefix(prefix+"handoff.", f)
+}
+
+// Supported config stores.
+const (
+	StoreKV         = "kvstore"
+	StoreFilesystem = "filesystem"
+	StoreKubernetes = "kubernetes"
+)
+
+// Methods of measuring the weight of a config.
+const (
+	MeasureSeries  = "series"
+	MeasureTargets = "targets"
+	MeasureNone    = "none"
+)
+
+// WeightedShardingConfig configures sharding configs by their load instead of
+// by their count.
+type WeightedShardingConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// How the weight of configs which don't declare a shard_weight is
+	// measured by the agent running them.
+	MeasuredWeight string `yaml:"measured_weight"`
+
+	// Maximum weight assigned to a node, relative to the average weight per
+	// node. Higher values move fewer configs during a reshard at the cost of
+	// a less even distribution.
+	MaxLoadFactor float64 `yaml:"max_load_factor"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *WeightedShardingConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain WeightedShardingConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	switch c.MeasuredWeight {
+	case MeasureSeries, MeasureTargets, MeasureNone:
+	default:
+		return fmt.Errorf("unknown measured_weight %q: must be one of %q, %q or %q", c.MeasuredWeight, MeasureSeries, MeasureTargets, MeasureNone)
+	}
+	if c.MaxLoadFactor < 1 {
+		return fmt.Errorf("max_load_factor must be at least 1")
+	}
+	return nil
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given
+// FlagSet with a specified prefix.
+func (c *WeightedShardingConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&c.Enabled, prefix+"enabled", false, "shard configs by their weight instead of by their count")
+	f.StringVar(&c.MeasuredWeight, prefix+"measured-weight", MeasureSeries, "how to measure the weight of configs without a shard_weight. One of series, targets or none.")
+	f.Float64Var(&c.MaxLoadFactor, prefix+"max-load-factor", 1.25, "maximum weight assigned to a node relative to the average weight per node")
+}
+
+// HandoffConfig configures handing off configs which changed owners. When
+// enabled, the previous owner of a config keeps running it until its new
+// owners confirm they are ready, so moved configs are scraped without gaps.
+type HandoffConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// How often the previous owner asks the new owners whether they are ready.
+	CheckInterval time.Duration `yaml:"check_interval"`
+
+	// Maximum time the previous owner keeps running a config before
+	// releasing it without confirmation.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given
+// FlagSet with a specified prefix.
+func (c *HandoffConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&c.Enabled, prefix+"enabled", false, "keep running configs that changed owners until the new owners are ready")
+	f.DurationVar(&c.CheckInterval, prefix+"check-interval", 5*time.Second, "how often to check whether the new owners of configs are ready")
+	f.DurationVar(&c.Timeout, prefix+"timeout", 5*time.Minute, "maximum time to keep running a config that changed owners")
 }
@@ -0,0 +1,22 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_sharedKVStore(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.KVStore.Store = "etcd"
+	cfg.Lifecycler.RingConfig.KVStore.Store = "consul"
+
+	require.Equal(t, cfg.KVStore, cfg.sharedKVStore())
+
+	// Configs stored outside of a KV store fall back to the KV store of the
+	// ring, which is always configured.
+	cfg.ConfigStore = StoreFilesystem
+	shared := cfg.sharedKVStore()
+	require.Equal(t, "consul", shared.Store)
+	require.Equal(t, cfg.KVStore.Prefix, shared.Prefix)
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/grafana/agent/pkg/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/scrape"
 )
 
 var (
@@ -32,25 +34,66 @@ type configWatcher struct {
 	stopped bool
 	stop    context.CancelFunc
 
-	store    configstore.Store
-	im       instance.Manager
-	owns     OwnershipFunc
-	validate ValidationFunc
+	store     configstore.Store
+	im        instance.Manager
+	owns      OwnershipFunc
+	rebalance RebalanceFunc
+	report    ReportFunc
+	handoff   HandoffFunc
+	validate  ValidationFunc
 
 	refreshMut  sync.Mutex
 	instanceMut sync.Mutex
 	instances   map[string]struct{}
+
+	// settled holds the instances which were running during the previous
+	// refresh. Only their weights are measured, since the weight of an
+	// instance which just started isn't known yet.
+	settled map[string]struct{}
+
+	// releasing holds the instances which changed owners but are kept running
+	// until their new owners are ready, along with when they started being
+	// released.
+	releasing map[string]time.Time
 }
 
 // OwnershipFunc should determine if a given keep is owned by the caller.
 type OwnershipFunc = func(key string) (bool, error)
 
+// RebalanceFunc should recompute ownership given the weight declared by each
+// config and the weights measured from the configs running locally.
+type RebalanceFunc = func(ctx context.Context, declared, measured map[string]int64) error
+
+// ReportFunc should publish the result of a refresh: when it finished, the
+// number of configs running afterwards and the error it returned.
+type ReportFunc = func(finished time.Time, running int, err error)
+
+// HandoffFunc should ask the new owners of the given keys whether they are
+// ready to run them, returning the keys which all of their new owners
+// confirmed. Confirmed keys should be returned even if an error occurred.
+type HandoffFunc = func(ctx context.Context, keys []string) (ready []string, err error)
+
 // ValidationFunc should validate a config.
 type ValidationFunc = func(*instance.Config) error
 
+// watcherHooks are the optional functions used by a configWatcher. A nil
+// function disables the feature which uses it.
+type watcherHooks struct {
+	// rebalance is called with the weights of all configs before ownership
+	// is checked when weighted sharding is enabled.
+	rebalance RebalanceFunc
+	// report is called after every refresh.
+	report ReportFunc
+	// handoff is called to check whether the new owners of configs are ready
+	// when handoff is enabled.
+	handoff HandoffFunc
+}
+
 // newConfigWatcher watches store for changes and checks for each config against
-// owns. It will also poll the configstore at a configurable interval.
-func newConfigWatcher(log log.Logger, cfg Config, store configstore.Store, im instance.Manager, owns OwnershipFunc, validate ValidationFunc) (*configWatcher, error) {
+// owns. It will also poll the configstore at a configurable interval. When
+// handoff is enabled, configs which changed owners keep running until their
+// new owners are ready.
+func newConfigWatcher(log log.Logger, cfg Config, store configstore.Store, im instance.Manager, owns OwnershipFunc, validate ValidationFunc, hooks watcherHooks) (*configWatcher, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	w := &configWatcher{
@@ -58,17 +101,22 @@ func newConfigWatcher(log log.Logger, cfg Config, store configstore.Store, im in
 
 		stop: cancel,
 
-		store:    store,
-		im:       im,
-		owns:     owns,
-		validate: validate,
+		store:     store,
+		im:        im,
+		owns:      owns,
+		rebalance: hooks.rebalance,
+		report:    hooks.report,
+		handoff:   hooks.handoff,
+		validate:  validate,
 
 		instances: make(map[string]struct{}),
+		releasing: make(map[string]time.Time),
 	}
 	if err := w.ApplyConfig(cfg); err != nil {
 		return nil, err
 	}
 	go w.run(ctx)
+	go w.runHandoff(ctx)
 	return w, nil
 }
 
@@ -108,11 +156,165 @@ func (w *configWatcher) run(ctx context.Context) {
 	}
 }
 
+// runHandoff periodically releases the configs which changed owners once
+// their new owners are ready.
+func (w *configWatcher) runHandoff(ctx context.Context) {
+	for {
+		w.mut.Lock()
+		interval := w.cfg.Handoff.CheckInterval
+		w.mut.Unlock()
+		if interval <= 0 {
+			interval = DefaultConfig.Handoff.CheckInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			w.checkHandoffs(ctx)
+		}
+	}
+}
+
+// checkHandoffs releases every config whose new owners are ready or which
+// has been waiting for longer than the handoff timeout.
+func (w *configWatcher) checkHandoffs(ctx context.Context) {
+	w.mut.Lock()
+	var (
+		handsOff = w.handsOff()
+		timeout  = w.cfg.Handoff.Timeout
+	)
+	w.mut.Unlock()
+
+	w.instanceMut.Lock()
+	keys := make([]string, 0, len(w.releasing))
+	for key := range w.releasing {
+		keys = append(keys, key)
+	}
+	w.instanceMut.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	confirmed := make(map[string]struct{}, len(keys))
+	if handsOff {
+		ready, err := w.handoff(ctx, keys)
+		if err != nil {
+			level.Warn(w.log).Log("msg", "failed to check if the new owners of configs are ready", "err", err)
+		}
+		for _, key := range ready {
+			confirmed[key] = struct{}{}
+		}
+	}
+
+	for _, key := range keys {
+		// Release everything at once if handoff was disabled in the meantime.
+		_, ok := confirmed[key]
+		if err := w.release(key, ok || !handsOff, timeout); err != nil {
+			level.Error(w.log).Log("msg", "failed to release config", "key", key, "err", err)
+		}
+	}
+}
+
+// release stops running a config which changed owners if its new owners
+// confirmed they are ready or if it waited longer than timeout.
+func (w *configWatcher) release(key string, confirmed bool, timeout time.Duration) error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if w.stopped {
+		return nil
+	}
+
+	w.instanceMut.Lock()
+	defer w.instanceMut.Unlock()
+
+	// The config may have been deleted or become owned again in the meantime.
+	started, releasing := w.releasing[key]
+	if !releasing {
+		return nil
+	}
+
+	switch {
+	case confirmed:
+		level.Info(w.log).Log("msg", "new owners of config are ready, untracking config", "key", key)
+	case time.Since(started) >= timeout:
+		level.Warn(w.log).Log("msg", "timed out waiting for the new owners of config to be ready, untracking config", "key", key, "timeout", timeout)
+	default:
+		return nil
+	}
+
+	err := w.im.DeleteConfig(key)
+	delete(w.instances, key)
+	delete(w.releasing, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+	return nil
+}
+
+// Ready returns the keys which are running and which have scraped all of
+// their targets at least once. Only the targets of the scrape jobs of each
+// config are checked, since configs may share an instance. Configs without
+// scrape jobs, such as configs which only evaluate rules, are ready as soon
+// as they're running. Configs which are being released are never ready.
+func (w *configWatcher) Ready(keys []string) []string {
+	w.instanceMut.Lock()
+	var running []string
+	for _, key := range keys {
+		_, isRunning := w.instances[key]
+		_, isReleasing := w.releasing[key]
+		if isRunning && !isReleasing {
+			running = append(running, key)
+		}
+	}
+	w.instanceMut.Unlock()
+
+	if len(running) == 0 {
+		return nil
+	}
+
+	var (
+		configs = w.im.ListConfigs()
+		ready   = make([]string, 0, len(running))
+	)
+	for _, key := range running {
+		cfg, ok := configs[key]
+		if !ok {
+			continue
+		}
+		if len(cfg.ScrapeConfigs) == 0 {
+			ready = append(ready, key)
+			continue
+		}
+		inst, err := w.im.GetInstance(key)
+		if err == nil && scrapedAll(configTargets(inst, cfg)) {
+			ready = append(ready, key)
+		}
+	}
+	return ready
+}
+
+// scrapedAll returns true if there is at least one target and all targets
+// have been scraped.
+func scrapedAll(targets []*scrape.Target) bool {
+	for _, t := range targets {
+		if t.LastScrape().IsZero() {
+			return false
+		}
+	}
+	return len(targets) > 0
+}
+
 // Refresh reloads all configs from the configstore. Deleted configs will be
 // removed.
 func (w *configWatcher) Refresh(ctx context.Context) (err error) {
 	w.mut.Lock()
-	enabled := w.cfg.Enabled
+	var (
+		enabled  = w.cfg.Enabled
+		sharding = w.cfg.WeightedSharding
+	)
 	w.mut.Unlock()
 	if !enabled {
 		level.Debug(w.log).Log("msg", "refresh skipped because clustering is disabled")
@@ -129,16 +331,42 @@ func (w *configWatcher) Refresh(ctx context.Context) (err error) {
 			success = "0"
 		}
 		reshardDuration.WithLabelValues(success).Observe(time.Since(start).Seconds())
+
+		if w.report != nil {
+			w.instanceMut.Lock()
+			running := len(w.instances)
+			w.instanceMut.Unlock()
+
+			w.report(time.Now(), running, err)
+		}
 	}()
 
-	configs, err := w.store.All(ctx, func(key string) bool {
+	// stored records every key in the store, so configs which changed owners
+	// can be told apart from configs which were deleted.
+	var (
+		storedMut sync.Mutex
+		stored    = make(map[string]struct{})
+	)
+
+	owned := func(key string) bool {
+		storedMut.Lock()
+		stored[key] = struct{}{}
+		storedMut.Unlock()
+
 		owns, err := w.owns(key)
 		if err != nil {
 			level.Error(w.log).Log("msg", "failed to check for ownership, instance will be deleted if it is running", "key", key, "err", err)
 			return false
 		}
 		return owns
-	})
+	}
+
+	var configs <-chan instance.Config
+	if sharding.Enabled && w.rebalance != nil {
+		configs, err = w.rebalancedConfigs(ctx, sharding.MeasuredWeight, owned)
+	} else {
+		configs, err = w.store.All(ctx, owned)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get configs from store: %w", err)
 	}
@@ -183,8 +411,12 @@ Outer:
 	}
 	w.instanceMut.Unlock()
 
-	// Send a deleted event for any key that has gone away.
+	// Send a deleted event for any key that has gone away. Keys which are
+	// still in the store changed owners and may be handed off instead.
 	for _, key := range deleted {
+		if _, exists := stored[key]; exists && w.beginRelease(key) {
+			continue
+		}
 		if err := w.handleEvent(configstore.WatchEvent{Key: key, Config: nil}); err != nil {
 			level.Error(w.log).Log("msg", "failed to process changed config", "key", key, "err", err)
 		}
@@ -193,6 +425,138 @@ Outer:
 	return firstError
 }
 
+// rebalancedConfigs reads all configs from the store and rebalances ownership
+// using their weights. The returned channel holds the configs that are owned
+// after rebalancing.
+func (w *configWatcher) rebalancedConfigs(ctx context.Context, measure string, owned func(key string) bool) (<-chan instance.Config, error) {
+	all, err := w.store.All(ctx, func(string) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		configs  []instance.Config
+		declared = make(map[string]int64)
+	)
+Collect:
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case cfg, ok := <-all:
+			if !ok {
+				break Collect
+			}
+			configs = append(configs, cfg)
+			declared[cfg.Name] = cfg.ShardWeight
+		}
+	}
+
+	if err := w.rebalance(ctx, declared, w.measureWeights(measure)); err != nil {
+		// Ownership falls back to the previous assignment, which is better than
+		// moving every weighted config at once.
+		level.Error(w.log).Log("msg", "failed to rebalance configs by weight", "err", err)
+	}
+
+	ch := make(chan instance.Config, len(configs))
+	for _, cfg := range configs {
+		if owned(cfg.Name) {
+			ch <- cfg
+		}
+	}
+	close(ch)
+	return ch, nil
+}
+
+// measureWeights measures the weight of the configs which have been running
+// since the previous refresh.
+func (w *configWatcher) measureWeights(measure string) map[string]int64 {
+	w.instanceMut.Lock()
+	var (
+		settled = w.settled
+		keys    []string
+	)
+	w.settled = make(map[string]struct{}, len(w.instances))
+	for key := range w.instances {
+		w.settled[key] = struct{}{}
+		if _, ok := settled[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	w.instanceMut.Unlock()
+
+	if measure == MeasureNone || len(keys) == 0 {
+		return nil
+	}
+
+	var (
+		configs = w.im.ListConfigs()
+		weights = make(map[string]int64, len(keys))
+
+		// Configs may share an instance, so the series of each instance are
+		// only counted once.
+		series = make(map[instance.ManagedInstance]int64)
+	)
+
+	for _, key := range keys {
+		cfg, ok := configs[key]
+		if !ok {
+			continue
+		}
+		inst, err := w.im.GetInstance(key)
+		if err != nil {
+			continue
+		}
+
+		switch measure {
+		case MeasureTargets:
+			weights[key] = int64(len(configTargets(inst, cfg)))
+		case MeasureSeries:
+			total, ok := series[inst]
+			if !ok {
+				stats, err := inst.CardinalityStats(1)
+				if err != nil {
+					level.Debug(w.log).Log("msg", "failed to measure weight of config", "key", key, "err", err)
+					continue
+				}
+				total = int64(stats.NumSeries)
+				series[inst] = total
+			}
+			weights[key] = splitSeries(inst, cfg, total)
+		}
+	}
+	return weights
+}
+
+// configTargets returns the active targets of cfg in inst. inst may run other
+// configs too, so only the targets of the scrape jobs of cfg are returned.
+func configTargets(inst instance.ManagedInstance, cfg instance.Config) []*scrape.Target {
+	var (
+		active  = inst.TargetsActive()
+		targets []*scrape.Target
+	)
+	for _, sc := range cfg.ScrapeConfigs {
+		targets = append(targets, active[sc.JobName]...)
+	}
+	return targets
+}
+
+// splitSeries returns the share of series, the number of series in inst,
+// which belongs to cfg. Series can't be traced back to the config which
+// scraped them, so when inst runs other configs too, series are split between
+// them by their number of active targets.
+func splitSeries(inst instance.ManagedInstance, cfg instance.Config, series int64) int64 {
+	var total int64
+	for _, targets := range inst.TargetsActive() {
+		total += int64(len(targets))
+	}
+	own := int64(len(configTargets(inst, cfg)))
+	if total == 0 || own == total {
+		return series
+	}
+	return series * own / total
+}
+
 func (w *configWatcher) handleEvent(ev configstore.WatchEvent) error {
 	w.mut.Lock()
 	defer w.mut.Unlock()
@@ -214,7 +578,16 @@ func (w *configWatcher) handleEvent(ev configstore.WatchEvent) error {
 		isDeleted    = ev.Config == nil
 	)
 
+	if owned || isDeleted {
+		delete(w.releasing, ev.Key)
+	}
+
 	switch {
+	// A config we're running got moved to a new owner, but keeps running
+	// until the new owner is ready.
+	case isRunning && !owned && !isDeleted && w.handsOff():
+		w.startRelease(ev.Key)
+
 	// Two deletion scenarios:
 	// 1. A config we're running got moved to a new owner.
 	// 2. A config we're running got deleted
@@ -239,6 +612,10 @@ func (w *configWatcher) handleEvent(ev configstore.WatchEvent) error {
 			)
 		}
 
+		if lset := w.replicaLabels(ev.Key); len(lset) > 0 {
+			ev.Config.AddExternalLabels(lset)
+		}
+
 		if _, exist := w.instances[ev.Key]; !exist {
 			level.Info(w.log).Log("msg", "tracking new config", "key", ev.Key)
 		}
@@ -252,6 +629,58 @@ func (w *configWatcher) handleEvent(ev configstore.WatchEvent) error {
 	return nil
 }
 
+// beginRelease starts releasing a running config which changed owners.
+// Returns false if handoff is disabled, in which case the config should be
+// deleted immediately.
+func (w *configWatcher) beginRelease(key string) bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if w.stopped || !w.handsOff() {
+		return false
+	}
+
+	w.instanceMut.Lock()
+	defer w.instanceMut.Unlock()
+
+	if _, isRunning := w.instances[key]; isRunning {
+		w.startRelease(key)
+	}
+	return true
+}
+
+// startRelease marks a running config as being released. w.mut and
+// w.instanceMut must be held when calling startRelease.
+func (w *configWatcher) startRelease(key string) {
+	if _, releasing := w.releasing[key]; releasing {
+		return
+	}
+	level.Info(w.log).Log("msg", "config changed owners, running it until the new owners are ready", "key", key)
+	w.releasing[key] = time.Now()
+}
+
+// handsOff returns true if configs which changed owners should be handed
+// off. w.mut must be held when calling handsOff.
+func (w *configWatcher) handsOff() bool {
+	return w.cfg.Handoff.Enabled && w.handoff != nil
+}
+
+// replicaLabels returns the external labels to add to the config with the
+// given key when configs are run by more than one agent. w.mut must be held
+// when calling replicaLabels.
+func (w *configWatcher) replicaLabels(key string) labels.Labels {
+	if w.cfg.ReplicationFactor <= 1 {
+		return nil
+	}
+
+	b := labels.NewBuilder(nil)
+	b.Set(w.cfg.ReplicaLabel, w.cfg.Lifecycler.ID)
+	if w.cfg.ClusterLabel != "" {
+		b.Set(w.cfg.ClusterLabel, key)
+	}
+	return b.Labels()
+}
+
 // Stop stops the configWatcher. Cannot be called more than once.
 func (w *configWatcher) Stop() error {
 	w.mut.Lock()
@@ -275,6 +704,7 @@ func (w *configWatcher) Stop() error {
 		}
 	}
 	w.instances = make(map[string]struct{})
+	w.releasing = make(map[string]time.Time)
 
 	return nil
 }
@@ -2,12 +2,18 @@ package cluster
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/grafana/agent/pkg/metrics/instance"
 	"github.com/grafana/agent/pkg/metrics/instance/configstore"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/scrape"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -31,7 +37,7 @@ func Test_configWatcher_Refresh(t *testing.T) {
 	cfg.Enabled = true
 	cfg.ReshardInterval = time.Hour
 
-	w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+	w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate, watcherHooks{})
 	require.NoError(t, err)
 	t.Cleanup(func() { _ = w.Stop() })
 
@@ -71,6 +77,57 @@ func Test_configWatcher_Refresh(t *testing.T) {
 	im.AssertCalled(t, "DeleteConfig", "hello")
 }
 
+func Test_configWatcher_Refresh_Weighted(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+			AllFunc: func(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+				ch := make(chan instance.Config, 2)
+				ch <- instance.Config{Name: "heavy", ShardWeight: 100}
+				ch <- instance.Config{Name: "light"}
+				close(ch)
+				return ch, nil
+			},
+		}
+
+		im mockConfigManager
+
+		validate = func(*instance.Config) error { return nil }
+
+		// Only own configs after rebalancing.
+		rebalanced bool
+		declared   map[string]int64
+		rebalance  = func(_ context.Context, d, _ map[string]int64) error {
+			rebalanced, declared = true, d
+			return nil
+		}
+		owned = func(key string) (bool, error) { return rebalanced && key == "heavy", nil }
+	)
+	cfg.Enabled = true
+	cfg.ReshardInterval = time.Hour
+	cfg.WeightedSharding.Enabled = true
+	cfg.WeightedSharding.MeasuredWeight = MeasureNone
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate, watcherHooks{rebalance: rebalance})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+	im.On("DeleteConfig", mock.Anything).Return(nil)
+
+	err = w.Refresh(context.Background())
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int64{"heavy": 100, "light": 0}, declared)
+	im.AssertCalled(t, "ApplyConfig", instance.Config{Name: "heavy", ShardWeight: 100})
+	im.AssertNumberOfCalls(t, "ApplyConfig", 1)
+}
+
 func Test_configWatcher_handleEvent(t *testing.T) {
 	var (
 		cfg   = DefaultConfig
@@ -93,7 +150,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im  mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate, watcherHooks{})
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -112,7 +169,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im  mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate, watcherHooks{})
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -135,7 +192,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im  mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, unowned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, unowned, validate, watcherHooks{})
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -159,7 +216,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			owns    = func(key string) (bool, error) { return isOwned, nil }
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owns, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owns, validate, watcherHooks{})
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -187,7 +244,7 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 			im mockConfigManager
 		)
 
-		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate)
+		w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate, watcherHooks{})
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = w.Stop() })
 
@@ -206,12 +263,339 @@ func Test_configWatcher_handleEvent(t *testing.T) {
 	})
 }
 
+func Test_configWatcher_ReplicaLabels(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+		}
+
+		im mockConfigManager
+
+		validate = func(*instance.Config) error { return nil }
+		owned    = func(key string) (bool, error) { return true, nil }
+	)
+	cfg.Enabled = true
+	cfg.ReplicationFactor = 2
+	cfg.Lifecycler.ID = "agent-a"
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate, watcherHooks{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+
+	err = w.handleEvent(configstore.WatchEvent{Key: "replicated", Config: &instance.Config{Name: "replicated"}})
+	require.NoError(t, err)
+
+	expect := instance.Config{Name: "replicated"}
+	expect.AddExternalLabels(labels.FromStrings("__replica__", "agent-a", "cluster", "replicated"))
+	im.AssertCalled(t, "ApplyConfig", expect)
+}
+
+func Test_configWatcher_Handoff(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+			AllFunc: func(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+				ch := make(chan instance.Config, 1)
+				if keep("moved") {
+					ch <- instance.Config{Name: "moved"}
+				}
+				close(ch)
+				return ch, nil
+			},
+		}
+
+		im mockConfigManager
+
+		validate = func(*instance.Config) error { return nil }
+
+		isOwned = true
+		owns    = func(key string) (bool, error) { return isOwned, nil }
+
+		ready   []string
+		handoff = func(_ context.Context, keys []string) ([]string, error) { return ready, nil }
+	)
+	cfg.Enabled = true
+	cfg.ReshardInterval = time.Hour
+	cfg.Handoff.Enabled = true
+	cfg.Handoff.CheckInterval = time.Hour
+	cfg.Handoff.Timeout = time.Hour
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owns, validate, watcherHooks{handoff: handoff})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+	im.On("DeleteConfig", mock.Anything).Return(nil)
+
+	require.NoError(t, w.Refresh(context.Background()))
+
+	// The config keeps running after changing owners until the new owners are
+	// ready.
+	isOwned = false
+	require.NoError(t, w.Refresh(context.Background()))
+	w.checkHandoffs(context.Background())
+	im.AssertNotCalled(t, "DeleteConfig", "moved")
+
+	ready = []string{"moved"}
+	w.checkHandoffs(context.Background())
+	im.AssertCalled(t, "DeleteConfig", "moved")
+	im.AssertNumberOfCalls(t, "ApplyConfig", 1)
+}
+
+func Test_configWatcher_Handoff_Timeout(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+		}
+
+		im mockConfigManager
+
+		validate = func(*instance.Config) error { return nil }
+
+		isOwned = true
+		owns    = func(key string) (bool, error) { return isOwned, nil }
+
+		handoff = func(_ context.Context, keys []string) ([]string, error) { return nil, nil }
+	)
+	cfg.Enabled = true
+	cfg.Handoff.Enabled = true
+	cfg.Handoff.CheckInterval = time.Hour
+	cfg.Handoff.Timeout = time.Nanosecond
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owns, validate, watcherHooks{handoff: handoff})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+	im.On("DeleteConfig", mock.Anything).Return(nil)
+
+	require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: "moved", Config: &instance.Config{}}))
+
+	isOwned = false
+	require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: "moved", Config: &instance.Config{}}))
+	im.AssertNotCalled(t, "DeleteConfig", "moved")
+
+	// The config is released without confirmation once the timeout passed.
+	time.Sleep(time.Millisecond)
+	w.checkHandoffs(context.Background())
+	im.AssertCalled(t, "DeleteConfig", "moved")
+}
+
+func Test_configWatcher_Ready(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+		}
+
+		validate = func(*instance.Config) error { return nil }
+		owned    = func(key string) (bool, error) { return true, nil }
+	)
+	cfg.Enabled = true
+
+	scraped := scrape.NewTarget(labels.FromStrings("job", "scraped"), nil, nil)
+	scraped.Report(time.Now(), time.Second, nil)
+	pending := scrape.NewTarget(labels.FromStrings("job", "pending"), nil, nil)
+
+	// All configs are grouped into a single instance, which runs the targets
+	// of every config.
+	var (
+		shared = targetsInstance{targets: map[string][]*scrape.Target{
+			"scraped": {scraped},
+			"pending": {scraped, pending},
+		}}
+		groups = make(map[string]struct{})
+	)
+	im := instance.NewGroupManager(instance.MockManager{
+		GetInstanceFunc: func(string) (instance.ManagedInstance, error) { return shared, nil },
+		ApplyConfigFunc: func(c instance.Config) error {
+			groups[c.Name] = struct{}{}
+			return nil
+		},
+		DeleteConfigFunc: func(string) error { return nil },
+	})
+
+	w, err := newConfigWatcher(log, cfg, &store, im, owned, validate, watcherHooks{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	for _, key := range []string{"scraped", "pending", "notargets"} {
+		c, err := instance.UnmarshalConfig(strings.NewReader(fmt.Sprintf("scrape_configs: [{job_name: %s}]", key)))
+		require.NoError(t, err)
+		c.Name = key
+		require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: key, Config: c}))
+	}
+
+	// Configs without scrape jobs are ready as soon as they're running.
+	noScrape, err := instance.UnmarshalConfig(strings.NewReader("{}"))
+	require.NoError(t, err)
+	noScrape.Name = "noscrape"
+	require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: "noscrape", Config: noScrape}))
+	require.Len(t, groups, 1, "configs should share one instance")
+
+	ready := w.Ready([]string{"scraped", "pending", "notargets", "noscrape", "missing"})
+	require.Equal(t, []string{"scraped", "noscrape"}, ready)
+}
+
+// Test_configWatcher_Handoff_NoScrapeConfigs ensures that configs without
+// scrape jobs, such as configs which only evaluate rules, are handed off as
+// soon as the new owner runs them instead of after the handoff timeout.
+func Test_configWatcher_Handoff_NoScrapeConfigs(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+		}
+
+		validate = func(*instance.Config) error { return nil }
+		owned    = func(key string) (bool, error) { return true, nil }
+	)
+	cfg.Enabled = true
+	cfg.Handoff.Enabled = true
+	cfg.Handoff.CheckInterval = time.Hour
+	cfg.Handoff.Timeout = time.Hour
+
+	rulesOnly, err := instance.UnmarshalConfig(strings.NewReader("{}"))
+	require.NoError(t, err)
+	rulesOnly.Name = "rules"
+
+	// The new owner runs the config without any targets.
+	newOwnerIM := instance.NewGroupManager(instance.MockManager{
+		GetInstanceFunc: func(string) (instance.ManagedInstance, error) {
+			return targetsInstance{}, nil
+		},
+		ApplyConfigFunc:  func(instance.Config) error { return nil },
+		DeleteConfigFunc: func(string) error { return nil },
+	})
+	newOwner, err := newConfigWatcher(log, cfg, &store, newOwnerIM, owned, validate, watcherHooks{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = newOwner.Stop() })
+	require.NoError(t, newOwner.handleEvent(configstore.WatchEvent{Key: "rules", Config: rulesOnly}))
+
+	var (
+		im mockConfigManager
+
+		isOwned = true
+		owns    = func(key string) (bool, error) { return isOwned, nil }
+		handoff = func(_ context.Context, keys []string) ([]string, error) {
+			return newOwner.Ready(keys), nil
+		}
+	)
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+	im.On("DeleteConfig", mock.Anything).Return(nil)
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owns, validate, watcherHooks{handoff: handoff})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: "rules", Config: rulesOnly}))
+
+	isOwned = false
+	require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: "rules", Config: rulesOnly}))
+	w.checkHandoffs(context.Background())
+	im.AssertCalled(t, "DeleteConfig", "rules")
+}
+
+func Test_configWatcher_measureWeights(t *testing.T) {
+	var (
+		log = util.TestLogger(t)
+
+		cfg   = DefaultConfig
+		store = configstore.Mock{
+			WatchFunc: func() <-chan configstore.WatchEvent {
+				return make(chan configstore.WatchEvent)
+			},
+		}
+
+		im mockConfigManager
+
+		validate = func(*instance.Config) error { return nil }
+		owned    = func(key string) (bool, error) { return true, nil }
+	)
+	cfg.Enabled = true
+
+	w, err := newConfigWatcher(log, cfg, &store, &im, owned, validate, watcherHooks{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Stop() })
+
+	target := func(job string) *scrape.Target {
+		return scrape.NewTarget(labels.FromStrings("job", job), nil, nil)
+	}
+
+	// Both configs share an instance with 40 series.
+	var (
+		small  = instance.Config{Name: "small", ScrapeConfigs: []*config.ScrapeConfig{{JobName: "small"}}}
+		large  = instance.Config{Name: "large", ScrapeConfigs: []*config.ScrapeConfig{{JobName: "large"}}}
+		shared = &targetsInstance{
+			targets: map[string][]*scrape.Target{
+				"small": {target("small")},
+				"large": {target("large"), target("large"), target("large")},
+			},
+			series: 40,
+		}
+	)
+
+	im.On("ApplyConfig", mock.Anything).Return(nil)
+	im.On("ListConfigs").Return(map[string]instance.Config{"small": small, "large": large})
+	im.On("GetInstance", mock.Anything).Return(shared, nil)
+
+	require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: "small", Config: &small}))
+	require.NoError(t, w.handleEvent(configstore.WatchEvent{Key: "large", Config: &large}))
+
+	// Configs are only measured once they ran for a whole refresh.
+	require.Nil(t, w.measureWeights(MeasureTargets))
+
+	require.Equal(t, map[string]int64{"small": 1, "large": 3}, w.measureWeights(MeasureTargets))
+	require.Equal(t, map[string]int64{"small": 10, "large": 30}, w.measureWeights(MeasureSeries))
+}
+
+// targetsInstance is an instance.ManagedInstance which only implements
+// TargetsActive and CardinalityStats.
+type targetsInstance struct {
+	instance.ManagedInstance
+	targets map[string][]*scrape.Target
+	series  int
+}
+
+func (i targetsInstance) TargetsActive() map[string][]*scrape.Target {
+	return i.targets
+}
+
+func (i targetsInstance) CardinalityStats(limit int) (wal.CardinalityStats, error) {
+	return wal.CardinalityStats{NumSeries: i.series}, nil
+}
+
 type mockConfigManager struct {
 	mock.Mock
 }
 
 func (m *mockConfigManager) GetInstance(name string) (instance.ManagedInstance, error) {
-	args := m.Mock.Called()
+	args := m.Mock.Called(name)
 	return args.Get(0).(instance.ManagedInstance), args.Error(1)
 }
 
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // APIResponse is the base object returned for any API call.
@@ -57,6 +58,114 @@ type GetConfigurationResponse struct {
 	Value string `json:"value"`
 }
 
+// ApplyConfigurationsRequest is the body of a request to ValidateConfigurations
+// or ApplyConfigurations.
+type ApplyConfigurationsRequest struct {
+	// Configs is the set of configurations to apply.
+	Configs []ConfigurationValue `json:"configs"`
+
+	// Prune deletes all existing configurations which are not in Configs.
+	Prune bool `json:"prune"`
+}
+
+// ConfigurationValue is a named configuration.
+type ConfigurationValue struct {
+	Name string `json:"name"`
+
+	// Value is the stringified YAML configuration.
+	Value string `json:"value"`
+}
+
+// ApplyConfigurationsResponse is contained inside an APIResponse and provides
+// the names of the configurations changed by applying a set of
+// configurations. Returned by ApplyConfigurations, and by
+// ValidateConfigurations for the changes that would be made.
+type ApplyConfigurationsResponse struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	Deleted   []string `json:"deleted"`
+}
+
+// ListConfigRevisionsResponse is contained inside an APIResponse and provides
+// the recorded revisions of a configuration, oldest first. Returned by
+// ListConfigRevisions.
+type ListConfigRevisionsResponse struct {
+	Revisions []ConfigRevision `json:"revisions"`
+}
+
+// RollbackConfigurationResponse is contained inside an APIResponse and
+// provides the revision recorded for a rollback. Returned by
+// RollbackConfiguration.
+type RollbackConfigurationResponse struct {
+	// Revision is nil if the rollback was applied but couldn't be recorded.
+	Revision *ConfigRevision `json:"revision,omitempty"`
+}
+
+// ConfigRevision is a recorded change to a configuration.
+type ConfigRevision struct {
+	// ID of the revision, increasing with every change to the configuration.
+	ID int `json:"id" yaml:"id"`
+
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	Author    string    `json:"author" yaml:"author"`
+
+	// Operation is one of "put", "delete" or "rollback".
+	Operation string `json:"operation" yaml:"operation"`
+
+	// Value is the stringified YAML configuration after the change. It is
+	// empty if the configuration was deleted.
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+
+	// Diff is a unified diff of the configuration before and after the
+	// change.
+	Diff string `json:"diff" yaml:"diff"`
+}
+
+// ClusterStatusResponse is contained inside an APIResponse and provides the
+// nodes in the scraping service cluster and the owners of every
+// configuration. Returned by ClusterStatus.
+type ClusterStatusResponse struct {
+	Nodes   []NodeStatus      `json:"nodes"`
+	Configs []ConfigOwnership `json:"configs"`
+}
+
+// NodeStatus is the status of a node in the scraping service cluster.
+type NodeStatus struct {
+	ID    string `json:"id"`
+	Addr  string `json:"addr"`
+	State string `json:"state"`
+
+	// LastHeartbeat is the last time the node heartbeated the ring.
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+
+	// AssignedConfigs is the number of configurations owned by the node.
+	AssignedConfigs int `json:"assigned_configs"`
+
+	// RunningConfigs is the number of configurations the node was running
+	// after its last reshard.
+	RunningConfigs int `json:"running_configs"`
+
+	// LastReshard is when the node last finished resharding. It is the zero
+	// time if the node hasn't reported a reshard.
+	LastReshard time.Time `json:"last_reshard"`
+
+	// LastReshardError is the error returned by the last reshard, if any.
+	LastReshardError string `json:"last_reshard_error,omitempty"`
+}
+
+// ConfigOwnership holds the nodes which own a configuration.
+type ConfigOwnership struct {
+	Name string `json:"name"`
+
+	// Owners are the IDs of the nodes which own the configuration.
+	Owners []string `json:"owners"`
+
+	// Error is set when the owners of the configuration couldn't be
+	// determined.
+	Error string `json:"error,omitempty"`
+}
+
 // WriteResponse writes a response object to the provided ResponseWriter w and with a
 // status code of statusCode. resp is marshaled to JSON.
 func WriteResponse(w http.ResponseWriter, statusCode int, resp interface{}) error {
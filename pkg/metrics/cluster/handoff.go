@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/agentproto"
+	"github.com/grafana/agent/pkg/metrics/cluster/client"
+	"github.com/weaveworks/common/user"
+)
+
+// ConfirmHandoff asks the other owners of each key whether they are ready to
+// run it, returning the keys which all of their other owners confirmed. Keys
+// without other owners are never confirmed. Confirmed keys are returned along
+// with the first error that occurred.
+func (n *node) ConfirmHandoff(ctx context.Context, keys []string) ([]string, error) {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	if n.ring == nil || n.lc == nil {
+		return nil, errNodeDisabled
+	}
+
+	var (
+		// pending maps keys to the addresses of the owners which haven't
+		// confirmed them yet.
+		pending = make(map[string]map[string]struct{}, len(keys))
+		byOwner = make(map[string][]string)
+
+		firstError error
+	)
+	for _, key := range keys {
+		owners, err := n.owners(key)
+		if err != nil {
+			if firstError == nil {
+				firstError = err
+			}
+			continue
+		}
+
+		for _, addr := range owners {
+			// Skip over ourselves.
+			if addr == n.lc.Addr {
+				continue
+			}
+			if pending[key] == nil {
+				pending[key] = make(map[string]struct{})
+			}
+			pending[key][addr] = struct{}{}
+			byOwner[addr] = append(byOwner[addr], key)
+		}
+	}
+
+	ctx = user.InjectOrgID(ctx, "fake")
+	for addr, ownedKeys := range byOwner {
+		ready, err := n.confirmHandoff(ctx, addr, ownedKeys)
+		if err != nil {
+			level.Warn(n.log).Log("msg", "failed to confirm handoff of configs", "addr", addr, "err", err)
+			if firstError == nil {
+				firstError = err
+			}
+			continue
+		}
+		for _, key := range ready {
+			if owners, ok := pending[key]; ok {
+				delete(owners, addr)
+			}
+		}
+	}
+
+	var confirmed []string
+	for key, owners := range pending {
+		if len(owners) == 0 {
+			confirmed = append(confirmed, key)
+		}
+	}
+	sort.Strings(confirmed)
+	return confirmed, firstError
+}
+
+// confirmHandoff asks the node at addr which of keys it is ready to run.
+func (n *node) confirmHandoff(ctx context.Context, addr string, keys []string) ([]string, error) {
+	cli, err := client.New(n.cfg.Client, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	resp, err := cli.ConfirmHandoff(ctx, &agentproto.ConfirmHandoffRequest{Configs: keys})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ready, nil
+}
+
+// ConfirmHandoff implements agentproto.ScrapingServiceServer, and returns
+// the requested configs which are running locally and have scraped all of
+// their targets.
+func (c *Cluster) ConfirmHandoff(ctx context.Context, req *agentproto.ConfirmHandoffRequest) (*agentproto.ConfirmHandoffResponse, error) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	return &agentproto.ConfirmHandoffResponse{Ready: c.watcher.Ready(req.Configs)}, nil
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/grafana/agent/pkg/agentproto"
+	"github.com/grafana/agent/pkg/metrics/instance/configstore"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_node_ConfirmHandoff(t *testing.T) {
+	local := &agentproto.FuncScrapingServiceServer{
+		ReshardFunc: func(c context.Context, rr *agentproto.ReshardRequest) (*empty.Empty, error) {
+			return &empty.Empty{}, nil
+		},
+	}
+
+	remote := &agentproto.FuncScrapingServiceServer{
+		ReshardFunc: func(c context.Context, rr *agentproto.ReshardRequest) (*empty.Empty, error) {
+			return &empty.Empty{}, nil
+		},
+		ConfirmHandoffFunc: func(c context.Context, req *agentproto.ConfirmHandoffRequest) (*agentproto.ConfirmHandoffResponse, error) {
+			var ready []string
+			for _, key := range req.Configs {
+				if key != "pending" {
+					ready = append(ready, key)
+				}
+			}
+			return &agentproto.ConfirmHandoffResponse{Ready: ready}, nil
+		},
+	}
+	startNode(t, remote)
+
+	nodeConfig := DefaultConfig
+	nodeConfig.Enabled = true
+	nodeConfig.Lifecycler = testLifecyclerConfig(t)
+	nodeConfig.KVStore.Mock = consul.NewInMemoryClient(configstore.GetCodec())
+
+	n, err := newNode(prometheus.NewRegistry(), util.TestLogger(t), nodeConfig, local)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = n.Stop() })
+	require.NoError(t, n.WaitJoined(context.Background()))
+
+	// Both nodes own every key, so only the keys the remote node is ready to
+	// run are confirmed.
+	require.Eventually(t, func() bool {
+		ready, err := n.ConfirmHandoff(context.Background(), []string{"ready", "pending", "other"})
+		return err == nil && reflect.DeepEqual(ready, []string{"other", "ready"})
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -48,6 +48,18 @@ type node struct {
 
 	exited bool
 	reload chan struct{}
+
+	// weights stores the measured weights of configs when weighted sharding is
+	// enabled.
+	weights kv.Client
+
+	// status stores the status published by every node after a refresh.
+	status kv.Client
+
+	// assignment maps config keys to the addresses of the nodes which own
+	// them, computed by Rebalance when weighted sharding is enabled.
+	assignmentMut sync.RWMutex
+	assignment    map[string][]string
 }
 
 // newNode creates a new node and registers it to the ring.
@@ -105,6 +117,10 @@ func (n *node) ApplyConfig(cfg Config) error {
 		n.ring = nil
 	}
 
+	n.weights = nil
+	n.status = nil
+	n.setAssignment(nil)
+
 	if !cfg.Enabled {
 		n.cfg = cfg
 		return nil
@@ -134,6 +150,18 @@ func (n *node) ApplyConfig(cfg Config) error {
 	}
 	n.lc = lc
 
+	if cfg.WeightedSharding.Enabled {
+		n.weights, err = newWeightsClient(cfg.sharedKVStore(), n.reg)
+		if err != nil {
+			return fmt.Errorf("failed to create shard weights client: %w", err)
+		}
+	}
+
+	n.status, err = newStatusClient(cfg.sharedKVStore(), n.reg)
+	if err != nil {
+		return fmt.Errorf("failed to create node status client: %w", err)
+	}
+
 	n.cfg = cfg
 
 	// Reload and reshard the cluster.
@@ -357,22 +385,97 @@ func (n *node) TransferOut(ctx context.Context) error {
 
 // Owns checks to see if a key is owned by this node. owns will return
 // an error if the ring is empty or if there aren't enough healthy nodes.
+//
+// When weighted sharding is enabled, keys are owned according to the
+// assignment computed by the last call to Rebalance. Keys which weren't known
+// during the last Rebalance fall back to the ring.
 func (n *node) Owns(key string) (bool, error) {
 	n.mut.RLock()
 	defer n.mut.RUnlock()
 
-	rs, err := n.ring.Get(keyHash(key), ring.Write, nil, nil, nil)
+	owners, err := n.owners(key)
 	if err != nil {
 		return false, err
 	}
-	for _, r := range rs.Instances {
-		if r.Addr == n.lc.Addr {
+	for _, addr := range owners {
+		if addr == n.lc.Addr {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
+// owners returns the addresses of the nodes which own a key. n.mut must be
+// held when calling owners.
+func (n *node) owners(key string) ([]string, error) {
+	n.assignmentMut.RLock()
+	owners, assigned := n.assignment[key]
+	n.assignmentMut.RUnlock()
+	if assigned {
+		return owners, nil
+	}
+
+	rs, err := n.ring.Get(keyHash(key), ring.Write, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(rs.Instances))
+	for _, r := range rs.Instances {
+		addrs = append(addrs, r.Addr)
+	}
+	return addrs, nil
+}
+
+// Rebalance recomputes the ownership of configs using their weights. declared
+// holds the weight declared by every config in the store, where 0 means the
+// config didn't declare a weight, and measured holds the weights this node
+// measured from the configs it runs.
+//
+// Measured weights are merged into the weights published by the other nodes,
+// so every node weighs configs the same way.
+func (n *node) Rebalance(ctx context.Context, declared, measured map[string]int64) error {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	if n.ring == nil || n.lc == nil || n.weights == nil {
+		return fmt.Errorf("weighted sharding disabled")
+	}
+
+	published, err := publishWeights(ctx, n.weights, shardWeightsKey(n.cfg.KVStore.Prefix), declared, measured)
+	if err != nil {
+		return fmt.Errorf("failed to publish measured weights: %w", err)
+	}
+
+	rs, err := n.ring.GetAllHealthy(ring.Write)
+	if err != nil {
+		return err
+	}
+
+	var (
+		weights    = resolveWeights(declared, published)
+		assignment = assignWeighted(rs.Instances, weights, n.cfg.ReplicationFactor, n.cfg.WeightedSharding.MaxLoadFactor)
+
+		owned int64
+	)
+	for key, owners := range assignment {
+		for _, addr := range owners {
+			if addr == n.lc.Addr {
+				owned += weights[key]
+			}
+		}
+	}
+	ownedWeight.Set(float64(owned))
+
+	n.setAssignment(assignment)
+	return nil
+}
+
+func (n *node) setAssignment(assignment map[string][]string) {
+	n.assignmentMut.Lock()
+	defer n.assignmentMut.Unlock()
+	n.assignment = assignment
+}
+
 func keyHash(key string) uint32 {
 	h := fnv.New32()
 	_, _ = h.Write([]byte(key))
@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+)
+
+// assignWeighted assigns keys to the addresses of replicas instances in the
+// ring using consistent hashing with bounded loads. A key is assigned to the
+// first instances clockwise from its hash in the ring whose total weight would
+// stay within loadFactor times the average weight per instance.
+//
+// Keys keep their place in the ring unless the instance that would own them
+// is full, so adding or removing a key or an instance only moves the keys
+// needed to keep every instance within its bound. The assignment only depends
+// on its arguments, so every node computes the same assignment given the same
+// ring and weights.
+func assignWeighted(instances []ring.InstanceDesc, weights map[string]int64, replicas int, loadFactor float64) map[string][]string {
+	if len(instances) == 0 {
+		return nil
+	}
+	if replicas < 1 {
+		replicas = 1
+	} else if replicas > len(instances) {
+		replicas = len(instances)
+	}
+
+	type ringToken struct {
+		token uint32
+		addr  string
+	}
+	var tokens []ringToken
+	for _, inst := range instances {
+		for _, t := range inst.Tokens {
+			tokens = append(tokens, ringToken{token: t, addr: inst.Addr})
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].token != tokens[j].token {
+			return tokens[i].token < tokens[j].token
+		}
+		return tokens[i].addr < tokens[j].addr
+	})
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var total int64
+	keys := make([]string, 0, len(weights))
+	for key, weight := range weights {
+		total += weight
+		keys = append(keys, key)
+	}
+
+	// Place the heaviest keys first so they aren't left without an instance
+	// with enough free capacity to hold them.
+	sort.Slice(keys, func(i, j int) bool {
+		if weights[keys[i]] != weights[keys[j]] {
+			return weights[keys[i]] > weights[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	var (
+		capacity   = int64(math.Ceil(loadFactor * float64(total*int64(replicas)) / float64(len(instances))))
+		loads      = make(map[string]int64, len(instances))
+		assignment = make(map[string][]string, len(keys))
+	)
+
+	for _, key := range keys {
+		var (
+			weight = weights[key]
+			hash   = keyHash(key)
+			start  = sort.Search(len(tokens), func(i int) bool { return tokens[i].token > hash })
+
+			owners []string
+			full   []string
+			seen   = make(map[string]struct{}, len(instances))
+		)
+
+		for i := 0; i < len(tokens) && len(owners) < replicas; i++ {
+			addr := tokens[(start+i)%len(tokens)].addr
+			if _, ok := seen[addr]; ok {
+				continue
+			}
+			seen[addr] = struct{}{}
+
+			if loads[addr]+weight <= capacity {
+				owners = append(owners, addr)
+			} else {
+				full = append(full, addr)
+			}
+		}
+
+		// Not enough instances have capacity left for key, which can only
+		// happen when it weighs more than the free capacity of most instances.
+		// Put the remaining replicas on the least loaded instances.
+		if len(owners) < replicas {
+			sort.SliceStable(full, func(i, j int) bool { return loads[full[i]] < loads[full[j]] })
+			owners = append(owners, full[:replicas-len(owners)]...)
+		}
+
+		for _, addr := range owners {
+			loads[addr] += weight
+		}
+		assignment[key] = owners
+	}
+
+	return assignment
+}
+
+// resolveWeights returns the weight of every key in declared. Declared weights
+// take precedence over measured weights. Keys with neither are weighted as the
+// average of the other keys.
+func resolveWeights(declared, measured map[string]int64) map[string]int64 {
+	var (
+		weights = make(map[string]int64, len(declared))
+		unknown []string
+
+		known int64
+		sum   int64
+	)
+
+	for key, weight := range declared {
+		if weight <= 0 {
+			weight = measured[key]
+		}
+		if weight <= 0 {
+			unknown = append(unknown, key)
+			continue
+		}
+
+		weights[key] = weight
+		known++
+		sum += weight
+	}
+
+	average := int64(1)
+	if known > 0 && sum/known > 0 {
+		average = sum / known
+	}
+	for _, key := range unknown {
+		weights[key] = average
+	}
+	return weights
+}
@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_assignWeighted(t *testing.T) {
+	weights := make(map[string]int64)
+	for i := 0; i < 100; i++ {
+		weights[fmt.Sprintf("small-%d", i)] = 10
+	}
+	for i := 0; i < 4; i++ {
+		weights[fmt.Sprintf("large-%d", i)] = 1000
+	}
+
+	tt := []struct {
+		replicas int
+		capacity int64
+	}{
+		// The total weight is 5000, so no instance should have more than
+		// 1.25 * 5000 / 4 = 1563.
+		{replicas: 1, capacity: 1563},
+		// Each replica adds to the total weight.
+		{replicas: 2, capacity: 3125},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("replicas=%d", tc.replicas), func(t *testing.T) {
+			assignment := assignWeighted(testInstances(4), weights, tc.replicas, 1.25)
+			require.Len(t, assignment, len(weights))
+
+			loads := make(map[string]int64)
+			for key, owners := range assignment {
+				require.Len(t, owners, tc.replicas)
+
+				for _, addr := range owners {
+					loads[addr] += weights[key]
+				}
+			}
+			require.Len(t, loads, 4)
+			for addr, load := range loads {
+				require.LessOrEqual(t, load, tc.capacity, "instance %s is overloaded", addr)
+			}
+		})
+	}
+}
+
+func Test_assignWeighted_Moves(t *testing.T) {
+	weights := make(map[string]int64)
+	for i := 0; i < 200; i++ {
+		weights[fmt.Sprintf("config-%d", i)] = int64(i%10 + 1)
+	}
+
+	instances := testInstances(5)
+	before := assignWeighted(instances[:4], weights, 1, 1.25)
+	after := assignWeighted(instances, weights, 1, 1.25)
+
+	// Adding a fifth instance should move about a fifth of the keys.
+	var moved int
+	for key := range weights {
+		if before[key][0] != after[key][0] {
+			moved++
+		}
+	}
+	require.LessOrEqual(t, moved, len(weights)/4)
+}
+
+func Test_assignWeighted_Overweight(t *testing.T) {
+	// a is heavier than the capacity of any instance, so it's placed on the
+	// least loaded instance, leaving b for the other one.
+	assignment := assignWeighted(testInstances(2), map[string]int64{"a": 100, "b": 1}, 1, 1)
+	require.Len(t, assignment, 2)
+	require.NotEqual(t, assignment["a"], assignment["b"])
+
+	// Replicas are limited to the number of instances, even when they're full.
+	assignment = assignWeighted(testInstances(2), map[string]int64{"a": 100, "b": 1}, 3, 1)
+	require.ElementsMatch(t, []string{"agent-0", "agent-1"}, assignment["a"])
+	require.ElementsMatch(t, []string{"agent-0", "agent-1"}, assignment["b"])
+}
+
+func Test_resolveWeights(t *testing.T) {
+	weights := resolveWeights(
+		map[string]int64{"declared": 10, "measured": 0, "unknown": 0},
+		map[string]int64{"declared": 5, "measured": 20},
+	)
+	require.Equal(t, map[string]int64{
+		"declared": 10,
+		"measured": 20,
+		"unknown":  15,
+	}, weights)
+}
+
+// testInstances returns n ring instances with deterministic tokens.
+func testInstances(n int) []ring.InstanceDesc {
+	instances := make([]ring.InstanceDesc, 0, n)
+	for i := 0; i < n; i++ {
+		r := rand.New(rand.NewSource(int64(i)))
+
+		tokens := make([]uint32, 128)
+		for j := range tokens {
+			tokens[j] = r.Uint32()
+		}
+		instances = append(instances, ring.InstanceDesc{
+			Addr:   fmt.Sprintf("agent-%d", i),
+			Tokens: tokens,
+		})
+	}
+	return instances
+}
@@ -0,0 +1,266 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	"github.com/grafana/agent/pkg/metrics/instance/configstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+// errNodeDisabled is returned when the status of the cluster is requested
+// from a node which isn't part of the cluster.
+var errNodeDisabled = errors.New("node disabled")
+
+// reportTimeout is how long a node waits to publish its status after a
+// refresh.
+const reportTimeout = 10 * time.Second
+
+// nodeStatus is the status a node publishes after every refresh.
+type nodeStatus struct {
+	LastReshard      time.Time `yaml:"last_reshard"`
+	LastReshardError string    `yaml:"last_reshard_error,omitempty"`
+	RunningConfigs   int       `yaml:"running_configs"`
+}
+
+// newStatusClient creates a KV client for publishing node statuses. cfg
+// should be the shared KV store of the cluster. Statuses are stored outside
+// of the prefix the configs are stored in.
+func newStatusClient(cfg kv.Config, reg prometheus.Registerer) (kv.Client, error) {
+	cfg.Prefix = ""
+	return kv.NewClient(cfg, configstore.GetCodec(), kv.RegistererWithKVName(reg, "agent_node_status"))
+}
+
+// nodeStatusKey returns the key node statuses are stored at for configs
+// stored with the given prefix.
+func nodeStatusKey(configsPrefix string) string {
+	return strings.TrimSuffix(configsPrefix, "/") + "_node_status"
+}
+
+// publishStatus stores the status of the node with the given ID at key.
+// Statuses of nodes which aren't in members are removed.
+func publishStatus(ctx context.Context, client kv.Client, key, id string, status nodeStatus, members map[string]struct{}) error {
+	return client.CAS(ctx, key, func(in interface{}) (out interface{}, retry bool, err error) {
+		stored, err := decodeStatuses(in)
+		if err != nil {
+			return nil, false, err
+		}
+		if stored == nil {
+			stored = make(map[string]nodeStatus)
+		}
+
+		for storedID := range stored {
+			if _, member := members[storedID]; !member {
+				delete(stored, storedID)
+			}
+		}
+		stored[id] = status
+
+		bb, err := yaml.Marshal(stored)
+		if err != nil {
+			return nil, false, err
+		}
+		return string(bb), true, nil
+	})
+}
+
+func decodeStatuses(v interface{}) (map[string]nodeStatus, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	var statuses map[string]nodeStatus
+	if err := yaml.Unmarshal([]byte(v.(string)), &statuses); err != nil {
+		return nil, fmt.Errorf("failed to decode node statuses: %w", err)
+	}
+	return statuses, nil
+}
+
+// ReportRefresh publishes the result of a refresh so the status of the node
+// can be retrieved from any node in the cluster. Failures are logged.
+func (n *node) ReportRefresh(finished time.Time, running int, refreshErr error) {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	if n.ring == nil || n.lc == nil || n.status == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+
+	status := nodeStatus{
+		LastReshard:    finished.UTC(),
+		RunningConfigs: running,
+	}
+	if refreshErr != nil {
+		status.LastReshardError = refreshErr.Error()
+	}
+
+	desc, err := n.ringDesc(ctx)
+	if err != nil {
+		level.Warn(n.log).Log("msg", "failed to get ring to publish node status", "err", err)
+		return
+	}
+	members := make(map[string]struct{}, len(desc.Ingesters))
+	for id := range desc.Ingesters {
+		members[id] = struct{}{}
+	}
+
+	err = publishStatus(ctx, n.status, nodeStatusKey(n.cfg.KVStore.Prefix), n.lc.ID, status, members)
+	if err != nil {
+		level.Warn(n.log).Log("msg", "failed to publish node status", "err", err)
+	}
+}
+
+// Status returns the nodes in the cluster and the owners of each of the
+// given config keys, using the same ownership rules as Owns.
+func (n *node) Status(ctx context.Context, keys []string) (*configapi.ClusterStatusResponse, error) {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	if n.ring == nil || n.lc == nil || n.status == nil {
+		return nil, errNodeDisabled
+	}
+
+	desc, err := n.ringDesc(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ring: %w", err)
+	}
+
+	v, err := n.status.Get(ctx, nodeStatusKey(n.cfg.KVStore.Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node statuses: %w", err)
+	}
+	statuses, err := decodeStatuses(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		resp = &configapi.ClusterStatusResponse{
+			Nodes:   make([]configapi.NodeStatus, 0, len(desc.Ingesters)),
+			Configs: make([]configapi.ConfigOwnership, 0, len(keys)),
+		}
+
+		addrIDs  = make(map[string]string, len(desc.Ingesters))
+		assigned = make(map[string]int, len(desc.Ingesters))
+	)
+	for id, inst := range desc.Ingesters {
+		addrIDs[inst.Addr] = id
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		ownership := configapi.ConfigOwnership{Name: key, Owners: []string{}}
+
+		owners, err := n.owners(key)
+		if err != nil {
+			ownership.Error = err.Error()
+		}
+		for _, addr := range owners {
+			id, ok := addrIDs[addr]
+			if !ok {
+				id = addr
+			}
+			ownership.Owners = append(ownership.Owners, id)
+			assigned[id]++
+		}
+		sort.Strings(ownership.Owners)
+
+		resp.Configs = append(resp.Configs, ownership)
+	}
+
+	for id, inst := range desc.Ingesters {
+		status := statuses[id]
+		resp.Nodes = append(resp.Nodes, configapi.NodeStatus{
+			ID:               id,
+			Addr:             inst.Addr,
+			State:            inst.State.String(),
+			LastHeartbeat:    time.Unix(inst.Timestamp, 0).UTC(),
+			AssignedConfigs:  assigned[id],
+			RunningConfigs:   status.RunningConfigs,
+			LastReshard:      status.LastReshard,
+			LastReshardError: status.LastReshardError,
+		})
+	}
+	sort.Slice(resp.Nodes, func(i, j int) bool { return resp.Nodes[i].ID < resp.Nodes[j].ID })
+
+	return resp, nil
+}
+
+// ringDesc returns the current state of the ring. n.mut must be held.
+func (n *node) ringDesc(ctx context.Context) (*ring.Desc, error) {
+	v, err := n.ring.KVClient.Get(ctx, agentKey)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return ring.NewDesc(), nil
+	}
+	return v.(*ring.Desc), nil
+}
+
+// ClusterStatus returns the nodes in the cluster and the owners of every
+// config in the configstore. Requests are authenticated the same way as the
+// config management API, and only configs the client can read are listed.
+func (c *Cluster) ClusterStatus(rw http.ResponseWriter, r *http.Request) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	id, err := c.auth.Authenticate(r)
+	if err != nil {
+		c.writeError(rw, http.StatusUnauthorized, err)
+		return
+	}
+
+	keys, err := c.store.List(r.Context())
+	if errors.Is(err, configstore.ErrNotConnected) {
+		c.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
+		return
+	} else if err != nil {
+		c.writeError(rw, http.StatusInternalServerError, fmt.Errorf("failed to list configs: %w", err))
+		return
+	}
+
+	resp, err := c.node.Status(r.Context(), keys)
+	if errors.Is(err, errNodeDisabled) {
+		c.writeError(rw, http.StatusNotFound, fmt.Errorf("scraping service cluster is not enabled"))
+		return
+	} else if err != nil {
+		c.writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Configs are filtered after getting the status so the number of configs
+	// assigned to each node still counts every config.
+	if id != nil {
+		allowed := resp.Configs[:0]
+		for _, ownership := range resp.Configs {
+			if id.Allowed(ownership.Name, false) {
+				allowed = append(allowed, ownership)
+			}
+		}
+		resp.Configs = allowed
+	}
+
+	if err := configapi.WriteResponse(rw, http.StatusOK, resp); err != nil {
+		level.Error(c.log).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+func (c *Cluster) writeError(rw http.ResponseWriter, statusCode int, writeErr error) {
+	if err := configapi.WriteError(rw, statusCode, writeErr); err != nil {
+		level.Error(c.log).Log("msg", "failed to write response", "err", err)
+	}
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/grafana/agent/pkg/agentproto"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	"github.com/grafana/agent/pkg/metrics/instance/configstore"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_publishStatus(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		client = consul.NewInMemoryClient(configstore.GetCodec())
+		key    = nodeStatusKey("configurations/")
+		now    = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	)
+	require.Equal(t, "configurations_node_status", key)
+
+	members := map[string]struct{}{"a": {}, "b": {}}
+	require.NoError(t, publishStatus(ctx, client, key, "a", nodeStatus{LastReshard: now, RunningConfigs: 1}, members))
+	require.NoError(t, publishStatus(ctx, client, key, "b", nodeStatus{LastReshard: now, LastReshardError: "failed"}, members))
+
+	v, err := client.Get(ctx, key)
+	require.NoError(t, err)
+	statuses, err := decodeStatuses(v)
+	require.NoError(t, err)
+	require.Equal(t, map[string]nodeStatus{
+		"a": {LastReshard: now, RunningConfigs: 1},
+		"b": {LastReshard: now, LastReshardError: "failed"},
+	}, statuses)
+
+	// Statuses of nodes which left the ring are removed.
+	require.NoError(t, publishStatus(ctx, client, key, "b", nodeStatus{LastReshard: now}, map[string]struct{}{"b": {}}))
+
+	v, err = client.Get(ctx, key)
+	require.NoError(t, err)
+	statuses, err = decodeStatuses(v)
+	require.NoError(t, err)
+	require.Equal(t, map[string]nodeStatus{"b": {LastReshard: now}}, statuses)
+}
+
+func Test_node_Status(t *testing.T) {
+	local := &agentproto.FuncScrapingServiceServer{
+		ReshardFunc: func(c context.Context, rr *agentproto.ReshardRequest) (*empty.Empty, error) {
+			return &empty.Empty{}, nil
+		},
+	}
+
+	nodeConfig := DefaultConfig
+	nodeConfig.Enabled = true
+	nodeConfig.Lifecycler = testLifecyclerConfig(t)
+	nodeConfig.KVStore.Mock = consul.NewInMemoryClient(configstore.GetCodec())
+
+	n, err := newNode(prometheus.NewRegistry(), util.TestLogger(t), nodeConfig, local)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = n.Stop() })
+	require.NoError(t, n.WaitJoined(context.Background()))
+
+	finished := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	n.ReportRefresh(finished, 1, errors.New("failed to apply config"))
+
+	resp, err := n.Status(context.Background(), []string{"b", "a"})
+	require.NoError(t, err)
+
+	id := nodeConfig.Lifecycler.ID
+	require.Len(t, resp.Nodes, 1)
+	require.Equal(t, id, resp.Nodes[0].ID)
+	require.Equal(t, "ACTIVE", resp.Nodes[0].State)
+	require.Equal(t, 2, resp.Nodes[0].AssignedConfigs)
+	require.Equal(t, 1, resp.Nodes[0].RunningConfigs)
+	require.Equal(t, finished, resp.Nodes[0].LastReshard)
+	require.Equal(t, "failed to apply config", resp.Nodes[0].LastReshardError)
+
+	require.Len(t, resp.Configs, 2)
+	require.Equal(t, "a", resp.Configs[0].Name)
+	require.Equal(t, []string{id}, resp.Configs[0].Owners)
+	require.Equal(t, "b", resp.Configs[1].Name)
+	require.Equal(t, []string{id}, resp.Configs[1].Owners)
+}
+
+func TestCluster_ClusterStatus_Auth(t *testing.T) {
+	local := &agentproto.FuncScrapingServiceServer{
+		ReshardFunc: func(c context.Context, rr *agentproto.ReshardRequest) (*empty.Empty, error) {
+			return &empty.Empty{}, nil
+		},
+	}
+
+	nodeConfig := DefaultConfig
+	nodeConfig.Enabled = true
+	nodeConfig.Lifecycler = testLifecyclerConfig(t)
+	nodeConfig.KVStore.Mock = consul.NewInMemoryClient(configstore.GetCodec())
+
+	n, err := newNode(prometheus.NewRegistry(), util.TestLogger(t), nodeConfig, local)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = n.Stop() })
+	require.NoError(t, n.WaitJoined(context.Background()))
+
+	auth, err := configstore.NewAuth(util.TestLogger(t), configstore.AuthConfig{
+		Tokens: []configstore.TokenConfig{
+			{Name: "team-a", Token: "team-a-token", PermissionsConfig: configstore.PermissionsConfig{Tenant: "team-a"}},
+		},
+	})
+	require.NoError(t, err)
+
+	c := &Cluster{
+		log:  util.TestLogger(t),
+		node: n,
+		auth: auth,
+		store: &configstore.Mock{
+			ListFunc: func(ctx context.Context) ([]string, error) {
+				return []string{"team-a.config", "team-b.config"}, nil
+			},
+		},
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		c.ClusterStatus(rw, httptest.NewRequest(http.MethodGet, "/agent/api/v1/cluster/status", nil))
+		require.Equal(t, http.StatusUnauthorized, rw.Code)
+	})
+
+	t.Run("configs are filtered", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/agent/api/v1/cluster/status", nil)
+		r.Header.Set("Authorization", "Bearer team-a-token")
+
+		rw := httptest.NewRecorder()
+		c.ClusterStatus(rw, r)
+		require.Equal(t, http.StatusOK, rw.Code)
+
+		var resp struct {
+			Data configapi.ClusterStatusResponse `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(rw.Body).Decode(&resp))
+		require.Len(t, resp.Data.Configs, 1)
+		require.Equal(t, "team-a.config", resp.Data.Configs[0].Name)
+
+		// Every config is still counted as assigned to the node.
+		require.Len(t, resp.Data.Nodes, 1)
+		require.Equal(t, 2, resp.Data.Nodes[0].AssignedConfigs)
+	})
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/grafana/agent/pkg/metrics/instance/configstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gopkg.in/yaml.v2"
+)
+
+var ownedWeight = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "agent_prometheus_scraping_service_owned_weight",
+	Help: "Total weight of the configs owned by this node when weighted sharding is enabled.",
+})
+
+// weightChangeThreshold is how much a measured weight has to change relative
+// to its published weight before it's published again. Measured weights vary
+// a little between refreshes, and publishing every change would move configs
+// between nodes on every reshard.
+const weightChangeThreshold = 0.2
+
+// newWeightsClient creates a KV client for publishing measured weights. cfg
+// should be the shared KV store of the cluster. Weights are stored outside of
+// the prefix the configs are stored in.
+func newWeightsClient(cfg kv.Config, reg prometheus.Registerer) (kv.Client, error) {
+	cfg.Prefix = ""
+	return kv.NewClient(cfg, configstore.GetCodec(), kv.RegistererWithKVName(reg, "agent_shard_weights"))
+}
+
+// shardWeightsKey returns the key measured weights are stored at for configs
+// stored with the given prefix.
+func shardWeightsKey(configsPrefix string) string {
+	return strings.TrimSuffix(configsPrefix, "/") + "_shard_weights"
+}
+
+// publishWeights merges measured into the weights stored at key and returns
+// the merged weights. Weights of configs which are no longer in declared are
+// removed. A measured weight only replaces the stored weight of a config when
+// it changed by more than weightChangeThreshold.
+func publishWeights(ctx context.Context, client kv.Client, key string, declared, measured map[string]int64) (map[string]int64, error) {
+	var merged map[string]int64
+
+	err := client.CAS(ctx, key, func(in interface{}) (out interface{}, retry bool, err error) {
+		var stored map[string]int64
+		if in != nil {
+			if err := yaml.Unmarshal([]byte(in.(string)), &stored); err != nil {
+				return nil, false, fmt.Errorf("failed to decode stored weights: %w", err)
+			}
+		}
+
+		merged = make(map[string]int64, len(declared))
+		for key, weight := range stored {
+			if _, exist := declared[key]; exist {
+				merged[key] = weight
+			}
+		}
+		for key, weight := range measured {
+			if _, exist := declared[key]; !exist || weight <= 0 {
+				continue
+			}
+			if prev, ok := merged[key]; ok && !weightChanged(prev, weight) {
+				continue
+			}
+			merged[key] = weight
+		}
+
+		// Avoid writing to the store when nothing changed.
+		if in != nil && reflect.DeepEqual(stored, merged) {
+			return nil, false, nil
+		}
+
+		bb, err := yaml.Marshal(merged)
+		if err != nil {
+			return nil, false, err
+		}
+		return string(bb), true, nil
+	})
+	return merged, err
+}
+
+// weightChanged returns true if weight differs from prev by more than
+// weightChangeThreshold.
+func weightChanged(prev, weight int64) bool {
+	return math.Abs(float64(weight-prev)) > weightChangeThreshold*float64(prev)
+}
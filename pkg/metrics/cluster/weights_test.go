@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/grafana/agent/pkg/metrics/instance/configstore"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_publishWeights(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		client = consul.NewInMemoryClient(configstore.GetCodec())
+		key    = shardWeightsKey("configurations/")
+	)
+	require.Equal(t, "configurations_shard_weights", key)
+
+	// Two nodes publish the weights of the configs they run.
+	weights, err := publishWeights(ctx, client, key,
+		map[string]int64{"a": 0, "b": 0, "c": 0},
+		map[string]int64{"a": 10},
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"a": 10}, weights)
+
+	weights, err = publishWeights(ctx, client, key,
+		map[string]int64{"a": 0, "b": 0, "c": 0},
+		map[string]int64{"b": 20, "c": 0},
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"a": 10, "b": 20}, weights)
+
+	// Small changes to measured weights are ignored so configs don't move
+	// between nodes on every refresh.
+	weights, err = publishWeights(ctx, client, key,
+		map[string]int64{"a": 0, "b": 0, "c": 0},
+		map[string]int64{"a": 11, "b": 30},
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"a": 10, "b": 30}, weights)
+
+	// Weights of deleted configs are removed.
+	weights, err = publishWeights(ctx, client, key, map[string]int64{"b": 0}, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"b": 30}, weights)
+}
@@ -0,0 +1,56 @@
+package ha
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/codec"
+)
+
+// leaderRecord is the value stored in the KV store for an HA cluster.
+type leaderRecord struct {
+	// Replica is the name of the current leader.
+	Replica string `json:"replica"`
+
+	// UpdatedAt is the Unix timestamp in milliseconds when the leader last
+	// renewed the record.
+	UpdatedAt int64 `json:"updated_at"`
+
+	// SentAt is the Unix timestamp in milliseconds of the newest sample which
+	// the leader's instances are known to have sent over remote_write. A new
+	// leader resends samples newer than SentAt when taking over.
+	SentAt int64 `json:"sent_at,omitempty"`
+}
+
+// GetCodec returns the codec for encoding and decoding leader records in the
+// KV store.
+func GetCodec() codec.Codec {
+	return &jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (*jsonCodec) Decode(bb []byte) (interface{}, error) {
+	// Decode is called with an empty slice when a key is deleted.
+	if len(bb) == 0 {
+		return nil, nil
+	}
+
+	var rec leaderRecord
+	if err := json.Unmarshal(bb, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func (*jsonCodec) Encode(v interface{}) ([]byte, error) {
+	rec, ok := v.(*leaderRecord)
+	if !ok {
+		panic(fmt.Sprintf("unexpected type %T passed to jsonCodec.Encode", v))
+	}
+	return json.Marshal(rec)
+}
+
+func (*jsonCodec) CodecID() string {
+	return "agentHA/json"
+}
@@ -0,0 +1,62 @@
+// Package ha implements high availability for pairs of agents which scrape
+// the same targets. Agents in the same HA cluster elect a leader through a
+// KV store, and only the leader sends samples over remote_write.
+package ha
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultConfig holds the default settings for HA mode.
+var DefaultConfig = Config{
+	ClusterLabel:    "cluster",
+	UpdateInterval:  10 * time.Second,
+	FailoverTimeout: 30 * time.Second,
+}
+
+// Config controls HA mode.
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+
+	// ClusterLabel is the name of the external label which identifies the HA
+	// cluster. Agents with the same value for the label elect a leader
+	// together.
+	ClusterLabel string `yaml:"cluster_label,omitempty"`
+
+	// ReplicaName uniquely identifies the agent within its HA cluster.
+	// Defaults to the hostname.
+	ReplicaName string `yaml:"replica_name,omitempty"`
+
+	// How frequently the leader is checked and renewed.
+	UpdateInterval time.Duration `yaml:"update_interval,omitempty"`
+
+	// How long a leader may go without renewing before another replica takes
+	// over.
+	FailoverTimeout time.Duration `yaml:"failover_timeout,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// Validate ensures the Config is valid.
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	switch {
+	case c.ClusterLabel == "":
+		return errors.New("ha cluster_label must not be empty")
+	case c.UpdateInterval <= 0:
+		return errors.New("ha update_interval must be greater than 0s")
+	case c.FailoverTimeout <= c.UpdateInterval:
+		return errors.New("ha failover_timeout must be greater than update_interval")
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package ha
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"go.uber.org/atomic"
+)
+
+const (
+	// kvPrefix is the prefix of keys in the KV store, keeping leader records
+	// separate from the configs stored by the scraping service.
+	kvPrefix = "ha/"
+
+	// releaseTimeout is how long to wait for leadership to be given up when
+	// stopping.
+	releaseTimeout = 5 * time.Second
+)
+
+// remoteWritePauser is implemented by instances which can stop sending
+// samples over remote_write while still writing them to their WAL.
+type remoteWritePauser interface {
+	SetRemoteWritePaused(paused bool) error
+}
+
+// remoteWriteResender is implemented by instances which can report which
+// samples they have sent over remote_write and resend the samples written to
+// their WAL while remote_write was paused. While paused, instances are told
+// which samples the leader has sent so they keep the rest in their WAL.
+type remoteWriteResender interface {
+	RemoteWriteTimestamp() int64
+	SetPausedRemoteWriteTimestamp(ts int64)
+	ResendRemoteWrite(from int64)
+}
+
+type electorMetrics struct {
+	leader          prometheus.Gauge
+	leaderChanges   prometheus.Counter
+	failedElections prometheus.Counter
+}
+
+func newElectorMetrics(reg prometheus.Registerer) *electorMetrics {
+	return &electorMetrics{
+		leader: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "agent_prometheus_ha_leader",
+			Help: "1 if the agent is the leader of its HA cluster or HA mode is disabled, 0 otherwise.",
+		}),
+		leaderChanges: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_ha_leader_changes_total",
+			Help: "Total number of times the agent became or stopped being the leader of its HA cluster.",
+		}),
+		failedElections: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_ha_failed_elections_total",
+			Help: "Total number of elections which failed because the KV store could not be updated.",
+		}),
+	}
+}
+
+// Elector elects a leader among the agents of an HA cluster. Every instance
+// keeps scraping and writing to its WAL, but instances of agents which aren't
+// the leader have remote_write paused. When the leader stops renewing its
+// leadership, another agent takes over and starts sending samples, starting
+// from the last sample the previous leader is known to have sent.
+type Elector struct {
+	logger  log.Logger
+	manager instance.Manager
+	metrics *electorMetrics
+	kvReg   *util.Unregisterer
+
+	// now returns the current time. Overridden by tests.
+	now func() time.Time
+
+	leader *atomic.Bool
+
+	mut     sync.Mutex
+	cfg     Config
+	kvCfg   kv.Config
+	cluster string
+	session *session
+}
+
+// session is a running election loop.
+type session struct {
+	cli     kv.Client
+	cluster string
+	replica string
+	cfg     Config
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a new Elector. HA mode is disabled until ApplyConfig is called
+// with an enabled Config, so the agent is treated as the leader.
+func New(l log.Logger, reg prometheus.Registerer, manager instance.Manager) *Elector {
+	metrics := newElectorMetrics(reg)
+	metrics.leader.Set(1)
+
+	return &Elector{
+		logger:  log.With(l, "component", "ha"),
+		manager: manager,
+		metrics: metrics,
+		kvReg:   util.WrapWithUnregisterer(reg),
+		now:     time.Now,
+		leader:  atomic.NewBool(true),
+	}
+}
+
+// IsLeader returns true if the agent is the leader of its HA cluster or HA
+// mode is disabled. Instances should only send samples over remote_write when
+// IsLeader returns true.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// ApplyConfig updates the Elector. kvCfg configures the KV store used for
+// elections, and cluster is the name of the HA cluster the agent is a part
+// of. The agent starts as a follower until it wins an election.
+func (e *Elector) ApplyConfig(cfg Config, kvCfg kv.Config, cluster string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if util.CompareYAML(e.cfg, cfg) && util.CompareYAML(e.kvCfg, kvCfg) && e.cluster == cluster {
+		return nil
+	}
+
+	e.stop()
+	e.cfg, e.kvCfg, e.cluster = Config{}, kv.Config{}, ""
+
+	if !cfg.Enabled {
+		e.cfg = cfg
+		e.setLeader(true)
+		e.syncInstances()
+		return nil
+	}
+	if cluster == "" {
+		return fmt.Errorf("ha cluster label %q is not set in external_labels", cfg.ClusterLabel)
+	}
+
+	replica := cfg.ReplicaName
+	if replica == "" {
+		hostname, err := instance.Hostname()
+		if err != nil {
+			return err
+		}
+		replica = hostname
+	}
+
+	sessionKV := kvCfg
+	sessionKV.Prefix = kvPrefix
+	cli, err := kv.NewClient(sessionKV, GetCodec(), kv.RegistererWithKVName(e.kvReg, "agent_ha"))
+	if err != nil {
+		return fmt.Errorf("failed to create kv client: %w", err)
+	}
+	e.cfg, e.kvCfg, e.cluster = cfg, kvCfg, cluster
+
+	e.setLeader(false)
+	e.syncInstances()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{
+		cli:     cli,
+		cluster: cluster,
+		replica: replica,
+		cfg:     cfg,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	e.session = s
+	go e.run(ctx, s)
+
+	level.Info(e.logger).Log("msg", "HA mode enabled", "cluster", cluster, "replica", replica)
+	return nil
+}
+
+// run runs elections until ctx is canceled.
+func (e *Elector) run(ctx context.Context, s *session) {
+	defer close(s.done)
+
+	t := time.NewTicker(s.cfg.UpdateInterval)
+	defer t.Stop()
+
+	for {
+		e.elect(ctx, s)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// elect runs a single election, renewing leadership if the agent is the
+// leader or taking over if the current leader timed out. Instances are synced
+// with the outcome after every election, including instances created since
+// the last election.
+func (e *Elector) elect(ctx context.Context, s *session) {
+	var (
+		leader string
+
+		// resendFrom is set to the timestamp of the last sample sent by the
+		// previous leader when the agent becomes the leader.
+		resendFrom int64
+
+		// leaderSentAt is set to the timestamp of the last sample sent by the
+		// leader when the agent is a follower.
+		leaderSentAt int64
+
+		wasLeader = e.IsLeader()
+		sentAt    = e.remoteWriteTimestamp()
+	)
+
+	err := s.cli.CAS(ctx, s.cluster, func(in interface{}) (out interface{}, retry bool, err error) {
+		now := e.now()
+		resendFrom, leaderSentAt = 0, 0
+
+		cur, _ := in.(*leaderRecord)
+		if cur != nil && cur.Replica != s.replica {
+			age := now.Sub(timestamp.Time(cur.UpdatedAt))
+			if age < s.cfg.FailoverTimeout {
+				leader = cur.Replica
+				leaderSentAt = cur.SentAt
+				return nil, false, nil
+			}
+			level.Warn(e.logger).Log("msg", "HA leader timed out, taking over", "cluster", s.cluster, "previous_leader", cur.Replica, "last_update", age)
+		}
+
+		leader = s.replica
+		rec := &leaderRecord{Replica: s.replica, UpdatedAt: timestamp.FromTime(now)}
+		if cur != nil {
+			rec.SentAt = cur.SentAt
+		}
+
+		// Only instances of the leader send samples, so sentAt can only be
+		// trusted if the agent was already the leader. A new leader keeps the
+		// timestamp of the previous leader until it has caught up.
+		if !wasLeader {
+			resendFrom = rec.SentAt
+		} else if sentAt > rec.SentAt {
+			rec.SentAt = sentAt
+		}
+		return rec, true, nil
+	})
+
+	// If the KV store can't be reached, keep the current role. Followers can't
+	// know whether the leader is still sending samples, and stepping down as
+	// the leader would stop samples from being sent by any agent.
+	if err != nil && ctx.Err() == nil {
+		e.metrics.failedElections.Inc()
+		level.Error(e.logger).Log("msg", "failed to run HA election, keeping current role", "cluster", s.cluster, "err", err)
+	} else if err == nil {
+		if e.setLeader(leader == s.replica) {
+			level.Info(e.logger).Log("msg", "HA leader changed", "cluster", s.cluster, "leader", leader, "is_leader", leader == s.replica)
+		}
+	}
+
+	e.syncInstances()
+
+	// Followers keep the samples the leader hasn't sent yet in their WAL, so
+	// they can be resent if the leader fails. Truncating them against the
+	// current time would lose them on failover.
+	if err == nil && !e.IsLeader() {
+		e.followInstances(leaderSentAt)
+	}
+
+	if resendFrom > 0 && e.IsLeader() {
+		level.Info(e.logger).Log("msg", "resending samples not sent by the previous HA leader", "cluster", s.cluster, "from", timestamp.Time(resendFrom))
+		e.resendInstances(resendFrom)
+	}
+}
+
+// setLeader updates the leader state. Returns true if the state changed.
+func (e *Elector) setLeader(leader bool) bool {
+	if e.leader.Swap(leader) == leader {
+		return false
+	}
+
+	e.metrics.leaderChanges.Inc()
+	if leader {
+		e.metrics.leader.Set(1)
+	} else {
+		e.metrics.leader.Set(0)
+	}
+	return true
+}
+
+// syncInstances pauses or resumes remote_write for all instances based on
+// the current leader state.
+func (e *Elector) syncInstances() {
+	paused := !e.IsLeader()
+
+	for name, inst := range e.manager.ListInstances() {
+		p, ok := inst.(remoteWritePauser)
+		if !ok {
+			continue
+		}
+		if err := p.SetRemoteWritePaused(paused); err != nil {
+			level.Error(e.logger).Log("msg", "failed to update remote_write for HA", "instance", name, "paused", paused, "err", err)
+		}
+	}
+}
+
+// remoteWriteTimestamp returns the lowest timestamp of the last sample sent
+// over remote_write across all instances. Instances which don't send samples
+// over remote_write report the current time.
+func (e *Elector) remoteWriteTimestamp() int64 {
+	ts := timestamp.FromTime(e.now())
+	for _, inst := range e.manager.ListInstances() {
+		r, ok := inst.(remoteWriteResender)
+		if !ok {
+			continue
+		}
+		if instTs := r.RemoteWriteTimestamp(); instTs < ts {
+			ts = instTs
+		}
+	}
+	return ts
+}
+
+// followInstances tells all instances which samples the leader has sent
+// while their remote_write is paused.
+func (e *Elector) followInstances(sentAt int64) {
+	for _, inst := range e.manager.ListInstances() {
+		if r, ok := inst.(remoteWriteResender); ok {
+			r.SetPausedRemoteWriteTimestamp(sentAt)
+		}
+	}
+}
+
+// resendInstances resends samples newer than from for all instances.
+func (e *Elector) resendInstances(from int64) {
+	for _, inst := range e.manager.ListInstances() {
+		if r, ok := inst.(remoteWriteResender); ok {
+			r.ResendRemoteWrite(from)
+		}
+	}
+}
+
+// Stop stops the Elector. If the agent is the leader, it gives up leadership
+// so another agent can take over without waiting for the failover timeout.
+// Instances are left in their current state.
+func (e *Elector) Stop() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	e.stop()
+}
+
+func (e *Elector) stop() {
+	s := e.session
+	if s == nil {
+		return
+	}
+	e.session = nil
+
+	s.cancel()
+	<-s.done
+
+	if e.IsLeader() {
+		e.release(s)
+	}
+
+	// Unregister all metrics that the kv client may have registered.
+	e.kvReg.UnregisterAll()
+}
+
+// release gives up leadership by marking the leader record as expired. The
+// timestamp of the last sent sample is kept for the next leader.
+func (e *Elector) release(s *session) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	err := s.cli.CAS(ctx, s.cluster, func(in interface{}) (out interface{}, retry bool, err error) {
+		cur, _ := in.(*leaderRecord)
+		if cur == nil || cur.Replica != s.replica {
+			return nil, false, nil
+		}
+		return &leaderRecord{Replica: s.replica, SentAt: cur.SentAt}, true, nil
+	})
+	if err != nil {
+		level.Warn(e.logger).Log("msg", "failed to give up HA leadership", "cluster", s.cluster, "err", err)
+	}
+}
@@ -0,0 +1,248 @@
+package ha
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElector_Failover(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	a, aInst := newTestElector(t, clock)
+	b, bInst := newTestElector(t, clock)
+
+	cfg := DefaultConfig
+	cfg.Enabled = true
+	aSession := newTestSession(t, cfg, "a", "failover")
+	bSession := newTestSession(t, cfg, "b", "failover")
+
+	// The first replica to run an election becomes the leader.
+	a.elect(context.Background(), aSession)
+	b.elect(context.Background(), bSession)
+	require.True(t, a.IsLeader())
+	require.False(t, b.IsLeader())
+	require.False(t, aInst.Paused())
+	require.True(t, bInst.Paused())
+
+	// b shouldn't take over while a keeps renewing its leadership.
+	aInst.SetRemoteWriteTimestamp(1000)
+	now = now.Add(cfg.FailoverTimeout / 2)
+	a.elect(context.Background(), aSession)
+	now = now.Add(cfg.FailoverTimeout / 2)
+	b.elect(context.Background(), bSession)
+	require.True(t, a.IsLeader())
+	require.False(t, b.IsLeader())
+
+	// b should keep the samples a hasn't sent yet in its WAL.
+	require.Equal(t, int64(1000), bInst.PausedRemoteWriteTimestamp())
+
+	// After a stops renewing for the failover timeout, b should take over and
+	// a should step down on its next election.
+	now = now.Add(cfg.FailoverTimeout)
+	b.elect(context.Background(), bSession)
+	a.elect(context.Background(), aSession)
+	require.False(t, a.IsLeader())
+	require.True(t, b.IsLeader())
+	require.True(t, aInst.Paused())
+	require.False(t, bInst.Paused())
+
+	// b should resend everything after the last sample a reported as sent,
+	// and keep a's timestamp until its own instances have caught up.
+	require.Equal(t, int64(1000), bInst.ResendFrom())
+	require.Equal(t, int64(0), aInst.ResendFrom())
+	require.Equal(t, int64(1000), aInst.PausedRemoteWriteTimestamp())
+
+	bInst.SetRemoteWriteTimestamp(500)
+	b.elect(context.Background(), bSession)
+	require.Equal(t, int64(1000), readRecord(t, bSession).SentAt)
+
+	bInst.SetRemoteWriteTimestamp(2000)
+	b.elect(context.Background(), bSession)
+	require.Equal(t, int64(2000), readRecord(t, bSession).SentAt)
+}
+
+func readRecord(t *testing.T, s *session) *leaderRecord {
+	t.Helper()
+
+	v, err := s.cli.Get(context.Background(), s.cluster)
+	require.NoError(t, err)
+	rec, ok := v.(*leaderRecord)
+	require.True(t, ok)
+	return rec
+}
+
+func TestElector_Release(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	a, _ := newTestElector(t, clock)
+	b, _ := newTestElector(t, clock)
+
+	cfg := DefaultConfig
+	cfg.Enabled = true
+	aSession := newTestSession(t, cfg, "a", "release")
+	bSession := newTestSession(t, cfg, "b", "release")
+
+	a.elect(context.Background(), aSession)
+	require.True(t, a.IsLeader())
+
+	// Giving up leadership should let b take over without waiting for the
+	// failover timeout.
+	a.release(aSession)
+	b.elect(context.Background(), bSession)
+	require.True(t, b.IsLeader())
+}
+
+func TestElector_ApplyConfig(t *testing.T) {
+	e, inst := newTestElector(t, time.Now)
+
+	cfg := DefaultConfig
+	cfg.Enabled = true
+	cfg.ReplicaName = "a"
+	cfg.UpdateInterval = 10 * time.Millisecond
+	cfg.FailoverTimeout = time.Second
+
+	err := e.ApplyConfig(cfg, kv.Config{Store: "inmemory"}, "")
+	require.EqualError(t, err, `ha cluster label "cluster" is not set in external_labels`)
+
+	err = e.ApplyConfig(cfg, kv.Config{Store: "inmemory"}, "apply-config")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return e.IsLeader() && !inst.Paused()
+	}, time.Second, 10*time.Millisecond)
+
+	// Disabling HA mode should resume remote_write.
+	cfg.Enabled = false
+	require.NoError(t, e.ApplyConfig(cfg, kv.Config{Store: "inmemory"}, "apply-config"))
+	require.True(t, e.IsLeader())
+	require.False(t, inst.Paused())
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tt := []struct {
+		name   string
+		mut    func(c *Config)
+		expect string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"disabled", func(c *Config) { c.Enabled, c.ClusterLabel = false, "" }, ""},
+		{"no cluster label", func(c *Config) { c.ClusterLabel = "" }, "ha cluster_label must not be empty"},
+		{"no update interval", func(c *Config) { c.UpdateInterval = 0 }, "ha update_interval must be greater than 0s"},
+		{"short failover timeout", func(c *Config) { c.FailoverTimeout = c.UpdateInterval }, "ha failover_timeout must be greater than update_interval"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig
+			cfg.Enabled = true
+			tc.mut(&cfg)
+
+			err := cfg.Validate()
+			if tc.expect == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expect)
+			}
+		})
+	}
+}
+
+func newTestElector(t *testing.T, now func() time.Time) (*Elector, *pausableInstance) {
+	t.Helper()
+
+	inst := &pausableInstance{}
+	mm := instance.MockManager{
+		ListInstancesFunc: func() map[string]instance.ManagedInstance {
+			return map[string]instance.ManagedInstance{"test": inst}
+		},
+	}
+
+	e := New(log.NewNopLogger(), prometheus.NewRegistry(), mm)
+	e.now = now
+	t.Cleanup(e.Stop)
+	return e, inst
+}
+
+func newTestSession(t *testing.T, cfg Config, replica, cluster string) *session {
+	t.Helper()
+
+	cli, err := kv.NewClient(kv.Config{Store: "inmemory", Prefix: kvPrefix}, GetCodec(), prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	return &session{
+		cli:     cli,
+		cluster: cluster,
+		replica: replica,
+		cfg:     cfg,
+	}
+}
+
+// pausableInstance is an instance which records whether remote_write is
+// paused and which samples it was asked to resend.
+type pausableInstance struct {
+	instance.NoOpInstance
+
+	mut          sync.Mutex
+	paused       bool
+	sentAt       int64
+	pausedSentAt int64
+	resendFrom   int64
+}
+
+func (i *pausableInstance) SetRemoteWritePaused(paused bool) error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.paused = paused
+	return nil
+}
+
+func (i *pausableInstance) Paused() bool {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.paused
+}
+
+func (i *pausableInstance) RemoteWriteTimestamp() int64 {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.sentAt
+}
+
+func (i *pausableInstance) SetRemoteWriteTimestamp(ts int64) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.sentAt = ts
+}
+
+func (i *pausableInstance) SetPausedRemoteWriteTimestamp(ts int64) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.pausedSentAt = ts
+}
+
+func (i *pausableInstance) PausedRemoteWriteTimestamp() int64 {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.pausedSentAt
+}
+
+func (i *pausableInstance) ResendRemoteWrite(from int64) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.resendFrom = from
+}
+
+func (i *pausableInstance) ResendFrom() int64 {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.resendFrom
+}
@@ -18,6 +18,15 @@ func (a *Agent) WireAPI(r *mux.Router) {
 
 	r.HandleFunc("/agent/api/v1/instances", a.ListInstancesHandler).Methods("GET")
 	r.HandleFunc("/agent/api/v1/targets", a.ListTargetsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/targets", a.InstanceTargetsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/scrape_debug", a.ScrapeDebugHandler).Methods("GET")
+
+	r.HandleFunc("/agent/api/v1/instances/{instance}/api/v1/query", a.InstantQueryHandler).Methods("GET", "POST")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/api/v1/query_range", a.RangeQueryHandler).Methods("GET", "POST")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/api/v1/write", a.RemoteWriteHandler).Methods("POST")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/api/v1/alerts", a.AlertsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/api/v1/rules", a.RulesHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/instances/{instance}/api/v1/status/tsdb", a.TSDBStatusHandler).Methods("GET")
 }
 
 // ListInstancesHandler writes the set of currently running instances to the http.ResponseWriter.
@@ -11,9 +11,11 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/kit/log"
 	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
@@ -135,7 +137,8 @@ func TestAgent_ListTargetsHandler(t *testing.T) {
 }
 
 type mockInstanceScrape struct {
-	tgts map[string][]*scrape.Target
+	tgts    map[string][]*scrape.Target
+	dropped map[string][]*scrape.Target
 }
 
 func (i *mockInstanceScrape) Run(ctx context.Context) error {
@@ -151,6 +154,10 @@ func (i *mockInstanceScrape) TargetsActive() map[string][]*scrape.Target {
 	return i.tgts
 }
 
+func (i *mockInstanceScrape) TargetsDropped() map[string][]*scrape.Target {
+	return i.dropped
+}
+
 func (i *mockInstanceScrape) StorageDirectory() string {
 	return ""
 }
@@ -158,3 +165,15 @@ func (i *mockInstanceScrape) StorageDirectory() string {
 func (i *mockInstanceScrape) Appender(ctx context.Context) storage.Appender {
 	return nil
 }
+
+func (i *mockInstanceScrape) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return storage.NoopQuerier(), nil
+}
+
+func (i *mockInstanceScrape) RuleGroups() []*rules.Group {
+	return nil
+}
+
+func (i *mockInstanceScrape) CardinalityStats(_ int) (wal.CardinalityStats, error) {
+	return wal.CardinalityStats{}, nil
+}
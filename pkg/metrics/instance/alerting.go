@@ -0,0 +1,128 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/notifier"
+	"github.com/prometheus/prometheus/rules"
+)
+
+// alertQueueCapacity is the number of alerts which can be queued for sending
+// to Alertmanagers. Matches the Prometheus default.
+const alertQueueCapacity = 10000
+
+// alertSender sends alerts to Alertmanagers.
+type alertSender interface {
+	Send(alerts ...*notifier.Alert)
+}
+
+// alertNotifier discovers Alertmanagers and sends alerts from alerting rules
+// to them.
+type alertNotifier struct {
+	manager   *notifier.Manager
+	discovery *discovery.Manager
+
+	rg *runGroupContext
+}
+
+// newAlertNotifier creates a new alertNotifier. The notifier will not send
+// alerts until Run is called.
+func newAlertNotifier(ctx context.Context, l log.Logger, reg prometheus.Registerer) *alertNotifier {
+	ctx, cancel := context.WithCancel(ctx)
+
+	manager := notifier.NewManager(&notifier.Options{
+		QueueCapacity: alertQueueCapacity,
+		Registerer:    reg,
+	}, log.With(l, "component", "notifier"))
+
+	discoveryLogger := log.With(l, "component", "notify discovery manager")
+	discoveryManager := discovery.NewManager(ctx, discoveryLogger, discovery.Name("notify"))
+
+	rg := runGroupWithContext(ctx)
+	rg.Add(func() error {
+		err := discoveryManager.Run()
+		level.Info(l).Log("msg", "notify discovery manager stopped")
+		return err
+	}, func(err error) {
+		level.Info(l).Log("msg", "stopping notify discovery manager...")
+		cancel()
+	})
+	rg.Add(func() error {
+		manager.Run(discoveryManager.SyncCh())
+		level.Info(l).Log("msg", "notifier stopped")
+		return nil
+	}, func(err error) {
+		level.Info(l).Log("msg", "stopping notifier...")
+		manager.Stop()
+	})
+
+	return &alertNotifier{
+		manager:   manager,
+		discovery: discoveryManager,
+		rg:        rg,
+	}
+}
+
+// ApplyConfig updates the Alertmanagers alerts are sent to.
+func (n *alertNotifier) ApplyConfig(cfg *Config) error {
+	err := n.manager.ApplyConfig(&config.Config{
+		GlobalConfig:   cfg.prometheusGlobal(),
+		AlertingConfig: cfg.Alerting,
+	})
+	if err != nil {
+		return fmt.Errorf("failed applying config to notifier: %w", err)
+	}
+
+	// The keys must match the ones used by the notifier for its Alertmanager
+	// sets.
+	c := map[string]discovery.Configs{}
+	for k, v := range cfg.Alerting.AlertmanagerConfigs.ToMap() {
+		c[k] = v.ServiceDiscoveryConfigs
+	}
+	if err := n.discovery.ApplyConfig(c); err != nil {
+		return fmt.Errorf("failed applying config to notify discovery manager: %w", err)
+	}
+	return nil
+}
+
+// Send implements alertSender.
+func (n *alertNotifier) Send(alerts ...*notifier.Alert) { n.manager.Send(alerts...) }
+
+// Run runs the notifier until Stop is called.
+func (n *alertNotifier) Run() error { return n.rg.Run() }
+
+// Stop stops the notifier. Queued alerts which haven't been sent yet are
+// dropped.
+func (n *alertNotifier) Stop(err error) { n.rg.Stop(err) }
+
+// sendAlerts returns a rules.NotifyFunc which converts alerts from alerting
+// rules and passes them to s.
+func sendAlerts(s alertSender) rules.NotifyFunc {
+	return func(_ context.Context, _ string, alerts ...*rules.Alert) {
+		if len(alerts) == 0 {
+			return
+		}
+
+		res := make([]*notifier.Alert, 0, len(alerts))
+		for _, alert := range alerts {
+			a := &notifier.Alert{
+				StartsAt:    alert.FiredAt,
+				Labels:      alert.Labels,
+				Annotations: alert.Annotations,
+			}
+			if !alert.ResolvedAt.IsZero() {
+				a.EndsAt = alert.ResolvedAt
+			} else {
+				a.EndsAt = alert.ValidUntil
+			}
+			res = append(res, a)
+		}
+		s.Send(res...)
+	}
+}
@@ -1,11 +1,14 @@
 package configstore
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -23,6 +26,8 @@ type API struct {
 	storeMut  sync.Mutex
 	store     Store
 	validator Validator
+	history   *History
+	auth      *Auth
 
 	totalCreatedConfigs prometheus.Counter
 	totalUpdatedConfigs prometheus.Counter
@@ -55,6 +60,24 @@ func NewAPI(l log.Logger, store Store, v Validator) *API {
 	}
 }
 
+// SetHistory sets the History used to record changes made through the API.
+// Revisions are not recorded and can't be listed or rolled back to when h
+// is nil.
+func (api *API) SetHistory(h *History) {
+	api.storeMut.Lock()
+	defer api.storeMut.Unlock()
+	api.history = h
+}
+
+// SetAuth sets the Auth used to authenticate and authorize requests and to
+// audit changes made through the API. All requests are allowed and changes
+// are not audited when a is nil.
+func (api *API) SetAuth(a *Auth) {
+	api.storeMut.Lock()
+	defer api.storeMut.Unlock()
+	api.auth = a
+}
+
 // WireAPI injects routes into the provided mux router for the config
 // store API.
 func (api *API) WireAPI(r *mux.Router) {
@@ -62,10 +85,14 @@ func (api *API) WireAPI(r *mux.Router) {
 	// name when reading the path variable.
 	r = r.UseEncodedPath()
 
-	r.HandleFunc("/agent/api/v1/configs", api.ListConfigurations).Methods("GET")
-	r.HandleFunc("/agent/api/v1/configs/{name}", api.GetConfiguration).Methods("GET")
-	r.HandleFunc("/agent/api/v1/config/{name}", api.PutConfiguration).Methods("PUT", "POST")
-	r.HandleFunc("/agent/api/v1/config/{name}", api.DeleteConfiguration).Methods("DELETE")
+	r.HandleFunc("/agent/api/v1/configs", api.handle("", api.ListConfigurations)).Methods("GET")
+	r.HandleFunc("/agent/api/v1/configs/validate", api.handle("", api.ValidateConfigurations)).Methods("POST")
+	r.HandleFunc("/agent/api/v1/configs/apply", api.handle("apply", api.ApplyConfigurations)).Methods("POST")
+	r.HandleFunc("/agent/api/v1/configs/{name}", api.handle("", api.GetConfiguration)).Methods("GET")
+	r.HandleFunc("/agent/api/v1/configs/{name}/revisions", api.handle("", api.ListConfigRevisions)).Methods("GET")
+	r.HandleFunc("/agent/api/v1/config/{name}", api.handle("put", api.PutConfiguration)).Methods("PUT", "POST")
+	r.HandleFunc("/agent/api/v1/config/{name}", api.handle("delete", api.DeleteConfiguration)).Methods("DELETE")
+	r.HandleFunc("/agent/api/v1/config/{name}/rollback/{revision}", api.handle("rollback", api.RollbackConfiguration)).Methods("POST")
 }
 
 // Describe implements prometheus.Collector.
@@ -99,6 +126,17 @@ func (api *API) ListConfigurations(rw http.ResponseWriter, r *http.Request) {
 		api.writeError(rw, http.StatusInternalServerError, fmt.Errorf("failed to write config: %w", err))
 		return
 	}
+
+	// Only list the configs the client is allowed to read.
+	if id := identityFromContext(r.Context()); id != nil {
+		allowed := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if id.Allowed(key, false) {
+				allowed = append(allowed, key)
+			}
+		}
+		keys = allowed
+	}
 	api.writeResponse(rw, http.StatusOK, configapi.ListConfigurationsResponse{Configs: keys})
 }
 
@@ -116,6 +154,9 @@ func (api *API) GetConfiguration(rw http.ResponseWriter, r *http.Request) {
 		api.writeError(rw, http.StatusBadRequest, err)
 		return
 	}
+	if !api.authorize(rw, r, configKey, false) {
+		return
+	}
 
 	cfg, err := api.store.Get(r.Context(), configKey)
 	switch {
@@ -151,6 +192,9 @@ func (api *API) PutConfiguration(rw http.ResponseWriter, r *http.Request) {
 		api.writeError(rw, http.StatusBadRequest, err)
 		return
 	}
+	if !api.authorize(rw, r, configName, true) {
+		return
+	}
 
 	var config strings.Builder
 	if _, err := io.Copy(&config, r.Body); err != nil {
@@ -158,26 +202,13 @@ func (api *API) PutConfiguration(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg, err := instance.UnmarshalConfig(strings.NewReader(config.String()))
+	cfg, err := api.parseConfig(configName, config.String())
 	if err != nil {
-		api.writeError(rw, http.StatusBadRequest, fmt.Errorf("could not unmarshal config: %w", err))
+		api.writeError(rw, http.StatusBadRequest, err)
 		return
 	}
-	cfg.Name = configName
 
-	if api.validator != nil {
-		validateCfg, err := instance.UnmarshalConfig(strings.NewReader(config.String()))
-		if err != nil {
-			api.writeError(rw, http.StatusBadRequest, fmt.Errorf("could not unmarshal config: %w", err))
-			return
-		}
-		validateCfg.Name = configName
-
-		if err := api.validator(validateCfg); err != nil {
-			api.writeError(rw, http.StatusBadRequest, fmt.Errorf("failed to validate config: %w", err))
-			return
-		}
-	}
+	prev := api.currentValue(r.Context(), configName)
 
 	created, err := api.store.Put(r.Context(), *cfg)
 	switch {
@@ -188,6 +219,8 @@ func (api *API) PutConfiguration(rw http.ResponseWriter, r *http.Request) {
 	case err != nil:
 		api.writeError(rw, http.StatusInternalServerError, err)
 	default:
+		api.recordRevision(r, configName, OperationPut, prev, cfg)
+
 		if created {
 			api.totalCreatedConfigs.Inc()
 			api.writeResponse(rw, http.StatusCreated, nil)
@@ -212,6 +245,11 @@ func (api *API) DeleteConfiguration(rw http.ResponseWriter, r *http.Request) {
 		api.writeError(rw, http.StatusBadRequest, err)
 		return
 	}
+	if !api.authorize(rw, r, configKey, true) {
+		return
+	}
+
+	prev := api.currentValue(r.Context(), configKey)
 
 	err = api.store.Delete(r.Context(), configKey)
 	switch {
@@ -222,11 +260,413 @@ func (api *API) DeleteConfiguration(rw http.ResponseWriter, r *http.Request) {
 	case err != nil:
 		api.writeError(rw, http.StatusInternalServerError, err)
 	default:
+		api.recordRevision(r, configKey, OperationDelete, prev, nil)
+
 		api.totalDeletedConfigs.Inc()
 		api.writeResponse(rw, http.StatusOK, nil)
 	}
 }
 
+// ListConfigRevisions lists the recorded revisions of a configuration.
+func (api *API) ListConfigRevisions(rw http.ResponseWriter, r *http.Request) {
+	api.storeMut.Lock()
+	defer api.storeMut.Unlock()
+	if api.history == nil {
+		api.writeError(rw, http.StatusNotFound, fmt.Errorf("config history is not enabled"))
+		return
+	}
+
+	configKey, err := getConfigName(r)
+	if err != nil {
+		api.writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if !api.authorize(rw, r, configKey, false) {
+		return
+	}
+
+	revs, err := api.history.Revisions(r.Context(), configKey)
+	if err != nil {
+		api.writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	if revs == nil {
+		revs = []configapi.ConfigRevision{}
+	}
+	api.writeResponse(rw, http.StatusOK, configapi.ListConfigRevisionsResponse{Revisions: revs})
+}
+
+// RollbackConfiguration restores a configuration to a recorded revision. The
+// rollback is recorded as a new revision. Rolling back to a revision which
+// deleted the configuration deletes it.
+func (api *API) RollbackConfiguration(rw http.ResponseWriter, r *http.Request) {
+	api.storeMut.Lock()
+	defer api.storeMut.Unlock()
+	if api.store == nil {
+		api.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
+		return
+	}
+	if api.history == nil {
+		api.writeError(rw, http.StatusNotFound, fmt.Errorf("config history is not enabled"))
+		return
+	}
+
+	configKey, err := getConfigName(r)
+	if err != nil {
+		api.writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if !api.authorize(rw, r, configKey, true) {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["revision"])
+	if err != nil {
+		api.writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid revision: %w", err))
+		return
+	}
+
+	target, err := api.history.Revision(r.Context(), configKey, id)
+	switch {
+	case errors.As(err, &RevisionNotExistError{}):
+		api.writeError(rw, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		api.writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	var cfg *instance.Config
+	if target.Value != "" {
+		cfg, err = api.parseConfig(configKey, target.Value)
+		if err != nil {
+			api.writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	prev := api.currentValue(r.Context(), configKey)
+
+	var created bool
+	if cfg != nil {
+		created, err = api.store.Put(r.Context(), *cfg)
+	} else {
+		err = api.store.Delete(r.Context(), configKey)
+	}
+	switch {
+	case errors.Is(err, ErrNotConnected):
+		api.writeError(rw, http.StatusNotFound, err)
+		return
+	case errors.As(err, &NotUniqueError{}), errors.As(err, &NotExistError{}):
+		api.writeError(rw, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		api.writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch {
+	case cfg == nil:
+		api.totalDeletedConfigs.Inc()
+	case created:
+		api.totalCreatedConfigs.Inc()
+	default:
+		api.totalUpdatedConfigs.Inc()
+	}
+
+	var resp configapi.RollbackConfigurationResponse
+	if rev, ok := api.recordRevision(r, configKey, OperationRollback, prev, cfg); ok {
+		resp.Revision = &rev
+	}
+	api.writeResponse(rw, http.StatusOK, &resp)
+}
+
+// ValidateConfigurations validates a set of configurations without storing
+// them. Each configuration is validated the same way as PutConfiguration,
+// and scrape jobs must be unique across the set and the existing
+// configurations. The changes which would be made by ApplyConfigurations are
+// returned.
+func (api *API) ValidateConfigurations(rw http.ResponseWriter, r *http.Request) {
+	api.storeMut.Lock()
+	defer api.storeMut.Unlock()
+	if api.store == nil {
+		api.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
+		return
+	}
+
+	plan, ok := api.readApplyPlan(rw, r)
+	if !ok {
+		return
+	}
+	api.writeResponse(rw, http.StatusOK, &plan.resp)
+}
+
+// ApplyConfigurations applies a set of configurations. Either every
+// configuration is applied or none are: the set is rejected if any
+// configuration fails validation, and changes are reverted if storing any
+// configuration fails. The error lists the configurations which couldn't be
+// reverted, if any.
+func (api *API) ApplyConfigurations(rw http.ResponseWriter, r *http.Request) {
+	api.storeMut.Lock()
+	defer api.storeMut.Unlock()
+	if api.store == nil {
+		api.writeError(rw, http.StatusNotFound, fmt.Errorf("no config store running"))
+		return
+	}
+
+	plan, ok := api.readApplyPlan(rw, r)
+	if !ok {
+		return
+	}
+
+	err := api.apply(r.Context(), plan)
+	switch {
+	case errors.As(err, &RevertFailedError{}):
+		api.writeError(rw, http.StatusInternalServerError, err)
+		return
+	case errors.Is(err, ErrNotConnected):
+		api.writeError(rw, http.StatusNotFound, err)
+		return
+	case errors.As(err, &NotUniqueError{}):
+		api.writeError(rw, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		api.writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, cfg := range plan.puts {
+		prev := ""
+		if prevCfg, ok := plan.prev[cfg.Name]; ok {
+			prev = marshalValue(&prevCfg)
+		}
+		api.recordRevision(r, cfg.Name, OperationPut, prev, cfg)
+	}
+	for _, name := range plan.deletes {
+		prevCfg := plan.prev[name]
+		api.recordRevision(r, name, OperationDelete, marshalValue(&prevCfg), nil)
+	}
+
+	auditConfigs(r, plan.resp.Deleted...)
+
+	api.totalCreatedConfigs.Add(float64(len(plan.resp.Created)))
+	api.totalUpdatedConfigs.Add(float64(len(plan.resp.Updated)))
+	api.totalDeletedConfigs.Add(float64(len(plan.resp.Deleted)))
+	api.writeResponse(rw, http.StatusOK, &plan.resp)
+}
+
+// readApplyPlan reads a set of configurations from the body of r and plans
+// applying them. The client must be allowed to change every configuration in
+// the set, and pruning only deletes configurations the client is allowed to
+// change. If reading or planning fails, an error is written to rw and ok is
+// false.
+func (api *API) readApplyPlan(rw http.ResponseWriter, r *http.Request) (plan *applyPlan, ok bool) {
+	var req configapi.ApplyConfigurationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.writeError(rw, http.StatusBadRequest, fmt.Errorf("could not decode request: %w", err))
+		return nil, false
+	}
+
+	for _, v := range req.Configs {
+		auditConfigs(r, v.Name)
+	}
+	for _, v := range req.Configs {
+		if !api.authorize(rw, r, v.Name, true) {
+			return nil, false
+		}
+	}
+
+	scope := func(string) bool { return true }
+	if id := identityFromContext(r.Context()); id != nil {
+		scope = func(name string) bool { return id.Allowed(name, true) }
+	}
+
+	plan, err := api.planApply(r.Context(), req, scope)
+	switch {
+	case errors.Is(err, ErrNotConnected):
+		api.writeError(rw, http.StatusNotFound, err)
+		return nil, false
+	case errors.As(err, &InvalidConfigsError{}):
+		api.writeError(rw, http.StatusBadRequest, err)
+		return nil, false
+	case err != nil:
+		api.writeError(rw, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return plan, true
+}
+
+// parseConfig unmarshals the config named name from its YAML and validates
+// it.
+func (api *API) parseConfig(name, value string) (*instance.Config, error) {
+	cfg, err := instance.UnmarshalConfig(strings.NewReader(value))
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal config: %w", err)
+	}
+	cfg.Name = name
+
+	if api.validator != nil {
+		validateCfg, err := instance.UnmarshalConfig(strings.NewReader(value))
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal config: %w", err)
+		}
+		validateCfg.Name = name
+
+		if err := api.validator(validateCfg); err != nil {
+			return nil, fmt.Errorf("failed to validate config: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// currentValue returns the YAML of the config with the given key in the
+// store to diff changes against. It returns an empty string if history is
+// disabled or the config doesn't exist.
+func (api *API) currentValue(ctx context.Context, key string) string {
+	if api.history == nil {
+		return ""
+	}
+
+	cfg, err := api.store.Get(ctx, key)
+	if errors.As(err, &NotExistError{}) {
+		return ""
+	} else if err != nil {
+		level.Warn(api.log).Log("msg", "failed to get config to record revision", "name", key, "err", err)
+		return ""
+	}
+
+	bb, err := instance.MarshalConfig(&cfg, false)
+	if err != nil {
+		level.Warn(api.log).Log("msg", "failed to marshal config to record revision", "name", key, "err", err)
+		return ""
+	}
+	return string(bb)
+}
+
+// recordRevision records a change to a config made by the request r in the
+// history. cfg is nil if the config was deleted. Failures are logged, since
+// the change has already been applied to the store.
+func (api *API) recordRevision(r *http.Request, key, op, prev string, cfg *instance.Config) (configapi.ConfigRevision, bool) {
+	if api.history == nil {
+		return configapi.ConfigRevision{}, false
+	}
+
+	var next string
+	if cfg != nil {
+		bb, err := instance.MarshalConfig(cfg, false)
+		if err != nil {
+			level.Error(api.log).Log("msg", "failed to marshal config to record revision", "name", key, "err", err)
+			return configapi.ConfigRevision{}, false
+		}
+		next = string(bb)
+	}
+
+	rev, err := api.history.Record(r.Context(), key, configAuthor(r), op, prev, next)
+	if err != nil {
+		level.Error(api.log).Log("msg", "failed to record config revision", "name", key, "err", err)
+		return configapi.ConfigRevision{}, false
+	}
+	return rev, true
+}
+
+// AuthorHeader is the HTTP header used to set the author of a change to a
+// config. It is ignored for authenticated requests, which use the name of the
+// authenticated client. The remote address of the request is used when it is
+// not set.
+const AuthorHeader = "X-Config-Author"
+
+func configAuthor(r *http.Request) string {
+	if id := identityFromContext(r.Context()); id != nil {
+		return id.Name
+	}
+	if author := r.Header.Get(AuthorHeader); author != "" {
+		return author
+	}
+	return r.RemoteAddr
+}
+
+// handle wraps h to authenticate requests. action names the change made by
+// h for the audit log, and is empty for handlers which don't make changes.
+func (api *API) handle(action string, h http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		api.storeMut.Lock()
+		auth := api.auth
+		api.storeMut.Unlock()
+
+		if auth == nil {
+			h(rw, r)
+			return
+		}
+
+		if action != "" {
+			rec := &auditRecord{}
+			if name, err := getConfigName(r); err == nil && name != "" {
+				rec.configs = append(rec.configs, name)
+			}
+
+			sw := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+			rw = sw
+			r = r.WithContext(context.WithValue(r.Context(), auditKey{}, rec))
+			defer func() {
+				auth.Audit(r, identityFromContext(r.Context()), action, rec.configs, sw.status)
+			}()
+		}
+
+		id, err := auth.Authenticate(r)
+		if err != nil {
+			api.writeError(rw, http.StatusUnauthorized, err)
+			return
+		}
+		if id != nil {
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+		}
+		h(rw, r)
+	}
+}
+
+// authorize checks that the client making the request r is allowed to
+// access the config with the given name. write must be true for changing the
+// config. If the client isn't allowed, an error is written to rw and false is
+// returned.
+func (api *API) authorize(rw http.ResponseWriter, r *http.Request, name string, write bool) bool {
+	id := identityFromContext(r.Context())
+	if id == nil || id.Allowed(name, write) {
+		return true
+	}
+
+	access := "read"
+	if write {
+		access = "change"
+	}
+	api.writeError(rw, http.StatusForbidden, fmt.Errorf("%s is not allowed to %s config %s", id.Name, access, name))
+	return false
+}
+
+type auditKey struct{}
+
+// auditRecord holds the configs changed by a request for the audit log.
+type auditRecord struct {
+	configs []string
+}
+
+// auditConfigs adds configs changed by the request r to its audit log
+// entry. It does nothing if r isn't audited.
+func auditConfigs(r *http.Request, names ...string) {
+	if rec, ok := r.Context().Value(auditKey{}).(*auditRecord); ok {
+		rec.configs = append(rec.configs, names...)
+	}
+}
+
+// statusWriter records the status code written to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
 func (api *API) writeError(rw http.ResponseWriter, statusCode int, writeErr error) {
 	err := configapi.WriteError(rw, statusCode, writeErr)
 	if err != nil {
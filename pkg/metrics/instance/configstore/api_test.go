@@ -3,13 +3,17 @@ package configstore
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
 	"github.com/go-kit/kit/log"
 	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/client"
@@ -261,6 +265,346 @@ func TestServer_URLEncoded(t *testing.T) {
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestServer_ConfigHistory(t *testing.T) {
+	configs := make(map[string]instance.Config)
+	s := newMapStore(configs)
+
+	api := NewAPI(log.NewNopLogger(), s, nil)
+	api.SetHistory(NewHistory(consul.NewInMemoryClient(GetCodec()), 10))
+	env := newAPITestEnvironment(t, api)
+	cli := client.New(env.srv.URL)
+
+	putConfig := func(hostFilter bool) {
+		cfg := instance.DefaultConfig
+		cfg.HostFilter = hostFilter
+		bb, err := instance.MarshalConfig(&cfg, false)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, env.srv.URL+"/agent/api/v1/config/history", bytes.NewReader(bb))
+		require.NoError(t, err)
+		req.Header.Set(AuthorHeader, "alice")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Less(t, resp.StatusCode, 300)
+	}
+
+	putConfig(false)
+	putConfig(true)
+	require.NoError(t, cli.DeleteConfiguration(context.Background(), "history"))
+
+	resp, err := cli.ListConfigRevisions(context.Background(), "history")
+	require.NoError(t, err)
+	require.Len(t, resp.Revisions, 3)
+	require.Equal(t, "alice", resp.Revisions[0].Author)
+	require.Equal(t, OperationPut, resp.Revisions[1].Operation)
+	require.Contains(t, resp.Revisions[1].Diff, "+host_filter: true")
+	require.Equal(t, OperationDelete, resp.Revisions[2].Operation)
+
+	t.Run("Rollback", func(t *testing.T) {
+		resp, err := cli.RollbackConfiguration(context.Background(), "history", 1)
+		require.NoError(t, err)
+		require.NotNil(t, resp.Revision)
+		require.Equal(t, 4, resp.Revision.ID)
+		require.Equal(t, OperationRollback, resp.Revision.Operation)
+
+		require.Contains(t, configs, "history")
+		require.False(t, configs["history"].HostFilter)
+	})
+
+	t.Run("Rollback to deletion", func(t *testing.T) {
+		_, err := cli.RollbackConfiguration(context.Background(), "history", 3)
+		require.NoError(t, err)
+		require.NotContains(t, configs, "history")
+	})
+
+	t.Run("Unknown revision", func(t *testing.T) {
+		_, err := cli.RollbackConfiguration(context.Background(), "history", 100)
+		require.EqualError(t, err, "revision 100 of configuration history does not exist")
+	})
+}
+
+func TestServer_ConfigHistory_Disabled(t *testing.T) {
+	api := NewAPI(log.NewNopLogger(), &Mock{}, nil)
+	env := newAPITestEnvironment(t, api)
+
+	resp, err := http.Get(env.srv.URL + "/agent/api/v1/configs/disabled/revisions")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServer_ValidateConfigurations(t *testing.T) {
+	configs := map[string]instance.Config{
+		"existing": newTestConfig(t, "existing", "existing-job"),
+		"other":    newTestConfig(t, "other", "other-job"),
+	}
+	s := newMapStore(configs)
+
+	api := NewAPI(log.NewNopLogger(), s, nil)
+	env := newAPITestEnvironment(t, api)
+	cli := client.New(env.srv.URL)
+
+	t.Run("Valid", func(t *testing.T) {
+		existing := newTestConfig(t, "existing", "existing-job")
+		created := newTestConfig(t, "created", "created-job")
+
+		resp, err := cli.ValidateConfigurations(context.Background(), []*instance.Config{&existing, &created}, true)
+		require.NoError(t, err)
+		require.Equal(t, []string{"created"}, resp.Created)
+		require.Equal(t, []string{"existing"}, resp.Unchanged)
+		require.Equal(t, []string{"other"}, resp.Deleted)
+
+		// Nothing is stored.
+		require.Len(t, configs, 2)
+		require.NotContains(t, configs, "created")
+	})
+
+	t.Run("Not unique", func(t *testing.T) {
+		a := newTestConfig(t, "a", "other-job")
+		b := newTestConfig(t, "b", "b-job")
+
+		_, err := cli.ValidateConfigurations(context.Background(), []*instance.Config{&a, &b}, false)
+		require.EqualError(t, err, `invalid configs: config a: found multiple scrape configs in config store with job name "other-job"`)
+
+		// Jobs of configs which would be pruned don't conflict.
+		_, err = cli.ValidateConfigurations(context.Background(), []*instance.Config{&a, &b}, true)
+		require.NoError(t, err)
+	})
+}
+
+func TestServer_ValidateConfigurations_Invalid(t *testing.T) {
+	s := newMapStore(map[string]instance.Config{})
+
+	api := NewAPI(log.NewNopLogger(), s, func(c *instance.Config) error {
+		if c.Name == "bad" {
+			return fmt.Errorf("custom validation error")
+		}
+		return nil
+	})
+	env := newAPITestEnvironment(t, api)
+
+	body := `{"configs": [
+		{"name": "good", "value": "{}"},
+		{"name": "bad", "value": "{}"},
+		{"name": "malformed", "value": "not_a_field: true"}
+	]}`
+	resp, err := http.Post(env.srv.URL+"/agent/api/v1/configs/validate", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var apiResp struct {
+		Data configapi.ErrorResponse `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&apiResp))
+	require.Contains(t, apiResp.Data.Error, "config bad: failed to validate config: custom validation error")
+	require.Contains(t, apiResp.Data.Error, "config malformed: could not unmarshal config")
+	require.NotContains(t, apiResp.Data.Error, "config good")
+}
+
+func TestServer_ApplyConfigurations(t *testing.T) {
+	configs := map[string]instance.Config{
+		"from": newTestConfig(t, "from", "moved-job"),
+		"old":  newTestConfig(t, "old", "old-job"),
+	}
+	s := newMapStore(configs)
+
+	api := NewAPI(log.NewNopLogger(), s, nil)
+	env := newAPITestEnvironment(t, api)
+	cli := client.New(env.srv.URL)
+
+	// The job is moved from one config to another, which can only be stored
+	// once "from" is updated.
+	to := newTestConfig(t, "to", "moved-job")
+	from := newTestConfig(t, "from", "new-job")
+
+	resp, err := cli.ApplyConfigurations(context.Background(), []*instance.Config{&to, &from}, true)
+	require.NoError(t, err)
+	require.Equal(t, &configapi.ApplyConfigurationsResponse{
+		Created:   []string{"to"},
+		Updated:   []string{"from"},
+		Unchanged: []string{},
+		Deleted:   []string{"old"},
+	}, resp)
+
+	require.Len(t, configs, 2)
+	require.Equal(t, "new-job", configs["from"].ScrapeConfigs[0].JobName)
+	require.Equal(t, "moved-job", configs["to"].ScrapeConfigs[0].JobName)
+}
+
+func TestServer_ApplyConfigurations_Revert(t *testing.T) {
+	configs := map[string]instance.Config{
+		"a": newTestConfig(t, "a", "a-job"),
+	}
+	s := newMapStore(configs)
+
+	// Fail storing b after a was already updated.
+	putFunc := s.PutFunc
+	s.PutFunc = func(ctx context.Context, c instance.Config) (created bool, err error) {
+		if c.Name == "b" {
+			return false, fmt.Errorf("store unavailable")
+		}
+		return putFunc(ctx, c)
+	}
+
+	api := NewAPI(log.NewNopLogger(), s, nil)
+	env := newAPITestEnvironment(t, api)
+	cli := client.New(env.srv.URL)
+
+	a := newTestConfig(t, "a", "a-new-job")
+	b := newTestConfig(t, "b", "b-job")
+
+	_, err := cli.ApplyConfigurations(context.Background(), []*instance.Config{&a, &b}, false)
+	require.EqualError(t, err, "failed to put config b: store unavailable")
+
+	require.Len(t, configs, 1)
+	require.Equal(t, "a-job", configs["a"].ScrapeConfigs[0].JobName)
+
+	// Configs which couldn't be reverted are reported.
+	s.PutFunc = func(ctx context.Context, c instance.Config) (created bool, err error) {
+		if c.Name == "b" || c.ScrapeConfigs[0].JobName == "a-job" {
+			return false, fmt.Errorf("store unavailable")
+		}
+		return putFunc(ctx, c)
+	}
+
+	_, err = cli.ApplyConfigurations(context.Background(), []*instance.Config{&a, &b}, false)
+	require.EqualError(t, err, "failed to put config b: store unavailable; failed to revert configs: config a: store unavailable")
+	require.Equal(t, "a-new-job", configs["a"].ScrapeConfigs[0].JobName)
+}
+
+func TestServer_Auth(t *testing.T) {
+	configs := map[string]instance.Config{
+		"team-a.existing": newTestConfig(t, "team-a.existing", "team-a-job"),
+		"team-b.existing": newTestConfig(t, "team-b.existing", "team-b-job"),
+	}
+	s := newMapStore(configs)
+	s.ListFunc = func(ctx context.Context) ([]string, error) {
+		keys := make([]string, 0, len(configs))
+		for key := range configs {
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	auth, err := NewAuth(log.NewNopLogger(), AuthConfig{
+		Tokens: []TokenConfig{
+			{Name: "team-a", Token: "team-a-token", PermissionsConfig: PermissionsConfig{Tenant: "team-a"}},
+			{Name: "viewer", Token: "viewer-token", PermissionsConfig: PermissionsConfig{ReadOnly: true}},
+		},
+		AuditLogFile: auditFile,
+	})
+	require.NoError(t, err)
+
+	api := NewAPI(log.NewNopLogger(), s, nil)
+	api.SetAuth(auth)
+	env := newAPITestEnvironment(t, api)
+
+	ctx := context.Background()
+	cfg := newTestConfig(t, "", "new-job")
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		_, err := client.New(env.srv.URL).ListConfigs(ctx)
+		require.EqualError(t, err, ErrUnauthenticated.Error())
+	})
+
+	t.Run("list is filtered", func(t *testing.T) {
+		resp, err := client.NewWithBearerToken(env.srv.URL, "team-a-token").ListConfigs(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"team-a.existing"}, resp.Configs)
+	})
+
+	t.Run("put outside tenant", func(t *testing.T) {
+		cli := client.NewWithBearerToken(env.srv.URL, "team-a-token")
+		err := cli.PutConfiguration(ctx, "team-b.new", &cfg)
+		require.EqualError(t, err, "team-a is not allowed to change config team-b.new")
+
+		err = cli.PutConfiguration(ctx, "team-a.new", &cfg)
+		require.NoError(t, err)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		cli := client.NewWithBearerToken(env.srv.URL, "viewer-token")
+		_, err := cli.GetConfiguration(ctx, "team-b.existing")
+		require.NoError(t, err)
+
+		err = cli.DeleteConfiguration(ctx, "team-b.existing")
+		require.EqualError(t, err, "viewer is not allowed to change config team-b.existing")
+	})
+
+	t.Run("prune is limited to tenant", func(t *testing.T) {
+		cli := client.NewWithBearerToken(env.srv.URL, "team-a-token")
+
+		applyCfg := newTestConfig(t, "team-a.applied", "applied-job")
+		resp, err := cli.ApplyConfigurations(ctx, []*instance.Config{&applyCfg}, true)
+		require.NoError(t, err)
+		require.Equal(t, []string{"team-a.applied"}, resp.Created)
+		require.Equal(t, []string{"team-a.existing", "team-a.new"}, resp.Deleted)
+
+		require.Contains(t, configs, "team-b.existing")
+	})
+
+	require.NoError(t, auth.Close())
+	bb, err := ioutil.ReadFile(auditFile)
+	require.NoError(t, err)
+
+	audit := string(bb)
+	require.Contains(t, audit, "action=put identity=team-a")
+	require.Contains(t, audit, "configs=team-b.new status=403")
+	require.Contains(t, audit, "configs=team-a.new status=201")
+	require.Contains(t, audit, "action=delete identity=viewer")
+	require.Contains(t, audit, "configs=team-a.applied,team-a.existing,team-a.new status=200")
+	require.NotContains(t, audit, "action=get")
+}
+
+// newMapStore returns a Mock store backed by configs. Like other stores, it
+// checks that scrape jobs are unique on Put.
+func newMapStore(configs map[string]instance.Config) *Mock {
+	all := func() <-chan instance.Config {
+		list := make([]instance.Config, 0, len(configs))
+		for _, cfg := range configs {
+			list = append(list, cfg)
+		}
+		return configsChan(list)
+	}
+
+	return &Mock{
+		GetFunc: func(ctx context.Context, key string) (instance.Config, error) {
+			cfg, ok := configs[key]
+			if !ok {
+				return instance.Config{}, NotExistError{Key: key}
+			}
+			return cfg, nil
+		},
+		PutFunc: func(ctx context.Context, c instance.Config) (created bool, err error) {
+			if err := checkUnique(all(), &c); err != nil {
+				return false, err
+			}
+			_, exist := configs[c.Name]
+			configs[c.Name] = c
+			return !exist, nil
+		},
+		DeleteFunc: func(ctx context.Context, key string) error {
+			if _, exist := configs[key]; !exist {
+				return NotExistError{Key: key}
+			}
+			delete(configs, key)
+			return nil
+		},
+		AllFunc: func(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+			return all(), nil
+		},
+	}
+}
+
+func newTestConfig(t *testing.T, name, job string) instance.Config {
+	t.Helper()
+
+	cfg, err := instance.UnmarshalConfig(strings.NewReader(fmt.Sprintf("scrape_configs:\n- job_name: %s", job)))
+	require.NoError(t, err)
+	cfg.Name = name
+	return *cfg
+}
+
 type apiTestEnvironment struct {
 	srv    *httptest.Server
 	router *mux.Router
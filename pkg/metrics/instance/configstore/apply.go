@@ -0,0 +1,264 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	"github.com/grafana/agent/pkg/metrics/instance"
+)
+
+// InvalidConfigsError is returned when a set of configs to apply fails
+// validation. It holds one error per invalid config.
+type InvalidConfigsError struct {
+	Errors []string
+}
+
+// Error implements error.
+func (e InvalidConfigsError) Error() string {
+	return fmt.Sprintf("invalid configs: %s", strings.Join(e.Errors, "; "))
+}
+
+// RevertFailedError is returned when applying a set of configs failed and
+// some of the changes already made couldn't be reverted. It holds one error
+// per config left changed in the store.
+type RevertFailedError struct {
+	Err    error
+	Errors []string
+}
+
+// Error implements error.
+func (e RevertFailedError) Error() string {
+	return fmt.Sprintf("%s; failed to revert configs: %s", e.Err, strings.Join(e.Errors, "; "))
+}
+
+// Unwrap returns the error which caused the changes to be reverted.
+func (e RevertFailedError) Unwrap() error {
+	return e.Err
+}
+
+// revertTimeout is how long reverting the changes of a failed apply may take.
+// Changes are reverted even if the request was canceled.
+const revertTimeout = 30 * time.Second
+
+// applyPlan is a validated set of changes to make to a Store.
+type applyPlan struct {
+	// puts are the configs to create or update.
+	puts []*instance.Config
+	// deletes are the keys of configs to delete.
+	deletes []string
+	// prev holds the existing configs which are updated or deleted.
+	prev map[string]instance.Config
+
+	resp configapi.ApplyConfigurationsResponse
+}
+
+// planApply validates the configs in req and determines the changes needed to
+// apply them to the store. Every config is validated before returning, and
+// InvalidConfigsError is returned if any config is invalid. When pruning,
+// only existing configs for which scope returns true are deleted.
+//
+// planApply must be called with storeMut held.
+func (api *API) planApply(ctx context.Context, req configapi.ApplyConfigurationsRequest, scope func(name string) bool) (*applyPlan, error) {
+	var (
+		invalid []string
+		configs = make(map[string]*instance.Config, len(req.Configs))
+		names   = make([]string, 0, len(req.Configs))
+	)
+	for _, v := range req.Configs {
+		if v.Name == "" {
+			invalid = append(invalid, "config with empty name")
+			continue
+		} else if _, exist := configs[v.Name]; exist {
+			invalid = append(invalid, fmt.Sprintf("config %s: defined multiple times", v.Name))
+			continue
+		}
+
+		cfg, err := api.parseConfig(v.Name, v.Value)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("config %s: %s", v.Name, err))
+			continue
+		}
+		configs[v.Name] = cfg
+		names = append(names, v.Name)
+	}
+
+	existing := make(map[string]instance.Config)
+	all, err := api.store.All(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing configs: %w", err)
+	}
+	for cfg := range all {
+		existing[cfg.Name] = cfg
+	}
+
+	// Validate uniqueness against the set of configs that would be in the
+	// store once everything is applied.
+	final := make([]instance.Config, 0, len(existing)+len(configs))
+	for name, cfg := range existing {
+		if _, replaced := configs[name]; replaced || (req.Prune && scope(name)) {
+			continue
+		}
+		final = append(final, cfg)
+	}
+	for _, name := range names {
+		final = append(final, *configs[name])
+	}
+	for _, name := range names {
+		if err := checkUnique(configsChan(final), configs[name]); err != nil {
+			invalid = append(invalid, fmt.Sprintf("config %s: %s", name, err))
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, InvalidConfigsError{Errors: invalid}
+	}
+
+	plan := &applyPlan{
+		prev: make(map[string]instance.Config),
+		resp: configapi.ApplyConfigurationsResponse{
+			Created:   []string{},
+			Updated:   []string{},
+			Unchanged: []string{},
+			Deleted:   []string{},
+		},
+	}
+	for _, name := range names {
+		cfg := configs[name]
+
+		prev, exist := existing[name]
+		switch {
+		case !exist:
+			plan.resp.Created = append(plan.resp.Created, name)
+		case isUnchanged(&prev, cfg):
+			plan.resp.Unchanged = append(plan.resp.Unchanged, name)
+			continue
+		default:
+			plan.resp.Updated = append(plan.resp.Updated, name)
+			plan.prev[name] = prev
+		}
+		plan.puts = append(plan.puts, cfg)
+	}
+	if req.Prune {
+		for name, prev := range existing {
+			if _, keep := configs[name]; keep || !scope(name) {
+				continue
+			}
+			plan.resp.Deleted = append(plan.resp.Deleted, name)
+			plan.deletes = append(plan.deletes, name)
+			plan.prev[name] = prev
+		}
+	}
+
+	for _, list := range [][]string{plan.resp.Created, plan.resp.Updated, plan.resp.Unchanged, plan.resp.Deleted, plan.deletes} {
+		sort.Strings(list)
+	}
+	return plan, nil
+}
+
+// apply makes the changes in plan to the store. If any change fails, the
+// changes already made are reverted so the store is left as it was. Changes
+// are made one config at a time, so agents watching the store may briefly see
+// and run some of the changes before they are reverted. RevertFailedError is
+// returned if some changes couldn't be reverted.
+//
+// apply must be called with storeMut held.
+func (api *API) apply(ctx context.Context, plan *applyPlan) error {
+	var applied []string
+
+	revert := func(applyErr error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), revertTimeout)
+		defer cancel()
+
+		var failed []string
+		for i := len(applied) - 1; i >= 0; i-- {
+			name := applied[i]
+
+			var err error
+			if prev, ok := plan.prev[name]; ok {
+				_, err = api.store.Put(ctx, prev)
+			} else {
+				err = api.store.Delete(ctx, name)
+			}
+			if err != nil {
+				level.Error(api.log).Log("msg", "failed to revert config after failed apply", "name", name, "err", err)
+				failed = append(failed, fmt.Sprintf("config %s: %s", name, err))
+			}
+		}
+
+		if len(failed) > 0 {
+			return RevertFailedError{Err: applyErr, Errors: failed}
+		}
+		return applyErr
+	}
+
+	// Deletes are applied first since they can only free up job names used by
+	// the configs being put.
+	for _, name := range plan.deletes {
+		err := api.store.Delete(ctx, name)
+		if err != nil && !errors.As(err, &NotExistError{}) {
+			return revert(fmt.Errorf("failed to delete config %s: %w", name, err))
+		}
+		applied = append(applied, name)
+	}
+
+	// Stores check uniqueness on every put, so moving a job from one config
+	// to another can fail depending on the order configs are put in. Configs
+	// failing because of uniqueness are retried until no more progress is
+	// made.
+	pending := plan.puts
+	for len(pending) > 0 {
+		var (
+			retry   []*instance.Config
+			lastErr error
+		)
+		for _, cfg := range pending {
+			_, err := api.store.Put(ctx, *cfg)
+			if errors.As(err, &NotUniqueError{}) {
+				retry = append(retry, cfg)
+				lastErr = fmt.Errorf("failed to put config %s: %w", cfg.Name, err)
+				continue
+			} else if err != nil {
+				return revert(fmt.Errorf("failed to put config %s: %w", cfg.Name, err))
+			}
+			applied = append(applied, cfg.Name)
+		}
+
+		if len(retry) == len(pending) {
+			return revert(lastErr)
+		}
+		pending = retry
+	}
+
+	return nil
+}
+
+func configsChan(cfgs []instance.Config) <-chan instance.Config {
+	ch := make(chan instance.Config, len(cfgs))
+	for _, cfg := range cfgs {
+		ch <- cfg
+	}
+	close(ch)
+	return ch
+}
+
+// isUnchanged returns true if prev and next marshal to the same YAML.
+func isUnchanged(prev, next *instance.Config) bool {
+	prevValue := marshalValue(prev)
+	return prevValue != "" && prevValue == marshalValue(next)
+}
+
+// marshalValue returns the YAML of cfg, or an empty string if it can't be
+// marshaled.
+func marshalValue(cfg *instance.Config) string {
+	bb, err := instance.MarshalConfig(cfg, false)
+	if err != nil {
+		return ""
+	}
+	return string(bb)
+}
@@ -0,0 +1,258 @@
+package configstore
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	config_util "github.com/prometheus/common/config"
+)
+
+// AuthConfig configures authentication and authorization for the API. When
+// no tokens or client certificates are configured, all requests are allowed.
+type AuthConfig struct {
+	// Tokens are the bearer tokens accepted by the API.
+	Tokens []TokenConfig `yaml:"tokens,omitempty"`
+
+	// ClientCertificates are the TLS client identities accepted by the API.
+	// Clients are only identified by certificates which were verified by the
+	// server, which requires the server's client_auth_type to verify client
+	// certificates.
+	ClientCertificates []ClientCertificateConfig `yaml:"client_certificates,omitempty"`
+
+	// File to write the audit log of writes to. When empty, the audit log is
+	// written to the Agent's log.
+	AuditLogFile string `yaml:"audit_log_file,omitempty"`
+}
+
+// Enabled returns true if requests must be authenticated.
+func (c *AuthConfig) Enabled() bool {
+	return len(c.Tokens) > 0 || len(c.ClientCertificates) > 0
+}
+
+// TenantSeparator separates the tenant from the rest of the name of a
+// tenant's configs. Unlike a slash, it is accepted in config names by every
+// config store.
+const TenantSeparator = "."
+
+// PermissionsConfig limits the configs a credential can access.
+type PermissionsConfig struct {
+	// Tenant limits access to configs whose name starts with the tenant
+	// followed by TenantSeparator, e.g. "team-a.".
+	Tenant string `yaml:"tenant,omitempty"`
+
+	// ConfigPrefixes limits access to configs whose name starts with one of
+	// the prefixes.
+	ConfigPrefixes []string `yaml:"config_prefixes,omitempty"`
+
+	// ReadOnly prevents changing configs.
+	ReadOnly bool `yaml:"read_only,omitempty"`
+}
+
+func (c *PermissionsConfig) validate() error {
+	if strings.Contains(c.Tenant, TenantSeparator) {
+		return fmt.Errorf("tenant %q must not contain %q", c.Tenant, TenantSeparator)
+	}
+	return nil
+}
+
+// TokenConfig is a bearer token accepted by the API.
+type TokenConfig struct {
+	// Name identifies the token holder in the audit log and config history.
+	Name string `yaml:"name"`
+
+	Token     config_util.Secret `yaml:"token,omitempty"`
+	TokenFile string             `yaml:"token_file,omitempty"`
+
+	PermissionsConfig `yaml:",inline"`
+}
+
+// ClientCertificateConfig is a TLS client identity accepted by the API.
+type ClientCertificateConfig struct {
+	// CommonName is the subject common name of the client certificate. It
+	// identifies the client in the audit log and config history.
+	CommonName string `yaml:"common_name"`
+
+	PermissionsConfig `yaml:",inline"`
+}
+
+// ErrUnauthenticated is returned when a request doesn't have valid
+// credentials.
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+// Identity is an authenticated client of the API.
+type Identity struct {
+	Name string
+
+	prefixes []string
+	readOnly bool
+}
+
+func newIdentity(name string, cfg PermissionsConfig) *Identity {
+	id := &Identity{
+		Name:     name,
+		prefixes: cfg.ConfigPrefixes,
+		readOnly: cfg.ReadOnly,
+	}
+	if cfg.Tenant != "" {
+		id.prefixes = append(id.prefixes, cfg.Tenant+TenantSeparator)
+	}
+	return id
+}
+
+// Allowed returns true if the identity may access the config with the given
+// name. write must be true for changing the config.
+func (id *Identity) Allowed(name string, write bool) bool {
+	if write && id.readOnly {
+		return false
+	}
+	if len(id.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range id.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// identityFromContext returns the identity of an authenticated request. It
+// returns nil when authentication is disabled.
+func identityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// Auth authenticates requests to the API and writes the audit log.
+type Auth struct {
+	enabled bool
+	tokens  map[string]*Identity
+	certs   map[string]*Identity
+
+	audit  log.Logger
+	closer io.Closer
+}
+
+// NewAuth creates a new Auth from cfg. Audit logs are written to l unless
+// cfg sets an audit log file.
+func NewAuth(l log.Logger, cfg AuthConfig) (*Auth, error) {
+	a := &Auth{
+		enabled: cfg.Enabled(),
+		tokens:  make(map[string]*Identity, len(cfg.Tokens)),
+		certs:   make(map[string]*Identity, len(cfg.ClientCertificates)),
+	}
+
+	for _, tc := range cfg.Tokens {
+		if tc.Token != "" && tc.TokenFile != "" {
+			return nil, fmt.Errorf("token and token_file for %s are mutually exclusive", tc.Name)
+		}
+
+		token := string(tc.Token)
+		if tc.TokenFile != "" {
+			bb, err := ioutil.ReadFile(tc.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read token file for %s: %w", tc.Name, err)
+			}
+			token = strings.TrimSpace(string(bb))
+		}
+		if token == "" {
+			return nil, fmt.Errorf("token for %s must not be empty", tc.Name)
+		}
+		if err := tc.validate(); err != nil {
+			return nil, fmt.Errorf("invalid permissions for %s: %w", tc.Name, err)
+		}
+		a.tokens[token] = newIdentity(tc.Name, tc.PermissionsConfig)
+	}
+	for _, cc := range cfg.ClientCertificates {
+		if err := cc.validate(); err != nil {
+			return nil, fmt.Errorf("invalid permissions for %s: %w", cc.CommonName, err)
+		}
+		a.certs[cc.CommonName] = newIdentity(cc.CommonName, cc.PermissionsConfig)
+	}
+
+	if cfg.AuditLogFile != "" {
+		f, err := os.OpenFile(cfg.AuditLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log file: %w", err)
+		}
+		a.audit = log.With(log.NewLogfmtLogger(log.NewSyncWriter(f)), "ts", log.DefaultTimestampUTC)
+		a.closer = f
+	} else {
+		a.audit = level.Info(log.With(l, "component", "configapi_audit"))
+	}
+
+	return a, nil
+}
+
+// Authenticate returns the identity of the client making the request r. The
+// identity is nil if authentication is disabled. Bearer tokens are checked
+// before client certificates.
+func (a *Auth) Authenticate(r *http.Request) (*Identity, error) {
+	if !a.enabled {
+		return nil, nil
+	}
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header {
+			return nil, ErrUnauthenticated
+		}
+
+		// Compare against every token so the time taken doesn't reveal which
+		// tokens exist.
+		var found *Identity
+		for candidate, id := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+				found = id
+			}
+		}
+		if found == nil {
+			return nil, ErrUnauthenticated
+		}
+		return found, nil
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		if id, ok := a.certs[r.TLS.VerifiedChains[0][0].Subject.CommonName]; ok {
+			return id, nil
+		}
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+// Audit records a write request to the audit log. id is nil if the client
+// wasn't authenticated.
+func (a *Auth) Audit(r *http.Request, id *Identity, action string, configs []string, status int) {
+	identity := "anonymous"
+	if id != nil {
+		identity = id.Name
+	}
+	a.audit.Log(
+		"msg", "config api write",
+		"action", action,
+		"identity", identity,
+		"remote_addr", r.RemoteAddr,
+		"configs", strings.Join(configs, ","),
+		"status", status,
+	)
+}
+
+// Close closes the audit log file, if any.
+func (a *Auth) Close() error {
+	if a.closer != nil {
+		return a.closer.Close()
+	}
+	return nil
+}
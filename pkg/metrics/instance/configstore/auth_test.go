@@ -0,0 +1,121 @@
+package configstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth_Authenticate(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("file-token\n"), 0600))
+
+	a, err := NewAuth(log.NewNopLogger(), AuthConfig{
+		Tokens: []TokenConfig{
+			{Name: "admin", Token: "admin-token"},
+			{Name: "from-file", TokenFile: tokenFile},
+		},
+		ClientCertificates: []ClientCertificateConfig{
+			{CommonName: "client.example.com"},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = a.Close() })
+
+	tt := []struct {
+		name   string
+		header string
+		cn     string
+		expect string
+	}{
+		{name: "token", header: "Bearer admin-token", expect: "admin"},
+		{name: "token file", header: "Bearer file-token", expect: "from-file"},
+		{name: "unknown token", header: "Bearer other"},
+		{name: "not bearer", header: "Basic YWRtaW46YWRtaW4="},
+		{name: "client certificate", cn: "client.example.com", expect: "client.example.com"},
+		{name: "unknown client certificate", cn: "other.example.com"},
+		{name: "invalid token with client certificate", header: "Bearer other", cn: "client.example.com"},
+		{name: "no credentials"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+			if tc.cn != "" {
+				r.TLS = &tls.ConnectionState{
+					VerifiedChains: [][]*x509.Certificate{{
+						{Subject: pkix.Name{CommonName: tc.cn}},
+					}},
+				}
+			}
+
+			id, err := a.Authenticate(r)
+			if tc.expect == "" {
+				require.True(t, errors.Is(err, ErrUnauthenticated))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, id.Name)
+		})
+	}
+}
+
+func TestAuth_Disabled(t *testing.T) {
+	a, err := NewAuth(log.NewNopLogger(), AuthConfig{})
+	require.NoError(t, err)
+
+	id, err := a.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, err)
+	require.Nil(t, id)
+}
+
+func TestAuth_InvalidTenant(t *testing.T) {
+	_, err := NewAuth(log.NewNopLogger(), AuthConfig{
+		Tokens: []TokenConfig{
+			{Name: "team-a", Token: "team-a-token", PermissionsConfig: PermissionsConfig{Tenant: "team.a"}},
+		},
+	})
+	require.EqualError(t, err, `invalid permissions for team-a: tenant "team.a" must not contain "."`)
+}
+
+func TestIdentity_Allowed(t *testing.T) {
+	id := newIdentity("tenant", PermissionsConfig{
+		Tenant:         "team-a",
+		ConfigPrefixes: []string{"shared-"},
+	})
+	require.True(t, id.Allowed("team-a.config", true))
+	require.True(t, id.Allowed("shared-config", true))
+	require.False(t, id.Allowed("team-b.config", false))
+	require.False(t, id.Allowed("team-a", false))
+
+	id = newIdentity("viewer", PermissionsConfig{ReadOnly: true})
+	require.True(t, id.Allowed("config", false))
+	require.False(t, id.Allowed("config", true))
+}
+
+func TestAuth_AuditLogFile(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+
+	a, err := NewAuth(log.NewNopLogger(), AuthConfig{AuditLogFile: auditFile})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPut, "/", nil)
+	a.Audit(r, &Identity{Name: "admin"}, "put", []string{"a", "b"}, http.StatusCreated)
+	require.NoError(t, a.Close())
+
+	bb, err := ioutil.ReadFile(auditFile)
+	require.NoError(t, err)
+	require.Contains(t, string(bb), "action=put identity=admin remote_addr=192.0.2.1:1234 configs=a,b status=201")
+}
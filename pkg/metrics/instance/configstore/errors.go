@@ -16,6 +16,17 @@ func (e NotExistError) Error() string {
 	return fmt.Sprintf("configuration %s does not exist", e.Key)
 }
 
+// RevisionNotExistError is used when a revision of a config doesn't exist.
+type RevisionNotExistError struct {
+	Key string
+	ID  int
+}
+
+// Error implements error.
+func (e RevisionNotExistError) Error() string {
+	return fmt.Sprintf("revision %d of configuration %s does not exist", e.ID, e.Key)
+}
+
 // NotUniqueError is used when two scrape jobs have the same name.
 type NotUniqueError struct {
 	ScrapeJob string
@@ -0,0 +1,425 @@
+package configstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	fsnotify "gopkg.in/fsnotify/fsnotify.v1"
+)
+
+// configExtensions are the file extensions of config files in a Filesystem
+// store. New configs are written with the first extension.
+var configExtensions = []string{".yml", ".yaml"}
+
+// Filesystem is a Store backed by a directory of instance config files. Each
+// file holds one config, keyed by the name of the file without its .yml or
+// .yaml extension. Files starting with a dot are ignored.
+//
+// Changes to the directory are detected through filesystem notifications,
+// including changes made by other processes such as a GitOps tool. Not all
+// filesystems support notifications, in which case changes are only found by
+// polling All.
+type Filesystem struct {
+	log log.Logger
+	dir string
+
+	// writeMut serializes Put and Delete, which is needed for Put to check
+	// that scrape jobs are unique across the store.
+	writeMut sync.Mutex
+
+	watcher   *fsnotify.Watcher
+	cancel    context.CancelFunc
+	done      chan struct{}
+	configsCh chan WatchEvent
+
+	// known holds the contents of the config files as of the last scan of the
+	// directory, used to find out which configs changed.
+	known map[string][]byte
+}
+
+// NewFilesystem creates a new Filesystem store using the given directory,
+// which is created if it doesn't exist.
+func NewFilesystem(l log.Logger, dir string) (*Filesystem, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("config directory must be set")
+	}
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create filesystem watcher: %w", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch config directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Filesystem{
+		log: l,
+		dir: dir,
+
+		watcher:   watcher,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		configsCh: make(chan WatchEvent),
+	}
+
+	// Read the initial state of the directory so only later changes are
+	// reported by Watch.
+	s.known, err = s.readFiles()
+	if err != nil {
+		cancel()
+		watcher.Close()
+		return nil, err
+	}
+
+	go s.run(ctx)
+	return s, nil
+}
+
+func (s *Filesystem) run(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			// Changing permissions doesn't change a config.
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			s.rescan(ctx)
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			level.Error(s.log).Log("msg", "error watching config directory", "dir", s.dir, "err", err)
+		}
+	}
+}
+
+// rescan reads the directory and sends a WatchEvent for every config that
+// was added, changed, or removed since the last scan. The whole directory is
+// read instead of the file from the notification so that files replaced
+// through symlinks, such as Kubernetes ConfigMap volumes, are picked up.
+func (s *Filesystem) rescan(ctx context.Context) {
+	files, err := s.readFiles()
+	if err != nil {
+		level.Error(s.log).Log("msg", "failed to read config directory", "dir", s.dir, "err", err)
+		return
+	}
+
+	var events []WatchEvent
+	for key, contents := range files {
+		if prev, ok := s.known[key]; ok && bytes.Equal(prev, contents) {
+			continue
+		}
+
+		cfg, err := unmarshalNamed(key, contents)
+		if err != nil {
+			level.Error(s.log).Log("msg", "could not unmarshal config from directory", "name", key, "err", err)
+			continue
+		}
+		events = append(events, WatchEvent{Key: key, Config: cfg})
+	}
+	for key := range s.known {
+		if _, ok := files[key]; !ok {
+			events = append(events, WatchEvent{Key: key, Config: nil})
+		}
+	}
+	s.known = files
+
+	for _, ev := range events {
+		select {
+		case <-ctx.Done():
+			return
+		case s.configsCh <- ev:
+		}
+	}
+}
+
+// readFiles reads the contents of all config files in the directory.
+func (s *Filesystem) readFiles() (map[string][]byte, error) {
+	paths, err := s.configPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(paths))
+	for key, path := range paths {
+		bb, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// Deleted since the directory was listed.
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read config %s: %w", key, err)
+		}
+		files[key] = bb
+	}
+	return files, nil
+}
+
+// configPaths returns the paths of all config files in the directory by key.
+func (s *Filesystem) configPaths() (map[string]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config directory: %w", err)
+	}
+
+	paths := make(map[string]string, len(infos))
+	for _, fi := range infos {
+		key, ok := configKey(fi.Name())
+		if !ok {
+			continue
+		}
+
+		path := filepath.Join(s.dir, fi.Name())
+
+		// Follow symlinks to make sure they point to a file.
+		if fi.Mode()&os.ModeSymlink != 0 {
+			fi, err = os.Stat(path)
+			if err != nil {
+				continue
+			}
+		}
+		if fi.IsDir() {
+			continue
+		}
+
+		if prev, exist := paths[key]; exist {
+			level.Warn(s.log).Log("msg", "found multiple files for config, ignoring one", "name", key, "used", prev, "ignored", path)
+			continue
+		}
+		paths[key] = path
+	}
+	return paths, nil
+}
+
+// configKey returns the key of a config stored in the file with the given
+// name. ok is false if the file doesn't hold a config.
+func configKey(name string) (key string, ok bool) {
+	if strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	for _, ext := range configExtensions {
+		if strings.HasSuffix(name, ext) && len(name) > len(ext) {
+			return strings.TrimSuffix(name, ext), true
+		}
+	}
+	return "", false
+}
+
+// configPath returns the path of the file for key and whether it exists.
+func (s *Filesystem) configPath(key string) (path string, exists bool, err error) {
+	if key == "" || strings.HasPrefix(key, ".") || strings.ContainsAny(key, `/\`) {
+		return "", false, fmt.Errorf("invalid config name %q for a config directory", key)
+	}
+
+	for _, ext := range configExtensions {
+		path := filepath.Join(s.dir, key+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, true, nil
+		} else if !os.IsNotExist(err) {
+			return "", false, err
+		}
+	}
+	return filepath.Join(s.dir, key+configExtensions[0]), false, nil
+}
+
+// unmarshalNamed unmarshals a config stored under key, which is used as the
+// name of the config when it doesn't have one. Empty contents are a config
+// with all default values.
+func unmarshalNamed(key string, contents []byte) (*instance.Config, error) {
+	if len(bytes.TrimSpace(contents)) == 0 {
+		contents = []byte("{}")
+	}
+
+	cfg, err := instance.UnmarshalConfig(bytes.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.Name == "":
+		cfg.Name = key
+	case cfg.Name != key:
+		return nil, fmt.Errorf("config name %q does not match key %q", cfg.Name, key)
+	}
+	return cfg, nil
+}
+
+// List returns the list of all configs in the directory.
+func (s *Filesystem) List(ctx context.Context) ([]string, error) {
+	paths, err := s.configPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(paths))
+	for key := range paths {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Get retrieves an individual config from the directory.
+func (s *Filesystem) Get(ctx context.Context, key string) (instance.Config, error) {
+	path, exists, err := s.configPath(key)
+	if err != nil {
+		return instance.Config{}, err
+	} else if !exists {
+		return instance.Config{}, NotExistError{Key: key}
+	}
+
+	bb, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return instance.Config{}, NotExistError{Key: key}
+	} else if err != nil {
+		return instance.Config{}, fmt.Errorf("failed to get config %s: %w", key, err)
+	}
+
+	cfg, err := unmarshalNamed(key, bb)
+	if err != nil {
+		return instance.Config{}, fmt.Errorf("failed to unmarshal config %s: %w", key, err)
+	}
+	return *cfg, nil
+}
+
+// Put adds or updates a config in the directory. The file is replaced
+// atomically so readers never see a partially written config.
+func (s *Filesystem) Put(ctx context.Context, c instance.Config) (bool, error) {
+	s.writeMut.Lock()
+	defer s.writeMut.Unlock()
+
+	path, exists, err := s.configPath(c.Name)
+	if err != nil {
+		return false, err
+	}
+
+	bb, err := instance.MarshalConfig(&c, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	cfgCh, err := s.All(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to check validity of config: %w", err)
+	}
+	if err := checkUnique(cfgCh, &c); err != nil {
+		return false, fmt.Errorf("failed to check uniqueness of config: %w", err)
+	}
+
+	// Temporary files start with a dot so they're ignored by the store.
+	f, err := ioutil.TempFile(s.dir, "."+c.Name+".tmp")
+	if err != nil {
+		return false, fmt.Errorf("failed to put config: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bb); err != nil {
+		f.Close()
+		return false, fmt.Errorf("failed to put config: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return false, fmt.Errorf("failed to put config: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return false, fmt.Errorf("failed to put config: %w", err)
+	}
+	return !exists, nil
+}
+
+// Delete deletes a config from the directory. It returns NotExistError if the
+// config doesn't exist.
+func (s *Filesystem) Delete(ctx context.Context, key string) error {
+	s.writeMut.Lock()
+	defer s.writeMut.Unlock()
+
+	path, exists, err := s.configPath(key)
+	if err != nil {
+		return err
+	} else if !exists {
+		return NotExistError{Key: key}
+	}
+
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return NotExistError{Key: key}
+	} else if err != nil {
+		return fmt.Errorf("error deleting configuration: %w", err)
+	}
+	return nil
+}
+
+// All retrieves the set of all configs in the directory. Files which fail to
+// be read or unmarshaled are logged and skipped.
+func (s *Filesystem) All(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+	paths, err := s.configPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan instance.Config)
+	go func() {
+		defer close(ch)
+
+		for key, path := range paths {
+			if keep != nil && !keep(key) {
+				level.Debug(s.log).Log("msg", "skipping key that was filtered out", "key", key)
+				continue
+			}
+
+			bb, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				level.Debug(s.log).Log("msg", "skipping key that was deleted after list was called", "key", key)
+				continue
+			} else if err != nil {
+				level.Error(s.log).Log("msg", "failed to get config with key", "key", key, "err", err)
+				continue
+			}
+
+			cfg, err := unmarshalNamed(key, bb)
+			if err != nil {
+				level.Error(s.log).Log("msg", "failed to unmarshal config from directory", "key", key, "err", err)
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- *cfg:
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// Watch watches the directory for changed configs.
+func (s *Filesystem) Watch() <-chan WatchEvent {
+	return s.configsCh
+}
+
+// Close closes the Filesystem store.
+func (s *Filesystem) Close() error {
+	s.cancel()
+	err := s.watcher.Close()
+	<-s.done
+	return err
+}
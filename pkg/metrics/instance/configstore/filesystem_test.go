@@ -0,0 +1,173 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilesystem_List(t *testing.T) {
+	dir, s := newTestFilesystem(t)
+
+	writeConfigFile(t, dir, "a.yml", "name: a")
+	writeConfigFile(t, dir, "b.yaml", "name: b")
+	writeConfigFile(t, dir, "c.yml", "")
+	writeConfigFile(t, dir, ".hidden.yml", "name: hidden")
+	writeConfigFile(t, dir, "README.md", "not a config")
+
+	list, err := s.List(context.Background())
+	require.NoError(t, err)
+	sort.Strings(list)
+	require.Equal(t, []string{"a", "b", "c"}, list)
+}
+
+func TestFilesystem_Get(t *testing.T) {
+	dir, s := newTestFilesystem(t)
+	writeConfigFile(t, dir, "someconfig.yml", "name: someconfig")
+	writeConfigFile(t, dir, "unnamed.yaml", "")
+	writeConfigFile(t, dir, "mismatched.yml", "name: other")
+
+	cfg, err := s.Get(context.Background(), "someconfig")
+	require.NoError(t, err)
+
+	expect := instance.DefaultConfig
+	expect.Name = "someconfig"
+	require.Equal(t, expect, cfg)
+
+	// The name of the file is used when the config doesn't have one.
+	cfg, err = s.Get(context.Background(), "unnamed")
+	require.NoError(t, err)
+	require.Equal(t, "unnamed", cfg.Name)
+
+	_, err = s.Get(context.Background(), "mismatched")
+	require.Error(t, err)
+
+	_, err = s.Get(context.Background(), "missing")
+	require.True(t, errors.Is(err, NotExistError{Key: "missing"}))
+}
+
+func TestFilesystem_Put(t *testing.T) {
+	_, s := newTestFilesystem(t)
+
+	cfg := instance.DefaultConfig
+	cfg.Name = "newconfig"
+
+	created, err := s.Put(context.Background(), cfg)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	actual, err := s.Get(context.Background(), "newconfig")
+	require.NoError(t, err)
+	require.Equal(t, cfg, actual)
+
+	created, err = s.Put(context.Background(), cfg)
+	require.NoError(t, err)
+	require.False(t, created)
+
+	// Names that can't be used as file names are rejected.
+	cfg.Name = "../escape"
+	_, err = s.Put(context.Background(), cfg)
+	require.Error(t, err)
+}
+
+func TestFilesystem_Put_NotUnique(t *testing.T) {
+	dir, s := newTestFilesystem(t)
+	writeConfigFile(t, dir, "a.yml", "scrape_configs:\n- job_name: job")
+
+	cfg, err := instance.UnmarshalConfig(strings.NewReader("name: b\nscrape_configs:\n- job_name: job"))
+	require.NoError(t, err)
+
+	_, err = s.Put(context.Background(), *cfg)
+	require.True(t, errors.As(err, &NotUniqueError{}))
+}
+
+func TestFilesystem_Delete(t *testing.T) {
+	dir, s := newTestFilesystem(t)
+	writeConfigFile(t, dir, "a.yml", "name: a")
+
+	require.NoError(t, s.Delete(context.Background(), "a"))
+
+	_, err := os.Stat(filepath.Join(dir, "a.yml"))
+	require.True(t, os.IsNotExist(err))
+
+	err = s.Delete(context.Background(), "a")
+	require.True(t, errors.Is(err, NotExistError{Key: "a"}))
+}
+
+func TestFilesystem_All(t *testing.T) {
+	dir, s := newTestFilesystem(t)
+	writeConfigFile(t, dir, "a.yml", "name: a")
+	writeConfigFile(t, dir, "b.yml", "name: b")
+	writeConfigFile(t, dir, "invalid.yml", "not_a_field: true")
+
+	ch, err := s.All(context.Background(), func(key string) bool { return key != "b" })
+	require.NoError(t, err)
+
+	var keys []string
+	for cfg := range ch {
+		keys = append(keys, cfg.Name)
+	}
+	require.Equal(t, []string{"a"}, keys)
+}
+
+func TestFilesystem_Watch(t *testing.T) {
+	dir, s := newTestFilesystem(t)
+
+	writeConfigFile(t, dir, "new.yml", "name: new")
+	ev := nextWatchEvent(t, s)
+	require.Equal(t, "new", ev.Key)
+	require.NotNil(t, ev.Config)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "new.yml")))
+	ev = nextWatchEvent(t, s)
+	require.Equal(t, "new", ev.Key)
+	require.Nil(t, ev.Config)
+}
+
+func newTestFilesystem(t *testing.T) (string, *Filesystem) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "configs")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s, err := NewFilesystem(log.NewNopLogger(), dir)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	return dir, s
+}
+
+// writeConfigFile atomically writes a file to dir so that watchers only see
+// a single change.
+func writeConfigFile(t *testing.T, dir, name, contents string) {
+	t.Helper()
+
+	tmp := filepath.Join(dir, ".tmp-"+name)
+	require.NoError(t, ioutil.WriteFile(tmp, []byte(contents), 0660))
+	require.NoError(t, os.Rename(tmp, filepath.Join(dir, name)))
+}
+
+func nextWatchEvent(t *testing.T, s *Filesystem) WatchEvent {
+	t.Helper()
+
+	select {
+	case ev := <-s.Watch():
+		return ev
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for watch event")
+	}
+	return WatchEvent{}
+}
@@ -0,0 +1,125 @@
+package configstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v2"
+)
+
+// Operations recorded in a revision.
+const (
+	OperationPut      = "put"
+	OperationDelete   = "delete"
+	OperationRollback = "rollback"
+)
+
+// History records revisions of configs to a KV store. It is independent of
+// the Store holding the configs, so it can be used with any Store.
+type History struct {
+	client kv.Client
+	limit  int
+	now    func() time.Time
+}
+
+// NewHistory creates a new History which stores revisions in client, keyed
+// by the name of the config. Only the most recent limit revisions of each
+// config are kept.
+func NewHistory(client kv.Client, limit int) *History {
+	return &History{
+		client: client,
+		limit:  limit,
+		now:    time.Now,
+	}
+}
+
+// Record records a new revision of the config with the given key. prev and
+// next are the YAML of the config before and after the change, and are empty
+// when the config didn't exist. The recorded revision is returned.
+func (h *History) Record(ctx context.Context, key, author, op, prev, next string) (configapi.ConfigRevision, error) {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(prev),
+		B:        difflib.SplitLines(next),
+		FromFile: key,
+		ToFile:   key,
+		Context:  3,
+	})
+	if err != nil {
+		return configapi.ConfigRevision{}, fmt.Errorf("failed to diff config: %w", err)
+	}
+
+	rev := configapi.ConfigRevision{
+		Timestamp: h.now().UTC(),
+		Author:    author,
+		Operation: op,
+		Value:     next,
+		Diff:      diff,
+	}
+
+	err = h.client.CAS(ctx, key, func(in interface{}) (out interface{}, retry bool, err error) {
+		revs, err := decodeRevisions(in)
+		if err != nil {
+			return nil, false, err
+		}
+
+		rev.ID = 1
+		if len(revs) > 0 {
+			rev.ID = revs[len(revs)-1].ID + 1
+		}
+		revs = append(revs, rev)
+		if h.limit > 0 && len(revs) > h.limit {
+			revs = revs[len(revs)-h.limit:]
+		}
+
+		bb, err := yaml.Marshal(revs)
+		if err != nil {
+			return nil, false, err
+		}
+		return string(bb), true, nil
+	})
+	if err != nil {
+		return configapi.ConfigRevision{}, fmt.Errorf("failed to record revision of config %s: %w", key, err)
+	}
+	return rev, nil
+}
+
+// Revisions returns the recorded revisions of the config with the given key,
+// oldest first.
+func (h *History) Revisions(ctx context.Context, key string) ([]configapi.ConfigRevision, error) {
+	v, err := h.client.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revisions of config %s: %w", key, err)
+	}
+	return decodeRevisions(v)
+}
+
+// Revision returns a single revision of the config with the given key. It
+// returns RevisionNotExistError if the revision isn't recorded.
+func (h *History) Revision(ctx context.Context, key string, id int) (configapi.ConfigRevision, error) {
+	revs, err := h.Revisions(ctx, key)
+	if err != nil {
+		return configapi.ConfigRevision{}, err
+	}
+	for _, rev := range revs {
+		if rev.ID == id {
+			return rev, nil
+		}
+	}
+	return configapi.ConfigRevision{}, RevisionNotExistError{Key: key, ID: id}
+}
+
+func decodeRevisions(v interface{}) ([]configapi.ConfigRevision, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	var revs []configapi.ConfigRevision
+	if err := yaml.Unmarshal([]byte(v.(string)), &revs); err != nil {
+		return nil, fmt.Errorf("failed to decode revisions: %w", err)
+	}
+	return revs, nil
+}
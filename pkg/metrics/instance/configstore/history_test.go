@@ -0,0 +1,55 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/ring/kv/consul"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	h := NewHistory(consul.NewInMemoryClient(GetCodec()), 2)
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	rev, err := h.Record(ctx, "config", "alice", OperationPut, "", "name: config\n")
+	require.NoError(t, err)
+	require.Equal(t, 1, rev.ID)
+	require.Equal(t, now, rev.Timestamp)
+	require.Equal(t, "alice", rev.Author)
+	require.Contains(t, rev.Diff, "+name: config")
+
+	rev, err = h.Record(ctx, "config", "bob", OperationPut, "name: config\n", "name: config\nhost_filter: true\n")
+	require.NoError(t, err)
+	require.Equal(t, 2, rev.ID)
+	require.Contains(t, rev.Diff, "+host_filter: true")
+	require.NotContains(t, rev.Diff, "+name: config")
+
+	rev, err = h.Record(ctx, "config", "bob", OperationDelete, "name: config\nhost_filter: true\n", "")
+	require.NoError(t, err)
+	require.Equal(t, 3, rev.ID)
+	require.Empty(t, rev.Value)
+
+	// Only the most recent two revisions are kept.
+	revs, err := h.Revisions(ctx, "config")
+	require.NoError(t, err)
+	require.Len(t, revs, 2)
+	require.Equal(t, 2, revs[0].ID)
+	require.Equal(t, 3, revs[1].ID)
+
+	rev, err = h.Revision(ctx, "config", 2)
+	require.NoError(t, err)
+	require.Equal(t, "name: config\nhost_filter: true\n", rev.Value)
+
+	_, err = h.Revision(ctx, "config", 1)
+	require.True(t, errors.Is(err, RevisionNotExistError{Key: "config", ID: 1}))
+
+	revs, err = h.Revisions(ctx, "other")
+	require.NoError(t, err)
+	require.Empty(t, revs)
+}
@@ -0,0 +1,362 @@
+package configstore
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ConfigMapKey is the key in the data of a ConfigMap that holds the instance
+// config.
+const ConfigMapKey = "config.yml"
+
+// DefaultKubernetesLabels are the labels used to select ConfigMaps holding
+// instance configs when no labels are configured.
+var DefaultKubernetesLabels = map[string]string{
+	"agent.grafana.com/instance-config": "true",
+}
+
+// KubernetesConfig configures a Kubernetes store.
+type KubernetesConfig struct {
+	// Path to a kubeconfig file. When empty, the in-cluster config is used.
+	KubeconfigFile string `yaml:"kubeconfig_file"`
+
+	// Namespace the ConfigMaps are stored in.
+	Namespace string `yaml:"namespace"`
+
+	// Labels which select ConfigMaps holding instance configs. They are added
+	// to every ConfigMap created by the store.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// RegisterFlagsWithPrefix adds the flags required to config this to the given
+// FlagSet with a specified prefix.
+func (c *KubernetesConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.StringVar(&c.KubeconfigFile, prefix+"kubeconfig-file", "", "path to a kubeconfig file. Uses the in-cluster config when empty.")
+	f.StringVar(&c.Namespace, prefix+"namespace", "", "namespace the config ConfigMaps are stored in")
+}
+
+// Kubernetes is a Store backed by Kubernetes ConfigMaps. Each ConfigMap holds
+// one config under ConfigMapKey and is named after the config. Only
+// ConfigMaps in the configured namespace matching the configured labels are
+// used.
+//
+// Watch is implemented with an informer on the selected ConfigMaps.
+// Removing the labels from a ConfigMap is reported as a deletion.
+type Kubernetes struct {
+	log       log.Logger
+	client    kubernetes.Interface
+	namespace string
+	labels    labels.Set
+	selector  labels.Selector
+
+	// writeMut serializes Put, which is needed to check that scrape jobs are
+	// unique across the store.
+	writeMut sync.Mutex
+
+	cancel    context.CancelFunc
+	configsCh chan WatchEvent
+}
+
+// NewKubernetes creates a new Kubernetes store, connecting to the API server
+// from the kubeconfig file in cfg or from the in-cluster config.
+func NewKubernetes(l log.Logger, cfg KubernetesConfig) (*Kubernetes, error) {
+	var (
+		restConfig *rest.Config
+		err        error
+	)
+	if cfg.KubeconfigFile != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", cfg.KubeconfigFile)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Kubernetes client config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return NewKubernetesWithClient(l, client, cfg)
+}
+
+// NewKubernetesWithClient creates a new Kubernetes store using an existing
+// client. The KubeconfigFile in cfg is ignored.
+func NewKubernetesWithClient(l log.Logger, client kubernetes.Interface, cfg KubernetesConfig) (*Kubernetes, error) {
+	if cfg.Namespace == "" {
+		return nil, fmt.Errorf("namespace must be set")
+	}
+
+	set := labels.Set(cfg.Labels)
+	if len(set) == 0 {
+		set = labels.Set(DefaultKubernetesLabels)
+	}
+	selector, err := labels.ValidatedSelectorFromSet(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid labels: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Kubernetes{
+		log:       l,
+		client:    client,
+		namespace: cfg.Namespace,
+		labels:    set,
+		selector:  selector,
+
+		cancel:    cancel,
+		configsCh: make(chan WatchEvent),
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(cfg.Namespace),
+		informers.WithTweakListOptions(func(opts *meta_v1.ListOptions) {
+			opts.LabelSelector = selector.String()
+		}),
+	)
+	factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.sendConfig(ctx, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCM, ok1 := oldObj.(*core_v1.ConfigMap)
+			newCM, ok2 := newObj.(*core_v1.ConfigMap)
+			if ok1 && ok2 && oldCM.Data[ConfigMapKey] == newCM.Data[ConfigMapKey] {
+				return
+			}
+			s.sendConfig(ctx, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			cm, ok := obj.(*core_v1.ConfigMap)
+			if !ok {
+				return
+			}
+			s.send(ctx, WatchEvent{Key: cm.Name, Config: nil})
+		},
+	})
+	factory.Start(ctx.Done())
+
+	return s, nil
+}
+
+func (s *Kubernetes) sendConfig(ctx context.Context, obj interface{}) {
+	cm, ok := obj.(*core_v1.ConfigMap)
+	if !ok {
+		return
+	}
+
+	cfg, err := unmarshalNamed(cm.Name, []byte(cm.Data[ConfigMapKey]))
+	if err != nil {
+		level.Error(s.log).Log("msg", "could not unmarshal config from ConfigMap", "name", cm.Name, "err", err)
+		return
+	}
+	s.send(ctx, WatchEvent{Key: cm.Name, Config: cfg})
+}
+
+func (s *Kubernetes) send(ctx context.Context, ev WatchEvent) {
+	select {
+	case <-ctx.Done():
+	case s.configsCh <- ev:
+	}
+}
+
+// selected returns true if cm holds an instance config for the store.
+func (s *Kubernetes) selected(cm *core_v1.ConfigMap) bool {
+	return s.selector.Matches(labels.Set(cm.Labels))
+}
+
+// getConfigMap gets the ConfigMap for key. It returns NotExistError if the
+// ConfigMap doesn't exist or isn't selected by the store.
+func (s *Kubernetes) getConfigMap(ctx context.Context, key string) (*core_v1.ConfigMap, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, key, meta_v1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		return nil, NotExistError{Key: key}
+	} else if err != nil {
+		return nil, err
+	}
+	if !s.selected(cm) {
+		return nil, NotExistError{Key: key}
+	}
+	return cm, nil
+}
+
+// List returns the list of all configs in the namespace.
+func (s *Kubernetes) List(ctx context.Context) ([]string, error) {
+	list, err := s.listConfigMaps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(list.Items))
+	for _, cm := range list.Items {
+		keys = append(keys, cm.Name)
+	}
+	return keys, nil
+}
+
+func (s *Kubernetes) listConfigMaps(ctx context.Context) (*core_v1.ConfigMapList, error) {
+	list, err := s.client.CoreV1().ConfigMaps(s.namespace).List(ctx, meta_v1.ListOptions{
+		LabelSelector: s.selector.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ConfigMaps: %w", err)
+	}
+	return list, nil
+}
+
+// Get retrieves an individual config from its ConfigMap.
+func (s *Kubernetes) Get(ctx context.Context, key string) (instance.Config, error) {
+	cm, err := s.getConfigMap(ctx, key)
+	if err != nil {
+		return instance.Config{}, fmt.Errorf("failed to get config %s: %w", key, err)
+	}
+
+	cfg, err := unmarshalNamed(key, []byte(cm.Data[ConfigMapKey]))
+	if err != nil {
+		return instance.Config{}, fmt.Errorf("failed to unmarshal config %s: %w", key, err)
+	}
+	return *cfg, nil
+}
+
+// Put creates or updates the ConfigMap for a config. Names of configs must be
+// valid ConfigMap names. Put fails if a ConfigMap with the same name exists
+// but isn't selected by the store.
+func (s *Kubernetes) Put(ctx context.Context, c instance.Config) (bool, error) {
+	s.writeMut.Lock()
+	defer s.writeMut.Unlock()
+
+	if errs := validation.IsDNS1123Subdomain(c.Name); len(errs) > 0 {
+		return false, fmt.Errorf("invalid config name %q for a ConfigMap: %s", c.Name, strings.Join(errs, ", "))
+	}
+
+	bb, err := instance.MarshalConfig(&c, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	cfgCh, err := s.All(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to check validity of config: %w", err)
+	}
+	if err := checkUnique(cfgCh, &c); err != nil {
+		return false, fmt.Errorf("failed to check uniqueness of config: %w", err)
+	}
+
+	configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+
+	cm, err := configMaps.Get(ctx, c.Name, meta_v1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		cm = &core_v1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:      c.Name,
+				Namespace: s.namespace,
+				Labels:    labels.Merge(nil, s.labels),
+			},
+			Data: map[string]string{ConfigMapKey: string(bb)},
+		}
+		if _, err := configMaps.Create(ctx, cm, meta_v1.CreateOptions{}); err != nil {
+			return false, fmt.Errorf("failed to put config: %w", err)
+		}
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to put config: %w", err)
+	}
+
+	if !s.selected(cm) {
+		return false, fmt.Errorf("failed to put config: ConfigMap %s/%s exists but doesn't match the store's labels", s.namespace, c.Name)
+	}
+
+	// The resource version of cm is kept so the update fails if the ConfigMap
+	// changed since it was read.
+	if cm.Data == nil {
+		cm.Data = make(map[string]string, 1)
+	}
+	cm.Data[ConfigMapKey] = string(bb)
+	if _, err := configMaps.Update(ctx, cm, meta_v1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to put config: %w", err)
+	}
+	return false, nil
+}
+
+// Delete deletes the ConfigMap of a config. It returns NotExistError if the
+// config doesn't exist.
+func (s *Kubernetes) Delete(ctx context.Context, key string) error {
+	cm, err := s.getConfigMap(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	// Only delete the ConfigMap that was checked to be selected, in case it
+	// was replaced in the meantime.
+	err = s.client.CoreV1().ConfigMaps(s.namespace).Delete(ctx, key, *meta_v1.NewPreconditionDeleteOptions(string(cm.UID)))
+	if k8s_errors.IsNotFound(err) {
+		return NotExistError{Key: key}
+	} else if err != nil {
+		return fmt.Errorf("error deleting configuration: %w", err)
+	}
+	return nil
+}
+
+// All retrieves the set of all configs in the namespace. All ConfigMaps are
+// retrieved with a single request. ConfigMaps which fail to be unmarshaled
+// are logged and skipped.
+func (s *Kubernetes) All(ctx context.Context, keep func(key string) bool) (<-chan instance.Config, error) {
+	list, err := s.listConfigMaps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []*instance.Config
+	for _, cm := range list.Items {
+		if keep != nil && !keep(cm.Name) {
+			level.Debug(s.log).Log("msg", "skipping key that was filtered out", "key", cm.Name)
+			continue
+		}
+
+		cfg, err := unmarshalNamed(cm.Name, []byte(cm.Data[ConfigMapKey]))
+		if err != nil {
+			level.Error(s.log).Log("msg", "failed to unmarshal config from ConfigMap", "key", cm.Name, "err", err)
+			continue
+		}
+		configs = append(configs, cfg)
+	}
+
+	ch := make(chan instance.Config, len(configs))
+	for _, cfg := range configs {
+		ch <- *cfg
+	}
+	close(ch)
+	return ch, nil
+}
+
+// Watch watches the selected ConfigMaps for changed configs.
+func (s *Kubernetes) Watch() <-chan WatchEvent {
+	return s.configsCh
+}
+
+// Close closes the Kubernetes store, stopping its informer.
+func (s *Kubernetes) Close() error {
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,174 @@
+// These tests depend on test assets from controller-runtime which don't work on Windows.
+
+// +build !windows,has_network
+
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/hashicorp/go-getter"
+	"github.com/stretchr/testify/require"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+var (
+	envTestK8sVersion = "1.19.2"
+
+	envtestToolsURL = fmt.Sprintf(
+		"https://storage.googleapis.com/kubebuilder-tools/kubebuilder-tools-%s-%s-%s.tar.gz",
+		envTestK8sVersion,
+		runtime.GOOS,
+		runtime.GOARCH,
+	)
+)
+
+// TestKubernetes creates an example Kubernetes cluster and runs the
+// Kubernetes store against it.
+func TestKubernetes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var env envtest.Environment
+	setupEnvtest(t, &env)
+
+	cfg, err := env.Start()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = env.Stop() })
+
+	cli, err := kubernetes.NewForConfig(cfg)
+	require.NoError(t, err)
+
+	_, err = cli.CoreV1().Namespaces().Create(ctx, &core_v1.Namespace{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "configs"},
+	}, meta_v1.CreateOptions{})
+	require.NoError(t, err)
+
+	s, err := NewKubernetesWithClient(log.NewNopLogger(), cli, KubernetesConfig{
+		Namespace: "configs",
+		Labels:    map[string]string{"test": "configstore"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	// An unrelated ConfigMap which must be ignored by the store.
+	_, err = cli.CoreV1().ConfigMaps("configs").Create(ctx, &core_v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "unrelated"},
+		Data:       map[string]string{ConfigMapKey: "name: unrelated"},
+	}, meta_v1.CreateOptions{})
+	require.NoError(t, err)
+
+	t.Run("put", func(t *testing.T) {
+		cfg := instance.DefaultConfig
+		cfg.Name = "a"
+
+		created, err := s.Put(ctx, cfg)
+		require.NoError(t, err)
+		require.True(t, created)
+
+		ev := nextKubernetesEvent(t, s)
+		require.Equal(t, "a", ev.Key)
+		require.NotNil(t, ev.Config)
+
+		created, err = s.Put(ctx, cfg)
+		require.NoError(t, err)
+		require.False(t, created)
+
+		cm, err := cli.CoreV1().ConfigMaps("configs").Get(ctx, "a", meta_v1.GetOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "configstore", cm.Labels["test"])
+	})
+
+	t.Run("put invalid name", func(t *testing.T) {
+		cfg := instance.DefaultConfig
+		cfg.Name = "Not_A_ConfigMap"
+		_, err := s.Put(ctx, cfg)
+		require.Error(t, err)
+	})
+
+	t.Run("put unselected", func(t *testing.T) {
+		cfg := instance.DefaultConfig
+		cfg.Name = "unrelated"
+		_, err := s.Put(ctx, cfg)
+		require.Error(t, err)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		cfg, err := s.Get(ctx, "a")
+		require.NoError(t, err)
+		require.Equal(t, "a", cfg.Name)
+
+		_, err = s.Get(ctx, "unrelated")
+		require.True(t, errors.Is(err, NotExistError{Key: "unrelated"}))
+	})
+
+	t.Run("list and all", func(t *testing.T) {
+		cfg := instance.DefaultConfig
+		cfg.Name = "b"
+		_, err := s.Put(ctx, cfg)
+		require.NoError(t, err)
+		_ = nextKubernetesEvent(t, s)
+
+		keys, err := s.List(ctx)
+		require.NoError(t, err)
+		sort.Strings(keys)
+		require.Equal(t, []string{"a", "b"}, keys)
+
+		ch, err := s.All(ctx, func(key string) bool { return key == "b" })
+		require.NoError(t, err)
+
+		var names []string
+		for cfg := range ch {
+			names = append(names, cfg.Name)
+		}
+		require.Equal(t, []string{"b"}, names)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, s.Delete(ctx, "a"))
+
+		ev := nextKubernetesEvent(t, s)
+		require.Equal(t, "a", ev.Key)
+		require.Nil(t, ev.Config)
+
+		err := s.Delete(ctx, "a")
+		require.True(t, errors.Is(err, NotExistError{Key: "a"}))
+
+		err = s.Delete(ctx, "unrelated")
+		require.True(t, errors.Is(err, NotExistError{Key: "unrelated"}))
+	})
+}
+
+func nextKubernetesEvent(t *testing.T, s *Kubernetes) WatchEvent {
+	t.Helper()
+
+	select {
+	case ev := <-s.Watch():
+		return ev
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out waiting for watch event")
+	}
+	return WatchEvent{}
+}
+
+func setupEnvtest(t *testing.T, env *envtest.Environment) {
+	t.Helper()
+	storagePath := t.TempDir()
+
+	err := getter.Get(storagePath, envtestToolsURL)
+	require.NoError(t, err, "failed to download dependencies for envtest")
+
+	env.BinaryAssetsDirectory = filepath.Join(storagePath, "kubebuilder", "bin")
+}
@@ -301,6 +301,11 @@ func hashConfig(c Config) (string, error) {
 	if err != nil {
 		return "", err
 	}
+
+	// External labels added to the config aren't marshaled, but configs may
+	// only be grouped if they're the same.
+	bb = append(bb, groupable.externalLabels.String()...)
+
 	hash := md5.Sum(bb)
 	return hex.EncodeToString(hash[:]), nil
 }
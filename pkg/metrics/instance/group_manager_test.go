@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/stretchr/testify/require"
 )
 
@@ -361,6 +362,19 @@ remote_write: []`
 		hashA, hashB := getHashesFromConfigs(t, configAText, configBText)
 		require.NotEqual(t, hashA, hashB)
 	})
+
+	t.Run("added external labels must match", func(t *testing.T) {
+		configA := testUnmarshalConfig(t, "name: configA")
+		configA.AddExternalLabels(labels.FromStrings("cluster", "configA"))
+		configB := testUnmarshalConfig(t, "name: configB")
+		configB.AddExternalLabels(labels.FromStrings("cluster", "configB"))
+
+		hashA, err := hashConfig(configA)
+		require.NoError(t, err)
+		hashB, err := hashConfig(configB)
+		require.NoError(t, err)
+		require.NotEqual(t, hashA, hashB)
+	})
 }
 
 func getHashesFromConfigs(t *testing.T, configAText, configBText string) (string, string) {
@@ -25,8 +25,11 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/storage/remote"
@@ -58,11 +61,11 @@ var (
 // Config is a specific agent that runs within the overall Prometheus
 // agent. It has its own set of scrape_configs and remote_write rules.
 type Config struct {
-	Name                     string                      `yaml:"name,omitempty"`
-	HostFilter               bool                        `yaml:"host_filter,omitempty"`
-	HostFilterRelabelConfigs []*relabel.Config           `yaml:"host_filter_relabel_configs,omitempty"`
-	ScrapeConfigs            []*config.ScrapeConfig      `yaml:"scrape_configs,omitempty"`
-	RemoteWrite              []*config.RemoteWriteConfig `yaml:"remote_write,omitempty"`
+	Name                     string                 `yaml:"name,omitempty"`
+	HostFilter               bool                   `yaml:"host_filter,omitempty"`
+	HostFilterRelabelConfigs []*relabel.Config      `yaml:"host_filter_relabel_configs,omitempty"`
+	ScrapeConfigs            []*config.ScrapeConfig `yaml:"scrape_configs,omitempty"`
+	RemoteWrite              []*RemoteWriteConfig   `yaml:"remote_write,omitempty"`
 
 	// How frequently the WAL should be truncated.
 	WALTruncateFrequency time.Duration `yaml:"wal_truncate_frequency,omitempty"`
@@ -74,7 +77,41 @@ type Config struct {
 	RemoteFlushDeadline  time.Duration `yaml:"remote_flush_deadline,omitempty"`
 	WriteStaleOnShutdown bool          `yaml:"write_stale_on_shutdown,omitempty"`
 
+	// Whether a snapshot of the in-memory series should be written on
+	// shutdown so only newer WAL segments need to be replayed on restart.
+	WALSnapshotOnShutdown bool `yaml:"wal_snapshot_on_shutdown,omitempty"`
+
+	// How long recently written samples are kept in memory for querying. 0
+	// disables querying.
+	QueryRetention time.Duration `yaml:"query_retention,omitempty"`
+
+	// Limits on the number of series in the WAL. Samples for new series which
+	// would exceed a limit are dropped. 0 disables a limit.
+	MaxActiveSeries       int `yaml:"max_active_series,omitempty"`
+	MaxNewSeriesPerMinute int `yaml:"max_new_series_per_minute,omitempty"`
+
+	// Recording and alerting rules evaluated against the recent samples kept
+	// in memory by query_retention. Results are appended to the WAL.
+	RuleFiles  []string    `yaml:"rule_files,omitempty"`
+	RuleGroups []RuleGroup `yaml:"rule_groups,omitempty"`
+
+	// Whether series used as inputs to recording rules should be dropped
+	// from remote_write.
+	DropRuleInputs bool `yaml:"drop_rule_inputs,omitempty"`
+
+	// Alertmanagers to send alerts from alerting rules to.
+	Alerting config.AlertingConfig `yaml:"alerting,omitempty"`
+
+	// Relative load of the config used by weighted sharding in the scraping
+	// service, in number of series. 0 uses the weight measured by the agent
+	// running the config.
+	ShardWeight int64 `yaml:"shard_weight,omitempty"`
+
 	global GlobalConfig `yaml:"-"`
+
+	// externalLabels are added to the global external labels. Unlike the
+	// global settings, they are part of the config when configs are grouped.
+	externalLabels labels.Labels
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler.
@@ -121,6 +158,18 @@ func (c *Config) ApplyDefaults(global GlobalConfig) error {
 		return errors.New("remote_flush_deadline must be greater than 0s")
 	case c.MinWALTime > c.MaxWALTime:
 		return errors.New("min_wal_time must be less than max_wal_time")
+	case c.QueryRetention < 0:
+		return errors.New("query_retention must not be negative")
+	case c.MaxActiveSeries < 0:
+		return errors.New("max_active_series must not be negative")
+	case c.MaxNewSeriesPerMinute < 0:
+		return errors.New("max_new_series_per_minute must not be negative")
+	case c.ShardWeight < 0:
+		return errors.New("shard_weight must not be negative")
+	}
+
+	if err := c.validateRules(); err != nil {
+		return err
 	}
 
 	jobNames := map[string]struct{}{}
@@ -189,11 +238,42 @@ func (c *Config) ApplyDefaults(global GlobalConfig) error {
 			return fmt.Errorf("found duplicate remote write configs with name %q", cfg.Name)
 		}
 		rwNames[cfg.Name] = struct{}{}
+
+		if _, err := cfg.matchers(); err != nil {
+			return fmt.Errorf("remote_write %s: %w", cfg.Name, err)
+		}
 	}
 
 	return nil
 }
 
+// AddExternalLabels adds lset to the external labels of the config, on top
+// of the external labels inherited from the global settings. Labels which are
+// set in the global settings are never replaced.
+func (c *Config) AddExternalLabels(lset labels.Labels) {
+	b := labels.NewBuilder(c.externalLabels)
+	for _, l := range lset {
+		b.Set(l.Name, l.Value)
+	}
+	c.externalLabels = b.Labels()
+}
+
+// prometheusGlobal returns the global Prometheus settings of the config,
+// including the external labels added by AddExternalLabels.
+func (c *Config) prometheusGlobal() config.GlobalConfig {
+	global := c.global.Prometheus
+	if len(c.externalLabels) == 0 {
+		return global
+	}
+
+	b := labels.NewBuilder(c.externalLabels)
+	for _, l := range global.ExternalLabels {
+		b.Set(l.Name, l.Value)
+	}
+	global.ExternalLabels = b.Labels()
+	return global
+}
+
 // Clone makes a deep copy of the config along with global settings.
 func (c *Config) Clone() (Config, error) {
 	bb, err := MarshalConfig(c, false)
@@ -205,6 +285,7 @@ func (c *Config) Clone() (Config, error) {
 		return Config{}, err
 	}
 	cp.global = c.global
+	cp.externalLabels = c.externalLabels
 
 	// Some tests will trip up on this; the marshal/unmarshal cycle might set
 	// an empty slice to nil. Set it back to an empty slice if we detect this
@@ -213,7 +294,7 @@ func (c *Config) Clone() (Config, error) {
 		cp.ScrapeConfigs = []*config.ScrapeConfig{}
 	}
 	if cp.RemoteWrite == nil && c.RemoteWrite != nil {
-		cp.RemoteWrite = []*config.RemoteWriteConfig{}
+		cp.RemoteWrite = []*RemoteWriteConfig{}
 	}
 
 	return *cp, nil
@@ -235,6 +316,19 @@ type Instance struct {
 	readyScrapeManager *readyScrapeManager
 	remoteStore        *remote.Storage
 	storage            storage.Storage
+	ruleManager        *ruleManager
+	notifier           *alertNotifier
+
+	// remoteWritePaused is set when samples should only be written to the WAL
+	// and not sent over remote_write.
+	remoteWritePaused bool
+
+	// pausedRemoteWriteTimestamp is the timestamp of the newest sample known to
+	// have been sent by another agent while remote_write is paused.
+	pausedRemoteWriteTimestamp int64
+
+	// resend is the running resend of samples started by ResendRemoteWrite.
+	resend *resend
 
 	hostFilter *HostFilter
 
@@ -254,7 +348,11 @@ func New(reg prometheus.Registerer, cfg Config, walDir string, logger log.Logger
 	instWALDir := filepath.Join(walDir, cfg.Name)
 
 	newWal := func(reg prometheus.Registerer) (walStorage, error) {
-		return wal.NewStorage(logger, reg, instWALDir)
+		return wal.NewStorageWithOptions(logger, reg, instWALDir, wal.Options{
+			QueryRetention:        cfg.QueryRetention,
+			MaxActiveSeries:       cfg.MaxActiveSeries,
+			MaxNewSeriesPerMinute: cfg.MaxNewSeriesPerMinute,
+		})
 	}
 
 	return newInstance(cfg, reg, logger, newWal)
@@ -341,6 +439,27 @@ func (i *Instance) Run(ctx context.Context) error {
 			},
 		)
 	}
+	{
+		// Rule manager. Stopped before the storage is closed by the scrape
+		// manager's interrupt function below.
+		rm := i.ruleManager
+		rg.Add(
+			func() error {
+				err := rm.Run()
+				level.Info(i.logger).Log("msg", "rule manager stopped")
+				return err
+			},
+			func(err error) {
+				level.Info(i.logger).Log("msg", "stopping rule manager...")
+				rm.Stop()
+			},
+		)
+	}
+	{
+		// Alert notifier. Stopped after the rule manager so it doesn't receive
+		// new alerts after stopping.
+		rg.Add(i.notifier.Run, i.notifier.Stop)
+	}
 	{
 		sm, err := i.readyScrapeManager.Get()
 		if err != nil {
@@ -371,6 +490,20 @@ func (i *Instance) Run(ctx context.Context) error {
 					}
 				}
 
+				// Likewise, only snapshot the WAL on a graceful shutdown. A snapshot
+				// taken from a failed instance may be missing samples.
+				if err == nil && cfg.WALSnapshotOnShutdown {
+					level.Info(i.logger).Log("msg", "writing WAL snapshot...")
+					err := i.wal.WriteSnapshot(i.getRemoteWriteTimestamp())
+					if err != nil {
+						level.Error(i.logger).Log("msg", "error writing WAL snapshot", "err", err)
+					}
+				}
+
+				i.mut.Lock()
+				i.stopResend()
+				i.mut.Unlock()
+
 				// Closing the storage closes both the WAL storage and remote wrte
 				// storage.
 				level.Info(i.logger).Log("msg", "closing storage...")
@@ -418,19 +551,25 @@ func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cf
 	// Setup the remote storage
 	remoteLogger := log.With(i.logger, "component", "remote")
 	i.remoteStore = remote.NewStorage(remoteLogger, reg, i.wal.StartTime, i.wal.Directory(), cfg.RemoteFlushDeadline, i.readyScrapeManager)
-	err = i.remoteStore.ApplyConfig(&config.Config{
-		GlobalConfig:       cfg.global.Prometheus,
-		RemoteWriteConfigs: cfg.RemoteWrite,
-	})
-	if err != nil {
+	if err := i.applyRemoteWrite(cfg); err != nil {
 		return fmt.Errorf("failed applying config to remote storage: %w", err)
 	}
 
 	i.storage = storage.NewFanout(i.logger, i.wal, i.remoteStore)
 
+	i.notifier = newAlertNotifier(ctx, i.logger, reg)
+	if err := i.notifier.ApplyConfig(cfg); err != nil {
+		return err
+	}
+
+	i.ruleManager = newRuleManager(ctx, i.logger, reg, i.wal, i.storage, i.notifier)
+	if err := i.ruleManager.ApplyConfig(cfg); err != nil {
+		return fmt.Errorf("failed applying config to rule manager: %w", err)
+	}
+
 	scrapeManager := newScrapeManager(log.With(i.logger, "component", "scrape manager"), i.storage)
 	err = scrapeManager.ApplyConfig(&config.Config{
-		GlobalConfig:  cfg.global.Prometheus,
+		GlobalConfig:  cfg.prometheusGlobal(),
 		ScrapeConfigs: cfg.ScrapeConfigs,
 	})
 	if err != nil {
@@ -464,13 +603,21 @@ func (i *Instance) Update(c Config) (err error) {
 		err = errImmutableField{Field: "remote_flush_deadline"}
 	case i.cfg.WriteStaleOnShutdown != c.WriteStaleOnShutdown:
 		err = errImmutableField{Field: "write_stale_on_shutdown"}
+	case i.cfg.WALSnapshotOnShutdown != c.WALSnapshotOnShutdown:
+		err = errImmutableField{Field: "wal_snapshot_on_shutdown"}
+	case i.cfg.QueryRetention != c.QueryRetention:
+		err = errImmutableField{Field: "query_retention"}
+	case i.cfg.MaxActiveSeries != c.MaxActiveSeries:
+		err = errImmutableField{Field: "max_active_series"}
+	case i.cfg.MaxNewSeriesPerMinute != c.MaxNewSeriesPerMinute:
+		err = errImmutableField{Field: "max_new_series_per_minute"}
 	}
 	if err != nil {
 		return ErrInvalidUpdate{Inner: err}
 	}
 
 	// Check to see if the components exist yet.
-	if i.discovery == nil || i.remoteStore == nil || i.readyScrapeManager == nil {
+	if i.discovery == nil || i.remoteStore == nil || i.readyScrapeManager == nil || i.ruleManager == nil || i.notifier == nil {
 		return ErrInvalidUpdate{
 			Inner: fmt.Errorf("cannot dynamically update because instance is not running"),
 		}
@@ -483,8 +630,10 @@ func (i *Instance) Update(c Config) (err error) {
 	//
 	// 1. Local config
 	// 2. Remote Store
-	// 3. Scrape Manager
-	// 4. Discovery Manager
+	// 3. Notifier
+	// 4. Rule Manager
+	// 5. Scrape Manager
+	// 6. Discovery Manager
 
 	originalConfig := i.cfg
 	defer func() {
@@ -501,20 +650,24 @@ func (i *Instance) Update(c Config) (err error) {
 		i.hostFilter.PatchSD(c.ScrapeConfigs)
 	}
 
-	err = i.remoteStore.ApplyConfig(&config.Config{
-		GlobalConfig:       c.global.Prometheus,
-		RemoteWriteConfigs: c.RemoteWrite,
-	})
-	if err != nil {
+	if err := i.applyRemoteWrite(&c); err != nil {
 		return fmt.Errorf("error applying new remote_write configs: %w", err)
 	}
 
+	if err := i.notifier.ApplyConfig(&c); err != nil {
+		return fmt.Errorf("error applying new alerting config: %w", err)
+	}
+
+	if err := i.ruleManager.ApplyConfig(&c); err != nil {
+		return fmt.Errorf("error applying new rules: %w", err)
+	}
+
 	sm, err := i.readyScrapeManager.Get()
 	if err != nil {
 		return fmt.Errorf("couldn't get scrape manager to apply new scrape configs: %w", err)
 	}
 	err = sm.ApplyConfig(&config.Config{
-		GlobalConfig:  c.global.Prometheus,
+		GlobalConfig:  c.prometheusGlobal(),
 		ScrapeConfigs: c.ScrapeConfigs,
 	})
 	if err != nil {
@@ -553,15 +706,164 @@ func (i *Instance) TargetsActive() map[string][]*scrape.Target {
 	return mgr.TargetsActive()
 }
 
+// TargetsDropped returns the set of targets dropped by relabeling from the
+// scrape manager. Returns nil if the scrape manager is not ready yet.
+func (i *Instance) TargetsDropped() map[string][]*scrape.Target {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.readyScrapeManager == nil {
+		return nil
+	}
+
+	mgr, err := i.readyScrapeManager.Get()
+	if err == ErrNotReady {
+		return nil
+	} else if err != nil {
+		level.Error(i.logger).Log("msg", "failed to get scrape manager when collecting dropped targets", "err", err)
+		return nil
+	}
+	return mgr.TargetsDropped()
+}
+
 // StorageDirectory returns the directory where this Instance is writing series
 // and samples to for the WAL.
 func (i *Instance) StorageDirectory() string {
 	return i.wal.Directory()
 }
 
-// Appender returns a storage.Appender from the instance's WAL
+// Appender returns a storage.Appender from the instance's WAL. If the
+// instance isn't running, the returned Appender fails every call.
 func (i *Instance) Appender(ctx context.Context) storage.Appender {
-	return i.wal.Appender(ctx)
+	i.mut.Lock()
+	w := i.wal
+	i.mut.Unlock()
+
+	if w == nil {
+		return errorAppender{err: fmt.Errorf("instance is not running")}
+	}
+	return w.Appender(ctx)
+}
+
+// errorAppender is a storage.Appender which returns err from every call.
+type errorAppender struct {
+	err error
+}
+
+func (a errorAppender) Append(uint64, labels.Labels, int64, float64) (uint64, error) {
+	return 0, a.err
+}
+
+func (a errorAppender) AppendExemplar(uint64, labels.Labels, exemplar.Exemplar) (uint64, error) {
+	return 0, a.err
+}
+
+func (a errorAppender) Commit() error   { return a.err }
+func (a errorAppender) Rollback() error { return a.err }
+
+// Querier returns a storage.Querier for the recent samples held in the
+// instance's WAL. Querier returns an error if the instance isn't running or
+// query_retention isn't set.
+func (i *Instance) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	i.mut.Lock()
+	wal := i.wal
+	i.mut.Unlock()
+
+	if wal == nil {
+		return nil, fmt.Errorf("instance is not running")
+	}
+	return wal.Querier(ctx, mint, maxt)
+}
+
+// CardinalityStats returns the cardinality of the series held in memory by the
+// instance's WAL, limiting each list of stats to the top limit entries.
+func (i *Instance) CardinalityStats(limit int) (wal.CardinalityStats, error) {
+	i.mut.Lock()
+	w := i.wal
+	i.mut.Unlock()
+
+	if w == nil {
+		return wal.CardinalityStats{}, fmt.Errorf("instance is not running")
+	}
+	return w.CardinalityStats(limit), nil
+}
+
+// RuleGroups returns the rule groups being evaluated by the instance. Returns
+// nil if the instance isn't running.
+func (i *Instance) RuleGroups() []*rules.Group {
+	i.mut.Lock()
+	rm := i.ruleManager
+	i.mut.Unlock()
+
+	if rm == nil {
+		return nil
+	}
+	return rm.RuleGroups()
+}
+
+// SetRemoteWritePaused pauses or resumes sending samples over remote_write.
+// While paused, samples are still written to the WAL. Once resumed, only new
+// samples are sent; use ResendRemoteWrite to send the samples written while
+// remote_write was paused. Pausing stops any running resend. The setting is
+// kept across restarts of the instance.
+func (i *Instance) SetRemoteWritePaused(paused bool) error {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	if i.remoteWritePaused == paused {
+		return nil
+	}
+	i.remoteWritePaused = paused
+	if paused {
+		i.stopResend()
+	}
+
+	if i.remoteStore == nil {
+		return nil
+	}
+	return i.applyRemoteWrite(&i.cfg)
+}
+
+// SetPausedRemoteWriteTimestamp sets the timestamp of the newest sample known
+// to have been sent by another agent while remote_write is paused. Samples
+// newer than ts are kept in the WAL while paused, so they can still be resent
+// by ResendRemoteWrite if the instance has to take over sending samples.
+func (i *Instance) SetPausedRemoteWriteTimestamp(ts int64) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	i.pausedRemoteWriteTimestamp = ts
+}
+
+// applyRemoteWrite applies the remote_write configs from cfg to the remote
+// storage. No remote_write configs are applied while remote_write is paused.
+// i.mut must be held when calling applyRemoteWrite.
+func (i *Instance) applyRemoteWrite(cfg *Config) error {
+	var rwConfigs []*config.RemoteWriteConfig
+	if !i.remoteWritePaused {
+		var err error
+		rwConfigs, err = remoteWriteConfigs(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to build remote_write configs: %w", err)
+		}
+	}
+
+	return i.remoteStore.ApplyConfig(&config.Config{
+		GlobalConfig:       cfg.prometheusGlobal(),
+		RemoteWriteConfigs: rwConfigs,
+	})
+}
+
+// EvictWALSegments removes the oldest segments from the instance's WAL until
+// at least bytes have been freed. Samples in evicted segments are lost.
+func (i *Instance) EvictWALSegments(bytes int64) (wal.EvictStats, error) {
+	i.mut.Lock()
+	w := i.wal
+	i.mut.Unlock()
+
+	if w == nil {
+		return wal.EvictStats{}, fmt.Errorf("instance is not running")
+	}
+	return w.EvictSegments(bytes)
 }
 
 type discoveryService struct {
@@ -654,7 +956,7 @@ func (i *Instance) truncateLoop(ctx context.Context, wal walStorage, cfg *Config
 			//
 			// Subtracting a duration from ts will delay when it will be considered
 			// inactive and scheduled for deletion.
-			ts := i.getRemoteWriteTimestamp() - i.cfg.MinWALTime.Milliseconds()
+			ts := i.truncateTimestamp() - i.cfg.MinWALTime.Milliseconds()
 			if ts < 0 {
 				ts = 0
 			}
@@ -684,17 +986,63 @@ func (i *Instance) truncateLoop(ctx context.Context, wal walStorage, cfg *Config
 	}
 }
 
+// RemoteWriteTimestamp returns the timestamp of the newest sample which is
+// known to have been sent by every remote_write config. Samples which are
+// still being resent by ResendRemoteWrite aren't considered sent. If no
+// remote_write configs are set or remote_write is paused, the current time
+// is returned.
+func (i *Instance) RemoteWriteTimestamp() int64 {
+	return i.getRemoteWriteTimestamp()
+}
+
 // getRemoteWriteTimestamp looks up the last successful remote write timestamp.
 // This is passed to wal.Storage for its truncation. If no remote write sections
-// are configured, getRemoteWriteTimestamp returns the current time.
+// are configured or remote_write is paused, getRemoteWriteTimestamp returns the
+// current time.
+//
+// If the WAL was loaded from a snapshot, the returned timestamp is never lower
+// than the timestamp stored in the snapshot. remote_write doesn't resend
+// samples written before a restart, so they don't need to be kept. Samples
+// being resent by ResendRemoteWrite are always kept.
 func (i *Instance) getRemoteWriteTimestamp() int64 {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
-	if len(i.cfg.RemoteWrite) == 0 {
+	if len(i.cfg.RemoteWrite) == 0 || i.remoteWritePaused {
 		return timestamp.FromTime(time.Now())
 	}
 
+	ts := i.lowestRemoteWriteTimestamp()
+	if i.wal != nil {
+		if snapshotTs := i.wal.SnapshotRemoteWriteTimestamp(); snapshotTs > ts {
+			ts = snapshotTs
+		}
+	}
+	if i.resend != nil && i.resend.from < ts {
+		ts = i.resend.from
+	}
+	return ts
+}
+
+// truncateTimestamp returns the timestamp the WAL may be truncated up to.
+// It's the same as getRemoteWriteTimestamp, except that while remote_write
+// is paused the timestamp set by SetPausedRemoteWriteTimestamp is used, so
+// samples which haven't been sent by any agent yet are kept.
+func (i *Instance) truncateTimestamp() int64 {
+	i.mut.Lock()
+	paused := i.remoteWritePaused && len(i.cfg.RemoteWrite) > 0
+	pausedTs := i.pausedRemoteWriteTimestamp
+	i.mut.Unlock()
+
+	if paused {
+		return pausedTs
+	}
+	return i.getRemoteWriteTimestamp()
+}
+
+// lowestRemoteWriteTimestamp returns the lowest last successful timestamp
+// across all remote_write configs. i.mut must be held when calling.
+func (i *Instance) lowestRemoteWriteTimestamp() int64 {
 	lbls := make([]string, len(i.cfg.RemoteWrite))
 	for idx := 0; idx < len(lbls); idx++ {
 		lbls[idx] = i.cfg.RemoteWrite[idx].Name
@@ -726,7 +1074,7 @@ func (i *Instance) getRemoteWriteTimestamp() int64 {
 
 // walStorage is an interface satisfied by wal.Storage, and created for testing.
 type walStorage interface {
-	// walStorage implements Queryable/ChunkQueryable for compatibility, but is unused.
+	// walStorage implements ChunkQueryable for compatibility, but is unused.
 	storage.Queryable
 	storage.ChunkQueryable
 
@@ -734,8 +1082,13 @@ type walStorage interface {
 
 	StartTime() (int64, error)
 	WriteStalenessMarkers(remoteTsFunc func() int64) error
+	WriteSnapshot(remoteWriteTs int64) error
+	SnapshotRemoteWriteTimestamp() int64
+	ReadSamples(from, through int64, f func(lset labels.Labels, t int64, v float64) error) error
 	Appender(context.Context) storage.Appender
 	Truncate(mint int64) error
+	EvictSegments(bytes int64) (wal.EvictStats, error)
+	CardinalityStats(limit int) wal.CardinalityStats
 
 	Close() error
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
@@ -69,7 +70,7 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			}},
 		},
 	}}
-	cfg.RemoteWrite = []*config.RemoteWriteConfig{{Name: "write"}}
+	cfg.RemoteWrite = []*RemoteWriteConfig{{RemoteWriteConfig: config.RemoteWriteConfig{Name: "write"}}}
 
 	tt := []struct {
 		name     string
@@ -128,9 +129,9 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 		{
 			"multiple remote writes with same name",
 			func(c *Config) {
-				c.RemoteWrite = []*config.RemoteWriteConfig{
-					{Name: "foo"},
-					{Name: "foo"},
+				c.RemoteWrite = []*RemoteWriteConfig{
+					{RemoteWriteConfig: config.RemoteWriteConfig{Name: "foo"}},
+					{RemoteWriteConfig: config.RemoteWriteConfig{Name: "foo"}},
 				}
 			},
 			fmt.Errorf("found duplicate remote write configs with name \"foo\""),
@@ -150,7 +151,7 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			}
 			input.ScrapeConfigs = scrapeConfigs
 
-			var remoteWrites []*config.RemoteWriteConfig
+			var remoteWrites []*RemoteWriteConfig
 			for _, rw := range input.RemoteWrite {
 				rwCopy := *rw
 				remoteWrites = append(remoteWrites, &rwCopy)
@@ -185,6 +186,29 @@ remote_write:
 	require.NotEmpty(t, cfg.RemoteWrite[0].Name)
 }
 
+func TestConfig_AddExternalLabels(t *testing.T) {
+	global := DefaultGlobalConfig
+	global.Prometheus.ExternalLabels = labels.FromStrings("cluster", "prod", "region", "us")
+
+	cfg := DefaultConfig
+	cfg.Name = "instance"
+	require.NoError(t, cfg.ApplyDefaults(global))
+
+	// Labels from the global settings must not be replaced.
+	cfg.AddExternalLabels(labels.FromStrings("cluster", "instance", "__replica__", "agent-a"))
+	require.Equal(t,
+		labels.FromStrings("__replica__", "agent-a", "cluster", "prod", "region", "us"),
+		cfg.prometheusGlobal().ExternalLabels,
+	)
+
+	// Added labels must be kept when defaults are applied again.
+	require.NoError(t, cfg.ApplyDefaults(global))
+	require.Equal(t, "agent-a", cfg.prometheusGlobal().ExternalLabels.Get("__replica__"))
+
+	// The global config must not be modified.
+	require.Equal(t, labels.FromStrings("cluster", "prod", "region", "us"), global.Prometheus.ExternalLabels)
+}
+
 func TestInstance_Path(t *testing.T) {
 	scrapeAddr, closeSrv := getTestServer(t)
 	defer closeSrv()
@@ -211,6 +235,47 @@ func TestInstance_Path(t *testing.T) {
 	})
 }
 
+func TestInstance_Appender_NotRunning(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	globalConfig := getTestGlobalConfig(t)
+	cfg := getTestConfig(t, &globalConfig, "127.0.0.1:0")
+
+	inst, err := New(prometheus.NewRegistry(), cfg, walDir, log.NewNopLogger())
+	require.NoError(t, err)
+
+	// Appending to an instance which isn't running must fail instead of
+	// panicking.
+	app := inst.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo"), 0, 1)
+	require.Error(t, err)
+	require.Error(t, app.Commit())
+}
+
+func TestInstance_truncateTimestamp_Paused(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	globalConfig := getTestGlobalConfig(t)
+	cfg := getTestConfig(t, &globalConfig, "127.0.0.1:0")
+	cfg.RemoteWrite = []*RemoteWriteConfig{{RemoteWriteConfig: config.RemoteWriteConfig{Name: "write"}}}
+
+	inst, err := New(prometheus.NewRegistry(), cfg, walDir, log.NewNopLogger())
+	require.NoError(t, err)
+
+	// While paused, the WAL must only be truncated up to the samples another
+	// agent is known to have sent, not up to the current time.
+	require.NoError(t, inst.SetRemoteWritePaused(true))
+	inst.SetPausedRemoteWriteTimestamp(1000)
+	require.Equal(t, int64(1000), inst.truncateTimestamp())
+
+	require.NoError(t, inst.SetRemoteWritePaused(false))
+	require.Equal(t, inst.getRemoteWriteTimestamp(), inst.truncateTimestamp())
+}
+
 // TestInstance tests that discovery and scraping are working by using a mock
 // instance of the WAL storage and testing that samples get written to it.
 // This test touches most of Instance and is enough for a basic integration test.
@@ -414,9 +479,23 @@ type mockWalStorage struct {
 func (s *mockWalStorage) Directory() string                          { return s.directory }
 func (s *mockWalStorage) StartTime() (int64, error)                  { return 0, nil }
 func (s *mockWalStorage) WriteStalenessMarkers(f func() int64) error { return nil }
+func (s *mockWalStorage) WriteSnapshot(remoteWriteTs int64) error    { return nil }
+func (s *mockWalStorage) SnapshotRemoteWriteTimestamp() int64        { return 0 }
 func (s *mockWalStorage) Close() error                               { return nil }
 func (s *mockWalStorage) Truncate(mint int64) error                  { return nil }
 
+func (s *mockWalStorage) ReadSamples(from, through int64, f func(labels.Labels, int64, float64) error) error {
+	return nil
+}
+
+func (s *mockWalStorage) EvictSegments(bytes int64) (wal.EvictStats, error) {
+	return wal.EvictStats{}, nil
+}
+
+func (s *mockWalStorage) CardinalityStats(limit int) wal.CardinalityStats {
+	return wal.CardinalityStats{}
+}
+
 func (s *mockWalStorage) Appender(context.Context) storage.Appender {
 	return &mockAppender{s: s}
 }
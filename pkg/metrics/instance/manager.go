@@ -9,8 +9,10 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
 )
@@ -63,8 +65,12 @@ type ManagedInstance interface {
 	Run(ctx context.Context) error
 	Update(c Config) error
 	TargetsActive() map[string][]*scrape.Target
+	TargetsDropped() map[string][]*scrape.Target
 	StorageDirectory() string
 	Appender(ctx context.Context) storage.Appender
+	Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error)
+	RuleGroups() []*rules.Group
+	CardinalityStats(limit int) (wal.CardinalityStats, error)
 }
 
 // BasicManagerConfig controls the operations of a BasicManager.
@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/wal"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
@@ -101,8 +103,12 @@ type mockInstance struct {
 	RunFunc              func(ctx context.Context) error
 	UpdateFunc           func(c Config) error
 	TargetsActiveFunc    func() map[string][]*scrape.Target
+	TargetsDroppedFunc   func() map[string][]*scrape.Target
 	StorageDirectoryFunc func() string
 	AppenderFunc         func() storage.Appender
+	QuerierFunc          func(mint, maxt int64) (storage.Querier, error)
+	RuleGroupsFunc       func() []*rules.Group
+	CardinalityStatsFunc func(limit int) (wal.CardinalityStats, error)
 }
 
 func (m mockInstance) Run(ctx context.Context) error {
@@ -126,6 +132,13 @@ func (m mockInstance) TargetsActive() map[string][]*scrape.Target {
 	panic("TargetsActiveFunc not provided")
 }
 
+func (m mockInstance) TargetsDropped() map[string][]*scrape.Target {
+	if m.TargetsDroppedFunc != nil {
+		return m.TargetsDroppedFunc()
+	}
+	panic("TargetsDroppedFunc not provided")
+}
+
 func (m mockInstance) StorageDirectory() string {
 	if m.StorageDirectoryFunc != nil {
 		return m.StorageDirectoryFunc()
@@ -139,3 +152,24 @@ func (m mockInstance) Appender(_ context.Context) storage.Appender {
 	}
 	panic("AppenderFunc not provided")
 }
+
+func (m mockInstance) Querier(_ context.Context, mint, maxt int64) (storage.Querier, error) {
+	if m.QuerierFunc != nil {
+		return m.QuerierFunc(mint, maxt)
+	}
+	panic("QuerierFunc not provided")
+}
+
+func (m mockInstance) RuleGroups() []*rules.Group {
+	if m.RuleGroupsFunc != nil {
+		return m.RuleGroupsFunc()
+	}
+	panic("RuleGroupsFunc not provided")
+}
+
+func (m mockInstance) CardinalityStats(limit int) (wal.CardinalityStats, error) {
+	if m.CardinalityStatsFunc != nil {
+		return m.CardinalityStatsFunc(limit)
+	}
+	panic("CardinalityStatsFunc not provided")
+}
@@ -3,6 +3,8 @@ package instance
 import (
 	"context"
 
+	"github.com/grafana/agent/pkg/metrics/wal"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
 )
@@ -27,6 +29,11 @@ func (NoOpInstance) TargetsActive() map[string][]*scrape.Target {
 	return nil
 }
 
+// TargetsDropped implements Instance.
+func (NoOpInstance) TargetsDropped() map[string][]*scrape.Target {
+	return nil
+}
+
 // StorageDirectory implements Instance.
 func (NoOpInstance) StorageDirectory() string {
 	return ""
@@ -36,3 +43,18 @@ func (NoOpInstance) StorageDirectory() string {
 func (NoOpInstance) Appender(_ context.Context) storage.Appender {
 	return nil
 }
+
+// Querier implements Instance
+func (NoOpInstance) Querier(_ context.Context, _, _ int64) (storage.Querier, error) {
+	return storage.NoopQuerier(), nil
+}
+
+// RuleGroups implements Instance
+func (NoOpInstance) RuleGroups() []*rules.Group {
+	return nil
+}
+
+// CardinalityStats implements Instance
+func (NoOpInstance) CardinalityStats(_ int) (wal.CardinalityStats, error) {
+	return wal.CardinalityStats{}, nil
+}
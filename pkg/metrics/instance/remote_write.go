@@ -0,0 +1,157 @@
+package instance
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v2"
+)
+
+// seriesMatcherKey is the YAML key of RemoteWriteConfig.SeriesMatcher.
+const seriesMatcherKey = "series_matcher"
+
+// RemoteWriteConfig is a Prometheus remote_write config which can be limited
+// to a subset of the series of an instance.
+type RemoteWriteConfig struct {
+	config.RemoteWriteConfig `yaml:",inline"`
+
+	// SeriesMatcher is a series selector, such as {team="payments"}. When
+	// set, only series matching the selector are sent to the endpoint. The
+	// JSON tag keeps generated names the same for configs without a matcher.
+	SeriesMatcher string `yaml:"series_matcher,omitempty" json:",omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// The Prometheus config applies its own defaults and validation but
+	// rejects unknown fields, so series_matcher is removed before passing the
+	// rest of the config to it.
+	var raw yaml.MapSlice
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	*c = RemoteWriteConfig{}
+
+	rest := make(yaml.MapSlice, 0, len(raw))
+	for _, item := range raw {
+		if item.Key != seriesMatcherKey {
+			rest = append(rest, item)
+			continue
+		}
+		if item.Value == nil {
+			continue
+		}
+		matcher, ok := item.Value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", seriesMatcherKey)
+		}
+		c.SeriesMatcher = matcher
+	}
+
+	bb, err := yaml.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(bb, &c.RemoteWriteConfig); err != nil {
+		return err
+	}
+
+	_, err = c.matchers()
+	return err
+}
+
+// matchers returns the parsed SeriesMatcher. Returns nil if no matcher is set.
+func (c *RemoteWriteConfig) matchers() ([]*labels.Matcher, error) {
+	if c.SeriesMatcher == "" {
+		return nil, nil
+	}
+	ms, err := parser.ParseMetricSelector(c.SeriesMatcher)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", seriesMatcherKey, c.SeriesMatcher, err)
+	}
+	return ms, nil
+}
+
+// matcherRelabelConfigs converts matchers into relabel configs which keep
+// series that match all of them. Both matchers and relabel regexes are fully
+// anchored and treat missing labels as empty, so they select the same series.
+func matcherRelabelConfigs(ms []*labels.Matcher) ([]*relabel.Config, error) {
+	res := make([]*relabel.Config, 0, len(ms))
+	for _, m := range ms {
+		var (
+			action relabel.Action
+			expr   string
+		)
+		switch m.Type {
+		case labels.MatchEqual:
+			action, expr = relabel.Keep, regexp.QuoteMeta(m.Value)
+		case labels.MatchNotEqual:
+			action, expr = relabel.Drop, regexp.QuoteMeta(m.Value)
+		case labels.MatchRegexp:
+			action, expr = relabel.Keep, m.Value
+		case labels.MatchNotRegexp:
+			action, expr = relabel.Drop, m.Value
+		default:
+			return nil, fmt.Errorf("unsupported matcher type %s", m.Type)
+		}
+
+		re, err := relabel.NewRegexp(expr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &relabel.Config{
+			SourceLabels: model.LabelNames{model.LabelName(m.Name)},
+			Separator:    relabel.DefaultRelabelConfig.Separator,
+			Regex:        re,
+			Action:       action,
+		})
+	}
+	return res, nil
+}
+
+// remoteWriteConfigs returns the Prometheus remote_write configs to use for
+// cfg. Write relabel configs are added to each remote_write to:
+//
+//  1. Keep only the series selected by its series_matcher, if set. These run
+//     before the user's write_relabel_configs so the matcher applies to the
+//     series as they were written to the WAL.
+//  2. Drop the series used as inputs to recording rules when
+//     drop_rule_inputs is set.
+func remoteWriteConfigs(cfg *Config) ([]*config.RemoteWriteConfig, error) {
+	dropInputs, err := dropRuleInputsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*config.RemoteWriteConfig, 0, len(cfg.RemoteWrite))
+	for _, rw := range cfg.RemoteWrite {
+		cp := rw.RemoteWriteConfig
+
+		ms, err := rw.matchers()
+		if err != nil {
+			return nil, fmt.Errorf("remote_write %s: %w", rw.Name, err)
+		}
+		if len(ms) == 0 && dropInputs == nil {
+			res = append(res, &cp)
+			continue
+		}
+
+		rcs, err := matcherRelabelConfigs(ms)
+		if err != nil {
+			return nil, fmt.Errorf("remote_write %s: %w", rw.Name, err)
+		}
+		rcs = append(rcs, rw.WriteRelabelConfigs...)
+		if dropInputs != nil {
+			rcs = append(rcs, dropInputs)
+		}
+		cp.WriteRelabelConfigs = rcs
+		res = append(res, &cp)
+	}
+	return res, nil
+}
@@ -0,0 +1,222 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	cortex_util "github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// resendDelay is how long to wait after remote_write is resumed before
+// reading the samples to resend. It gives scrapes which started before
+// remote_write was resumed time to commit their samples to the WAL.
+const resendDelay = 30 * time.Second
+
+// resend is a running resend of samples started by ResendRemoteWrite.
+type resend struct {
+	from   int64
+	cancel context.CancelFunc
+}
+
+// ResendRemoteWrite sends the samples in the WAL with timestamps after from
+// over remote_write in the background. It is used when taking over sending
+// samples from another agent, as remote_write only sends samples which are
+// written after it is resumed. Samples which the other agent already sent
+// may be sent again.
+//
+// Only samples which haven't been truncated from the WAL can be resent. Any
+// resend which is still running is stopped when remote_write is paused or
+// the instance stops.
+func (i *Instance) ResendRemoteWrite(from int64) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+
+	through := timestamp.FromTime(time.Now())
+	if i.wal == nil || i.remoteWritePaused || len(i.cfg.RemoteWrite) == 0 || from >= through {
+		return
+	}
+
+	rwConfigs, err := remoteWriteConfigs(&i.cfg)
+	if err != nil {
+		level.Error(i.logger).Log("msg", "failed to build remote_write configs for resending samples", "err", err)
+		return
+	}
+
+	i.stopResend()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &resend{from: from, cancel: cancel}
+	i.resend = r
+
+	var (
+		wal            = i.wal
+		externalLabels = i.cfg.prometheusGlobal().ExternalLabels
+	)
+
+	go func() {
+		defer func() {
+			i.mut.Lock()
+			if i.resend == r {
+				i.resend = nil
+			}
+			i.mut.Unlock()
+		}()
+
+		level.Info(i.logger).Log("msg", "resending samples over remote_write", "from", timestamp.Time(from), "through", timestamp.Time(through))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resendDelay):
+		}
+
+		err := resendSamples(ctx, i.logger, wal, rwConfigs, externalLabels, from, through)
+		if err != nil && ctx.Err() == nil {
+			level.Error(i.logger).Log("msg", "failed to resend samples over remote_write", "err", err)
+			return
+		}
+		level.Info(i.logger).Log("msg", "finished resending samples over remote_write")
+	}()
+}
+
+// stopResend stops the running resend, if any. i.mut must be held when
+// calling stopResend.
+func (i *Instance) stopResend() {
+	if i.resend != nil {
+		i.resend.cancel()
+		i.resend = nil
+	}
+}
+
+// resendTarget is a remote_write endpoint which samples are being resent to.
+type resendTarget struct {
+	cfg    *config.RemoteWriteConfig
+	client remote.WriteClient
+	batch  []prompb.TimeSeries
+}
+
+// resendSamples reads samples from wal with timestamps after from and no
+// later than through and sends them to every remote_write config. Samples
+// are labeled and relabeled the same way remote_write would.
+func resendSamples(ctx context.Context, l log.Logger, wal walStorage, rwConfigs []*config.RemoteWriteConfig, externalLabels labels.Labels, from, through int64) error {
+	targets := make([]*resendTarget, 0, len(rwConfigs))
+	for _, rw := range rwConfigs {
+		cli, err := remote.NewWriteClient(rw.Name, &remote.ClientConfig{
+			URL:              rw.URL,
+			Timeout:          rw.RemoteTimeout,
+			HTTPClientConfig: rw.HTTPClientConfig,
+			SigV4Config:      rw.SigV4Config,
+			Headers:          rw.Headers,
+			RetryOnRateLimit: rw.QueueConfig.RetryOnRateLimit,
+		})
+		if err != nil {
+			return fmt.Errorf("remote_write %s: %w", rw.Name, err)
+		}
+		targets = append(targets, &resendTarget{cfg: rw, client: cli})
+	}
+
+	err := wal.ReadSamples(from, through, func(lset labels.Labels, t int64, v float64) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		lset = addMissingLabels(lset, externalLabels)
+		for _, target := range targets {
+			tlset := relabel.Process(lset, target.cfg.WriteRelabelConfigs...)
+			if tlset == nil {
+				continue
+			}
+
+			target.batch = append(target.batch, prompb.TimeSeries{
+				Labels:  labelsToLabelsProto(tlset),
+				Samples: []prompb.Sample{{Timestamp: t, Value: v}},
+			})
+			if len(target.batch) >= target.cfg.QueueConfig.MaxSamplesPerSend {
+				target.flush(ctx, l)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		target.flush(ctx, l)
+	}
+	return ctx.Err()
+}
+
+// flush sends the batched samples of t, retrying recoverable errors with
+// backoff until ctx is canceled. Batches which fail with an unrecoverable
+// error are dropped, the same as remote_write does.
+func (t *resendTarget) flush(ctx context.Context, l log.Logger) {
+	if len(t.batch) == 0 {
+		return
+	}
+	defer func() { t.batch = t.batch[:0] }()
+
+	wr := prompb.WriteRequest{Timeseries: t.batch}
+	req, err := wr.Marshal()
+	if err != nil {
+		level.Error(l).Log("msg", "failed to encode samples to resend", "remote_name", t.cfg.Name, "err", err)
+		return
+	}
+	req = snappy.Encode(nil, req)
+
+	backoff := cortex_util.NewBackoff(ctx, cortex_util.BackoffConfig{
+		MinBackoff: time.Duration(t.cfg.QueueConfig.MinBackoff),
+		MaxBackoff: time.Duration(t.cfg.QueueConfig.MaxBackoff),
+	})
+	for backoff.Ongoing() {
+		err = t.client.Store(ctx, req)
+		if err == nil {
+			return
+		}
+
+		var recoverable remote.RecoverableError
+		if !errors.As(err, &recoverable) {
+			break
+		}
+		backoff.Wait()
+	}
+	if ctx.Err() == nil {
+		level.Error(l).Log("msg", "dropped samples which couldn't be resent", "remote_name", t.cfg.Name, "count", len(t.batch), "err", err)
+	}
+}
+
+// addMissingLabels adds the labels from extra which aren't already set in
+// lset. External labels are only added to series which don't already have
+// them, the same way remote_write adds them.
+func addMissingLabels(lset, extra labels.Labels) labels.Labels {
+	if len(extra) == 0 {
+		return lset
+	}
+
+	b := labels.NewBuilder(lset)
+	for _, l := range extra {
+		if !lset.Has(l.Name) {
+			b.Set(l.Name, l.Value)
+		}
+	}
+	return b.Labels()
+}
+
+func labelsToLabelsProto(lset labels.Labels) []prompb.Label {
+	res := make([]prompb.Label, 0, len(lset))
+	for _, l := range lset {
+		res = append(res, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
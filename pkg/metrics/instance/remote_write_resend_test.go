@@ -0,0 +1,109 @@
+package instance
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resendSamples(t *testing.T) {
+	var (
+		mut      sync.Mutex
+		received []prompb.TimeSeries
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		bb, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		var req prompb.WriteRequest
+		require.NoError(t, req.Unmarshal(bb))
+
+		mut.Lock()
+		received = append(received, req.Timeseries...)
+		mut.Unlock()
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	rw := config.DefaultRemoteWriteConfig
+	rw.Name = "test"
+	rw.URL = &config_util.URL{URL: u}
+	rw.QueueConfig.MaxSamplesPerSend = 2
+	rw.WriteRelabelConfigs = []*relabel.Config{{
+		SourceLabels: model.LabelNames{"__name__"},
+		Separator:    relabel.DefaultRelabelConfig.Separator,
+		Regex:        relabel.MustNewRegexp("dropped"),
+		Action:       relabel.Drop,
+	}}
+
+	wal := &samplesWalStorage{samples: []walSample{
+		{lset: labels.FromStrings("__name__", "old"), t: 100},
+		{lset: labels.FromStrings("__name__", "foo"), t: 200, v: 1},
+		{lset: labels.FromStrings("__name__", "dropped"), t: 200},
+		{lset: labels.FromStrings("__name__", "foo", "cluster", "own"), t: 300, v: 2},
+		{lset: labels.FromStrings("__name__", "bar"), t: 300, v: 3},
+		{lset: labels.FromStrings("__name__", "new"), t: 400},
+	}}
+	externalLabels := labels.FromStrings("cluster", "external")
+
+	err = resendSamples(context.Background(), log.NewNopLogger(), wal, []*config.RemoteWriteConfig{&rw}, externalLabels, 100, 300)
+	require.NoError(t, err)
+
+	// Only samples in the range are sent, with external labels added when
+	// they're missing and write_relabel_configs applied.
+	require.Equal(t, []prompb.TimeSeries{
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "foo"}, {Name: "cluster", Value: "external"}},
+			Samples: []prompb.Sample{{Timestamp: 200, Value: 1}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "foo"}, {Name: "cluster", Value: "own"}},
+			Samples: []prompb.Sample{{Timestamp: 300, Value: 2}},
+		},
+		{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "bar"}, {Name: "cluster", Value: "external"}},
+			Samples: []prompb.Sample{{Timestamp: 300, Value: 3}},
+		},
+	}, received)
+}
+
+type walSample struct {
+	lset labels.Labels
+	t    int64
+	v    float64
+}
+
+type samplesWalStorage struct {
+	mockWalStorage
+	samples []walSample
+}
+
+func (s *samplesWalStorage) ReadSamples(from, through int64, f func(labels.Labels, int64, float64) error) error {
+	for _, sample := range s.samples {
+		if sample.t <= from || sample.t > through {
+			continue
+		}
+		if err := f(sample.lset, sample.t, sample.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
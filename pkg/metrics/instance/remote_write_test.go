@@ -0,0 +1,126 @@
+package instance
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteWriteConfig_UnmarshalYAML(t *testing.T) {
+	cfgText := `
+name: test
+remote_write:
+- url: http://localhost:9009/api/prom/push
+  name: payments
+  series_matcher: '{team="payments"}'
+- url: http://localhost:9009/api/prom/push
+  name: shared`
+
+	cfg, err := UnmarshalConfig(strings.NewReader(cfgText))
+	require.NoError(t, err)
+	require.Len(t, cfg.RemoteWrite, 2)
+
+	payments := cfg.RemoteWrite[0]
+	require.Equal(t, `{team="payments"}`, payments.SeriesMatcher)
+	require.Equal(t, "http://localhost:9009/api/prom/push", payments.URL.String())
+	require.Equal(t, model.Duration(30*time.Second), payments.RemoteTimeout, "Prometheus defaults should be applied")
+	require.Empty(t, cfg.RemoteWrite[1].SeriesMatcher)
+
+	// The matcher should survive being marshaled.
+	bb, err := MarshalConfig(cfg, false)
+	require.NoError(t, err)
+	cp, err := UnmarshalConfig(bytes.NewReader(bb))
+	require.NoError(t, err)
+	require.Equal(t, cfg.RemoteWrite, cp.RemoteWrite)
+
+	t.Run("invalid matcher", func(t *testing.T) {
+		_, err := UnmarshalConfig(strings.NewReader(`
+name: test
+remote_write:
+- url: http://localhost:9009/api/prom/push
+  series_matcher: '{team=}'`))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `invalid series_matcher "{team=}"`)
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := UnmarshalConfig(strings.NewReader(`
+name: test
+remote_write:
+- url: http://localhost:9009/api/prom/push
+  series_matchers: '{team="payments"}'`))
+		require.Error(t, err)
+	})
+
+	t.Run("missing url", func(t *testing.T) {
+		_, err := UnmarshalConfig(strings.NewReader(`
+name: test
+remote_write:
+- series_matcher: '{team="payments"}'`))
+		require.EqualError(t, err, "url for remote_write is empty")
+	})
+}
+
+func TestRemoteWriteConfigs_SeriesMatcher(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.RemoteWrite = []*RemoteWriteConfig{
+		{
+			RemoteWriteConfig: config.RemoteWriteConfig{Name: "payments"},
+			SeriesMatcher:     `{team="payments", env=~"prod|staging"}`,
+		},
+		{
+			RemoteWriteConfig: config.RemoteWriteConfig{
+				Name: "shared",
+				WriteRelabelConfigs: []*relabel.Config{{
+					SourceLabels: model.LabelNames{"team"},
+					Regex:        relabel.MustNewRegexp("(.*)"),
+					TargetLabel:  "owner",
+					Replacement:  "$1",
+					Action:       relabel.Replace,
+				}},
+			},
+			SeriesMatcher: `{team!="payments"}`,
+		},
+		{
+			RemoteWriteConfig: config.RemoteWriteConfig{Name: "all"},
+		},
+	}
+
+	rws, err := remoteWriteConfigs(&cfg)
+	require.NoError(t, err)
+	require.Len(t, rws, 3)
+	require.Len(t, cfg.RemoteWrite[1].WriteRelabelConfigs, 1, "original config should not be modified")
+
+	tt := []struct {
+		series labels.Labels
+		expect []string
+	}{
+		{labels.FromStrings("__name__", "up", "team", "payments", "env", "prod"), []string{"payments", "all"}},
+		{labels.FromStrings("__name__", "up", "team", "payments", "env", "dev"), []string{"all"}},
+		{labels.FromStrings("__name__", "up", "team", "search", "env", "prod"), []string{"shared", "all"}},
+		{labels.FromStrings("__name__", "up"), []string{"shared", "all"}},
+	}
+	for _, tc := range tt {
+		var sentTo []string
+		for _, rw := range rws {
+			if relabel.Process(tc.series, rw.WriteRelabelConfigs...) != nil {
+				sentTo = append(sentTo, rw.Name)
+			}
+		}
+		require.Equal(t, tc.expect, sentTo, "series %s", tc.series)
+	}
+
+	// The user's write_relabel_configs should run after the matcher.
+	shared := rws[1].WriteRelabelConfigs
+	require.Len(t, shared, 2)
+	require.Equal(t, relabel.Drop, shared[0].Action)
+	require.Equal(t, relabel.Replace, shared[1].Action)
+}
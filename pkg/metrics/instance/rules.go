@@ -0,0 +1,384 @@
+package instance
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v3"
+)
+
+// inlineRuleGroups is the identifier used for rule groups defined inline in
+// the instance config rather than in a rule file.
+const inlineRuleGroups = "<inline>"
+
+// Settings for the engine used for evaluating rules.
+const (
+	ruleQueryMaxSamples = 50000000
+	ruleQueryTimeout    = 2 * time.Minute
+)
+
+// Settings for alerting rules. These match the Prometheus defaults.
+const (
+	ruleOutageTolerance = time.Hour
+	ruleForGracePeriod  = 10 * time.Minute
+	ruleResendDelay     = time.Minute
+)
+
+// RuleGroup is a group of recording and alerting rules which are evaluated
+// together on an interval.
+type RuleGroup struct {
+	Name     string         `yaml:"name"`
+	Interval model.Duration `yaml:"interval,omitempty"`
+	Rules    []rulefmt.Rule `yaml:"rules"`
+}
+
+// hasRules returns true if the config defines any rules.
+func (c *Config) hasRules() bool {
+	return len(c.RuleFiles) > 0 || len(c.RuleGroups) > 0
+}
+
+// validateRules validates the rule settings of the config.
+func (c *Config) validateRules() error {
+	if !c.hasRules() {
+		return nil
+	}
+	if c.QueryRetention <= 0 {
+		return fmt.Errorf("query_retention must be set to evaluate rules")
+	}
+
+	for _, pattern := range c.RuleFiles {
+		if _, err := filepath.Glob(pattern); err != nil {
+			return fmt.Errorf("invalid rule file pattern %q: %w", pattern, err)
+		}
+	}
+
+	groupNames := map[string]struct{}{}
+	for _, g := range c.RuleGroups {
+		if g.Name == "" {
+			return fmt.Errorf("rule group must have a name")
+		}
+		if _, exist := groupNames[g.Name]; exist {
+			return fmt.Errorf("found multiple rule groups with name %s", g.Name)
+		}
+		groupNames[g.Name] = struct{}{}
+
+		for _, r := range g.Rules {
+			if err := validateRule(r); err != nil {
+				return fmt.Errorf("invalid rule in group %s: %w", g.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateRule(r rulefmt.Rule) error {
+	name := r.Record
+	if r.Alert != "" {
+		name = r.Alert
+	}
+
+	switch {
+	case r.Record != "" && r.Alert != "":
+		return fmt.Errorf("rule %s must not set both record and alert", r.Record)
+	case name == "":
+		return fmt.Errorf("rule must have a record or alert name")
+	case r.Record != "" && !model.IsValidMetricName(model.LabelValue(r.Record)):
+		return fmt.Errorf("invalid recording rule name %q", r.Record)
+	case r.Record != "" && r.For != 0:
+		return fmt.Errorf("recording rule %s must not set for", r.Record)
+	case r.Record != "" && len(r.Annotations) > 0:
+		return fmt.Errorf("recording rule %s must not set annotations", r.Record)
+	case r.Expr == "":
+		return fmt.Errorf("rule %s must have an expression", name)
+	}
+
+	if _, err := parser.ParseExpr(r.Expr); err != nil {
+		return fmt.Errorf("could not parse expression for rule %s: %w", name, err)
+	}
+	for k := range r.Labels {
+		if !model.LabelName(k).IsValid() {
+			return fmt.Errorf("invalid label name %q in rule %s", k, name)
+		}
+	}
+	for k := range r.Annotations {
+		if !model.LabelName(k).IsValid() {
+			return fmt.Errorf("invalid annotation name %q in rule %s", k, name)
+		}
+	}
+	return nil
+}
+
+// ruleIdentifiers returns the identifiers to load rule groups from: all
+// files matching the rule_files patterns, followed by the inline groups.
+func ruleIdentifiers(cfg *Config) ([]string, error) {
+	var ids []string
+	for _, pattern := range cfg.RuleFiles {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule file pattern %q: %w", pattern, err)
+		}
+		ids = append(ids, files...)
+	}
+	if len(cfg.RuleGroups) > 0 {
+		ids = append(ids, inlineRuleGroups)
+	}
+	return ids, nil
+}
+
+// ruleLoader implements rules.GroupLoader, loading rule groups from files or
+// from the inline rule groups of a config.
+type ruleLoader struct {
+	groups []RuleGroup
+}
+
+// Load implements rules.GroupLoader.
+func (l ruleLoader) Load(identifier string) (*rulefmt.RuleGroups, []error) {
+	if identifier == inlineRuleGroups {
+		return l.inlineGroups(), nil
+	}
+	return rulefmt.ParseFile(identifier)
+}
+
+func (l ruleLoader) inlineGroups() *rulefmt.RuleGroups {
+	rgs := &rulefmt.RuleGroups{Groups: make([]rulefmt.RuleGroup, 0, len(l.groups))}
+	for _, g := range l.groups {
+		rg := rulefmt.RuleGroup{
+			Name:     g.Name,
+			Interval: g.Interval,
+			Rules:    make([]rulefmt.RuleNode, 0, len(g.Rules)),
+		}
+		for _, r := range g.Rules {
+			rg.Rules = append(rg.Rules, rulefmt.RuleNode{
+				Record:      yaml.Node{Kind: yaml.ScalarNode, Value: r.Record},
+				Alert:       yaml.Node{Kind: yaml.ScalarNode, Value: r.Alert},
+				Expr:        yaml.Node{Kind: yaml.ScalarNode, Value: r.Expr},
+				For:         r.For,
+				Labels:      r.Labels,
+				Annotations: r.Annotations,
+			})
+		}
+		rgs.Groups = append(rgs.Groups, rg)
+	}
+	return rgs
+}
+
+// Parse implements rules.GroupLoader.
+func (ruleLoader) Parse(query string) (parser.Expr, error) { return parser.ParseExpr(query) }
+
+// ruleManager evaluates the rules of an instance.
+type ruleManager struct {
+	manager *rules.Manager
+	loader  *ruleLoader
+
+	// evalInterval is the global evaluation interval in milliseconds. It's
+	// used as the step for subqueries which don't specify one.
+	evalInterval *atomic.Int64
+
+	// Rule groups don't start evaluating until the manager runs, and stopping
+	// the manager waits for them to finish. running and stopped prevent Stop
+	// from blocking forever if the manager never ran.
+	mut     sync.Mutex
+	running bool
+	stopped bool
+}
+
+// newRuleManager creates a new ruleManager which evaluates rules against
+// queryable and appends the results to appendable. Alerts from alerting rules
+// are passed to sender.
+func newRuleManager(ctx context.Context, l log.Logger, reg prometheus.Registerer, queryable storage.Queryable, appendable storage.Appendable, sender alertSender) *ruleManager {
+	evalInterval := atomic.NewInt64(time.Duration(config.DefaultGlobalConfig.EvaluationInterval).Milliseconds())
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		Logger:     log.With(l, "component", "query engine"),
+		MaxSamples: ruleQueryMaxSamples,
+		Timeout:    ruleQueryTimeout,
+		NoStepSubqueryIntervalFn: func(int64) int64 {
+			return evalInterval.Load()
+		},
+	})
+
+	loader := &ruleLoader{}
+	manager := rules.NewManager(&rules.ManagerOptions{
+		ExternalURL:     &url.URL{},
+		QueryFunc:       rules.EngineQueryFunc(engine, queryable),
+		NotifyFunc:      sendAlerts(sender),
+		Context:         ctx,
+		Appendable:      appendable,
+		Queryable:       queryable,
+		Logger:          log.With(l, "component", "rule manager"),
+		Registerer:      reg,
+		OutageTolerance: ruleOutageTolerance,
+		ForGracePeriod:  ruleForGracePeriod,
+		ResendDelay:     ruleResendDelay,
+		GroupLoader:     loader,
+	})
+
+	return &ruleManager{manager: manager, loader: loader, evalInterval: evalInterval}
+}
+
+// ApplyConfig updates the rules being evaluated.
+func (m *ruleManager) ApplyConfig(cfg *Config) error {
+	ids, err := ruleIdentifiers(cfg)
+	if err != nil {
+		return err
+	}
+
+	m.loader.groups = cfg.RuleGroups
+	interval := time.Duration(cfg.global.Prometheus.EvaluationInterval)
+	m.evalInterval.Store(interval.Milliseconds())
+	return m.manager.Update(interval, ids, cfg.prometheusGlobal().ExternalLabels)
+}
+
+// RuleGroups returns the rule groups currently being evaluated.
+func (m *ruleManager) RuleGroups() []*rules.Group {
+	return m.manager.RuleGroups()
+}
+
+// Run runs the rule manager until Stop is called.
+func (m *ruleManager) Run() error {
+	m.mut.Lock()
+	if m.stopped {
+		m.mut.Unlock()
+		return nil
+	}
+	m.running = true
+	m.mut.Unlock()
+
+	m.manager.Run()
+	return nil
+}
+
+// Stop stops the rule manager.
+func (m *ruleManager) Stop() {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.stopped {
+		return
+	}
+	m.stopped = true
+
+	if m.running {
+		m.manager.Stop()
+	}
+}
+
+// dropRuleInputsConfig returns a write relabel config which drops the series
+// used as inputs to recording rules. Returns nil if drop_rule_inputs isn't set
+// or there are no inputs to drop.
+func dropRuleInputsConfig(cfg *Config) (*relabel.Config, error) {
+	if !cfg.DropRuleInputs || !cfg.hasRules() {
+		return nil, nil
+	}
+
+	names, err := ruleInputNames(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	re, err := relabel.NewRegexp(strings.Join(quoted, "|"))
+	if err != nil {
+		return nil, err
+	}
+	return &relabel.Config{
+		SourceLabels: model.LabelNames{model.MetricNameLabel},
+		Separator:    relabel.DefaultRelabelConfig.Separator,
+		Regex:        re,
+		Action:       relabel.Drop,
+	}, nil
+}
+
+// ruleInputNames returns the sorted list of metric names selected by
+// recording rules. Metric names which are produced by other recording rules
+// are excluded, as are selectors without an exact metric name. Alerting rules
+// are ignored.
+func ruleInputNames(cfg *Config) ([]string, error) {
+	ids, err := ruleIdentifiers(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		loader  = ruleLoader{groups: cfg.RuleGroups}
+		inputs  = map[string]struct{}{}
+		records = map[string]struct{}{}
+	)
+	for _, id := range ids {
+		rgs, errs := loader.Load(id)
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+
+		for _, g := range rgs.Groups {
+			for _, r := range g.Rules {
+				if r.Record.Value == "" {
+					continue
+				}
+				records[r.Record.Value] = struct{}{}
+
+				expr, err := parser.ParseExpr(r.Expr.Value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", id, err)
+				}
+				parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+					if vs, ok := node.(*parser.VectorSelector); ok {
+						if name := selectorName(vs); name != "" {
+							inputs[name] = struct{}{}
+						}
+					}
+					return nil
+				})
+			}
+		}
+	}
+
+	res := make([]string, 0, len(inputs))
+	for name := range inputs {
+		if _, isRecord := records[name]; isRecord {
+			continue
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// selectorName returns the exact metric name matched by vs, if any.
+func selectorName(vs *parser.VectorSelector) string {
+	if vs.Name != "" {
+		return vs.Name
+	}
+	for _, m := range vs.LabelMatchers {
+		if m.Name == labels.MetricName && m.Type == labels.MatchEqual {
+			return m.Value
+		}
+	}
+	return ""
+}
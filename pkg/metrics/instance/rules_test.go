@@ -0,0 +1,244 @@
+package instance
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/wal"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/notifier"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ValidateRules(t *testing.T) {
+	tt := []struct {
+		name    string
+		mutator func(c *Config)
+		expect  string
+	}{
+		{
+			name:    "valid",
+			mutator: func(c *Config) {},
+		},
+		{
+			name:    "missing query_retention",
+			mutator: func(c *Config) { c.QueryRetention = 0 },
+			expect:  "query_retention must be set to evaluate rules",
+		},
+		{
+			name: "duplicate group",
+			mutator: func(c *Config) {
+				c.RuleGroups = append(c.RuleGroups, c.RuleGroups[0])
+			},
+			expect: "found multiple rule groups with name test",
+		},
+		{
+			name: "alerting rule",
+			mutator: func(c *Config) {
+				c.RuleGroups[0].Rules = append(c.RuleGroups[0].Rules, rulefmt.Rule{
+					Alert:       "HighFoo",
+					Expr:        "foo > 1",
+					For:         model.Duration(time.Minute),
+					Annotations: map[string]string{"summary": "foo is high"},
+				})
+			},
+		},
+		{
+			name: "record and alert",
+			mutator: func(c *Config) {
+				c.RuleGroups[0].Rules[0].Alert = "HighFoo"
+			},
+			expect: "invalid rule in group test: rule job:foo:sum must not set both record and alert",
+		},
+		{
+			name: "recording rule with annotations",
+			mutator: func(c *Config) {
+				c.RuleGroups[0].Rules[0].Annotations = map[string]string{"summary": "foo"}
+			},
+			expect: "invalid rule in group test: recording rule job:foo:sum must not set annotations",
+		},
+		{
+			name: "invalid expression",
+			mutator: func(c *Config) {
+				c.RuleGroups[0].Rules[0].Expr = "sum(foo"
+			},
+			expect: "invalid rule in group test: could not parse expression for rule job:foo:sum",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := makeRulesConfig()
+			tc.mutator(&cfg)
+
+			err := cfg.validateRules()
+			if tc.expect == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expect)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteConfigs_DropRuleInputs(t *testing.T) {
+	cfg := makeRulesConfig()
+	cfg.RuleGroups[0].Rules = append(cfg.RuleGroups[0].Rules,
+		rulefmt.Rule{Record: "job:bar:rate5m", Expr: `rate({__name__="bar"}[5m])`},
+		rulefmt.Rule{Record: "job:baz:sum", Expr: `sum({__name__=~"baz.*"})`},
+		rulefmt.Rule{Record: "foo:sum", Expr: `sum(job:foo:sum)`},
+	)
+	cfg.RemoteWrite = []*RemoteWriteConfig{{RemoteWriteConfig: config.RemoteWriteConfig{Name: "rw"}}}
+
+	names, err := ruleInputNames(&cfg)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar", "foo"}, names)
+
+	// Without drop_rule_inputs, remote_write configs are unchanged.
+	rws, err := remoteWriteConfigs(&cfg)
+	require.NoError(t, err)
+	require.Equal(t, []*config.RemoteWriteConfig{&cfg.RemoteWrite[0].RemoteWriteConfig}, rws)
+
+	cfg.DropRuleInputs = true
+	rws, err = remoteWriteConfigs(&cfg)
+	require.NoError(t, err)
+	require.Len(t, rws, 1)
+	require.Empty(t, cfg.RemoteWrite[0].WriteRelabelConfigs, "original config should not be modified")
+
+	require.Len(t, rws[0].WriteRelabelConfigs, 1)
+	drop := rws[0].WriteRelabelConfigs[0]
+	require.Equal(t, relabel.Drop, drop.Action)
+	require.Equal(t, model.LabelNames{model.MetricNameLabel}, drop.SourceLabels)
+	require.True(t, drop.Regex.MatchString("foo"))
+	require.True(t, drop.Regex.MatchString("bar"))
+	require.False(t, drop.Regex.MatchString("job:foo:sum"))
+	require.False(t, drop.Regex.MatchString("foobar"))
+}
+
+func TestRuleManager_Eval(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := wal.NewStorageWithOptions(log.NewNopLogger(), nil, walDir, wal.Options{
+		QueryRetention: time.Hour,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	app := s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo", "job", "a"), timestamp.FromTime(now.Add(-10*time.Second)), 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo", "job", "b"), timestamp.FromTime(now.Add(-10*time.Second)), 2)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	cfg := makeRulesConfig()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rm := newRuleManager(ctx, log.NewNopLogger(), nil, s, s, &fakeAlertSender{})
+	require.NoError(t, rm.ApplyConfig(&cfg))
+
+	groups := rm.manager.RuleGroups()
+	require.Len(t, groups, 1)
+	groups[0].Eval(ctx, now)
+
+	q, err := s.Querier(ctx, 0, timestamp.FromTime(now))
+	require.NoError(t, err)
+	ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "job:foo:sum"))
+	require.True(t, ss.Next())
+	it := ss.At().Iterator()
+	require.True(t, it.Next())
+	_, v := it.At()
+	require.Equal(t, 3.0, v)
+	require.False(t, ss.Next())
+}
+
+func TestRuleManager_Alerts(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := wal.NewStorageWithOptions(log.NewNopLogger(), nil, walDir, wal.Options{
+		QueryRetention: time.Hour,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	now := time.Now()
+	app := s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo", "job", "a"), timestamp.FromTime(now.Add(-10*time.Second)), 1)
+	require.NoError(t, err)
+	_, err = app.Append(0, labels.FromStrings("__name__", "foo", "job", "b"), timestamp.FromTime(now.Add(-10*time.Second)), 2)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	cfg := makeRulesConfig()
+	cfg.RuleGroups[0].Rules = []rulefmt.Rule{{
+		Alert:       "HighFoo",
+		Expr:        "foo > 1",
+		Labels:      map[string]string{"severity": "page"},
+		Annotations: map[string]string{"summary": "{{ $labels.job }} is high"},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender := &fakeAlertSender{}
+	rm := newRuleManager(ctx, log.NewNopLogger(), nil, s, s, sender)
+	require.NoError(t, rm.ApplyConfig(&cfg))
+
+	groups := rm.RuleGroups()
+	require.Len(t, groups, 1)
+	groups[0].Eval(ctx, now)
+
+	// Only job b is over the threshold. The alert has no for duration, so it
+	// should be sent right away.
+	require.Len(t, sender.alerts, 1)
+	alert := sender.alerts[0]
+	require.Equal(t, labels.FromStrings("alertname", "HighFoo", "job", "b", "severity", "page"), alert.Labels)
+	require.Equal(t, "b is high", alert.Annotations.Get("summary"))
+	require.True(t, now.Equal(alert.StartsAt))
+	require.True(t, alert.EndsAt.After(now))
+
+	// The ALERTS series should have been written to the WAL.
+	q, err := s.Querier(ctx, 0, timestamp.FromTime(now))
+	require.NoError(t, err)
+	ss := q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, "__name__", "ALERTS"))
+	require.True(t, ss.Next())
+	require.Equal(t, "firing", ss.At().Labels().Get("alertstate"))
+	require.False(t, ss.Next())
+}
+
+type fakeAlertSender struct {
+	alerts []*notifier.Alert
+}
+
+func (s *fakeAlertSender) Send(alerts ...*notifier.Alert) {
+	s.alerts = append(s.alerts, alerts...)
+}
+
+func makeRulesConfig() Config {
+	cfg := DefaultConfig
+	cfg.Name = "test"
+	cfg.QueryRetention = time.Hour
+	cfg.RuleGroups = []RuleGroup{{
+		Name: "test",
+		Rules: []rulefmt.Rule{
+			{Record: "job:foo:sum", Expr: "sum(foo)"},
+		},
+	}}
+	return cfg
+}
@@ -0,0 +1,42 @@
+// Package otlp implements an OTLP metrics receiver which appends received
+// metrics to a metrics instance.
+package otlp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Config controls the OTLP metrics receiver.
+type Config struct {
+	// Instance is the name of the metrics instance to append received metrics
+	// to.
+	Instance string `yaml:"instance"`
+
+	// Protocols configures the protocols the receiver listens on. It is passed
+	// through to the OTLP receiver from the OpenTelemetry Collector:
+	// https://github.com/open-telemetry/opentelemetry-collector/blob/v0.30.0/receiver/otlpreceiver/config.go
+	Protocols map[string]interface{} `yaml:"protocols"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	return unmarshal((*plain)(c))
+}
+
+// Validate ensures the Config is valid.
+func (c *Config) Validate() error {
+	if c.Instance == "" {
+		return errors.New("otlp_receiver must specify an instance")
+	}
+	if len(c.Protocols) == 0 {
+		return errors.New("otlp_receiver must configure at least one protocol")
+	}
+	for name := range c.Protocols {
+		if name != protocolGRPC && name != protocolHTTP {
+			return fmt.Errorf("unsupported otlp_receiver protocol %q. supported values 'grpc', 'http'", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// Resource attributes used to identify the job and instance of a metric.
+// See https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/resource/semantic_conventions/README.md#service
+const (
+	attrServiceName       = "service.name"
+	attrServiceNamespace  = "service.namespace"
+	attrServiceInstanceID = "service.instance.id"
+)
+
+// Reasons data points may be dropped.
+const (
+	dropReasonTemporality = "unsupported_temporality"
+	dropReasonType        = "unsupported_type"
+)
+
+type receiverMetrics struct {
+	receivedDataPoints prometheus.Counter
+	droppedDataPoints  *prometheus.CounterVec
+	appendedSamples    prometheus.Counter
+}
+
+func newReceiverMetrics(reg prometheus.Registerer) *receiverMetrics {
+	return &receiverMetrics{
+		receivedDataPoints: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_otlp_received_data_points_total",
+			Help: "Total number of data points received by the OTLP receiver.",
+		}),
+		droppedDataPoints: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "agent_prometheus_otlp_dropped_data_points_total",
+			Help: "Total number of data points received by the OTLP receiver which could not be converted to samples.",
+		}, []string{"reason"}),
+		appendedSamples: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "agent_prometheus_otlp_appended_samples_total",
+			Help: "Total number of samples appended to instances by the OTLP receiver.",
+		}),
+	}
+}
+
+// metricsConsumer implements consumer.Metrics, converting received metrics to
+// Prometheus series and appending them to an instance.
+type metricsConsumer struct {
+	logger   log.Logger
+	manager  instance.Manager
+	instance string
+	metrics  *receiverMetrics
+}
+
+var _ consumer.Metrics = (*metricsConsumer)(nil)
+
+// Capabilities implements consumer.Metrics.
+func (c *metricsConsumer) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+// ConsumeMetrics implements consumer.Metrics. All metrics in md are
+// committed to the instance in a single transaction.
+func (c *metricsConsumer) ConsumeMetrics(ctx context.Context, md pdata.Metrics) error {
+	inst, err := c.manager.GetInstance(c.instance)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to get instance for received metrics", "instance", c.instance, "err", err)
+		return err
+	}
+
+	conv := &converter{
+		app: inst.Appender(ctx),
+		now: timestamp.FromTime(time.Now()),
+	}
+
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		conv.resourceLabels = resourceLabels(rm.Resource())
+
+		ilms := rm.InstrumentationLibraryMetrics()
+		for j := 0; j < ilms.Len(); j++ {
+			ms := ilms.At(j).Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				if err := conv.convertMetric(ms.At(k)); err != nil {
+					_ = conv.app.Rollback()
+					return fmt.Errorf("failed to append metric %s: %w", ms.At(k).Name(), err)
+				}
+			}
+		}
+	}
+
+	if err := conv.app.Commit(); err != nil {
+		return err
+	}
+
+	c.metrics.receivedDataPoints.Add(float64(conv.received))
+	c.metrics.appendedSamples.Add(float64(conv.appended))
+	for reason, count := range conv.dropped {
+		c.metrics.droppedDataPoints.WithLabelValues(reason).Add(float64(count))
+	}
+	return nil
+}
+
+// converter converts OTLP metrics into samples and appends them.
+type converter struct {
+	app storage.Appender
+	now int64
+
+	// resourceLabels are labels derived from the resource of the metrics
+	// currently being converted.
+	resourceLabels map[string]string
+
+	received int
+	appended int
+	dropped  map[string]int
+}
+
+func (c *converter) drop(reason string, count int) {
+	if c.dropped == nil {
+		c.dropped = make(map[string]int)
+	}
+	c.dropped[reason] += count
+}
+
+func (c *converter) convertMetric(m pdata.Metric) error {
+	name := sanitizeMetricName(m.Name())
+
+	switch m.DataType() {
+	case pdata.MetricDataTypeIntGauge:
+		return c.convertIntDataPoints(name, m.IntGauge().DataPoints())
+	case pdata.MetricDataTypeGauge:
+		return c.convertDoubleDataPoints(name, m.Gauge().DataPoints())
+
+	case pdata.MetricDataTypeIntSum:
+		dps := m.IntSum().DataPoints()
+		if m.IntSum().AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+			c.received += dps.Len()
+			c.drop(dropReasonTemporality, dps.Len())
+			return nil
+		}
+		return c.convertIntDataPoints(name, dps)
+	case pdata.MetricDataTypeSum:
+		dps := m.Sum().DataPoints()
+		if m.Sum().AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+			c.received += dps.Len()
+			c.drop(dropReasonTemporality, dps.Len())
+			return nil
+		}
+		return c.convertDoubleDataPoints(name, dps)
+
+	case pdata.MetricDataTypeIntHistogram:
+		dps := m.IntHistogram().DataPoints()
+		if m.IntHistogram().AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+			c.received += dps.Len()
+			c.drop(dropReasonTemporality, dps.Len())
+			return nil
+		}
+		for i := 0; i < dps.Len(); i++ {
+			dp := dps.At(i)
+			err := c.appendHistogram(name, dp.LabelsMap(), dp.Timestamp(), float64(dp.Sum()), dp.Count(), dp.ExplicitBounds(), dp.BucketCounts())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case pdata.MetricDataTypeHistogram:
+		dps := m.Histogram().DataPoints()
+		if m.Histogram().AggregationTemporality() != pdata.AggregationTemporalityCumulative {
+			c.received += dps.Len()
+			c.drop(dropReasonTemporality, dps.Len())
+			return nil
+		}
+		for i := 0; i < dps.Len(); i++ {
+			dp := dps.At(i)
+			err := c.appendHistogram(name, dp.LabelsMap(), dp.Timestamp(), dp.Sum(), dp.Count(), dp.ExplicitBounds(), dp.BucketCounts())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case pdata.MetricDataTypeSummary:
+		dps := m.Summary().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			if err := c.appendSummary(name, dps.At(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		c.received++
+		c.drop(dropReasonType, 1)
+		return nil
+	}
+}
+
+func (c *converter) convertIntDataPoints(name string, dps pdata.IntDataPointSlice) error {
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		c.received++
+		if err := c.append(name, dp.LabelsMap(), nil, dp.Timestamp(), float64(dp.Value())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *converter) convertDoubleDataPoints(name string, dps pdata.DoubleDataPointSlice) error {
+	for i := 0; i < dps.Len(); i++ {
+		dp := dps.At(i)
+		c.received++
+		if err := c.append(name, dp.LabelsMap(), nil, dp.Timestamp(), dp.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendHistogram appends a histogram data point as a set of cumulative
+// _bucket series along with _sum and _count series.
+func (c *converter) appendHistogram(name string, lm pdata.StringMap, ts pdata.Timestamp, sum float64, count uint64, bounds []float64, buckets []uint64) error {
+	c.received++
+
+	if err := c.append(name+"_sum", lm, nil, ts, sum); err != nil {
+		return err
+	}
+	if err := c.append(name+"_count", lm, nil, ts, float64(count)); err != nil {
+		return err
+	}
+
+	var cumulative uint64
+	for i, bound := range bounds {
+		if i >= len(buckets) {
+			break
+		}
+		cumulative += buckets[i]
+
+		le := map[string]string{model.BucketLabel: formatFloat(bound)}
+		if err := c.append(name+"_bucket", lm, le, ts, float64(cumulative)); err != nil {
+			return err
+		}
+	}
+
+	// The +Inf bucket always matches the total count.
+	le := map[string]string{model.BucketLabel: "+Inf"}
+	return c.append(name+"_bucket", lm, le, ts, float64(count))
+}
+
+// appendSummary appends a summary data point as a set of series with a
+// quantile label along with _sum and _count series.
+func (c *converter) appendSummary(name string, dp pdata.SummaryDataPoint) error {
+	c.received++
+
+	if err := c.append(name+"_sum", dp.LabelsMap(), nil, dp.Timestamp(), dp.Sum()); err != nil {
+		return err
+	}
+	if err := c.append(name+"_count", dp.LabelsMap(), nil, dp.Timestamp(), float64(dp.Count())); err != nil {
+		return err
+	}
+
+	qs := dp.QuantileValues()
+	for i := 0; i < qs.Len(); i++ {
+		q := qs.At(i)
+		quantile := map[string]string{model.QuantileLabel: formatFloat(q.Quantile())}
+		if err := c.append(name, dp.LabelsMap(), quantile, dp.Timestamp(), q.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// append appends a single sample. The labels for the sample are built from
+// the resource labels, the data point labels, and extra, in increasing order
+// of precedence.
+func (c *converter) append(name string, lm pdata.StringMap, extra map[string]string, ts pdata.Timestamp, v float64) error {
+	lbls := make(map[string]string, len(c.resourceLabels)+lm.Len()+len(extra)+1)
+	for ln, lv := range c.resourceLabels {
+		lbls[ln] = lv
+	}
+	lm.Range(func(ln, lv string) bool {
+		lbls[sanitizeLabelName(ln)] = lv
+		return true
+	})
+	for ln, lv := range extra {
+		lbls[ln] = lv
+	}
+	lbls[model.MetricNameLabel] = name
+
+	t := c.now
+	if ts != 0 {
+		t = timestamp.FromTime(ts.AsTime())
+	}
+
+	if _, err := c.app.Append(0, labels.FromMap(lbls), t, v); err != nil {
+		return err
+	}
+	c.appended++
+	return nil
+}
+
+// resourceLabels returns the job and instance labels for a resource, derived
+// from the service attributes of the resource. job and instance attributes
+// are used directly when service attributes aren't present.
+func resourceLabels(r pdata.Resource) map[string]string {
+	attrs := r.Attributes()
+	res := make(map[string]string, 2)
+
+	getString := func(key string) string {
+		v, ok := attrs.Get(key)
+		if !ok || v.Type() != pdata.AttributeValueTypeString {
+			return ""
+		}
+		return v.StringVal()
+	}
+
+	if job := getString(model.JobLabel); job != "" {
+		res[model.JobLabel] = job
+	}
+	if inst := getString(model.InstanceLabel); inst != "" {
+		res[model.InstanceLabel] = inst
+	}
+
+	if svc := getString(attrServiceName); svc != "" {
+		if ns := getString(attrServiceNamespace); ns != "" {
+			svc = ns + "/" + svc
+		}
+		res[model.JobLabel] = svc
+	}
+	if id := getString(attrServiceInstanceID); id != "" {
+		res[model.InstanceLabel] = id
+	}
+
+	return res
+}
+
+// sanitizeMetricName converts an OTLP metric name into a valid Prometheus
+// metric name.
+func sanitizeMetricName(s string) string {
+	return sanitize(s, true)
+}
+
+// sanitizeLabelName converts an OTLP attribute name into a valid Prometheus
+// label name.
+func sanitizeLabelName(s string) string {
+	return sanitize(s, false)
+}
+
+// sanitize replaces characters which aren't ASCII letters, digits or
+// underscores with underscores. Colons are kept if allowColon is true. Names
+// starting with a digit are prefixed with "key_".
+func sanitize(s string, allowColon bool) string {
+	if s == "" {
+		return s
+	}
+
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r == ':' && allowColon:
+			return r
+		}
+		return '_'
+	}, s)
+
+	if s[0] >= '0' && s[0] <= '9' {
+		s = "key_" + s
+	}
+	return s
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+}
@@ -0,0 +1,184 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestMetricsConsumer(t *testing.T) {
+	ts := time.Unix(100, 0)
+
+	md := pdata.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().InsertString("service.namespace", "demo")
+	rm.Resource().Attributes().InsertString("service.name", "app")
+	rm.Resource().Attributes().InsertString("service.instance.id", "host-1")
+	ms := rm.InstrumentationLibraryMetrics().AppendEmpty().Metrics()
+
+	gauge := ms.AppendEmpty()
+	gauge.SetName("temperature.celsius")
+	gauge.SetDataType(pdata.MetricDataTypeGauge)
+	gaugeDP := gauge.Gauge().DataPoints().AppendEmpty()
+	gaugeDP.LabelsMap().Insert("room.name", "kitchen")
+	gaugeDP.SetTimestamp(pdata.TimestampFromTime(ts))
+	gaugeDP.SetValue(21.5)
+
+	sum := ms.AppendEmpty()
+	sum.SetName("requests_total")
+	sum.SetDataType(pdata.MetricDataTypeIntSum)
+	sum.IntSum().SetAggregationTemporality(pdata.AggregationTemporalityCumulative)
+	sumDP := sum.IntSum().DataPoints().AppendEmpty()
+	sumDP.SetTimestamp(pdata.TimestampFromTime(ts))
+	sumDP.SetValue(10)
+
+	delta := ms.AppendEmpty()
+	delta.SetName("delta_total")
+	delta.SetDataType(pdata.MetricDataTypeSum)
+	delta.Sum().SetAggregationTemporality(pdata.AggregationTemporalityDelta)
+	delta.Sum().DataPoints().AppendEmpty().SetValue(5)
+
+	hist := ms.AppendEmpty()
+	hist.SetName("latency")
+	hist.SetDataType(pdata.MetricDataTypeHistogram)
+	hist.Histogram().SetAggregationTemporality(pdata.AggregationTemporalityCumulative)
+	histDP := hist.Histogram().DataPoints().AppendEmpty()
+	histDP.SetTimestamp(pdata.TimestampFromTime(ts))
+	histDP.SetCount(6)
+	histDP.SetSum(3.5)
+	histDP.SetExplicitBounds([]float64{0.5, 1})
+	histDP.SetBucketCounts([]uint64{2, 3, 1})
+
+	summary := ms.AppendEmpty()
+	summary.SetName("rpc_duration")
+	summary.SetDataType(pdata.MetricDataTypeSummary)
+	summaryDP := summary.Summary().DataPoints().AppendEmpty()
+	summaryDP.SetTimestamp(pdata.TimestampFromTime(ts))
+	summaryDP.SetCount(4)
+	summaryDP.SetSum(2)
+	q := summaryDP.QuantileValues().AppendEmpty()
+	q.SetQuantile(0.99)
+	q.SetValue(0.75)
+
+	inst := &mockInstance{}
+	c := &metricsConsumer{
+		logger:   log.NewNopLogger(),
+		manager:  mockManager(inst),
+		instance: "test",
+		metrics:  newReceiverMetrics(prometheus.NewRegistry()),
+	}
+	require.NoError(t, c.ConsumeMetrics(context.Background(), md))
+
+	tsMillis := ts.UnixNano() / int64(time.Millisecond)
+	resource := []string{"job", "demo/app", "instance", "host-1"}
+	series := func(name string, lbls ...string) string {
+		return labels.FromStrings(append(append([]string{"__name__", name}, resource...), lbls...)...).String()
+	}
+
+	expect := []appendedSample{
+		{series("temperature_celsius", "room_name", "kitchen"), tsMillis, 21.5},
+		{series("requests_total"), tsMillis, 10},
+		{series("latency_sum"), tsMillis, 3.5},
+		{series("latency_count"), tsMillis, 6},
+		{series("latency_bucket", "le", "0.5"), tsMillis, 2},
+		{series("latency_bucket", "le", "1"), tsMillis, 5},
+		{series("latency_bucket", "le", "+Inf"), tsMillis, 6},
+		{series("rpc_duration_sum"), tsMillis, 2},
+		{series("rpc_duration_count"), tsMillis, 4},
+		{series("rpc_duration", "quantile", "0.99"), tsMillis, 0.75},
+	}
+	sortSamples(expect)
+	sortSamples(inst.committed)
+	require.Equal(t, expect, inst.committed)
+}
+
+func TestMetricsConsumer_MissingInstance(t *testing.T) {
+	c := &metricsConsumer{
+		logger:   log.NewNopLogger(),
+		manager:  mockManager(&mockInstance{}),
+		instance: "missing",
+		metrics:  newReceiverMetrics(prometheus.NewRegistry()),
+	}
+	require.Error(t, c.ConsumeMetrics(context.Background(), pdata.NewMetrics()))
+}
+
+func TestSanitize(t *testing.T) {
+	tt := []struct{ in, metric, label string }{
+		{"http.server.duration", "http_server_duration", "http_server_duration"},
+		{"already_valid:name", "already_valid:name", "already_valid_name"},
+		{"0day", "key_0day", "key_0day"},
+		{"größe_µs", "gr__e__s", "gr__e__s"},
+		{"١٢metric", "__metric", "__metric"},
+		{"", "", ""},
+	}
+	for _, tc := range tt {
+		require.Equal(t, tc.metric, sanitizeMetricName(tc.in), tc.in)
+		require.Equal(t, tc.label, sanitizeLabelName(tc.in), tc.in)
+	}
+}
+
+func mockManager(inst *mockInstance) instance.Manager {
+	return &instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "test" {
+				return nil, fmt.Errorf("instance %s does not exist", name)
+			}
+			return inst, nil
+		},
+	}
+}
+
+type appendedSample struct {
+	series string
+	t      int64
+	v      float64
+}
+
+func sortSamples(ss []appendedSample) {
+	sort.Slice(ss, func(i, j int) bool { return ss[i].series < ss[j].series })
+}
+
+type mockInstance struct {
+	instance.NoOpInstance
+	committed []appendedSample
+}
+
+func (i *mockInstance) Appender(_ context.Context) storage.Appender {
+	return &mockAppender{inst: i}
+}
+
+type mockAppender struct {
+	inst    *mockInstance
+	pending []appendedSample
+}
+
+func (a *mockAppender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	a.pending = append(a.pending, appendedSample{series: l.String(), t: t, v: v})
+	return 0, nil
+}
+
+func (a *mockAppender) AppendExemplar(ref uint64, l labels.Labels, e exemplar.Exemplar) (uint64, error) {
+	return 0, nil
+}
+
+func (a *mockAppender) Commit() error {
+	a.inst.committed = append(a.inst.committed, a.pending...)
+	a.pending = nil
+	return nil
+}
+
+func (a *mockAppender) Rollback() error {
+	a.pending = nil
+	return nil
+}
@@ -0,0 +1,161 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/agent/pkg/build"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/config/configparser"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	protocolGRPC = "grpc"
+	protocolHTTP = "http"
+)
+
+// Receiver runs an OTLP receiver for metrics. Received metrics are converted
+// into Prometheus series and appended to a metrics instance.
+type Receiver struct {
+	logger  log.Logger
+	manager instance.Manager
+	metrics *receiverMetrics
+
+	mut      sync.Mutex
+	cfg      *Config
+	receiver component.MetricsReceiver
+}
+
+// New creates a new Receiver. The Receiver will not receive metrics until
+// ApplyConfig is called with a non-nil Config.
+func New(l log.Logger, reg prometheus.Registerer, manager instance.Manager) *Receiver {
+	return &Receiver{
+		logger:  log.With(l, "component", "otlp receiver"),
+		manager: manager,
+		metrics: newReceiverMetrics(reg),
+	}
+}
+
+// ApplyConfig updates the Receiver with a new Config. If the Config changed,
+// the running OTLP receiver will be restarted. A nil Config stops the
+// Receiver.
+func (r *Receiver) ApplyConfig(cfg *Config) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if util.CompareYAML(r.cfg, cfg) {
+		return nil
+	}
+
+	r.stop()
+	r.cfg = cfg
+
+	if cfg == nil {
+		return nil
+	}
+	if err := r.start(*cfg); err != nil {
+		r.cfg = nil
+		return fmt.Errorf("failed to start otlp receiver: %w", err)
+	}
+	return nil
+}
+
+func (r *Receiver) start(cfg Config) error {
+	factory := otlpreceiver.NewFactory()
+
+	otelCfg := factory.CreateDefaultConfig()
+	parser := configparser.NewParserFromStringMap(map[string]interface{}{
+		"protocols": cfg.Protocols,
+	})
+	if err := otelCfg.(config.CustomUnmarshable).Unmarshal(parser); err != nil {
+		return fmt.Errorf("invalid protocols: %w", err)
+	}
+	if err := otelCfg.Validate(); err != nil {
+		return err
+	}
+
+	consumer := &metricsConsumer{
+		logger:   r.logger,
+		manager:  r.manager,
+		instance: cfg.Instance,
+		metrics:  r.metrics,
+	}
+
+	settings := component.ReceiverCreateSettings{
+		// Logs from the OTLP receiver are discarded. Errors which prevent the
+		// receiver from running are reported through ReportFatalError.
+		Logger:         zap.NewNop(),
+		TracerProvider: trace.NewNoopTracerProvider(),
+		BuildInfo: component.BuildInfo{
+			Command:     "agent",
+			Description: "agent",
+			Version:     build.Version,
+		},
+	}
+
+	recv, err := factory.CreateMetricsReceiver(context.Background(), settings, otelCfg, consumer)
+	if err != nil {
+		return err
+	}
+	if err := recv.Start(context.Background(), r); err != nil {
+		return err
+	}
+
+	level.Info(r.logger).Log("msg", "otlp receiver started", "instance", cfg.Instance)
+	r.receiver = recv
+	return nil
+}
+
+// Stop stops the Receiver.
+func (r *Receiver) Stop() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.stop()
+	r.cfg = nil
+}
+
+func (r *Receiver) stop() {
+	if r.receiver == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := r.receiver.Shutdown(ctx); err != nil {
+		level.Error(r.logger).Log("msg", "failed to shut down otlp receiver", "err", err)
+	}
+	r.receiver = nil
+}
+
+// ReportFatalError implements component.Host.
+func (r *Receiver) ReportFatalError(err error) {
+	level.Error(r.logger).Log("msg", "fatal error reported by otlp receiver", "err", err)
+}
+
+// GetFactory implements component.Host.
+func (r *Receiver) GetFactory(component.Kind, config.Type) component.Factory {
+	return nil
+}
+
+// GetExtensions implements component.Host.
+func (r *Receiver) GetExtensions() map[config.ComponentID]component.Extension {
+	return nil
+}
+
+// GetExporters implements component.Host.
+func (r *Receiver) GetExporters() map[config.DataType]map[config.ComponentID]component.Exporter {
+	return nil
+}
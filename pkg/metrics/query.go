@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// Default settings for the PromQL engine used to query instances.
+const (
+	queryMaxSamples    = 50000000
+	queryTimeout       = 2 * time.Minute
+	queryLookbackDelta = 5 * time.Minute
+
+	// querySubqueryStep is the step used for subqueries which don't specify
+	// one. It matches the default Prometheus evaluation_interval.
+	querySubqueryStep = time.Minute
+
+	// maxQueryPoints is the maximum number of points per series a range query
+	// may return, mirroring the limit imposed by Prometheus.
+	maxQueryPoints = 11000
+)
+
+// Error types returned by the query API. These match the error types used by
+// the Prometheus HTTP API.
+const (
+	queryErrorBadData  = "bad_data"
+	queryErrorNotFound = "not_found"
+	queryErrorExec     = "execution"
+	queryErrorCanceled = "canceled"
+	queryErrorTimeout  = "timeout"
+	queryErrorInternal = "internal"
+)
+
+func newQueryEngine(l log.Logger) *promql.Engine {
+	return promql.NewEngine(promql.EngineOpts{
+		Logger:        log.With(l, "component", "query engine"),
+		MaxSamples:    queryMaxSamples,
+		Timeout:       queryTimeout,
+		LookbackDelta: queryLookbackDelta,
+		NoStepSubqueryIntervalFn: func(int64) int64 {
+			return querySubqueryStep.Milliseconds()
+		},
+	})
+}
+
+// queryResult is the data returned by a successful query. It matches the
+// format used by the Prometheus HTTP API.
+type queryResult struct {
+	ResultType parser.ValueType `json:"resultType"`
+	Result     parser.Value     `json:"result"`
+}
+
+// queryResponse is the envelope for query API responses. Unlike the rest of
+// the Agent API, it uses the response format from the Prometheus HTTP API so
+// existing Prometheus clients can query an instance.
+type queryResponse struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// InstantQueryHandler evaluates a PromQL expression at a single point in time
+// against the recent samples held in memory by an instance. It implements the
+// same parameters as the Prometheus /api/v1/query endpoint.
+func (a *Agent) InstantQueryHandler(w http.ResponseWriter, r *http.Request) {
+	ts := time.Now()
+	if t := r.FormValue("time"); t != "" {
+		var err error
+		ts, err = parseQueryTime(t)
+		if err != nil {
+			a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, fmt.Errorf("invalid parameter \"time\": %w", err))
+			return
+		}
+	}
+
+	a.runQuery(w, r, func(q storage.Queryable) (promql.Query, error) {
+		return a.queryEngine.NewInstantQuery(q, r.FormValue("query"), ts)
+	})
+}
+
+// RangeQueryHandler evaluates a PromQL expression over a range of time
+// against the recent samples held in memory by an instance. It implements the
+// same parameters as the Prometheus /api/v1/query_range endpoint.
+func (a *Agent) RangeQueryHandler(w http.ResponseWriter, r *http.Request) {
+	start, err := parseQueryTime(r.FormValue("start"))
+	if err != nil {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, fmt.Errorf("invalid parameter \"start\": %w", err))
+		return
+	}
+	end, err := parseQueryTime(r.FormValue("end"))
+	if err != nil {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, fmt.Errorf("invalid parameter \"end\": %w", err))
+		return
+	}
+	if end.Before(start) {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, errors.New("end timestamp must not be before start time"))
+		return
+	}
+
+	step, err := parseQueryDuration(r.FormValue("step"))
+	if err != nil {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, fmt.Errorf("invalid parameter \"step\": %w", err))
+		return
+	}
+	if step <= 0 {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, errors.New("zero or negative query resolution step widths are not accepted. Try a positive integer"))
+		return
+	}
+	if end.Sub(start)/step > maxQueryPoints {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, errors.New("exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)"))
+		return
+	}
+
+	a.runQuery(w, r, func(q storage.Queryable) (promql.Query, error) {
+		return a.queryEngine.NewRangeQuery(q, r.FormValue("query"), start, end, step)
+	})
+}
+
+// runQuery finds the instance named in the request, creates a query with
+// newQuery, and writes the result of executing it to w.
+func (a *Agent) runQuery(w http.ResponseWriter, r *http.Request, newQuery func(storage.Queryable) (promql.Query, error)) {
+	instanceName := mux.Vars(r)["instance"]
+
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		a.writeQueryError(w, http.StatusNotFound, queryErrorNotFound, err)
+		return
+	}
+
+	ctx := r.Context()
+	if to := r.FormValue("timeout"); to != "" {
+		timeout, err := parseQueryDuration(to)
+		if err != nil {
+			a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, fmt.Errorf("invalid parameter \"timeout\": %w", err))
+			return
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	qry, err := newQuery(storage.QueryableFunc(inst.Querier))
+	if err != nil {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, err)
+		return
+	}
+	defer qry.Close()
+
+	res := qry.Exec(ctx)
+	if res.Err != nil {
+		switch res.Err.(type) {
+		case promql.ErrQueryCanceled:
+			a.writeQueryError(w, http.StatusServiceUnavailable, queryErrorCanceled, res.Err)
+		case promql.ErrQueryTimeout:
+			a.writeQueryError(w, http.StatusServiceUnavailable, queryErrorTimeout, res.Err)
+		case promql.ErrStorage:
+			a.writeQueryError(w, http.StatusInternalServerError, queryErrorInternal, res.Err)
+		default:
+			a.writeQueryError(w, http.StatusUnprocessableEntity, queryErrorExec, res.Err)
+		}
+		return
+	}
+
+	a.writeQueryResponse(w, http.StatusOK, queryResponse{
+		Status: "success",
+		Data: queryResult{
+			ResultType: res.Value.Type(),
+			Result:     res.Value,
+		},
+	})
+}
+
+func (a *Agent) writeQueryError(w http.ResponseWriter, statusCode int, errorType string, err error) {
+	a.writeQueryResponse(w, statusCode, queryResponse{
+		Status:    "error",
+		ErrorType: errorType,
+		Error:     err.Error(),
+	})
+}
+
+func (a *Agent) writeQueryResponse(w http.ResponseWriter, statusCode int, resp queryResponse) {
+	bb, err := json.Marshal(resp)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to marshal query response", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(bb); err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// parseQueryTime parses a time as either a Unix timestamp with optional
+// decimal precision or an RFC3339 string.
+func parseQueryTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		s, ns := math.Modf(t)
+		ns = math.Round(ns*1000) / 1000
+		return time.Unix(int64(s), int64(ns*float64(time.Second))).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseQueryDuration parses a duration as either a number of seconds with
+// optional decimal precision or a Prometheus duration string.
+func parseQueryDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration. It overflows int64", s)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
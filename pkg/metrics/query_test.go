@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/wal"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_QueryHandlers(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := wal.NewStorageWithOptions(log.NewNopLogger(), nil, walDir, wal.Options{
+		QueryRetention: time.Hour,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Samples must be within the query retention to be queried.
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute).Unix()
+
+	app := s.Appender(context.Background())
+	lbls := labels.FromStrings("__name__", "foo", "job", "a")
+	for i := 0; i < 5; i++ {
+		ts := (time.Duration(start)*time.Second + 15*time.Second*time.Duration(i)).Milliseconds()
+		_, err := app.Append(0, lbls, ts, float64(i))
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	mockManager := &instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "test_instance" {
+				return nil, fmt.Errorf("instance %s does not exist", name)
+			}
+			return &mockInstanceQuery{q: s}, nil
+		},
+		ApplyConfigFunc: func(_ instance.Config) error { return nil },
+		StopFunc:        func() {},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	t.Run("instant query", func(t *testing.T) {
+		r := httptest.NewRequest("GET", fmt.Sprintf("/agent/api/v1/instances/test_instance/api/v1/query?query=foo&time=%d", start+60), nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.InstantQueryHandler(rr, r)
+		expect := fmt.Sprintf(`{
+			"status": "success",
+			"data": {
+				"resultType": "vector",
+				"result": [{
+					"metric": {"__name__": "foo", "job": "a"},
+					"value": [%d, "4"]
+				}]
+			}
+		}`, start+60)
+		require.JSONEq(t, expect, rr.Body.String())
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	})
+
+	t.Run("range query", func(t *testing.T) {
+		r := httptest.NewRequest("GET", fmt.Sprintf("/agent/api/v1/instances/test_instance/api/v1/query_range?query=foo&start=%d&end=%d&step=30", start, start+60), nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.RangeQueryHandler(rr, r)
+		expect := fmt.Sprintf(`{
+			"status": "success",
+			"data": {
+				"resultType": "matrix",
+				"result": [{
+					"metric": {"__name__": "foo", "job": "a"},
+					"values": [[%d, "0"], [%d, "2"], [%d, "4"]]
+				}]
+			}
+		}`, start, start+30, start+60)
+		require.JSONEq(t, expect, rr.Body.String())
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/test_instance/api/v1/query?query=foo{", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.InstantQueryHandler(rr, r)
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+		require.Contains(t, rr.Body.String(), `"errorType":"bad_data"`)
+	})
+
+	t.Run("unknown instance", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/missing/api/v1/query?query=foo", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "missing"})
+
+		rr := httptest.NewRecorder()
+		a.InstantQueryHandler(rr, r)
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+		require.Contains(t, rr.Body.String(), `"errorType":"not_found"`)
+	})
+}
+
+type mockInstanceQuery struct {
+	mockInstanceScrape
+	q storage.Queryable
+}
+
+func (i *mockInstanceQuery) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	return i.q.Querier(ctx, mint, maxt)
+}
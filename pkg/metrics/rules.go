@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/rules"
+)
+
+// alertsResult is the data returned by the alerts API. It matches the format
+// used by the Prometheus HTTP API.
+type alertsResult struct {
+	Alerts []*alertResponse `json:"alerts"`
+}
+
+type alertResponse struct {
+	Labels      labels.Labels `json:"labels"`
+	Annotations labels.Labels `json:"annotations"`
+	State       string        `json:"state"`
+	ActiveAt    *time.Time    `json:"activeAt,omitempty"`
+	Value       string        `json:"value"`
+}
+
+// rulesResult is the data returned by the rules API. It matches the format
+// used by the Prometheus HTTP API.
+type rulesResult struct {
+	RuleGroups []*ruleGroupResponse `json:"groups"`
+}
+
+type ruleGroupResponse struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	// Rules holds alertingRuleResponses and recordingRuleResponses.
+	Rules          []interface{} `json:"rules"`
+	Interval       float64       `json:"interval"`
+	EvaluationTime float64       `json:"evaluationTime"`
+	LastEvaluation time.Time     `json:"lastEvaluation"`
+}
+
+type alertingRuleResponse struct {
+	State          string           `json:"state"`
+	Name           string           `json:"name"`
+	Query          string           `json:"query"`
+	Duration       float64          `json:"duration"`
+	Labels         labels.Labels    `json:"labels"`
+	Annotations    labels.Labels    `json:"annotations"`
+	Alerts         []*alertResponse `json:"alerts"`
+	Health         rules.RuleHealth `json:"health"`
+	LastError      string           `json:"lastError,omitempty"`
+	EvaluationTime float64          `json:"evaluationTime"`
+	LastEvaluation time.Time        `json:"lastEvaluation"`
+	Type           string           `json:"type"`
+}
+
+type recordingRuleResponse struct {
+	Name           string           `json:"name"`
+	Query          string           `json:"query"`
+	Labels         labels.Labels    `json:"labels,omitempty"`
+	Health         rules.RuleHealth `json:"health"`
+	LastError      string           `json:"lastError,omitempty"`
+	EvaluationTime float64          `json:"evaluationTime"`
+	LastEvaluation time.Time        `json:"lastEvaluation"`
+	Type           string           `json:"type"`
+}
+
+// AlertsHandler writes the active alerts from the alerting rules of an
+// instance. It implements the Prometheus /api/v1/alerts endpoint.
+func (a *Agent) AlertsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, ok := a.instanceRuleGroups(w, r)
+	if !ok {
+		return
+	}
+
+	res := alertsResult{Alerts: []*alertResponse{}}
+	for _, g := range groups {
+		for _, rule := range g.AlertingRules() {
+			res.Alerts = append(res.Alerts, alertResponses(rule.ActiveAlerts())...)
+		}
+	}
+
+	a.writeQueryResponse(w, http.StatusOK, queryResponse{Status: "success", Data: res})
+}
+
+// RulesHandler writes the rule groups evaluated by an instance along with the
+// state of their rules. It implements the Prometheus /api/v1/rules endpoint,
+// including the type parameter to filter by alerting or recording rules.
+func (a *Agent) RulesHandler(w http.ResponseWriter, r *http.Request) {
+	typ := r.FormValue("type")
+	if typ != "" && typ != "alert" && typ != "record" {
+		a.writeQueryError(w, http.StatusBadRequest, queryErrorBadData, fmt.Errorf("invalid parameter \"type\": %q", typ))
+		return
+	}
+	returnAlerts := typ == "" || typ == "alert"
+	returnRecording := typ == "" || typ == "record"
+
+	groups, ok := a.instanceRuleGroups(w, r)
+	if !ok {
+		return
+	}
+
+	res := rulesResult{RuleGroups: make([]*ruleGroupResponse, 0, len(groups))}
+	for _, g := range groups {
+		grp := &ruleGroupResponse{
+			Name:           g.Name(),
+			File:           g.File(),
+			Rules:          []interface{}{},
+			Interval:       g.Interval().Seconds(),
+			EvaluationTime: g.GetEvaluationTime().Seconds(),
+			LastEvaluation: g.GetLastEvaluation(),
+		}
+
+		for _, rule := range g.Rules() {
+			var lastError string
+			if err := rule.LastError(); err != nil {
+				lastError = err.Error()
+			}
+
+			switch rule := rule.(type) {
+			case *rules.AlertingRule:
+				if !returnAlerts {
+					continue
+				}
+				grp.Rules = append(grp.Rules, alertingRuleResponse{
+					State:          rule.State().String(),
+					Name:           rule.Name(),
+					Query:          rule.Query().String(),
+					Duration:       rule.HoldDuration().Seconds(),
+					Labels:         rule.Labels(),
+					Annotations:    rule.Annotations(),
+					Alerts:         alertResponses(rule.ActiveAlerts()),
+					Health:         rule.Health(),
+					LastError:      lastError,
+					EvaluationTime: rule.GetEvaluationDuration().Seconds(),
+					LastEvaluation: rule.GetEvaluationTimestamp(),
+					Type:           "alerting",
+				})
+			case *rules.RecordingRule:
+				if !returnRecording {
+					continue
+				}
+				grp.Rules = append(grp.Rules, recordingRuleResponse{
+					Name:           rule.Name(),
+					Query:          rule.Query().String(),
+					Labels:         rule.Labels(),
+					Health:         rule.Health(),
+					LastError:      lastError,
+					EvaluationTime: rule.GetEvaluationDuration().Seconds(),
+					LastEvaluation: rule.GetEvaluationTimestamp(),
+					Type:           "recording",
+				})
+			}
+		}
+
+		if len(grp.Rules) > 0 || typ == "" {
+			res.RuleGroups = append(res.RuleGroups, grp)
+		}
+	}
+
+	a.writeQueryResponse(w, http.StatusOK, queryResponse{Status: "success", Data: res})
+}
+
+// instanceRuleGroups returns the rule groups of the instance named in the
+// request. If the instance doesn't exist, an error is written to w and false
+// is returned.
+func (a *Agent) instanceRuleGroups(w http.ResponseWriter, r *http.Request) ([]*rules.Group, bool) {
+	instanceName := mux.Vars(r)["instance"]
+
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		a.writeQueryError(w, http.StatusNotFound, queryErrorNotFound, err)
+		return nil, false
+	}
+	return inst.RuleGroups(), true
+}
+
+func alertResponses(alerts []*rules.Alert) []*alertResponse {
+	res := make([]*alertResponse, 0, len(alerts))
+	for _, alert := range alerts {
+		activeAt := alert.ActiveAt
+		res = append(res, &alertResponse{
+			Labels:      alert.Labels,
+			Annotations: alert.Annotations,
+			State:       alert.State.String(),
+			ActiveAt:    &activeAt,
+			Value:       strconv.FormatFloat(alert.Value, 'e', -1, 64),
+		})
+	}
+	return res
+}
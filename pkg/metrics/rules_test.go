@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/wal"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_RulesHandlers(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := wal.NewStorageWithOptions(log.NewNopLogger(), nil, walDir, wal.Options{
+		QueryRetention: time.Hour,
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	alertExpr, err := parser.ParseExpr("foo > 1")
+	require.NoError(t, err)
+	recordExpr, err := parser.ParseExpr("sum(foo)")
+	require.NoError(t, err)
+
+	group := rules.NewGroup(rules.GroupOptions{
+		Name:     "test",
+		File:     "<inline>",
+		Interval: time.Minute,
+		Rules: []rules.Rule{
+			rules.NewAlertingRule("HighFoo", alertExpr, 0, labels.FromStrings("severity", "page"), nil, nil, true, log.NewNopLogger()),
+			rules.NewRecordingRule("job:foo:sum", recordExpr, nil),
+		},
+		Opts: &rules.ManagerOptions{
+			ExternalURL: &url.URL{},
+			QueryFunc: func(_ context.Context, _ string, ts time.Time) (promql.Vector, error) {
+				return promql.Vector{{
+					Metric: labels.FromStrings("__name__", "foo", "job", "a"),
+					Point:  promql.Point{T: ts.UnixNano() / int64(time.Millisecond), V: 5},
+				}}, nil
+			},
+			NotifyFunc: func(context.Context, string, ...*rules.Alert) {},
+			Context:    context.Background(),
+			Appendable: s,
+			Queryable:  s,
+			Logger:     log.NewNopLogger(),
+		},
+	})
+	group.Eval(context.Background(), time.Unix(60, 0).UTC())
+
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	mockManager := &instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "test_instance" {
+				return nil, fmt.Errorf("instance %s does not exist", name)
+			}
+			return &mockInstanceRules{groups: []*rules.Group{group}}, nil
+		},
+		ApplyConfigFunc: func(_ instance.Config) error { return nil },
+		StopFunc:        func() {},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	t.Run("alerts", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/test_instance/api/v1/alerts", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.AlertsHandler(rr, r)
+		expect := `{
+			"status": "success",
+			"data": {
+				"alerts": [{
+					"labels": {"alertname": "HighFoo", "job": "a", "severity": "page"},
+					"annotations": {},
+					"state": "firing",
+					"activeAt": "1970-01-01T00:01:00Z",
+					"value": "5e+00"
+				}]
+			}
+		}`
+		require.JSONEq(t, expect, rr.Body.String())
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/test_instance/api/v1/rules", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.RulesHandler(rr, r)
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		resp := decodeRulesResponse(t, rr.Body.Bytes())
+		require.Len(t, resp.Data.Groups, 1)
+		require.Equal(t, "test", resp.Data.Groups[0].Name)
+		require.Equal(t, 60.0, resp.Data.Groups[0].Interval)
+
+		grpRules := resp.Data.Groups[0].Rules
+		require.Len(t, grpRules, 2)
+		require.Equal(t, "HighFoo", grpRules[0].Name)
+		require.Equal(t, "alerting", grpRules[0].Type)
+		require.Equal(t, "firing", grpRules[0].State)
+		require.Len(t, grpRules[0].Alerts, 1)
+		require.Equal(t, "job:foo:sum", grpRules[1].Name)
+		require.Equal(t, "recording", grpRules[1].Type)
+		require.Equal(t, "ok", grpRules[1].Health)
+	})
+
+	t.Run("rules filtered by type", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/test_instance/api/v1/rules?type=record", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.RulesHandler(rr, r)
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		resp := decodeRulesResponse(t, rr.Body.Bytes())
+		require.Len(t, resp.Data.Groups, 1)
+		require.Len(t, resp.Data.Groups[0].Rules, 1)
+		require.Equal(t, "recording", resp.Data.Groups[0].Rules[0].Type)
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/test_instance/api/v1/rules?type=foo", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.RulesHandler(rr, r)
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+		require.Contains(t, rr.Body.String(), `"errorType":"bad_data"`)
+	})
+
+	t.Run("unknown instance", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/missing/api/v1/alerts", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "missing"})
+
+		rr := httptest.NewRecorder()
+		a.AlertsHandler(rr, r)
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+		require.Contains(t, rr.Body.String(), `"errorType":"not_found"`)
+	})
+}
+
+type rulesTestResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Groups []struct {
+			Name     string  `json:"name"`
+			Interval float64 `json:"interval"`
+			Rules    []struct {
+				Name   string            `json:"name"`
+				Type   string            `json:"type"`
+				State  string            `json:"state"`
+				Health string            `json:"health"`
+				Alerts []json.RawMessage `json:"alerts"`
+			} `json:"rules"`
+		} `json:"groups"`
+	} `json:"data"`
+}
+
+func decodeRulesResponse(t *testing.T, bb []byte) rulesTestResponse {
+	t.Helper()
+
+	var resp rulesTestResponse
+	require.NoError(t, json.Unmarshal(bb, &resp))
+	require.Equal(t, "success", resp.Status)
+	return resp
+}
+
+type mockInstanceRules struct {
+	mockInstanceScrape
+	groups []*rules.Group
+}
+
+func (i *mockInstanceRules) RuleGroups() []*rules.Group { return i.groups }
@@ -0,0 +1,389 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/build"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/textparse"
+	"github.com/prometheus/prometheus/scrape"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// scrapeDebugAcceptHeader matches the Accept header sent by Prometheus
+	// scrapes.
+	scrapeDebugAcceptHeader = `application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1`
+
+	// scrapeDebugBodyLimit is the maximum size of a scrape body returned by
+	// the scrape debug API.
+	scrapeDebugBodyLimit = 16 << 20
+)
+
+var scrapeDebugUserAgent = fmt.Sprintf("GrafanaAgent/%s", build.Version)
+
+// ScrapeDebugResponse is returned by the ScrapeDebugHandler.
+type ScrapeDebugResponse struct {
+	Target ScrapeDebugTarget `json:"target"`
+	// Scrape is nil when the target was dropped by relabel_configs.
+	Scrape *ScrapeDebugScrape   `json:"scrape"`
+	Series []*ScrapeDebugSeries `json:"series"`
+}
+
+// ScrapeDebugTarget describes how the labels of a target were built.
+type ScrapeDebugTarget struct {
+	// DiscoveredLabels are the labels of the target before relabel_configs
+	// are applied.
+	DiscoveredLabels labels.Labels `json:"discovered_labels"`
+	// RelabelSteps holds the labels of the target after each rule from
+	// relabel_configs.
+	RelabelSteps []RelabelStep `json:"relabel_steps"`
+	// Labels are the labels added to series from the target. Nil if the
+	// target was dropped.
+	Labels labels.Labels `json:"labels"`
+	URL    string        `json:"url,omitempty"`
+}
+
+// ScrapeDebugScrape describes the result of scraping a target.
+type ScrapeDebugScrape struct {
+	StatusCode     int    `json:"status_code,omitempty"`
+	ContentType    string `json:"content_type,omitempty"`
+	ScrapeDuration int64  `json:"scrape_duration_ms"`
+	Body           string `json:"body"`
+	Error          string `json:"error,omitempty"`
+}
+
+// ScrapeDebugSeries describes how the labels of a scraped series were built.
+type ScrapeDebugSeries struct {
+	// Scraped are the labels exposed by the target.
+	Scraped labels.Labels `json:"scraped"`
+	// Labels are the labels after the target labels were added, before
+	// metric_relabel_configs are applied.
+	Labels labels.Labels `json:"labels"`
+	// MetricRelabelSteps holds the labels of the series after each rule from
+	// metric_relabel_configs.
+	MetricRelabelSteps []RelabelStep `json:"metric_relabel_steps"`
+	// Final are the labels of the series which would be appended. Nil if the
+	// series was dropped.
+	Final     labels.Labels `json:"final"`
+	Value     string        `json:"value"`
+	Timestamp *int64        `json:"timestamp,omitempty"`
+}
+
+// RelabelStep is the result of applying a single relabel rule.
+type RelabelStep struct {
+	// Rule is the relabel rule in YAML.
+	Rule string `json:"rule"`
+	// Labels after applying the rule. Nil if the rule dropped the labels.
+	Labels labels.Labels `json:"labels"`
+}
+
+// ScrapeDebugHandler scrapes a single target of an instance on demand and
+// returns the scrape body along with every relabeling step applied to the
+// target and its series. The target is scraped with the live scrape config of
+// the instance, but nothing is written to the WAL.
+//
+// Only targets which have been discovered by the job can be scraped. Other
+// addresses are rejected so the endpoint can't be used to send requests,
+// along with the credentials of the job, to arbitrary hosts.
+func (a *Agent) ScrapeDebugHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName := mux.Vars(r)["instance"]
+	job, address := r.FormValue("job"), r.FormValue("target")
+	if job == "" || address == "" {
+		a.writeTargetsError(w, http.StatusBadRequest, errors.New("job and target parameters must be provided"))
+		return
+	}
+
+	cfg, ok := a.mm.ListConfigs()[instanceName]
+	if !ok {
+		a.writeTargetsError(w, http.StatusNotFound, fmt.Errorf("instance %s does not exist", instanceName))
+		return
+	}
+	var sc *config.ScrapeConfig
+	for _, c := range cfg.ScrapeConfigs {
+		if c.JobName == job {
+			sc = c
+			break
+		}
+	}
+	if sc == nil {
+		a.writeTargetsError(w, http.StatusNotFound, fmt.Errorf("job %s does not exist in instance %s", job, instanceName))
+		return
+	}
+
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		a.writeTargetsError(w, http.StatusNotFound, fmt.Errorf("instance %s is not running", instanceName))
+		return
+	}
+	discovered := findDiscoveredLabels(inst.TargetsActive()[job], inst.TargetsDropped()[job], address)
+	if discovered == nil {
+		a.writeTargetsError(w, http.StatusNotFound, fmt.Errorf("target %s was not discovered by job %s", address, job))
+		return
+	}
+
+	resp := ScrapeDebugResponse{
+		Target: ScrapeDebugTarget{DiscoveredLabels: discovered},
+		Series: []*ScrapeDebugSeries{},
+	}
+
+	var relabeled labels.Labels
+	resp.Target.RelabelSteps, relabeled = traceRelabel(discovered, sc.RelabelConfigs)
+	if relabeled == nil {
+		a.writeScrapeDebugResponse(w, resp)
+		return
+	}
+
+	targetLabels, err := finalizeTargetLabels(relabeled)
+	if err != nil {
+		a.writeTargetsError(w, http.StatusBadRequest, fmt.Errorf("invalid target after relabeling: %w", err))
+		return
+	}
+	tgt := scrape.NewTarget(targetLabels, discovered, sc.Params)
+	resp.Target.Labels = tgt.Labels()
+	resp.Target.URL = tgt.URL().String()
+
+	body, contentType, res := scrapeTarget(r.Context(), sc, tgt)
+	resp.Scrape = res
+	if res.Error != "" {
+		a.writeScrapeDebugResponse(w, resp)
+		return
+	}
+
+	resp.Series, err = traceSeries(body, contentType, sc, tgt)
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to parse scrape body: %s", err)
+	}
+	a.writeScrapeDebugResponse(w, resp)
+}
+
+func (a *Agent) writeScrapeDebugResponse(w http.ResponseWriter, resp ScrapeDebugResponse) {
+	if err := configapi.WriteResponse(w, http.StatusOK, resp); err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// findDiscoveredLabels returns the discovered labels of the target matching
+// address. Active targets match by their address, instance label, or the
+// host of their URL. Returns nil if there is no matching target.
+func findDiscoveredLabels(active, dropped []*scrape.Target, address string) labels.Labels {
+	for _, tgt := range active {
+		if tgt.DiscoveredLabels().Get(model.AddressLabel) == address ||
+			tgt.Labels().Get(model.InstanceLabel) == address ||
+			tgt.URL().Host == address {
+			return tgt.DiscoveredLabels()
+		}
+	}
+	for _, tgt := range dropped {
+		if tgt.DiscoveredLabels().Get(model.AddressLabel) == address {
+			return tgt.DiscoveredLabels()
+		}
+	}
+	return nil
+}
+
+// finalizeTargetLabels builds the labels of a target from its labels after
+// relabeling, as is done by Prometheus: a default port is added to the
+// address, meta labels are removed, and the instance label defaults to the
+// address.
+func finalizeTargetLabels(lset labels.Labels) (labels.Labels, error) {
+	addr := lset.Get(model.AddressLabel)
+	if addr == "" {
+		return nil, errors.New("no address")
+	}
+
+	lb := labels.NewBuilder(lset)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if _, _, err := net.SplitHostPort(addr + ":1234"); err == nil {
+			switch lset.Get(model.SchemeLabel) {
+			case "http", "":
+				addr += ":80"
+			case "https":
+				addr += ":443"
+			default:
+				return nil, fmt.Errorf("invalid scheme: %q", lset.Get(model.SchemeLabel))
+			}
+			lb.Set(model.AddressLabel, addr)
+		}
+	}
+
+	for _, l := range lset {
+		if strings.HasPrefix(l.Name, model.MetaLabelPrefix) {
+			lb.Del(l.Name)
+		}
+	}
+	if lset.Get(model.InstanceLabel) == "" {
+		lb.Set(model.InstanceLabel, addr)
+	}
+	return lb.Labels(), nil
+}
+
+// scrapeTarget scrapes tgt using the HTTP client settings from sc. The
+// returned ScrapeDebugScrape has its Error set if the scrape failed.
+func scrapeTarget(ctx context.Context, sc *config.ScrapeConfig, tgt *scrape.Target) ([]byte, string, *ScrapeDebugScrape) {
+	res := &ScrapeDebugScrape{}
+
+	client, err := config_util.NewClientFromConfig(sc.HTTPClientConfig, sc.JobName, config_util.WithHTTP2Disabled())
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to create HTTP client: %s", err)
+		return nil, "", res
+	}
+
+	timeout := time.Duration(sc.ScrapeTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", tgt.URL().String(), nil)
+	if err != nil {
+		res.Error = err.Error()
+		return nil, "", res
+	}
+	req.Header.Add("Accept", scrapeDebugAcceptHeader)
+	req.Header.Add("Accept-Encoding", "gzip")
+	req.Header.Set("User-Agent", scrapeDebugUserAgent)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", fmt.Sprintf("%f", timeout.Seconds()))
+
+	start := time.Now()
+	defer func() { res.ScrapeDuration = time.Since(start).Milliseconds() }()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+		return nil, "", res
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	res.StatusCode = resp.StatusCode
+	res.ContentType = resp.Header.Get("Content-Type")
+
+	var bodyReader io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			res.Error = fmt.Sprintf("failed to decompress scrape body: %s", err)
+			return nil, "", res
+		}
+		defer gzr.Close()
+		bodyReader = gzr
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(bodyReader, scrapeDebugBodyLimit+1))
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to read scrape body: %s", err)
+		return nil, "", res
+	}
+	if len(body) > scrapeDebugBodyLimit {
+		res.Error = fmt.Sprintf("scrape body exceeds the %d byte limit", scrapeDebugBodyLimit)
+		return nil, "", res
+	}
+	res.Body = string(body)
+
+	if resp.StatusCode != http.StatusOK {
+		res.Error = fmt.Sprintf("server returned HTTP status %s", resp.Status)
+		return nil, "", res
+	}
+	return body, res.ContentType, res
+}
+
+// traceSeries parses a scrape body and builds the labels of every series the
+// same way as the scrape loop, recording each metric_relabel_configs step.
+func traceSeries(body []byte, contentType string, sc *config.ScrapeConfig, tgt *scrape.Target) ([]*ScrapeDebugSeries, error) {
+	res := []*ScrapeDebugSeries{}
+
+	p := textparse.New(body, contentType)
+	for {
+		entry, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return res, err
+		}
+		if entry != textparse.EntrySeries {
+			continue
+		}
+
+		_, ts, v := p.Series()
+
+		var scraped labels.Labels
+		p.Metric(&scraped)
+
+		series := &ScrapeDebugSeries{
+			Scraped: scraped,
+			Labels:  addTargetLabels(scraped, tgt, sc.HonorLabels),
+			Value:   strconv.FormatFloat(v, 'f', -1, 64),
+		}
+		if ts != nil && sc.HonorTimestamps {
+			t := *ts
+			series.Timestamp = &t
+		}
+		series.MetricRelabelSteps, series.Final = traceRelabel(series.Labels, sc.MetricRelabelConfigs)
+
+		res = append(res, series)
+	}
+	return res, nil
+}
+
+// addTargetLabels adds the labels of tgt to the labels of a scraped series.
+// When honor is false, conflicting labels from the series are renamed with
+// the exported_ prefix.
+func addTargetLabels(lset labels.Labels, tgt *scrape.Target, honor bool) labels.Labels {
+	lb := labels.NewBuilder(lset)
+	for _, l := range tgt.Labels() {
+		if honor {
+			if !lset.Has(l.Name) {
+				lb.Set(l.Name, l.Value)
+			}
+			continue
+		}
+
+		if existing := lset.Get(l.Name); existing != "" {
+			lb.Set(model.ExportedLabelPrefix+l.Name, existing)
+		}
+		lb.Set(l.Name, l.Value)
+	}
+	return lb.Labels()
+}
+
+// traceRelabel applies rcs to lset one rule at a time, returning the labels
+// after each rule and the final labels. Stops at the first rule which drops
+// the labels, in which case the final labels are nil.
+func traceRelabel(lset labels.Labels, rcs []*relabel.Config) ([]RelabelStep, labels.Labels) {
+	steps := make([]RelabelStep, 0, len(rcs))
+	for _, rc := range rcs {
+		lset = relabel.Process(lset, rc)
+		steps = append(steps, RelabelStep{Rule: relabelRuleYAML(rc), Labels: lset})
+		if lset == nil {
+			break
+		}
+	}
+	return steps, lset
+}
+
+// relabelRuleYAML returns rc in YAML.
+func relabelRuleYAML(rc *relabel.Config) string {
+	bb, err := yaml.Marshal(rc)
+	if err != nil {
+		return ""
+	}
+	return string(bb)
+}
@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_ScrapeDebugHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintln(w, `# TYPE http_requests_total counter`)
+		fmt.Fprintln(w, `http_requests_total{code="200",job="exposed"} 10`)
+		fmt.Fprintln(w, `go_goroutines 5`)
+	}))
+	defer srv.Close()
+
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	sc := config.DefaultScrapeConfig
+	sc.JobName = "job"
+	sc.ScrapeTimeout = model.Duration(10 * time.Second)
+	sc.HTTPClientConfig.BearerToken = "secret"
+	sc.RelabelConfigs = mustRelabelConfigs(t, `
+- target_label: team
+  replacement: payments
+- source_labels: [__meta_drop]
+  regex: "yes"
+  action: drop`)
+	sc.MetricRelabelConfigs = mustRelabelConfigs(t, `
+- source_labels: [__name__]
+  regex: go_.*
+  action: drop`)
+
+	cfg := makeInstanceConfig("test_instance")
+	cfg.ScrapeConfigs = []*config.ScrapeConfig{&sc}
+
+	active := scrape.NewTarget(labels.FromStrings(
+		model.AddressLabel, srvURL.Host,
+		model.InstanceLabel, srvURL.Host,
+		model.JobLabel, "job",
+	), labels.FromStrings(
+		model.AddressLabel, srvURL.Host,
+		model.JobLabel, "job",
+		model.MetricsPathLabel, "/metrics",
+		model.SchemeLabel, "http",
+	), nil)
+	dropped := scrape.NewTarget(nil, labels.FromStrings(
+		model.AddressLabel, "dropped:8080",
+		model.JobLabel, "job",
+		"__meta_drop", "yes",
+	), nil)
+
+	a := newScrapeDebugAgent(t, cfg, &mockInstanceScrape{
+		tgts:    map[string][]*scrape.Target{"job": {active}},
+		dropped: map[string][]*scrape.Target{"job": {dropped}},
+	})
+
+	t.Run("scraped target", func(t *testing.T) {
+		resp := scrapeDebug(t, a, "test_instance", "job", srvURL.Host, http.StatusOK)
+
+		require.Equal(t, srv.URL+"/metrics", resp.Target.URL)
+		require.Len(t, resp.Target.RelabelSteps, 2)
+		require.Equal(t, "payments", resp.Target.Labels.Get("team"))
+
+		require.NotNil(t, resp.Scrape)
+		require.Empty(t, resp.Scrape.Error)
+		require.Equal(t, http.StatusOK, resp.Scrape.StatusCode)
+		require.Contains(t, resp.Scrape.Body, "go_goroutines 5")
+
+		require.Len(t, resp.Series, 2)
+
+		requests := resp.Series[0]
+		require.Equal(t, "exposed", requests.Scraped.Get("job"))
+		require.Equal(t, "job", requests.Final.Get("job"))
+		require.Equal(t, "exposed", requests.Final.Get("exported_job"))
+		require.Equal(t, "payments", requests.Final.Get("team"))
+		require.Equal(t, "10", requests.Value)
+
+		goroutines := resp.Series[1]
+		require.Len(t, goroutines.MetricRelabelSteps, 1)
+		require.Nil(t, goroutines.Final, "series should be dropped by metric_relabel_configs")
+	})
+
+	t.Run("dropped target", func(t *testing.T) {
+		resp := scrapeDebug(t, a, "test_instance", "job", "dropped:8080", http.StatusOK)
+
+		require.Equal(t, "yes", resp.Target.DiscoveredLabels.Get("__meta_drop"))
+		require.Len(t, resp.Target.RelabelSteps, 2)
+		require.Nil(t, resp.Target.RelabelSteps[1].Labels)
+		require.Nil(t, resp.Target.Labels)
+		require.Nil(t, resp.Scrape)
+	})
+
+	t.Run("unknown target", func(t *testing.T) {
+		scrapeDebug(t, a, "test_instance", "job", "169.254.169.254:80", http.StatusNotFound)
+	})
+
+	t.Run("missing job", func(t *testing.T) {
+		scrapeDebug(t, a, "test_instance", "missing", srvURL.Host, http.StatusNotFound)
+	})
+
+	t.Run("missing instance", func(t *testing.T) {
+		scrapeDebug(t, a, "missing", "job", srvURL.Host, http.StatusNotFound)
+	})
+}
+
+func newScrapeDebugAgent(t *testing.T, cfg instance.Config, inst instance.ManagedInstance) *Agent {
+	t.Helper()
+
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+
+	mockManager := &instance.MockManager{
+		ListConfigsFunc: func() map[string]instance.Config {
+			return map[string]instance.Config{cfg.Name: cfg}
+		},
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != cfg.Name {
+				return nil, fmt.Errorf("instance %s does not exist", name)
+			}
+			return inst, nil
+		},
+		ListInstancesFunc: func() map[string]instance.ManagedInstance { return nil },
+		ApplyConfigFunc:   func(_ instance.Config) error { return nil },
+		DeleteConfigFunc:  func(name string) error { return nil },
+		StopFunc:          func() {},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+	return a
+}
+
+func scrapeDebug(t *testing.T, a *Agent, instanceName, job, target string, expectStatus int) ScrapeDebugResponse {
+	t.Helper()
+
+	query := url.Values{"job": {job}, "target": {target}}
+	r := httptest.NewRequest("GET", "/agent/api/v1/instances/"+instanceName+"/scrape_debug?"+query.Encode(), nil)
+	r = mux.SetURLVars(r, map[string]string{"instance": instanceName})
+
+	rr := httptest.NewRecorder()
+	a.ScrapeDebugHandler(rr, r)
+	require.Equal(t, expectStatus, rr.Result().StatusCode)
+
+	var resp struct {
+		Status string              `json:"status"`
+		Data   ScrapeDebugResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	return resp.Data
+}
@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/scrape"
+)
+
+// scrapeSeriesMetric is the report series written by the scrape loop holding
+// the number of series in the last scrape of a target after metric
+// relabeling.
+const scrapeSeriesMetric = "scrape_samples_post_metric_relabeling"
+
+// seriesCountLookback is how far before the last scrape of a target to look
+// for its series count. The report series is written with the time the scrape
+// was scheduled, which can be slightly before the scrape started.
+const seriesCountLookback = time.Minute
+
+// InstanceTargetsResponse is returned by the InstanceTargetsHandler.
+type InstanceTargetsResponse struct {
+	ActiveTargets  []ActiveTargetInfo  `json:"active_targets"`
+	DroppedTargets []DroppedTargetInfo `json:"dropped_targets"`
+}
+
+// ActiveTargetInfo describes a target being scraped by an instance.
+type ActiveTargetInfo struct {
+	TargetGroup string `json:"target_group"`
+
+	Endpoint         string        `json:"endpoint"`
+	State            string        `json:"state"`
+	Labels           labels.Labels `json:"labels"`
+	DiscoveredLabels labels.Labels `json:"discovered_labels"`
+	LastScrape       time.Time     `json:"last_scrape"`
+	ScrapeDuration   int64         `json:"scrape_duration_ms"`
+	ScrapeError      string        `json:"scrape_error"`
+
+	// SeriesCount is the number of series in the last scrape after metric
+	// relabeling. It is only set when the instance has query_retention
+	// enabled and the target has been scraped.
+	SeriesCount *int64 `json:"series_count,omitempty"`
+}
+
+// DroppedTargetInfo describes a discovered target which was dropped by
+// relabeling.
+type DroppedTargetInfo struct {
+	TargetGroup      string        `json:"target_group"`
+	DiscoveredLabels labels.Labels `json:"discovered_labels"`
+
+	// DroppedBy is the relabel rule which dropped the target. It is nil if
+	// the rule can't be determined, such as when relabel_configs changed
+	// since the target was discovered.
+	DroppedBy *DroppedByInfo `json:"dropped_by"`
+}
+
+// DroppedByInfo describes the relabel rule which dropped a target.
+type DroppedByInfo struct {
+	// Index of the rule within the relabel_configs of the scrape config.
+	Index int `json:"index"`
+	// Rule is the relabel rule in YAML.
+	Rule string `json:"rule"`
+}
+
+// InstanceTargetsHandler writes the active and dropped targets of a single
+// instance config along with their scrape health.
+func (a *Agent) InstanceTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName := mux.Vars(r)["instance"]
+
+	cfg, ok := a.mm.ListConfigs()[instanceName]
+	if !ok {
+		a.writeTargetsError(w, http.StatusNotFound, fmt.Errorf("instance %s does not exist", instanceName))
+		return
+	}
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		a.writeTargetsError(w, http.StatusNotFound, err)
+		return
+	}
+
+	// Instances may be shared between configs, so only targets from the
+	// config's own scrape configs are returned.
+	scrapeConfigs := make(map[string]*config.ScrapeConfig, len(cfg.ScrapeConfigs))
+	for _, sc := range cfg.ScrapeConfigs {
+		scrapeConfigs[sc.JobName] = sc
+	}
+
+	resp := InstanceTargetsResponse{
+		ActiveTargets:  []ActiveTargetInfo{},
+		DroppedTargets: []DroppedTargetInfo{},
+	}
+
+	for key, targets := range inst.TargetsActive() {
+		if _, ok := scrapeConfigs[key]; !ok {
+			continue
+		}
+		for _, tgt := range targets {
+			var lastError string
+			if scrapeError := tgt.LastError(); scrapeError != nil {
+				lastError = scrapeError.Error()
+			}
+
+			resp.ActiveTargets = append(resp.ActiveTargets, ActiveTargetInfo{
+				TargetGroup: key,
+
+				Endpoint:         tgt.URL().String(),
+				State:            string(tgt.Health()),
+				DiscoveredLabels: tgt.DiscoveredLabels(),
+				Labels:           tgt.Labels(),
+				LastScrape:       tgt.LastScrape(),
+				ScrapeDuration:   tgt.LastScrapeDuration().Milliseconds(),
+				ScrapeError:      lastError,
+				SeriesCount:      targetSeriesCount(r.Context(), inst, tgt),
+			})
+		}
+	}
+
+	for key, targets := range inst.TargetsDropped() {
+		sc, ok := scrapeConfigs[key]
+		if !ok {
+			continue
+		}
+		for _, tgt := range targets {
+			resp.DroppedTargets = append(resp.DroppedTargets, DroppedTargetInfo{
+				TargetGroup:      key,
+				DiscoveredLabels: tgt.DiscoveredLabels(),
+				DroppedBy:        droppedBy(sc.RelabelConfigs, tgt.DiscoveredLabels()),
+			})
+		}
+	}
+
+	sort.Slice(resp.ActiveTargets, func(i, j int) bool {
+		iTgt, jTgt := resp.ActiveTargets[i], resp.ActiveTargets[j]
+		if iTgt.TargetGroup != jTgt.TargetGroup {
+			return iTgt.TargetGroup < jTgt.TargetGroup
+		}
+		return labels.Compare(iTgt.Labels, jTgt.Labels) < 0
+	})
+	sort.Slice(resp.DroppedTargets, func(i, j int) bool {
+		iTgt, jTgt := resp.DroppedTargets[i], resp.DroppedTargets[j]
+		if iTgt.TargetGroup != jTgt.TargetGroup {
+			return iTgt.TargetGroup < jTgt.TargetGroup
+		}
+		return labels.Compare(iTgt.DiscoveredLabels, jTgt.DiscoveredLabels) < 0
+	})
+
+	err = configapi.WriteResponse(w, http.StatusOK, resp)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+func (a *Agent) writeTargetsError(w http.ResponseWriter, statusCode int, err error) {
+	if err := configapi.WriteError(w, statusCode, err); err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// targetSeriesCount looks up the number of series in the last scrape of tgt
+// from the report series written to the WAL. Returns nil if the count isn't
+// available.
+func targetSeriesCount(ctx context.Context, inst instance.ManagedInstance, tgt *scrape.Target) *int64 {
+	lastScrape := tgt.LastScrape()
+	if lastScrape.IsZero() {
+		return nil
+	}
+
+	q, err := inst.Querier(ctx, timestamp.FromTime(lastScrape.Add(-seriesCountLookback)), timestamp.FromTime(time.Now()))
+	if err != nil {
+		return nil
+	}
+	defer q.Close()
+
+	lset := tgt.Labels()
+	matchers := make([]*labels.Matcher, 0, len(lset)+1)
+	matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabel, scrapeSeriesMetric))
+	for _, l := range lset {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, l.Name, l.Value))
+	}
+
+	var (
+		found  bool
+		lastTs int64
+		value  float64
+	)
+
+	ss := q.Select(false, nil, matchers...)
+	for ss.Next() {
+		it := ss.At().Iterator()
+		for it.Next() {
+			if ts, v := it.At(); !found || ts >= lastTs {
+				found, lastTs, value = true, ts, v
+			}
+		}
+	}
+	if ss.Err() != nil || !found {
+		return nil
+	}
+
+	count := int64(value)
+	return &count
+}
+
+// droppedBy finds the rule from rcs which dropped a target with the given
+// discovered labels by applying the rules one at a time. Returns nil if none
+// of the rules drop the target.
+func droppedBy(rcs []*relabel.Config, discovered labels.Labels) *DroppedByInfo {
+	lset := discovered
+	for idx, rc := range rcs {
+		lset = relabel.Process(lset, rc)
+		if lset != nil {
+			continue
+		}
+		return &DroppedByInfo{Index: idx, Rule: relabelRuleYAML(rc)}
+	}
+	return nil
+}
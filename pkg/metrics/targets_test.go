@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestAgent_InstanceTargetsHandler(t *testing.T) {
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+
+	relabelConfigs := mustRelabelConfigs(t, `
+- source_labels: [__address__]
+  target_label: instance
+- source_labels: [__meta_keep]
+  regex: "no"
+  action: drop`)
+
+	cfg := makeInstanceConfig("test_instance")
+	cfg.ScrapeConfigs = []*config.ScrapeConfig{{JobName: "job", RelabelConfigs: relabelConfigs}}
+
+	active := scrape.NewTarget(labels.FromMap(map[string]string{
+		model.JobLabel:         "job",
+		model.InstanceLabel:    "localhost:12345",
+		model.SchemeLabel:      "http",
+		model.AddressLabel:     "localhost:12345",
+		model.MetricsPathLabel: "/metrics",
+	}), labels.FromMap(map[string]string{
+		model.AddressLabel: "localhost:12345",
+		"__meta_keep":      "yes",
+	}), nil)
+	active.Report(time.Date(1994, time.January, 12, 0, 0, 0, 0, time.UTC), time.Second, fmt.Errorf("something went wrong"))
+
+	dropped := scrape.NewTarget(nil, labels.FromMap(map[string]string{
+		model.AddressLabel: "localhost:54321",
+		"__meta_keep":      "no",
+	}), nil)
+
+	// Targets from other configs sharing the instance shouldn't be returned.
+	other := scrape.NewTarget(labels.FromMap(map[string]string{
+		model.JobLabel:     "other",
+		model.AddressLabel: "localhost:9090",
+	}), nil, nil)
+
+	mockManager := &instance.MockManager{
+		ListConfigsFunc: func() map[string]instance.Config {
+			return map[string]instance.Config{"test_instance": cfg}
+		},
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "test_instance" {
+				return nil, fmt.Errorf("instance %s does not exist", name)
+			}
+			return &mockInstanceScrape{
+				tgts: map[string][]*scrape.Target{
+					"job":   {active},
+					"other": {other},
+				},
+				dropped: map[string][]*scrape.Target{
+					"job": {dropped},
+				},
+			}, nil
+		},
+		ListInstancesFunc: func() map[string]instance.ManagedInstance { return nil },
+		ApplyConfigFunc:   func(_ instance.Config) error { return nil },
+		DeleteConfigFunc:  func(name string) error { return nil },
+		StopFunc:          func() {},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	t.Run("targets", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/test_instance/targets", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.InstanceTargetsHandler(rr, r)
+		require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+
+		var resp struct {
+			Status string                  `json:"status"`
+			Data   InstanceTargetsResponse `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		require.Equal(t, "success", resp.Status)
+
+		require.Len(t, resp.Data.ActiveTargets, 1)
+		tgt := resp.Data.ActiveTargets[0]
+		require.Equal(t, "job", tgt.TargetGroup)
+		require.Equal(t, "http://localhost:12345/metrics", tgt.Endpoint)
+		require.Equal(t, "down", tgt.State)
+		require.Equal(t, "something went wrong", tgt.ScrapeError)
+		require.Equal(t, int64(1000), tgt.ScrapeDuration)
+		require.Nil(t, tgt.SeriesCount, "series count should be omitted when the WAL can't be queried")
+
+		require.Len(t, resp.Data.DroppedTargets, 1)
+		drop := resp.Data.DroppedTargets[0]
+		require.Equal(t, "job", drop.TargetGroup)
+		require.Equal(t, "no", drop.DiscoveredLabels.Get("__meta_keep"))
+		require.NotNil(t, drop.DroppedBy)
+		require.Equal(t, 1, drop.DroppedBy.Index)
+		require.Contains(t, drop.DroppedBy.Rule, "action: drop")
+	})
+
+	t.Run("missing instance", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/agent/api/v1/instances/missing/targets", nil)
+		r = mux.SetURLVars(r, map[string]string{"instance": "missing"})
+
+		rr := httptest.NewRecorder()
+		a.InstanceTargetsHandler(rr, r)
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+}
+
+func TestDroppedBy(t *testing.T) {
+	rcs := mustRelabelConfigs(t, `
+- source_labels: [__meta_env]
+  target_label: env
+- source_labels: [env]
+  regex: dev
+  action: drop
+- source_labels: [__meta_team]
+  regex: payments
+  action: keep`)
+
+	tt := []struct {
+		name       string
+		discovered labels.Labels
+		expect     int
+	}{
+		{"dropped by drop", labels.FromStrings("__meta_env", "dev", "__meta_team", "payments"), 1},
+		{"dropped by keep", labels.FromStrings("__meta_env", "prod", "__meta_team", "search"), 2},
+		{"not dropped", labels.FromStrings("__meta_env", "prod", "__meta_team", "payments"), -1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			res := droppedBy(rcs, tc.discovered)
+			if tc.expect < 0 {
+				require.Nil(t, res)
+				return
+			}
+			require.NotNil(t, res)
+			require.Equal(t, tc.expect, res.Index)
+		})
+	}
+}
+
+func mustRelabelConfigs(t *testing.T, in string) []*relabel.Config {
+	t.Helper()
+
+	var rcs []*relabel.Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &rcs))
+	return rcs
+}
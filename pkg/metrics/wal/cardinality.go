@@ -0,0 +1,149 @@
+package wal
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// maxChurnPeriods is the number of truncation periods series churn is kept
+// for.
+const maxChurnPeriods = 10
+
+// CardinalityStats describes the cardinality of the series held in memory by
+// a Storage.
+type CardinalityStats struct {
+	// NumSeries is the number of active series.
+	NumSeries int
+
+	// SeriesCountByMetricName is the metric names with the most series.
+	SeriesCountByMetricName []CardinalityStat
+	// LabelValueCountByLabelName is the label names with the most unique
+	// values.
+	LabelValueCountByLabelName []CardinalityStat
+	// SeriesCountByLabelValuePair is the label name and value pairs used by
+	// the most series.
+	SeriesCountByLabelValuePair []CardinalityStat
+
+	// Churn is the number of series created and removed in the most recent
+	// truncation periods, ordered from oldest to newest. The last period is
+	// still in progress.
+	Churn []ChurnPeriod
+}
+
+// CardinalityStat is a count for a single name.
+type CardinalityStat struct {
+	Name  string
+	Count int
+}
+
+// ChurnPeriod is the series churn between two truncations of the WAL.
+type ChurnPeriod struct {
+	Start, End time.Time
+
+	// Created is the number of new series appended during the period.
+	Created int
+	// Removed is the number of series garbage collected at the end of the
+	// period.
+	Removed int
+}
+
+// CardinalityStats computes the cardinality of the active series. Each list
+// of stats is limited to the top limit entries.
+func (w *Storage) CardinalityStats(limit int) CardinalityStats {
+	var (
+		numSeries       int
+		metricNames     = make(map[string]int)
+		labelValues     = make(map[string]map[string]struct{})
+		labelValuePairs = make(map[string]int)
+	)
+
+	for series := range w.series.iterator().Channel() {
+		numSeries++
+
+		for _, l := range series.lset {
+			if l.Name == labels.MetricName {
+				metricNames[l.Value]++
+			}
+
+			values, ok := labelValues[l.Name]
+			if !ok {
+				values = make(map[string]struct{})
+				labelValues[l.Name] = values
+			}
+			values[l.Value] = struct{}{}
+
+			labelValuePairs[l.Name+"="+l.Value]++
+		}
+	}
+
+	labelValueCounts := make(map[string]int, len(labelValues))
+	for name, values := range labelValues {
+		labelValueCounts[name] = len(values)
+	}
+
+	return CardinalityStats{
+		NumSeries:                   numSeries,
+		SeriesCountByMetricName:     topCardinalityStats(metricNames, limit),
+		LabelValueCountByLabelName:  topCardinalityStats(labelValueCounts, limit),
+		SeriesCountByLabelValuePair: topCardinalityStats(labelValuePairs, limit),
+		Churn:                       w.seriesChurn(),
+	}
+}
+
+// seriesChurn returns the completed churn periods along with the period in
+// progress.
+func (w *Storage) seriesChurn() []ChurnPeriod {
+	w.churnMtx.Lock()
+	defer w.churnMtx.Unlock()
+
+	churn := make([]ChurnPeriod, 0, len(w.churn)+1)
+	churn = append(churn, w.churn...)
+	churn = append(churn, ChurnPeriod{
+		Start:   w.churnStart,
+		End:     time.Now(),
+		Created: int(w.churnCreated.Load()),
+	})
+	return churn
+}
+
+// completeChurnPeriod ends the current churn period, recording removed as the
+// number of series removed at the end of it.
+func (w *Storage) completeChurnPeriod(removed int) {
+	w.churnMtx.Lock()
+	defer w.churnMtx.Unlock()
+
+	now := time.Now()
+	w.churn = append(w.churn, ChurnPeriod{
+		Start:   w.churnStart,
+		End:     now,
+		Created: int(w.churnCreated.Swap(0)),
+		Removed: removed,
+	})
+	if len(w.churn) > maxChurnPeriods {
+		w.churn = w.churn[len(w.churn)-maxChurnPeriods:]
+	}
+	w.churnStart = now
+}
+
+// topCardinalityStats returns the limit entries from counts with the highest
+// count, breaking ties by name.
+func topCardinalityStats(counts map[string]int, limit int) []CardinalityStat {
+	stats := make([]CardinalityStat, 0, len(counts))
+	for name, count := range counts {
+		stats = append(stats, CardinalityStat{Name: name, Count: count})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Name < stats[j].Name
+	})
+
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
@@ -0,0 +1,78 @@
+package wal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_CardinalityStats(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(context.Background())
+	for _, lset := range []labels.Labels{
+		labels.FromStrings("__name__", "http_requests_total", "code", "200", "path", "/"),
+		labels.FromStrings("__name__", "http_requests_total", "code", "500", "path", "/"),
+		labels.FromStrings("__name__", "http_requests_total", "code", "200", "path", "/api"),
+		labels.FromStrings("__name__", "up", "path", "/"),
+	} {
+		_, err := app.Append(0, lset, 10, 1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	stats := s.CardinalityStats(2)
+	require.Equal(t, 4, stats.NumSeries)
+	require.Equal(t, []CardinalityStat{
+		{Name: "http_requests_total", Count: 3},
+		{Name: "up", Count: 1},
+	}, stats.SeriesCountByMetricName)
+	require.Equal(t, []CardinalityStat{
+		{Name: "__name__", Count: 2},
+		{Name: "code", Count: 2},
+	}, stats.LabelValueCountByLabelName)
+	require.Equal(t, []CardinalityStat{
+		{Name: "__name__=http_requests_total", Count: 3},
+		{Name: "path=/", Count: 3},
+	}, stats.SeriesCountByLabelValuePair)
+
+	require.Len(t, stats.Churn, 1)
+	require.Equal(t, 4, stats.Churn[0].Created)
+
+	// Series are removed after two truncations without receiving samples.
+	require.NoError(t, s.Truncate(100))
+	require.NoError(t, s.Truncate(100))
+
+	stats = s.CardinalityStats(0)
+	require.Equal(t, 0, stats.NumSeries)
+	require.Len(t, stats.Churn, 3)
+	require.Equal(t, ChurnPeriod{Created: 4, Removed: 0}, stripChurnTimes(stats.Churn[0]))
+	require.Equal(t, ChurnPeriod{Created: 0, Removed: 4}, stripChurnTimes(stats.Churn[1]))
+	require.Equal(t, ChurnPeriod{}, stripChurnTimes(stats.Churn[2]))
+}
+
+func TestTopCardinalityStats(t *testing.T) {
+	stats := topCardinalityStats(map[string]int{"a": 1, "b": 3, "c": 3, "d": 2}, 3)
+	require.Equal(t, []CardinalityStat{
+		{Name: "b", Count: 3},
+		{Name: "c", Count: 3},
+		{Name: "d", Count: 2},
+	}, stats)
+}
+
+func stripChurnTimes(p ChurnPeriod) ChurnPeriod {
+	return ChurnPeriod{Created: p.Created, Removed: p.Removed}
+}
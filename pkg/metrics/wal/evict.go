@@ -0,0 +1,89 @@
+package wal
+
+import (
+	"math"
+	"os"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// EvictStats describes the data removed by EvictSegments.
+type EvictStats struct {
+	// Segments is the number of segments removed.
+	Segments int
+	// Bytes is the total size of the removed segments.
+	Bytes int64
+	// DroppedSamples is the number of samples which were lost.
+	DroppedSamples int
+}
+
+// EvictSegments removes the oldest segments from the WAL until at least bytes
+// have been freed or only the segment currently being written to remains.
+//
+// Unlike Truncate, samples from evicted segments are dropped regardless of
+// whether they have been sent by remote_write yet. Series records are kept in
+// a checkpoint so samples in the remaining segments can still be read.
+func (w *Storage) EvictSegments(bytes int64) (EvictStats, error) {
+	var stats EvictStats
+
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
+
+	if w.walClosed {
+		return stats, ErrWALClosed
+	}
+
+	w.truncateMtx.Lock()
+	defer w.truncateMtx.Unlock()
+
+	first, last, err := wal.Segments(w.wal.Dir())
+	if err != nil {
+		return stats, errors.Wrap(err, "get segment range")
+	}
+
+	// Find the newest segment to evict. The last segment is never evicted
+	// since it's still being written to.
+	evictTo := -1
+	for i := first; i < last && stats.Bytes < bytes; i++ {
+		fi, err := os.Stat(wal.SegmentName(w.wal.Dir(), i))
+		if err != nil {
+			return stats, errors.Wrap(err, "stat segment")
+		}
+		stats.Bytes += fi.Size()
+		stats.Segments++
+		evictTo = i
+	}
+	if evictTo < 0 {
+		return EvictStats{}, nil
+	}
+
+	// Create a checkpoint with all series but no samples. This also drops
+	// samples held in the previous checkpoint, which are older than anything
+	// in the evicted segments.
+	cpStats, err := wal.Checkpoint(w.logger, w.wal, first, evictTo, w.keepSeries, math.MaxInt64)
+	if err != nil {
+		return EvictStats{}, errors.Wrap(err, "create checkpoint")
+	}
+	stats.DroppedSamples = cpStats.DroppedSamples
+
+	if err := w.wal.Truncate(evictTo + 1); err != nil {
+		return EvictStats{}, errors.Wrap(err, "truncate segments")
+	}
+	if err := wal.DeleteCheckpoints(w.wal.Dir(), evictTo); err != nil {
+		level.Error(w.logger).Log("msg", "delete old checkpoints", "err", err)
+	}
+
+	w.metrics.totalEvictedSegments.Add(float64(stats.Segments))
+	w.metrics.totalEvictedBytes.Add(float64(stats.Bytes))
+	w.metrics.totalEvictedSamples.Add(float64(stats.DroppedSamples))
+
+	level.Warn(w.logger).Log(
+		"msg", "evicted WAL segments to stay within disk quota",
+		"segments", stats.Segments,
+		"bytes", stats.Bytes,
+		"dropped_samples", stats.DroppedSamples,
+	)
+	return stats, nil
+}
@@ -0,0 +1,73 @@
+package wal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_EvictSegments(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+
+	lbls := labels.FromStrings("__name__", "foo")
+
+	// Write samples across three segments.
+	for seg := 0; seg < 3; seg++ {
+		app := s.Appender(context.Background())
+		for i := 0; i < 10; i++ {
+			_, err := app.Append(0, lbls, int64(seg*10+i), float64(i))
+			require.NoError(t, err)
+		}
+		require.NoError(t, app.Commit())
+		require.NoError(t, s.wal.NextSegment())
+	}
+
+	first, last, err := wal.Segments(s.wal.Dir())
+	require.NoError(t, err)
+	require.Equal(t, 0, first)
+	require.Equal(t, 3, last)
+
+	// Evicting a single byte should remove exactly one segment.
+	stats, err := s.EvictSegments(1)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Segments)
+	require.NotZero(t, stats.Bytes)
+	require.Equal(t, 10, stats.DroppedSamples)
+
+	first, _, err = wal.Segments(s.wal.Dir())
+	require.NoError(t, err)
+	require.Equal(t, 1, first)
+
+	// Evicting more than the WAL holds should never remove the last segment.
+	stats, err = s.EvictSegments(1 << 30)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Segments)
+
+	first, last, err = wal.Segments(s.wal.Dir())
+	require.NoError(t, err)
+	require.Equal(t, 3, first)
+	require.Equal(t, 3, last)
+
+	require.NoError(t, s.Close())
+
+	// The series should still be known after replaying the WAL, so appending
+	// to it doesn't create a new series.
+	s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	require.NotNil(t, s.series.getByHash(lbls.Hash(), lbls))
+}
@@ -0,0 +1,116 @@
+package wal
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// Reasons a new series may be rejected by a seriesLimiter.
+const (
+	limitReasonMaxActiveSeries       = "max_active_series"
+	limitReasonMaxNewSeriesPerMinute = "max_new_series_per_minute"
+)
+
+// limitLogInterval is how often a rejected series is logged for a given job
+// and reason. Rejections happen on the hot path, so logging every one would
+// flood the logs.
+const limitLogInterval = time.Minute
+
+// seriesLimiter enforces limits on the creation of new series within a
+// Storage. A zero limit disables that limit.
+type seriesLimiter struct {
+	maxActive       int
+	maxNewPerMinute int
+
+	active *atomic.Int64
+
+	mut           sync.Mutex
+	windowStart   time.Time
+	windowCreated int
+	lastLogged    map[limitLogKey]time.Time
+
+	// now is used for getting the current time. Overridden in tests.
+	now func() time.Time
+}
+
+type limitLogKey struct {
+	reason, job string
+}
+
+func newSeriesLimiter(opts Options) *seriesLimiter {
+	return &seriesLimiter{
+		maxActive:       opts.MaxActiveSeries,
+		maxNewPerMinute: opts.MaxNewSeriesPerMinute,
+		active:          atomic.NewInt64(0),
+		lastLogged:      make(map[limitLogKey]time.Time),
+		now:             time.Now,
+	}
+}
+
+// reserve reserves room for a new series. If the series may not be created,
+// reserve returns the reason it was rejected. Otherwise, it returns an empty
+// string and the series is counted as active.
+func (l *seriesLimiter) reserve() string {
+	if l.maxActive > 0 {
+		if l.active.Inc() > int64(l.maxActive) {
+			l.active.Dec()
+			return limitReasonMaxActiveSeries
+		}
+	} else {
+		l.active.Inc()
+	}
+
+	if l.maxNewPerMinute > 0 {
+		l.mut.Lock()
+		defer l.mut.Unlock()
+
+		now := l.now()
+		if now.Sub(l.windowStart) >= time.Minute {
+			l.windowStart = now
+			l.windowCreated = 0
+		}
+		if l.windowCreated >= l.maxNewPerMinute {
+			l.active.Dec()
+			return limitReasonMaxNewSeriesPerMinute
+		}
+		l.windowCreated++
+	}
+
+	return ""
+}
+
+// add counts series as active without checking limits. Used for series
+// replayed from the WAL.
+func (l *seriesLimiter) add(n int) { l.active.Add(int64(n)) }
+
+// release removes series from the active count.
+func (l *seriesLimiter) release(n int) { l.active.Sub(int64(n)) }
+
+// limit returns the configured limit for reason.
+func (l *seriesLimiter) limit(reason string) int {
+	switch reason {
+	case limitReasonMaxActiveSeries:
+		return l.maxActive
+	case limitReasonMaxNewSeriesPerMinute:
+		return l.maxNewPerMinute
+	default:
+		return 0
+	}
+}
+
+// shouldLog reports whether a rejection for the given reason and job should
+// be logged, limiting logs to one per limitLogInterval.
+func (l *seriesLimiter) shouldLog(reason, job string) bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	key := limitLogKey{reason: reason, job: job}
+	now := l.now()
+	if last, ok := l.lastLogged[key]; ok && now.Sub(last) < limitLogInterval {
+		return false
+	}
+	l.lastLogged[key] = now
+	return true
+}
@@ -0,0 +1,99 @@
+package wal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_MaxActiveSeries(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	reg := prometheus.NewRegistry()
+	s, err := NewStorageWithOptions(log.NewNopLogger(), reg, walDir, Options{
+		MaxActiveSeries: 2,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(context.Background())
+	a := labels.FromStrings("__name__", "a", "job", "test")
+	b := labels.FromStrings("__name__", "b", "job", "test")
+	c := labels.FromStrings("__name__", "c", "job", "test")
+
+	aRef, err := app.Append(0, a, 0, 0)
+	require.NoError(t, err)
+	require.NotZero(t, aRef)
+	_, err = app.Append(0, b, 0, 0)
+	require.NoError(t, err)
+
+	// The third series is over the limit and should be dropped without an
+	// error.
+	cRef, err := app.Append(0, c, 0, 0)
+	require.NoError(t, err)
+	require.Zero(t, cRef)
+
+	// Existing series can still be appended to.
+	_, err = app.Append(aRef, a, 1, 1)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, 1.0, testutil.ToFloat64(s.metrics.totalLimitedSamples.WithLabelValues(limitReasonMaxActiveSeries, "test")))
+
+	// After the series are garbage collected, new series may be created again.
+	// Series are only deleted after being marked for deletion in a previous
+	// GC cycle, so truncate twice.
+	require.NoError(t, s.Truncate(10))
+	require.NoError(t, s.Truncate(10))
+
+	app = s.Appender(context.Background())
+	cRef, err = app.Append(0, c, 20, 0)
+	require.NoError(t, err)
+	require.NotZero(t, cRef)
+	require.NoError(t, app.Commit())
+}
+
+func TestStorage_MaxNewSeriesPerMinute(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, Options{
+		MaxNewSeriesPerMinute: 1,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	now := time.Now()
+	s.limiter.now = func() time.Time { return now }
+
+	app := s.Appender(context.Background())
+	ref, err := app.Append(0, labels.FromStrings("__name__", "a"), 0, 0)
+	require.NoError(t, err)
+	require.NotZero(t, ref)
+
+	ref, err = app.Append(0, labels.FromStrings("__name__", "b"), 0, 0)
+	require.NoError(t, err)
+	require.Zero(t, ref, "second new series within a minute should be dropped")
+
+	// Move to the next minute; a new series may be created.
+	now = now.Add(time.Minute)
+	ref, err = app.Append(0, labels.FromStrings("__name__", "b"), 0, 0)
+	require.NoError(t, err)
+	require.NotZero(t, ref)
+	require.NoError(t, app.Commit())
+}
@@ -0,0 +1,161 @@
+package wal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/tsdbutil"
+)
+
+// ErrQueryDisabled is returned by Querier when the Storage wasn't configured
+// with a query retention.
+var ErrQueryDisabled = fmt.Errorf("querying is disabled: no query retention configured")
+
+// Querier returns a storage.Querier for samples held in memory that fall
+// within [mint, maxt]. Only samples no older than the configured query
+// retention are available, even if they are still held in memory.
+//
+// Querier implements storage.Queryable.
+func (w *Storage) Querier(_ context.Context, mint, maxt int64) (storage.Querier, error) {
+	if w.opts.QueryRetention <= 0 {
+		return nil, ErrQueryDisabled
+	}
+
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
+	if w.walClosed {
+		return nil, ErrWALClosed
+	}
+
+	if retentionMint := w.queryMinTime(); mint < retentionMint {
+		mint = retentionMint
+	}
+	return &querier{s: w.series, mint: mint, maxt: maxt}, nil
+}
+
+// queryMinTime returns the timestamp of the oldest sample which is still
+// within the query retention.
+func (w *Storage) queryMinTime() int64 {
+	return timestamp.FromTime(w.now().Add(-w.opts.QueryRetention))
+}
+
+// querier implements storage.Querier against the in-memory series of a
+// Storage. Queries are answered by scanning every series; the WAL doesn't
+// maintain a postings index.
+type querier struct {
+	s          *stripeSeries
+	mint, maxt int64
+}
+
+func (q *querier) Select(sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		if hints.Start > mint {
+			mint = hints.Start
+		}
+		if hints.End < maxt {
+			maxt = hints.End
+		}
+	}
+
+	var res []storage.Series
+	q.s.forEach(func(series *memSeries) {
+		if !matchLabels(series.lset, matchers) {
+			return
+		}
+
+		samples := series.samplesBetween(mint, maxt)
+		if len(samples) == 0 {
+			return
+		}
+
+		tsSamples := make([]tsdbutil.Sample, 0, len(samples))
+		for _, s := range samples {
+			tsSamples = append(tsSamples, s)
+		}
+		res = append(res, storage.NewListSeries(series.lset, tsSamples))
+	})
+
+	if sortSeries {
+		sort.Slice(res, func(i, j int) bool {
+			return labels.Compare(res[i].Labels(), res[j].Labels()) < 0
+		})
+	}
+	return &listSeriesSet{series: res, idx: -1}
+}
+
+func (q *querier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	values := map[string]struct{}{}
+	q.s.forEach(func(series *memSeries) {
+		if !q.hasSamples(series) || !matchLabels(series.lset, matchers) {
+			return
+		}
+		if v := series.lset.Get(name); v != "" {
+			values[v] = struct{}{}
+		}
+	})
+	return sortedKeys(values), nil, nil
+}
+
+func (q *querier) LabelNames() ([]string, storage.Warnings, error) {
+	names := map[string]struct{}{}
+	q.s.forEach(func(series *memSeries) {
+		if !q.hasSamples(series) {
+			return
+		}
+		for _, l := range series.lset {
+			names[l.Name] = struct{}{}
+		}
+	})
+	return sortedKeys(names), nil, nil
+}
+
+func (q *querier) Close() error { return nil }
+
+// hasSamples returns true if series has buffered samples in the querier's
+// time range. series must be locked by the caller.
+func (q *querier) hasSamples(series *memSeries) bool {
+	for _, s := range series.samples {
+		if s.t >= q.mint && s.t <= q.maxt {
+			return true
+		}
+	}
+	return false
+}
+
+func matchLabels(lset labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// listSeriesSet implements storage.SeriesSet over a slice of series.
+type listSeriesSet struct {
+	series []storage.Series
+	idx    int
+}
+
+func (s *listSeriesSet) Next() bool {
+	s.idx++
+	return s.idx < len(s.series)
+}
+
+func (s *listSeriesSet) At() storage.Series         { return s.series[s.idx] }
+func (s *listSeriesSet) Err() error                 { return nil }
+func (s *listSeriesSet) Warnings() storage.Warnings { return nil }
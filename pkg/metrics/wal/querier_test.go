@@ -0,0 +1,261 @@
+package wal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_Querier(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, Options{
+		QueryRetention: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	s.now = func() time.Time { return timestamp.Time(19) }
+
+	app := s.Appender(context.Background())
+	fooLabels := labels.FromStrings("__name__", "foo", "job", "a")
+	barLabels := labels.FromStrings("__name__", "bar", "job", "b")
+	for ts := int64(0); ts < 20; ts++ {
+		_, err := app.Append(0, fooLabels, ts, float64(ts))
+		require.NoError(t, err)
+		_, err = app.Append(0, barLabels, ts, float64(ts*10))
+		require.NoError(t, err)
+	}
+
+	// Uncommitted samples shouldn't be visible.
+	q, err := s.Querier(context.Background(), 0, 100)
+	require.NoError(t, err)
+	require.Empty(t, collectSeriesSet(t, q.Select(true, nil)))
+
+	require.NoError(t, app.Commit())
+
+	t.Run("retention", func(t *testing.T) {
+		q, err := s.Querier(context.Background(), 0, 100)
+		require.NoError(t, err)
+
+		res := collectSeriesSet(t, q.Select(true, nil))
+		require.Len(t, res, 2)
+		require.Equal(t, barLabels, res[0].lset)
+		require.Equal(t, fooLabels, res[1].lset)
+
+		// Only the last 10ms of samples should be kept.
+		require.Len(t, res[1].samples, 11)
+		require.Equal(t, int64(9), res[1].samples[0].t)
+		require.Equal(t, int64(19), res[1].samples[10].t)
+	})
+
+	t.Run("matchers and range", func(t *testing.T) {
+		q, err := s.Querier(context.Background(), 15, 17)
+		require.NoError(t, err)
+
+		res := collectSeriesSet(t, q.Select(false, nil, labels.MustNewMatcher(labels.MatchEqual, "job", "a")))
+		require.Len(t, res, 1)
+		require.Equal(t, fooLabels, res[0].lset)
+		require.Equal(t, []bufferedSample{{15, 15}, {16, 16}, {17, 17}}, res[0].samples)
+	})
+
+	t.Run("labels", func(t *testing.T) {
+		q, err := s.Querier(context.Background(), 0, 100)
+		require.NoError(t, err)
+
+		names, _, err := q.LabelNames()
+		require.NoError(t, err)
+		require.Equal(t, []string{"__name__", "job"}, names)
+
+		values, _, err := q.LabelValues("job", labels.MustNewMatcher(labels.MatchEqual, "__name__", "bar"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"b"}, values)
+	})
+}
+
+// TestStorage_Querier_Concurrent queries the Storage while samples are being
+// committed. Run with -race to detect unsynchronized access to samples.
+func TestStorage_Querier_Concurrent(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, Options{
+		QueryRetention: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	lset := labels.FromStrings("__name__", "foo")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ts := int64(0); ts < 500; ts++ {
+			app := s.Appender(context.Background())
+			if _, err := app.Append(0, lset, ts, float64(ts)); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := app.Commit(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		q, err := s.Querier(context.Background(), 0, 1000)
+		require.NoError(t, err)
+		_ = collectSeriesSet(t, q.Select(false, nil))
+		_, _, err = q.LabelNames()
+		require.NoError(t, err)
+		_, _, err = q.LabelValues("__name__")
+		require.NoError(t, err)
+	}
+}
+
+func TestStorage_Querier_Replay(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	opts := Options{QueryRetention: time.Hour}
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	app := s.Appender(context.Background())
+	lbls := labels.FromStrings("__name__", "foo")
+	for ts := int64(0); ts < 5; ts++ {
+		_, err := app.Append(0, lbls, ts, float64(ts))
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+	require.NoError(t, s.Close())
+
+	// Samples should be queryable again after replaying the WAL.
+	s, err = NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	s.now = func() time.Time { return timestamp.Time(4) }
+
+	q, err := s.Querier(context.Background(), 0, 100)
+	require.NoError(t, err)
+
+	res := collectSeriesSet(t, q.Select(false, nil))
+	require.Len(t, res, 1)
+	require.Equal(t, lbls, res[0].lset)
+	require.Len(t, res[0].samples, 5)
+}
+
+// TestStorage_Querier_IdleSeries ensures that samples of a series which stopped
+// receiving samples age out of the query retention.
+func TestStorage_Querier_IdleSeries(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, Options{
+		QueryRetention: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+	s.now = func() time.Time { return timestamp.Time(4) }
+
+	app := s.Appender(context.Background())
+	lbls := labels.FromStrings("__name__", "foo")
+	for ts := int64(0); ts < 5; ts++ {
+		_, err := app.Append(0, lbls, ts, float64(ts))
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+
+	q, err := s.Querier(context.Background(), 0, 100)
+	require.NoError(t, err)
+	res := collectSeriesSet(t, q.Select(false, nil))
+	require.Len(t, res, 1)
+	require.Len(t, res[0].samples, 5)
+
+	// Move past the retention without appending new samples. The samples
+	// should no longer be queryable, even though they're still in memory.
+	s.now = func() time.Time { return timestamp.Time(100) }
+
+	q, err = s.Querier(context.Background(), 0, 100)
+	require.NoError(t, err)
+	require.Empty(t, collectSeriesSet(t, q.Select(false, nil)))
+
+	// Truncating should release the expired samples while keeping the series.
+	require.NoError(t, s.Truncate(0))
+
+	var series int
+	s.series.forEach(func(s *memSeries) {
+		series++
+		require.Nil(t, s.samples)
+	})
+	require.Equal(t, 1, series)
+}
+
+func TestStorage_Querier_Disabled(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	_, err = s.Querier(context.Background(), 0, 100)
+	require.Equal(t, ErrQueryDisabled, err)
+}
+
+type queriedSeries struct {
+	lset    labels.Labels
+	samples []bufferedSample
+}
+
+func collectSeriesSet(t *testing.T, ss storage.SeriesSet) []queriedSeries {
+	t.Helper()
+
+	var res []queriedSeries
+	for ss.Next() {
+		series := ss.At()
+		qs := queriedSeries{lset: series.Labels()}
+
+		it := series.Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			qs.samples = append(qs.samples, bufferedSample{t: ts, v: v})
+		}
+		require.NoError(t, it.Err())
+
+		res = append(res, qs)
+	}
+	require.NoError(t, ss.Err())
+	return res
+}
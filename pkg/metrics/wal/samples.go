@@ -0,0 +1,141 @@
+package wal
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// recordReader is implemented by wal.Reader and wal.LiveReader.
+type recordReader interface {
+	Next() bool
+	Record() []byte
+	Err() error
+}
+
+// ReadSamples calls f for every sample in the WAL with a timestamp after from
+// and no later than through. Samples are read from the latest checkpoint and
+// the segments which follow it, so samples which have already been truncated
+// are not returned. Samples are returned in the order they were written and
+// may be returned more than once.
+//
+// The WAL isn't truncated while ReadSamples runs, but samples may still be
+// appended to it. Returning an error from f stops reading.
+func (w *Storage) ReadSamples(from, through int64, f func(lset labels.Labels, t int64, v float64) error) error {
+	w.walMtx.RLock()
+	closed := w.walClosed
+	w.walMtx.RUnlock()
+	if closed {
+		return ErrWALClosed
+	}
+
+	w.truncateMtx.Lock()
+	defer w.truncateMtx.Unlock()
+
+	var (
+		dec     record.Decoder
+		series  = make(map[uint64]labels.Labels)
+		dir     = SubDirectory(w.path)
+		samples []record.RefSample
+	)
+
+	readRecords := func(r recordReader) error {
+		for r.Next() {
+			rec := r.Record()
+			switch dec.Type(rec) {
+			case record.Series:
+				refs, err := dec.Series(rec, nil)
+				if err != nil {
+					return errors.Wrap(err, "decode series")
+				}
+				for _, s := range refs {
+					series[s.Ref] = s.Labels
+				}
+			case record.Samples:
+				var err error
+				samples, err = dec.Samples(rec, samples[:0])
+				if err != nil {
+					return errors.Wrap(err, "decode samples")
+				}
+				for _, s := range samples {
+					lset, ok := series[s.Ref]
+					if !ok || s.T <= from || s.T > through {
+						continue
+					}
+					if err := f(lset, s.T, s.V); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return r.Err()
+	}
+
+	checkpoint, checkpointIdx, err := wal.LastCheckpoint(dir)
+	if err != nil && err != record.ErrNotFound {
+		return errors.Wrap(err, "find last checkpoint")
+	}
+
+	first, last, err := wal.Segments(dir)
+	if err != nil {
+		return errors.Wrap(err, "get segment range")
+	}
+
+	if checkpoint != "" {
+		sr, err := wal.NewSegmentsReader(checkpoint)
+		if err != nil {
+			return errors.Wrap(err, "open checkpoint")
+		}
+		err = readRecords(wal.NewReader(sr))
+		_ = sr.Close()
+		if err != nil {
+			return err
+		}
+
+		first = checkpointIdx + 1
+	}
+
+	for i := first; i <= last; i++ {
+		// The last segment is still being written to, so it's read with a
+		// LiveReader which stops at a partially written record instead of
+		// treating it as corruption.
+		if i == last {
+			return w.readLiveSegment(wal.SegmentName(dir, i), readRecords)
+		}
+
+		s, err := wal.OpenReadSegment(wal.SegmentName(dir, i))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "open segment %d", i)
+		}
+		sr := wal.NewSegmentBufReader(s)
+		err = readRecords(wal.NewReader(sr))
+		_ = sr.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Storage) readLiveSegment(path string, f func(r recordReader) error) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := wal.NewLiveReader(w.logger, wal.NewLiveReaderMetrics(nil), file)
+	if err := f(r); err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package wal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_ReadSamples(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	defer s.Close()
+
+	app := s.Appender(context.Background())
+	payload := buildSeries([]string{"foo", "bar"})
+	for _, metric := range payload {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	// Samples written after a truncation are read from the new segment, while
+	// the series they belong to are read from the checkpoint.
+	require.NoError(t, s.Truncate(0))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.wal.NextSegment())
+	}
+	require.NoError(t, s.Truncate(0))
+
+	app = s.Appender(context.Background())
+	for _, metric := range payload {
+		metric.samples = []sample{{ts: 100, val: 100}}
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	type readSample struct {
+		name string
+		t    int64
+		v    float64
+	}
+	var actual []readSample
+	err = s.ReadSamples(2, 100, func(lset labels.Labels, t int64, v float64) error {
+		actual = append(actual, readSample{name: lset.Get("__name__"), t: t, v: v})
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Samples at or before the start of the range are skipped.
+	expect := []readSample{
+		{"foo", 10, 100},
+		{"bar", 20, 200},
+		{"foo", 100, 100},
+		{"bar", 100, 100},
+	}
+	require.ElementsMatch(t, expect, actual)
+
+	require.NoError(t, s.Close())
+	err = s.ReadSamples(0, 100, func(labels.Labels, int64, float64) error { return nil })
+	require.Equal(t, ErrWALClosed, err)
+}
@@ -2,6 +2,7 @@ package wal
 
 import (
 	"sync"
+	"time"
 
 	"github.com/prometheus/prometheus/pkg/intern"
 	"github.com/prometheus/prometheus/pkg/labels"
@@ -30,14 +31,73 @@ type memSeries struct {
 
 	// Whether this series has samples waiting to be committed to the WAL
 	pendingCommit bool
+
+	// samples holds recently committed samples in timestamp order. It is only
+	// populated when the Storage has a query retention configured.
+	samples []bufferedSample
+}
+
+// bufferedSample is a single sample held in memory for querying.
+type bufferedSample struct {
+	t int64
+	v float64
 }
 
+func (s bufferedSample) T() int64   { return s.t }
+func (s bufferedSample) V() float64 { return s.v }
+
 func (s *memSeries) updateTs(ts int64) {
 	s.lastTs = ts
 	s.willDelete = false
 	s.pendingCommit = true
 }
 
+// appendSample buffers a committed sample for querying, discarding any buffered
+// samples which are older than retention relative to the newest sample.
+// Samples that are out of order are ignored.
+func (s *memSeries) appendSample(t int64, v float64, retention time.Duration) {
+	if n := len(s.samples); n > 0 && t <= s.samples[n-1].t {
+		return
+	}
+	s.samples = append(s.samples, bufferedSample{t: t, v: v})
+
+	s.truncateSamples(t - retention.Milliseconds())
+}
+
+// truncateSamples discards buffered samples older than mint. The series must
+// be locked by the caller.
+func (s *memSeries) truncateSamples(mint int64) {
+	var drop int
+	for drop < len(s.samples) && s.samples[drop].t < mint {
+		drop++
+	}
+	switch {
+	case drop == len(s.samples):
+		// Release the backing array of series which stopped receiving samples.
+		s.samples = nil
+	case drop > 0:
+		// Copy the remaining samples to the front so the backing array doesn't
+		// grow unbounded.
+		n := copy(s.samples, s.samples[drop:])
+		s.samples = s.samples[:n]
+	}
+}
+
+// samplesBetween returns a copy of buffered samples in the range [mint, maxt].
+// The series must be locked by the caller.
+func (s *memSeries) samplesBetween(mint, maxt int64) []bufferedSample {
+	var res []bufferedSample
+	for _, smpl := range s.samples {
+		if smpl.t < mint {
+			continue
+		} else if smpl.t > maxt {
+			break
+		}
+		res = append(res, smpl)
+	}
+	return res
+}
+
 // seriesHashmap is a simple hashmap for memSeries by their label set. It is
 // built on top of a regular hashmap and holds a slice of series to resolve
 // hash collisions. Its methods require the hash to be submitted with it to
@@ -184,6 +244,14 @@ func (s *stripeSeries) gc(mint int64) map[uint64]struct{} {
 	return deleted
 }
 
+// truncateSamples discards the buffered samples older than mint from every
+// series.
+func (s *stripeSeries) truncateSamples(mint int64) {
+	s.forEach(func(series *memSeries) {
+		series.truncateSamples(mint)
+	})
+}
+
 func (s *stripeSeries) getByID(id uint64) *memSeries {
 	i := id & uint64(s.size-1)
 
@@ -216,6 +284,21 @@ func (s *stripeSeries) set(hash uint64, series *memSeries) {
 	s.locks[i].Unlock()
 }
 
+// forEach calls f for every series. Each series is locked while f runs, so f
+// may read fields which are changed after the series is created, such as its
+// buffered samples. f must not retain references to those fields.
+func (s *stripeSeries) forEach(f func(series *memSeries)) {
+	for i := 0; i < s.size; i++ {
+		s.locks[i].RLock()
+		for _, series := range s.series[i] {
+			series.Lock()
+			f(series)
+			series.Unlock()
+		}
+		s.locks[i].RUnlock()
+	}
+}
+
 func (s *stripeSeries) iterator() *stripeSeriesIterator {
 	return &stripeSeriesIterator{s}
 }
@@ -0,0 +1,354 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/encoding"
+	"github.com/prometheus/prometheus/tsdb/fileutil"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+const (
+	// snapshotFilename is the name of the snapshot file within the storage
+	// directory.
+	snapshotFilename = "snapshot"
+
+	snapshotMagic   uint32 = 0x4147534e // "AGSN"
+	snapshotVersion byte   = 1
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// snapshot is the in-memory state of a Storage at the time it was shut down.
+// Segments before segment were fully applied to the state, so only segment
+// and later segments need to be replayed.
+type snapshot struct {
+	// segment is the first segment which needs to be replayed.
+	segment int
+	// prevSegmentSize is the size of the segment before segment, used to
+	// verify the snapshot matches the WAL. -1 if segment is 0.
+	prevSegmentSize int64
+	// checkpoint is the index of the last checkpoint, or -1 if there were
+	// no checkpoints.
+	checkpoint int
+
+	remoteWriteTs int64
+	lastRef       uint64
+
+	series  []snapshotSeries
+	deleted map[uint64]int
+}
+
+type snapshotSeries struct {
+	ref     uint64
+	lset    labels.Labels
+	lastTs  int64
+	samples []bufferedSample
+}
+
+// SnapshotPath returns the path of the snapshot file for the storage
+// directory dir.
+func SnapshotPath(dir string) string {
+	return filepath.Join(dir, snapshotFilename)
+}
+
+// WriteSnapshot writes a snapshot of the in-memory series to disk so the next
+// Storage opened for the same directory only needs to replay segments written
+// after the snapshot. remoteWriteTs is the lowest timestamp sent by
+// remote_write, and is restored by SnapshotRemoteWriteTimestamp.
+//
+// WriteSnapshot should only be called on shutdown once nothing else is
+// appending to the Storage; samples appended after the snapshot are still
+// written to the WAL but will be replayed on top of the snapshot.
+func (w *Storage) WriteSnapshot(remoteWriteTs int64) error {
+	w.walMtx.Lock()
+	defer w.walMtx.Unlock()
+
+	if w.walClosed {
+		return ErrWALClosed
+	}
+
+	w.truncateMtx.Lock()
+	defer w.truncateMtx.Unlock()
+
+	// Start a new segment so every segment before it is covered by the
+	// snapshot.
+	if err := w.wal.NextSegment(); err != nil {
+		return errors.Wrap(err, "next segment")
+	}
+
+	s, err := w.currentSnapshotPosition()
+	if err != nil {
+		return err
+	}
+	s.remoteWriteTs = remoteWriteTs
+	s.lastRef = w.ref.Load()
+
+	// Samples are copied since they're changed by commits after the series is
+	// unlocked.
+	w.series.forEach(func(series *memSeries) {
+		s.series = append(s.series, snapshotSeries{
+			ref:     series.ref,
+			lset:    series.lset,
+			lastTs:  series.lastTs,
+			samples: append([]bufferedSample(nil), series.samples...),
+		})
+	})
+
+	w.deletedMtx.Lock()
+	s.deleted = make(map[uint64]int, len(w.deleted))
+	for ref, segment := range w.deleted {
+		s.deleted[ref] = segment
+	}
+	w.deletedMtx.Unlock()
+
+	path := SnapshotPath(w.path)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, encodeSnapshot(s), 0666); err != nil {
+		return errors.Wrap(err, "write snapshot")
+	}
+	if err := fileutil.Replace(tmp, path); err != nil {
+		return errors.Wrap(err, "replace snapshot")
+	}
+
+	level.Info(w.logger).Log("msg", "wrote WAL snapshot", "series", len(s.series), "segment", s.segment)
+	return nil
+}
+
+// SnapshotRemoteWriteTimestamp returns the lowest timestamp sent by
+// remote_write from the snapshot the Storage was loaded from. Returns 0 if no
+// snapshot was loaded.
+func (w *Storage) SnapshotRemoteWriteTimestamp() int64 {
+	return w.snapshotRemoteWriteTs.Load()
+}
+
+// currentSnapshotPosition returns a snapshot holding the position of the
+// current segment and checkpoint.
+func (w *Storage) currentSnapshotPosition() (*snapshot, error) {
+	dir := w.wal.Dir()
+
+	_, last, err := wal.Segments(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "get segment range")
+	}
+
+	s := &snapshot{segment: last, prevSegmentSize: -1, checkpoint: -1}
+	if last > 0 {
+		fi, err := os.Stat(wal.SegmentName(dir, last-1))
+		if err != nil {
+			return nil, errors.Wrap(err, "stat segment")
+		}
+		s.prevSegmentSize = fi.Size()
+	}
+
+	_, checkpoint, err := wal.LastCheckpoint(dir)
+	if err != nil && err != record.ErrNotFound {
+		return nil, errors.Wrap(err, "find last checkpoint")
+	} else if err == nil {
+		s.checkpoint = checkpoint
+	}
+	return s, nil
+}
+
+// loadSnapshot loads the snapshot from disk if one exists and matches the
+// WAL. Returns the first segment to replay from, or -1 if no snapshot was
+// loaded. The snapshot file is always removed so a stale snapshot is never
+// loaded after a crash.
+func (w *Storage) loadSnapshot() int {
+	path := SnapshotPath(w.path)
+	bb, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return -1
+	}
+	defer func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			level.Warn(w.logger).Log("msg", "failed to remove WAL snapshot", "err", err)
+		}
+	}()
+	if err != nil {
+		level.Warn(w.logger).Log("msg", "failed to read WAL snapshot, replaying full WAL", "err", err)
+		return -1
+	}
+
+	s, err := decodeSnapshot(bb)
+	if err == nil {
+		err = w.verifySnapshot(s)
+	}
+	if err != nil {
+		level.Warn(w.logger).Log("msg", "WAL snapshot is invalid, replaying full WAL", "err", err)
+		return -1
+	}
+
+	for _, ss := range s.series {
+		series := &memSeries{ref: ss.ref, lset: ss.lset, lastTs: ss.lastTs, samples: ss.samples}
+		w.series.set(ss.lset.Hash(), series)
+
+		w.metrics.numActiveSeries.Inc()
+		w.metrics.totalCreatedSeries.Inc()
+		w.limiter.add(1)
+	}
+
+	w.deletedMtx.Lock()
+	for ref, segment := range s.deleted {
+		w.deleted[ref] = segment
+	}
+	w.metrics.numDeletedSeries.Set(float64(len(w.deleted)))
+	w.deletedMtx.Unlock()
+
+	w.ref.Store(s.lastRef)
+	w.snapshotRemoteWriteTs.Store(s.remoteWriteTs)
+
+	level.Info(w.logger).Log("msg", "WAL snapshot loaded", "series", len(s.series), "segment", s.segment)
+	return s.segment
+}
+
+// verifySnapshot ensures s was taken from the current WAL: the checkpoint
+// must be the same, and the segment before the snapshot must be unchanged.
+func (w *Storage) verifySnapshot(s *snapshot) error {
+	dir := w.wal.Dir()
+
+	first, last, err := wal.Segments(dir)
+	if err != nil {
+		return errors.Wrap(err, "get segment range")
+	}
+	if s.segment < first || s.segment > last {
+		return fmt.Errorf("snapshot segment %d is not in the WAL (segments %d to %d)", s.segment, first, last)
+	}
+
+	if s.prevSegmentSize >= 0 {
+		fi, err := os.Stat(wal.SegmentName(dir, s.segment-1))
+		if err != nil {
+			return errors.Wrap(err, "stat segment")
+		}
+		if fi.Size() != s.prevSegmentSize {
+			return fmt.Errorf("segment %d changed since the snapshot was written", s.segment-1)
+		}
+	}
+
+	checkpoint := -1
+	if _, idx, err := wal.LastCheckpoint(dir); err != nil && err != record.ErrNotFound {
+		return errors.Wrap(err, "find last checkpoint")
+	} else if err == nil {
+		checkpoint = idx
+	}
+	if checkpoint != s.checkpoint {
+		return fmt.Errorf("checkpoint changed since the snapshot was written")
+	}
+	return nil
+}
+
+func encodeSnapshot(s *snapshot) []byte {
+	buf := encoding.Encbuf{}
+	buf.PutBE32(snapshotMagic)
+	buf.PutByte(snapshotVersion)
+
+	buf.PutVarint64(int64(s.segment))
+	buf.PutVarint64(s.prevSegmentSize)
+	buf.PutVarint64(int64(s.checkpoint))
+	buf.PutVarint64(s.remoteWriteTs)
+	buf.PutUvarint64(s.lastRef)
+
+	buf.PutUvarint(len(s.series))
+	for _, series := range s.series {
+		buf.PutUvarint64(series.ref)
+		buf.PutVarint64(series.lastTs)
+
+		buf.PutUvarint(len(series.lset))
+		for _, l := range series.lset {
+			buf.PutUvarintStr(l.Name)
+			buf.PutUvarintStr(l.Value)
+		}
+
+		buf.PutUvarint(len(series.samples))
+		for _, smpl := range series.samples {
+			buf.PutVarint64(smpl.t)
+			buf.PutBE64(math.Float64bits(smpl.v))
+		}
+	}
+
+	buf.PutUvarint(len(s.deleted))
+	for ref, segment := range s.deleted {
+		buf.PutUvarint64(ref)
+		buf.PutVarint64(int64(segment))
+	}
+
+	buf.PutBE32(crc32.Checksum(buf.Get(), castagnoliTable))
+	return buf.Get()
+}
+
+func decodeSnapshot(bb []byte) (*snapshot, error) {
+	if len(bb) < 9 {
+		return nil, errors.New("snapshot too short")
+	}
+
+	content, sum := bb[:len(bb)-4], bb[len(bb)-4:]
+	if crc32.Checksum(content, castagnoliTable) != binary.BigEndian.Uint32(sum) {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	dec := encoding.Decbuf{B: content}
+	if magic := dec.Be32(); magic != snapshotMagic {
+		return nil, fmt.Errorf("invalid snapshot magic %x", magic)
+	}
+	if version := dec.Byte(); version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	s := &snapshot{
+		segment:         int(dec.Varint64()),
+		prevSegmentSize: dec.Varint64(),
+		checkpoint:      int(dec.Varint64()),
+		remoteWriteTs:   dec.Varint64(),
+		lastRef:         dec.Uvarint64(),
+	}
+
+	numSeries := dec.Uvarint()
+	for i := 0; i < numSeries && dec.Err() == nil; i++ {
+		series := snapshotSeries{
+			ref:    dec.Uvarint64(),
+			lastTs: dec.Varint64(),
+		}
+
+		numLabels := dec.Uvarint()
+		for j := 0; j < numLabels && dec.Err() == nil; j++ {
+			series.lset = append(series.lset, labels.Label{
+				Name:  dec.UvarintStr(),
+				Value: dec.UvarintStr(),
+			})
+		}
+
+		numSamples := dec.Uvarint()
+		for j := 0; j < numSamples && dec.Err() == nil; j++ {
+			series.samples = append(series.samples, bufferedSample{
+				t: dec.Varint64(),
+				v: math.Float64frombits(dec.Be64()),
+			})
+		}
+
+		s.series = append(s.series, series)
+	}
+
+	numDeleted := dec.Uvarint()
+	s.deleted = make(map[uint64]int)
+	for i := 0; i < numDeleted && dec.Err() == nil; i++ {
+		s.deleted[dec.Uvarint64()] = int(dec.Varint64())
+	}
+
+	if dec.Err() != nil {
+		return nil, errors.Wrap(dec.Err(), "decode snapshot")
+	}
+	if dec.Len() != 0 {
+		return nil, fmt.Errorf("unexpected %d bytes at end of snapshot", dec.Len())
+	}
+	return s, nil
+}
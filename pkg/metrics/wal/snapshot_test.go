@@ -0,0 +1,120 @@
+package wal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_Snapshot(t *testing.T) {
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	opts := Options{QueryRetention: time.Hour}
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+
+	app := s.Appender(context.Background())
+	payload := buildSeries([]string{"foo", "bar", "baz", "blerg"})
+	for _, metric := range payload[:2] {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+
+	require.NoError(t, s.WriteSnapshot(1234))
+	require.FileExists(t, SnapshotPath(walDir))
+
+	// Samples written after the snapshot must be replayed on top of it.
+	app = s.Appender(context.Background())
+	for _, metric := range payload[2:] {
+		metric.Write(t, app)
+	}
+	require.NoError(t, app.Commit())
+	require.NoError(t, s.Close())
+
+	s, err = NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	require.NoFileExists(t, SnapshotPath(walDir), "snapshot should be removed after loading")
+	require.Equal(t, int64(1234), s.SnapshotRemoteWriteTimestamp())
+	require.Equal(t, uint64(len(payload)), s.ref.Load())
+
+	for _, metric := range payload {
+		series := s.series.getByID(*metric.ref)
+		require.NotNil(t, series, "series %s not restored", metric.name)
+		require.Equal(t, metric.name, series.lset.Get("__name__"))
+		require.Equal(t, metric.samples[len(metric.samples)-1].ts, series.lastTs)
+		require.Len(t, series.samples, len(metric.samples))
+	}
+}
+
+func TestStorage_Snapshot_Invalid(t *testing.T) {
+	tt := []struct {
+		name   string
+		modify func(t *testing.T, walDir string)
+	}{
+		{
+			name: "corrupted",
+			modify: func(t *testing.T, walDir string) {
+				bb, err := ioutil.ReadFile(SnapshotPath(walDir))
+				require.NoError(t, err)
+				bb[len(bb)/2] ^= 0xff
+				require.NoError(t, ioutil.WriteFile(SnapshotPath(walDir), bb, 0666))
+			},
+		},
+		{
+			name: "unknown segment",
+			modify: func(t *testing.T, walDir string) {
+				bb := encodeSnapshot(&snapshot{segment: 100, prevSegmentSize: -1, checkpoint: -1})
+				require.NoError(t, ioutil.WriteFile(SnapshotPath(walDir), bb, 0666))
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+			require.NoError(t, err)
+			defer os.RemoveAll(walDir)
+
+			s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+			require.NoError(t, err)
+
+			app := s.Appender(context.Background())
+			payload := buildSeries([]string{"foo", "bar"})
+			for _, metric := range payload {
+				metric.Write(t, app)
+			}
+			require.NoError(t, app.Commit())
+			require.NoError(t, s.WriteSnapshot(1234))
+			require.NoError(t, s.Close())
+
+			tc.modify(t, walDir)
+
+			// The full WAL should be replayed instead.
+			s, err = NewStorage(log.NewNopLogger(), nil, walDir)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, s.Close())
+			}()
+
+			require.NoFileExists(t, SnapshotPath(walDir))
+			require.Equal(t, int64(0), s.SnapshotRemoteWriteTimestamp())
+			for _, metric := range payload {
+				series := s.series.getByID(*metric.ref)
+				require.NotNil(t, series, "series %s not replayed", metric.name)
+				require.Equal(t, metric.samples[len(metric.samples)-1].ts, series.lastTs)
+			}
+		})
+	}
+}
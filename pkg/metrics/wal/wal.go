@@ -12,6 +12,7 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/exemplar"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/timestamp"
@@ -36,6 +37,10 @@ type storageMetrics struct {
 	totalRemovedSeries     prometheus.Counter
 	totalAppendedSamples   prometheus.Counter
 	totalAppendedExemplars prometheus.Counter
+	totalLimitedSamples    *prometheus.CounterVec
+	totalEvictedSegments   prometheus.Counter
+	totalEvictedBytes      prometheus.Counter
+	totalEvictedSamples    prometheus.Counter
 }
 
 func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
@@ -70,6 +75,26 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 		Help: "Total number of exemplars appended to the WAL",
 	})
 
+	m.totalLimitedSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_wal_storage_limited_samples_total",
+		Help: "Total number of samples dropped because creating their series would exceed a series limit",
+	}, []string{"reason", "job"})
+
+	m.totalEvictedSegments = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_storage_evicted_segments_total",
+		Help: "Total number of WAL segments evicted to stay within the disk quota",
+	})
+
+	m.totalEvictedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_storage_evicted_bytes_total",
+		Help: "Total number of bytes of WAL segments evicted to stay within the disk quota",
+	})
+
+	m.totalEvictedSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_storage_evicted_samples_total",
+		Help: "Total number of samples lost from evicting WAL segments to stay within the disk quota",
+	})
+
 	if r != nil {
 		r.MustRegister(
 			m.numActiveSeries,
@@ -78,6 +103,10 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 			m.totalRemovedSeries,
 			m.totalAppendedSamples,
 			m.totalAppendedExemplars,
+			m.totalLimitedSamples,
+			m.totalEvictedSegments,
+			m.totalEvictedBytes,
+			m.totalEvictedSamples,
 		)
 	}
 
@@ -95,16 +124,38 @@ func (m *storageMetrics) Unregister() {
 		m.totalRemovedSeries,
 		m.totalAppendedSamples,
 		m.totalAppendedExemplars,
+		m.totalLimitedSamples,
+		m.totalEvictedSegments,
+		m.totalEvictedBytes,
+		m.totalEvictedSamples,
 	}
 	for _, c := range cs {
 		m.r.Unregister(c)
 	}
 }
 
+// Options configures a Storage.
+type Options struct {
+	// QueryRetention is how long committed samples are kept in memory so they
+	// can be queried through Querier. A value of 0 disables querying.
+	QueryRetention time.Duration
+
+	// MaxActiveSeries is the maximum number of active series. Samples for new
+	// series beyond this limit are dropped. A value of 0 disables the limit.
+	MaxActiveSeries int
+
+	// MaxNewSeriesPerMinute is the maximum number of new series which may be
+	// created within a minute. Samples for new series beyond this limit are
+	// dropped. A value of 0 disables the limit.
+	MaxNewSeriesPerMinute int
+}
+
+// DefaultOptions holds the default Options for a Storage.
+var DefaultOptions = Options{}
+
 // Storage implements storage.Storage, and just writes to the WAL.
 type Storage struct {
-	// Embed Queryable/ChunkQueryable for compatibility, but don't actually implement it.
-	storage.Queryable
+	// Embed ChunkQueryable for compatibility, but don't actually implement it.
 	storage.ChunkQueryable
 
 	// Operations against the WAL must be protected by a mutex so it doesn't get
@@ -114,6 +165,10 @@ type Storage struct {
 	walMtx    sync.RWMutex
 	walClosed bool
 
+	// truncateMtx prevents checkpoints from being created concurrently by
+	// Truncate and EvictSegments.
+	truncateMtx sync.Mutex
+
 	path   string
 	wal    *wal.WAL
 	logger log.Logger
@@ -128,10 +183,41 @@ type Storage struct {
 	deleted    map[uint64]int // Deleted series, and what WAL segment they must be kept until.
 
 	metrics *storageMetrics
+	opts    Options
+	limiter *seriesLimiter
+
+	// snapshotRemoteWriteTs is the remote_write timestamp restored from a
+	// snapshot.
+	snapshotRemoteWriteTs *atomic.Int64
+
+	// Series churn for the current truncation period, and for the most recent
+	// completed periods.
+	churnMtx     sync.Mutex
+	churnStart   time.Time
+	churnCreated *atomic.Int64
+	churn        []ChurnPeriod
+
+	// now is used for getting the current time. Overridden in tests.
+	now func() time.Time
 }
 
-// NewStorage makes a new Storage.
+// NewStorage makes a new Storage using DefaultOptions.
 func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string) (*Storage, error) {
+	return NewStorageWithOptions(logger, registerer, path, DefaultOptions)
+}
+
+// NewStorageWithOptions makes a new Storage with custom Options.
+func NewStorageWithOptions(logger log.Logger, registerer prometheus.Registerer, path string, opts Options) (*Storage, error) {
+	if opts.QueryRetention < 0 {
+		return nil, fmt.Errorf("query retention must not be negative")
+	}
+	if opts.MaxActiveSeries < 0 {
+		return nil, fmt.Errorf("max active series must not be negative")
+	}
+	if opts.MaxNewSeriesPerMinute < 0 {
+		return nil, fmt.Errorf("max new series per minute must not be negative")
+	}
+
 	w, err := wal.NewSize(logger, registerer, SubDirectory(path), wal.DefaultSegmentSize, true)
 	if err != nil {
 		return nil, err
@@ -145,6 +231,15 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 		series:  newStripeSeries(),
 		metrics: newStorageMetrics(registerer),
 		ref:     atomic.NewUint64(0),
+		opts:    opts,
+		limiter: newSeriesLimiter(opts),
+
+		snapshotRemoteWriteTs: atomic.NewInt64(0),
+
+		churnStart:   time.Now(),
+		churnCreated: atomic.NewInt64(0),
+
+		now: time.Now,
 	}
 
 	storage.bufPool.New = func() interface{} {
@@ -180,6 +275,13 @@ func (w *Storage) replayWAL() error {
 	}
 
 	level.Info(w.logger).Log("msg", "replaying WAL, this may take a while", "dir", w.wal.Dir())
+
+	// If a snapshot was written on shutdown, only segments written after it
+	// need to be replayed.
+	if startFrom := w.loadSnapshot(); startFrom >= 0 {
+		return w.replaySegments(startFrom)
+	}
+
 	dir, startFrom, err := wal.LastCheckpoint(w.wal.Dir())
 	if err != nil && err != record.ErrNotFound {
 		return errors.Wrap(err, "find last checkpoint")
@@ -205,6 +307,11 @@ func (w *Storage) replayWAL() error {
 		level.Info(w.logger).Log("msg", "WAL checkpoint loaded")
 	}
 
+	return w.replaySegments(startFrom)
+}
+
+// replaySegments replays all segments starting from the segment startFrom.
+func (w *Storage) replaySegments(startFrom int) error {
 	// Find the last segment.
 	_, last, err := wal.Segments(w.wal.Dir())
 	if err != nil {
@@ -310,6 +417,7 @@ func (w *Storage) loadWAL(r *wal.Reader) (err error) {
 
 					w.metrics.numActiveSeries.Inc()
 					w.metrics.totalCreatedSeries.Inc()
+					w.limiter.add(1)
 
 					if biggestRef <= s.Ref {
 						biggestRef = s.Ref
@@ -332,6 +440,9 @@ func (w *Storage) loadWAL(r *wal.Reader) (err error) {
 				if s.T > series.lastTs {
 					series.lastTs = s.T
 				}
+				if w.opts.QueryRetention > 0 {
+					series.appendSample(s.T, s.V, w.opts.QueryRetention)
+				}
 				series.Unlock()
 			}
 
@@ -383,12 +494,21 @@ func (w *Storage) Truncate(mint int64) error {
 		return ErrWALClosed
 	}
 
+	w.truncateMtx.Lock()
+	defer w.truncateMtx.Unlock()
+
 	start := time.Now()
 
 	// Garbage collect series that haven't received an update since mint.
 	w.gc(mint)
 	level.Info(w.logger).Log("msg", "series GC completed", "duration", time.Since(start))
 
+	// Drop buffered samples which fell out of the query retention, so series
+	// which stopped receiving samples don't keep them in memory.
+	if w.opts.QueryRetention > 0 {
+		w.series.truncateSamples(w.queryMinTime())
+	}
+
 	first, last, err := wal.Segments(w.wal.Dir())
 	if err != nil {
 		return errors.Wrap(err, "get segment range")
@@ -413,17 +533,7 @@ func (w *Storage) Truncate(mint int64) error {
 		return nil
 	}
 
-	keep := func(id uint64) bool {
-		if w.series.getByID(id) != nil {
-			return true
-		}
-
-		w.deletedMtx.Lock()
-		_, ok := w.deleted[id]
-		w.deletedMtx.Unlock()
-		return ok
-	}
-	if _, err = wal.Checkpoint(w.logger, w.wal, first, last, keep, mint); err != nil {
+	if _, err = wal.Checkpoint(w.logger, w.wal, first, last, w.keepSeries, mint); err != nil {
 		return errors.Wrap(err, "create checkpoint")
 	}
 	if err := w.wal.Truncate(last + 1); err != nil {
@@ -457,10 +567,25 @@ func (w *Storage) Truncate(mint int64) error {
 	return nil
 }
 
+// keepSeries returns true if series records for id should be kept when
+// creating a checkpoint.
+func (w *Storage) keepSeries(id uint64) bool {
+	if w.series.getByID(id) != nil {
+		return true
+	}
+
+	w.deletedMtx.Lock()
+	_, ok := w.deleted[id]
+	w.deletedMtx.Unlock()
+	return ok
+}
+
 // gc removes data before the minimum timestamp from the head.
 func (w *Storage) gc(mint int64) {
 	deleted := w.series.gc(mint)
 	w.metrics.numActiveSeries.Sub(float64(len(deleted)))
+	w.limiter.release(len(deleted))
+	w.completeChurnPeriod(len(deleted))
 
 	_, last, _ := wal.Segments(w.wal.Dir())
 	w.deletedMtx.Lock()
@@ -580,6 +705,12 @@ func (a *appender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint
 
 		var created bool
 		series, created = a.getOrCreate(l)
+		if series == nil {
+			// Creating the series would exceed a limit. The sample is dropped
+			// without returning an error so the rest of the scrape or write
+			// request is still appended.
+			return 0, nil
+		}
 		if created {
 			a.series = append(a.series, record.RefSeries{
 				Ref:    series.ref,
@@ -588,6 +719,7 @@ func (a *appender) Append(ref uint64, l labels.Labels, t int64, v float64) (uint
 
 			a.w.metrics.numActiveSeries.Inc()
 			a.w.metrics.totalCreatedSeries.Inc()
+			a.w.churnCreated.Inc()
 		}
 	}
 
@@ -616,11 +748,33 @@ func (a *appender) getOrCreate(l labels.Labels) (series *memSeries, created bool
 		return series, false
 	}
 
+	if reason := a.w.limiter.reserve(); reason != "" {
+		a.w.seriesLimited(reason, l)
+		return nil, false
+	}
+
 	series = &memSeries{ref: a.w.ref.Inc(), lset: l}
 	a.w.series.set(l.Hash(), series)
 	return series, true
 }
 
+// seriesLimited records that a sample for a new series was dropped because of
+// a series limit.
+func (w *Storage) seriesLimited(reason string, l labels.Labels) {
+	job := l.Get(model.JobLabel)
+	w.metrics.totalLimitedSamples.WithLabelValues(reason, job).Inc()
+
+	if w.limiter.shouldLog(reason, job) {
+		level.Warn(w.logger).Log(
+			"msg", "dropping samples for new series due to series limit",
+			"reason", reason,
+			"limit", w.limiter.limit(reason),
+			"job", job,
+			"series", l.String(),
+		)
+	}
+}
+
 func (a *appender) AppendExemplar(ref uint64, _ labels.Labels, e exemplar.Exemplar) (uint64, error) {
 	s := a.w.series.getByID(ref)
 	if s == nil {
@@ -700,6 +854,9 @@ func (a *appender) Commit() error {
 		if series != nil {
 			series.Lock()
 			series.pendingCommit = false
+			if a.w.opts.QueryRetention > 0 {
+				series.appendSample(sample.T, sample.V, a.w.opts.QueryRetention)
+			}
 			series.Unlock()
 		}
 	}
@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// RemoteWriteHandler accepts Prometheus remote_write requests and appends the
+// received samples to the WAL of an instance. Appended samples are sent to
+// the instance's remote_write endpoints like any scraped sample.
+func (a *Agent) RemoteWriteHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName := mux.Vars(r)["instance"]
+
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logger := log.With(a.logger, "component", "remote write receiver", "instance", instanceName)
+	remote.NewWriteHandler(logger, inst).ServeHTTP(w, r)
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	"github.com/gorilla/mux"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/exemplar"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_RemoteWriteHandler(t *testing.T) {
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	inst := &mockInstanceAppend{}
+	mockManager := &instance.MockManager{
+		GetInstanceFunc: func(name string) (instance.ManagedInstance, error) {
+			if name != "test_instance" {
+				return nil, fmt.Errorf("instance %s does not exist", name)
+			}
+			return inst, nil
+		},
+		ApplyConfigFunc: func(_ instance.Config) error { return nil },
+		StopFunc:        func() {},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels: []prompb.Label{
+				{Name: "__name__", Value: "foo"},
+				{Name: "job", Value: "batch"},
+			},
+			Samples: []prompb.Sample{
+				{Timestamp: 1000, Value: 1},
+				{Timestamp: 2000, Value: 2},
+			},
+		}},
+	}
+	bb, err := req.Marshal()
+	require.NoError(t, err)
+	body := snappy.Encode(nil, bb)
+
+	t.Run("valid request", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/instances/test_instance/api/v1/write", bytes.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.RemoteWriteHandler(rr, r)
+		require.Equal(t, http.StatusNoContent, rr.Result().StatusCode)
+
+		lbls := labels.FromStrings("__name__", "foo", "job", "batch")
+		require.Equal(t, []appendedSample{
+			{l: lbls, t: 1000, v: 1},
+			{l: lbls, t: 2000, v: 2},
+		}, inst.Committed())
+	})
+
+	t.Run("malformed request", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/instances/test_instance/api/v1/write", bytes.NewReader([]byte("garbage")))
+		r = mux.SetURLVars(r, map[string]string{"instance": "test_instance"})
+
+		rr := httptest.NewRecorder()
+		a.RemoteWriteHandler(rr, r)
+		require.Equal(t, http.StatusBadRequest, rr.Result().StatusCode)
+	})
+
+	t.Run("unknown instance", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/agent/api/v1/instances/missing/api/v1/write", bytes.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"instance": "missing"})
+
+		rr := httptest.NewRecorder()
+		a.RemoteWriteHandler(rr, r)
+		require.Equal(t, http.StatusNotFound, rr.Result().StatusCode)
+	})
+}
+
+type appendedSample struct {
+	l labels.Labels
+	t int64
+	v float64
+}
+
+// mockInstanceAppend is a mock instance that records committed samples.
+type mockInstanceAppend struct {
+	mockInstanceScrape
+
+	mut       sync.Mutex
+	committed []appendedSample
+}
+
+func (i *mockInstanceAppend) Appender(_ context.Context) storage.Appender {
+	return &mockAppender{inst: i}
+}
+
+func (i *mockInstanceAppend) Committed() []appendedSample {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	return i.committed
+}
+
+type mockAppender struct {
+	inst    *mockInstanceAppend
+	pending []appendedSample
+}
+
+func (a *mockAppender) Append(_ uint64, l labels.Labels, t int64, v float64) (uint64, error) {
+	a.pending = append(a.pending, appendedSample{l: l, t: t, v: v})
+	return 0, nil
+}
+
+func (a *mockAppender) AppendExemplar(_ uint64, _ labels.Labels, _ exemplar.Exemplar) (uint64, error) {
+	return 0, nil
+}
+
+func (a *mockAppender) Commit() error {
+	a.inst.mut.Lock()
+	defer a.inst.mut.Unlock()
+	a.inst.committed = append(a.inst.committed, a.pending...)
+	a.pending = nil
+	return nil
+}
+
+func (a *mockAppender) Rollback() error {
+	a.pending = nil
+	return nil
+}
@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/prometheus/pkg/exemplar"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/require"
@@ -112,8 +114,20 @@ func (m *mockInstance) Update(_ instance.Config) error { return nil }
 
 func (m *mockInstance) TargetsActive() map[string][]*scrape.Target { return nil }
 
+func (m *mockInstance) TargetsDropped() map[string][]*scrape.Target { return nil }
+
 func (m *mockInstance) StorageDirectory() string { return "" }
 
+func (m *mockInstance) Querier(_ context.Context, _, _ int64) (storage.Querier, error) {
+	return storage.NoopQuerier(), nil
+}
+
+func (m *mockInstance) RuleGroups() []*rules.Group { return nil }
+
+func (m *mockInstance) CardinalityStats(_ int) (wal.CardinalityStats, error) {
+	return wal.CardinalityStats{}, nil
+}
+
 func (m *mockInstance) Appender(_ context.Context) storage.Appender {
 	if m.appender == nil {
 		m.appender = &mockAppender{}